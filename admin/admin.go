@@ -0,0 +1,323 @@
+// Package admin serves the RaftAdmin service of admin.proto over
+// gRPC, through which operators change the membership of a cluster,
+// transfer leadership, trigger snapshots and read the status of its
+// members, and calls it with Client; the raftadmin command wraps the
+// client.
+//
+//    server := admin.NewServer(node)
+//    go server.Serve(listener)
+//
+//    conn, err := grpc.NewClient(address,
+//        grpc.WithTransportCredentials(insecure.NewCredentials()))
+//    client := admin.NewClient(conn)
+//    status, err := client.Status(ctx)
+//
+// Operations only the leader can perform fail on other members with
+// a *raft.NotLeaderError naming the leader and its address, if
+// known.
+package admin
+
+import (
+    "context"
+    "errors"
+
+    "github.com/tawawhite/raft"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/status"
+)
+
+const serviceName = "raft.admin.RaftAdmin"
+
+// The trailers holding the leader's ID and address in answers to
+// operations made on another member.
+const (
+    leaderTrailer        = "raft-leader-id"
+    leaderAddressTrailer = "raft-leader-address"
+)
+
+// ServerOption makes a gRPC server encode the messages of the
+// RaftAdmin service, and leaves those of services generated by
+// protoc to the default codec. Servers passed to Register need it.
+func ServerOption() grpc.ServerOption {
+    return grpc.ForceServerCodec(codec{})
+}
+
+// NewServer returns a gRPC server serving the RaftAdmin service for
+// node, configured by options.
+func NewServer(node *raft.Node, options ...grpc.ServerOption) *grpc.Server {
+    server := grpc.NewServer(append([]grpc.ServerOption{ServerOption()}, options...)...)
+    Register(server, node)
+    return server
+}
+
+// Register serves the RaftAdmin service for node on server, which
+// must have been created with ServerOption.
+func Register(server *grpc.Server, node *raft.Node) {
+    server.RegisterService(&grpc.ServiceDesc{
+        ServiceName: serviceName,
+        HandlerType: (*any)(nil),
+        Methods: []grpc.MethodDesc{
+            method("Status", func() message { return &empty{} }, serveStatus),
+            method("Configuration", func() message { return &empty{} }, serveConfiguration),
+            method("AddVoter", func() message { return &member{} }, serveAddVoter),
+            method("AddReplica", func() message { return &member{} }, serveAddReplica),
+            method("RemoveServer", func() message { return &member{} }, serveRemoveServer),
+            method("SetLocality", func() message { return &member{} }, serveSetLocality),
+            method("LeadershipTransfer", func() message { return &member{} }, serveLeadershipTransfer),
+            method("Snapshot", func() message { return &empty{} }, serveSnapshot),
+            method("Drain", func() message { return &drainRequest{} }, serveDrain),
+        },
+        Metadata: "admin.proto",
+    }, node)
+}
+
+// method describes the unary method name, decoding requests made by
+// newRequest and answering them with serve.
+func method(name string, newRequest func() message, serve func(context.Context, *raft.Node, message) (message, error)) grpc.MethodDesc {
+    return grpc.MethodDesc{
+        MethodName: name,
+        Handler: func(srv any, ctx context.Context, decode func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+            request := newRequest()
+            if err := decode(request); err != nil {
+                return nil, err
+            }
+            handler := func(ctx context.Context, request any) (any, error) {
+                response, err := serve(ctx, srv.(*raft.Node), request.(message))
+                if err != nil {
+                    return nil, statusError(ctx, err)
+                }
+                return response, nil
+            }
+            if interceptor == nil {
+                return handler(ctx, request)
+            }
+            info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/" + name}
+            return interceptor(ctx, request, info, handler)
+        },
+    }
+}
+
+func serveStatus(ctx context.Context, node *raft.Node, request message) (message, error) {
+    response := nodeStatus(node.Status())
+    return &response, nil
+}
+
+func serveConfiguration(ctx context.Context, node *raft.Node, request message) (message, error) {
+    response := configuration(node.Configuration())
+    return &response, nil
+}
+
+func serveAddVoter(ctx context.Context, node *raft.Node, request message) (message, error) {
+    server := request.(*member)
+    future := node.AddVoter(ctx, server.Id, server.Address)
+    if err := future.Error(); err != nil {
+        return nil, err
+    }
+    return &index{index: future.Index(), term: future.Term()}, nil
+}
+
+func serveAddReplica(ctx context.Context, node *raft.Node, request message) (message, error) {
+    server := request.(*member)
+    future := node.AddReplica(ctx, server.Id, server.Address)
+    if err := future.Error(); err != nil {
+        return nil, err
+    }
+    return &index{index: future.Index(), term: future.Term()}, nil
+}
+
+func serveRemoveServer(ctx context.Context, node *raft.Node, request message) (message, error) {
+    future := node.RemoveServer(ctx, request.(*member).Id)
+    if err := future.Error(); err != nil {
+        return nil, err
+    }
+    return &index{index: future.Index(), term: future.Term()}, nil
+}
+
+func serveSetLocality(ctx context.Context, node *raft.Node, request message) (message, error) {
+    server := request.(*member)
+    future := node.SetLocality(ctx, server.Id, server.Locality)
+    if err := future.Error(); err != nil {
+        return nil, err
+    }
+    return &index{index: future.Index(), term: future.Term()}, nil
+}
+
+func serveLeadershipTransfer(ctx context.Context, node *raft.Node, request message) (message, error) {
+    var err error
+    if id := request.(*member).Id; id == "" {
+        err = node.LeadershipTransfer(ctx)
+    } else {
+        err = node.LeadershipTransferTo(ctx, id)
+    }
+    if err != nil {
+        return nil, err
+    }
+    return &empty{}, nil
+}
+
+func serveSnapshot(ctx context.Context, node *raft.Node, request message) (message, error) {
+    future := node.Snapshot()
+    select {
+    case <-future.Done():
+    case <-ctx.Done():
+        return nil, ctx.Err()
+    }
+    meta, snapshot, err := future.Open()
+    if err != nil {
+        return nil, err
+    }
+    snapshot.Close()
+    return &index{index: meta.Index, term: meta.Term}, nil
+}
+
+func serveDrain(ctx context.Context, node *raft.Node, request message) (message, error) {
+    if request.(*drainRequest).undrain {
+        node.Undrain()
+        return &empty{}, nil
+    }
+    if err := node.Drain(ctx); err != nil {
+        return nil, err
+    }
+    return &empty{}, nil
+}
+
+// statusError returns the gRPC status of err, telling clients
+// whether to redirect, retry or give up, and setting the trailer
+// naming the leader if only it could have served the request.
+func statusError(ctx context.Context, err error) error {
+    var notLeader *raft.NotLeaderError
+    code := codes.Unknown
+    switch {
+    case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+        return status.FromContextError(err).Err()
+    case errors.As(err, &notLeader):
+        grpc.SetTrailer(ctx, metadata.Pairs(
+            leaderTrailer, string(notLeader.LeaderId),
+            leaderAddressTrailer, string(notLeader.LeaderAddress)))
+        code = codes.FailedPrecondition
+    case errors.Is(err, raft.ErrInvalidConfig):
+        code = codes.InvalidArgument
+    case errors.Is(err, raft.ErrNoSnapshotter):
+        code = codes.Unimplemented
+    case errors.Is(err, raft.ErrRaftShutdown):
+        code = codes.Unavailable
+    case errors.Is(err, raft.ErrConfigurationChangeInProgress),
+        errors.Is(err, raft.ErrConfigurationChangeInterrupted),
+        errors.Is(err, raft.ErrLeadershipTransferInProgress),
+        errors.Is(err, raft.ErrLeadershipTransferFailed),
+        errors.Is(err, raft.ErrLeadershipLost),
+        errors.Is(err, raft.ErrRestoreInProgress):
+        code = codes.Aborted
+    }
+    return status.Error(code, err.Error())
+}
+
+// Client calls the RaftAdmin service of a member of a cluster.
+type Client struct {
+    conn grpc.ClientConnInterface
+}
+
+// NewClient returns a client calling the member conn is connected
+// to.
+func NewClient(conn grpc.ClientConnInterface) *Client {
+    return &Client{conn: conn}
+}
+
+// Status returns the status of the member.
+func (this *Client) Status(ctx context.Context) (raft.Status, error) {
+    var response nodeStatus
+    err := this.invoke(ctx, "Status", &empty{}, &response)
+    return raft.Status(response), err
+}
+
+// Configuration returns the membership of the cluster, as the member
+// knows it.
+func (this *Client) Configuration(ctx context.Context) (raft.Configuration, error) {
+    var response configuration
+    err := this.invoke(ctx, "Configuration", &empty{}, &response)
+    return raft.Configuration(response), err
+}
+
+// AddVoter adds the server id, reachable at address, to the cluster,
+// or changes its address if it is already a member, and returns the
+// index of the configuration entry that did once it is committed.
+// The member must be the leader.
+func (this *Client) AddVoter(ctx context.Context, id raft.ServerId, address raft.ServerAddress) (int, error) {
+    var response index
+    err := this.invoke(ctx, "AddVoter", &member{Id: id, Address: address}, &response)
+    return response.index, err
+}
+
+// AddReplica adds the server id, reachable at address, to the
+// cluster as a read-only replica, or demotes it if it is already a
+// voter, and returns the index of the configuration entry that did
+// once it is committed. The member must be the leader.
+func (this *Client) AddReplica(ctx context.Context, id raft.ServerId, address raft.ServerAddress) (int, error) {
+    var response index
+    err := this.invoke(ctx, "AddReplica", &member{Id: id, Address: address}, &response)
+    return response.index, err
+}
+
+// RemoveServer removes the member id from the cluster, and returns
+// the index of the configuration entry that did once it is
+// committed. The member must be the leader.
+func (this *Client) RemoveServer(ctx context.Context, id raft.ServerId) (int, error) {
+    var response index
+    err := this.invoke(ctx, "RemoveServer", &member{Id: id}, &response)
+    return response.index, err
+}
+
+// SetLocality records the locality of the member id, and returns
+// the index of the configuration entry that did once it is
+// committed. The member must be the leader.
+func (this *Client) SetLocality(ctx context.Context, id raft.ServerId, locality raft.Locality) (int, error) {
+    var response index
+    err := this.invoke(ctx, "SetLocality", &member{Id: id, Locality: locality}, &response)
+    return response.index, err
+}
+
+// LeadershipTransfer hands leadership to the member id, or if it is
+// empty, to the follower with the most up-to-date log. The member
+// must be the leader.
+func (this *Client) LeadershipTransfer(ctx context.Context, id raft.ServerId) error {
+    return this.invoke(ctx, "LeadershipTransfer", &member{Id: id}, &empty{})
+}
+
+// Snapshot snapshots the member's state machine and compacts its
+// log, returning the index and term of the last entry the snapshot
+// covers.
+func (this *Client) Snapshot(ctx context.Context) (int, int, error) {
+    var response index
+    err := this.invoke(ctx, "Snapshot", &empty{}, &response)
+    return response.index, response.term, err
+}
+
+// Drain drains the member for maintenance, returning once it has
+// handed over leadership, if it held it, and applied every entry it
+// knows to be committed; see raft.Node.Drain.
+func (this *Client) Drain(ctx context.Context) error {
+    return this.invoke(ctx, "Drain", &drainRequest{}, &empty{})
+}
+
+// Undrain ends the maintenance of the member.
+func (this *Client) Undrain(ctx context.Context) error {
+    return this.invoke(ctx, "Drain", &drainRequest{undrain: true}, &empty{})
+}
+
+// invoke calls method, turning the errors of operations made on a
+// member other than the leader into *raft.NotLeaderError.
+func (this *Client) invoke(ctx context.Context, method string, request, response message) error {
+    var trailer metadata.MD
+    err := this.conn.Invoke(ctx, "/"+serviceName+"/"+method, request, response,
+        grpc.ForceCodec(codec{}), grpc.Trailer(&trailer))
+    if leader := trailer.Get(leaderTrailer); err != nil && len(leader) > 0 {
+        notLeader := &raft.NotLeaderError{LeaderId: raft.ServerId(leader[0])}
+        if address := trailer.Get(leaderAddressTrailer); len(address) > 0 {
+            notLeader.LeaderAddress = raft.ServerAddress(address[0])
+        }
+        return notLeader
+    }
+    return err
+}
@@ -0,0 +1,181 @@
+package admin
+
+import (
+    "context"
+    "errors"
+    "net"
+    "testing"
+    "time"
+
+    "github.com/tawawhite/raft"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/credentials/insecure"
+    "google.golang.org/grpc/status"
+)
+
+// snapshotter snapshots nothing.
+type snapshotter struct{}
+
+func (snapshotter) Snapshot() ([]byte, error) { return []byte("state"), nil }
+
+func (snapshotter) Restore([]byte) error { return nil }
+
+// startCluster starts a cluster of members a, b and c, the first two
+// able to snapshot, and returns a client of the admin service of
+// each.
+func startCluster(t *testing.T) map[raft.ServerId]*Client {
+    registry := raft.NewRegistry()
+    t.Cleanup(func() { registry.Close() })
+    ids := []raft.ServerId{"a", "b", "c"}
+    clients := make(map[raft.ServerId]*Client)
+    for _, id := range ids {
+        var peers []raft.ServerId
+        for _, peer := range ids {
+            if peer != id {
+                peers = append(peers, peer)
+            }
+        }
+        options := []raft.Option{
+            raft.WithTimeouts(3, 10, 20),
+            func(config *raft.Config) { config.TickInterval = time.Millisecond },
+        }
+        if id != "c" {
+            options = append(options, raft.WithSnapshotter(snapshotter{}, 1000))
+        }
+        node, err := registry.NewNode(id, peers, func([]byte) {}, options...)
+        if err != nil {
+            t.Fatal(err)
+        }
+        node.Start()
+
+        listener, err := net.Listen("tcp", "127.0.0.1:0")
+        if err != nil {
+            t.Fatal(err)
+        }
+        server := NewServer(node)
+        go server.Serve(listener)
+        conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+        if err != nil {
+            t.Fatal(err)
+        }
+        t.Cleanup(func() {
+            conn.Close()
+            server.Stop()
+            node.Shutdown(context.Background())
+        })
+        clients[id] = NewClient(conn)
+    }
+    return clients
+}
+
+// leader waits for a member to lead the cluster and returns it.
+func leader(t *testing.T, clients map[raft.ServerId]*Client) raft.ServerId {
+    t.Helper()
+    for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+        for id, client := range clients {
+            status, err := client.Status(context.Background())
+            if err != nil {
+                t.Fatal(err)
+            }
+            if status.Role == raft.Leader && status.Id == id {
+                return id
+            }
+        }
+    }
+    t.Fatal("no leader elected")
+    return ""
+}
+
+func TestAdmin(t *testing.T) {
+    ctx := context.Background()
+    clients := startCluster(t)
+    leaderId := leader(t, clients)
+    var followerId raft.ServerId
+    for id := range clients {
+        if id != leaderId {
+            followerId = id
+        }
+    }
+
+    leaderStatus, err := clients[leaderId].Status(ctx)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if leaderStatus.Term == 0 || len(leaderStatus.Peers) != 2 || leaderStatus.LeaderId != leaderId {
+        t.Fatalf("leader status %+v", leaderStatus)
+    }
+
+    // Changes of membership made on a follower name the leader.
+    _, err = clients[followerId].AddVoter(ctx, "d", "d:1")
+    var notLeader *raft.NotLeaderError
+    if !errors.As(err, &notLeader) || notLeader.LeaderId != leaderId {
+        t.Fatalf("AddVoter on a follower: %v", err)
+    }
+
+    removed := raft.ServerId("c")
+    if leaderId == removed {
+        removed = followerId
+    }
+    index, err := clients[leaderId].RemoveServer(ctx, removed)
+    if err != nil || index == 0 {
+        t.Fatalf("RemoveServer: %d, %v", index, err)
+    }
+    configuration, err := clients[leaderId].Configuration(ctx)
+    if err != nil || len(configuration.Servers) != 2 || configuration.Index != index {
+        t.Fatalf("configuration after removing %s: %+v, %v", removed, configuration, err)
+    }
+    if _, err := clients[leaderId].RemoveServer(ctx, removed); status.Code(err) != codes.InvalidArgument {
+        t.Fatalf("removing a non-member: %v", err)
+    }
+    if _, err := clients[leaderId].AddVoter(ctx, removed, raft.ServerAddress(removed)); err != nil {
+        t.Fatal(err)
+    }
+    configuration, err = clients[leaderId].Configuration(ctx)
+    if err != nil || len(configuration.Servers) != 3 {
+        t.Fatalf("configuration after adding %s back: %+v, %v", removed, configuration, err)
+    }
+    locality := raft.Locality{Region: "us-east", Zone: "us-east-1b"}
+    if _, err := clients[leaderId].SetLocality(ctx, removed, locality); err != nil {
+        t.Fatal(err)
+    }
+    configuration, err = clients[leaderId].Configuration(ctx)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, server := range configuration.Servers {
+        if server.Id == removed && server.Locality != locality {
+            t.Fatalf("configuration after setting the locality of %s: %+v", removed, configuration)
+        }
+    }
+
+    index, term, err := clients["a"].Snapshot(ctx)
+    if err != nil || index == 0 || term == 0 {
+        t.Fatalf("Snapshot: %d, %d, %v", index, term, err)
+    }
+    if _, _, err := clients["c"].Snapshot(ctx); status.Code(err) != codes.Unimplemented {
+        t.Fatalf("Snapshot without a Snapshotter: %v", err)
+    }
+
+    if err := clients[leaderId].LeadershipTransfer(ctx, followerId); err != nil {
+        t.Fatal(err)
+    }
+    if id := leader(t, clients); id != followerId {
+        t.Fatalf("leadership went to %s, not %s", id, followerId)
+    }
+
+    // A drained leader hands over leadership, until undrained.
+    if err := clients[followerId].Drain(ctx); err != nil {
+        t.Fatal(err)
+    }
+    drained, err := clients[followerId].Status(ctx)
+    if err != nil || !drained.Draining || drained.Role == raft.Leader {
+        t.Fatalf("drained status %+v, %v", drained, err)
+    }
+    if err := clients[followerId].Undrain(ctx); err != nil {
+        t.Fatal(err)
+    }
+    if undrained, err := clients[followerId].Status(ctx); err != nil || undrained.Draining {
+        t.Fatalf("undrained status %+v, %v", undrained, err)
+    }
+}
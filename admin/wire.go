@@ -0,0 +1,373 @@
+package admin
+
+import (
+    "fmt"
+    "time"
+
+    "github.com/tawawhite/raft"
+    "google.golang.org/protobuf/encoding/protowire"
+    "google.golang.org/protobuf/proto"
+)
+
+// message is a message of admin.proto, encoded by hand like those
+// of raft.proto.
+type message interface {
+    marshal() []byte
+    unmarshal(buf []byte) error
+}
+
+// codec encodes the messages of admin.proto, and those of any other
+// service sharing the gRPC server, generated by protoc, as the
+// default codec does.
+type codec struct{}
+
+func (codec) Marshal(v any) ([]byte, error) {
+    switch v := v.(type) {
+    case message:
+        return v.marshal(), nil
+    case proto.Message:
+        return proto.Marshal(v)
+    }
+    return nil, fmt.Errorf("admin: cannot encode %T", v)
+}
+
+func (codec) Unmarshal(buf []byte, v any) error {
+    switch v := v.(type) {
+    case message:
+        return v.unmarshal(buf)
+    case proto.Message:
+        return proto.Unmarshal(buf, v)
+    }
+    return fmt.Errorf("admin: cannot decode %T", v)
+}
+
+func (codec) Name() string {
+    return "proto"
+}
+
+type empty struct{}
+
+func (*empty) marshal() []byte {
+    return nil
+}
+
+func (*empty) unmarshal(buf []byte) error {
+    return decodeFields(buf, func(protowire.Number, uint64, []byte) error { return nil })
+}
+
+type member raft.Server
+
+func (this *member) marshal() []byte {
+    buf := appendBytes(nil, 1, []byte(this.Id))
+    buf = appendBytes(buf, 2, []byte(this.Address))
+    buf = appendBool(buf, 3, this.Replica)
+    buf = appendBytes(buf, 4, []byte(this.Locality.Region))
+    buf = appendBytes(buf, 5, []byte(this.Locality.Zone))
+    return appendBool(buf, 6, this.Demoted)
+}
+
+func (this *member) unmarshal(buf []byte) error {
+    return decodeFields(buf, func(num protowire.Number, value uint64, bytes []byte) error {
+        switch num {
+        case 1:
+            this.Id = raft.ServerId(bytes)
+        case 2:
+            this.Address = raft.ServerAddress(bytes)
+        case 3:
+            this.Replica = value != 0
+        case 4:
+            this.Locality.Region = string(bytes)
+        case 5:
+            this.Locality.Zone = string(bytes)
+        case 6:
+            this.Demoted = value != 0
+        }
+        return nil
+    })
+}
+
+type configuration raft.Configuration
+
+func (this *configuration) marshal() []byte {
+    var buf []byte
+    for _, server := range this.Servers {
+        buf = appendBytes(buf, 1, (*member)(&server).marshal())
+    }
+    for _, server := range this.NextServers {
+        buf = appendBytes(buf, 2, (*member)(&server).marshal())
+    }
+    buf = appendVarint(buf, 3, uint64(this.Index))
+    buf = appendVarint(buf, 4, uint64(this.Term))
+    return appendBytes(buf, 5, []byte(this.ClusterId))
+}
+
+func (this *configuration) unmarshal(buf []byte) error {
+    return decodeFields(buf, func(num protowire.Number, value uint64, bytes []byte) error {
+        var server member
+        switch num {
+        case 1, 2:
+            if err := server.unmarshal(bytes); err != nil {
+                return err
+            }
+            if num == 1 {
+                this.Servers = append(this.Servers, raft.Server(server))
+            } else {
+                this.NextServers = append(this.NextServers, raft.Server(server))
+            }
+        case 3:
+            this.Index = int(value)
+        case 4:
+            this.Term = int(value)
+        case 5:
+            this.ClusterId = string(bytes)
+        }
+        return nil
+    })
+}
+
+type index struct {
+    index int
+    term  int
+}
+
+func (this *index) marshal() []byte {
+    buf := appendVarint(nil, 1, uint64(this.index))
+    return appendVarint(buf, 2, uint64(this.term))
+}
+
+func (this *index) unmarshal(buf []byte) error {
+    return decodeFields(buf, func(num protowire.Number, value uint64, bytes []byte) error {
+        switch num {
+        case 1:
+            this.index = int(value)
+        case 2:
+            this.term = int(value)
+        }
+        return nil
+    })
+}
+
+type drainRequest struct {
+    undrain bool
+}
+
+func (this *drainRequest) marshal() []byte {
+    return appendBool(nil, 1, this.undrain)
+}
+
+func (this *drainRequest) unmarshal(buf []byte) error {
+    return decodeFields(buf, func(num protowire.Number, value uint64, bytes []byte) error {
+        if num == 1 {
+            this.undrain = value != 0
+        }
+        return nil
+    })
+}
+
+type nodeStatus raft.Status
+
+func (this *nodeStatus) marshal() []byte {
+    buf := appendBytes(nil, 1, []byte(this.Id))
+    buf = appendVarint(buf, 2, uint64(this.Term))
+    buf = appendBytes(buf, 3, []byte(this.Role.String()))
+    buf = appendBytes(buf, 4, []byte(this.LeaderId))
+    buf = appendTime(buf, 5, this.LastContact)
+    buf = appendVarint(buf, 6, uint64(this.CommitIndex))
+    buf = appendVarint(buf, 7, uint64(this.LastApplied))
+    buf = appendVarint(buf, 8, uint64(this.LastLogIndex))
+    buf = appendVarint(buf, 9, uint64(this.LastLogTerm))
+    buf = appendVarint(buf, 10, uint64(this.ConfigurationIndex))
+    buf = appendVarint(buf, 11, this.ConfigurationChecksum)
+    for _, peer := range this.Peers {
+        buf = appendBytes(buf, 12, marshalPeer(peer))
+    }
+    buf = appendBytes(buf, 13, []byte(this.LeaderAddress))
+    buf = appendBytes(buf, 14, []byte(this.Locality.Region))
+    buf = appendBytes(buf, 15, []byte(this.Locality.Zone))
+    return appendBool(buf, 16, this.Draining)
+}
+
+func (this *nodeStatus) unmarshal(buf []byte) error {
+    return decodeFields(buf, func(num protowire.Number, value uint64, bytes []byte) error {
+        switch num {
+        case 1:
+            this.Id = raft.ServerId(bytes)
+        case 2:
+            this.Term = int(value)
+        case 3:
+            this.Role = parseRole(string(bytes))
+        case 4:
+            this.LeaderId = raft.ServerId(bytes)
+        case 5:
+            this.LastContact = decodeTime(value)
+        case 6:
+            this.CommitIndex = int(value)
+        case 7:
+            this.LastApplied = int(value)
+        case 8:
+            this.LastLogIndex = int(value)
+        case 9:
+            this.LastLogTerm = int(value)
+        case 10:
+            this.ConfigurationIndex = int(value)
+        case 11:
+            this.ConfigurationChecksum = value
+        case 12:
+            peer, err := unmarshalPeer(bytes)
+            if err != nil {
+                return err
+            }
+            this.Peers = append(this.Peers, peer)
+        case 13:
+            this.LeaderAddress = raft.ServerAddress(bytes)
+        case 14:
+            this.Locality.Region = string(bytes)
+        case 15:
+            this.Locality.Zone = string(bytes)
+        case 16:
+            this.Draining = value != 0
+        }
+        return nil
+    })
+}
+
+func marshalPeer(peer raft.PeerStatus) []byte {
+    buf := appendBytes(nil, 1, []byte(peer.Id))
+    buf = appendVarint(buf, 2, uint64(peer.NextIndex))
+    buf = appendVarint(buf, 3, uint64(peer.MatchIndex))
+    buf = appendTime(buf, 4, peer.LastContact)
+    buf = appendBool(buf, 5, peer.Reachable)
+    buf = appendVarint(buf, 6, uint64(peer.Failures))
+    buf = appendVarint(buf, 7, uint64(peer.EntriesBehind))
+    buf = appendVarint(buf, 8, uint64(peer.BytesBehind))
+    buf = appendBool(buf, 9, peer.Witness)
+    buf = appendBool(buf, 10, peer.Replica)
+    buf = appendBytes(buf, 11, []byte(peer.Locality.Region))
+    return appendBytes(buf, 12, []byte(peer.Locality.Zone))
+}
+
+func unmarshalPeer(buf []byte) (raft.PeerStatus, error) {
+    var peer raft.PeerStatus
+    err := decodeFields(buf, func(num protowire.Number, value uint64, bytes []byte) error {
+        switch num {
+        case 1:
+            peer.Id = raft.ServerId(bytes)
+        case 2:
+            peer.NextIndex = int(value)
+        case 3:
+            peer.MatchIndex = int(value)
+        case 4:
+            peer.LastContact = decodeTime(value)
+        case 5:
+            peer.Reachable = value != 0
+        case 6:
+            peer.Failures = int(value)
+        case 7:
+            peer.EntriesBehind = int(value)
+        case 8:
+            peer.BytesBehind = int(value)
+        case 9:
+            peer.Witness = value != 0
+        case 10:
+            peer.Replica = value != 0
+        case 11:
+            peer.Locality.Region = string(bytes)
+        case 12:
+            peer.Locality.Zone = string(bytes)
+        }
+        return nil
+    })
+    return peer, err
+}
+
+// parseRole returns the role String names.
+func parseRole(name string) raft.NodeType {
+    for _, role := range []raft.NodeType{raft.Follower, raft.Candidate, raft.Leader} {
+        if role.String() == name {
+            return role
+        }
+    }
+    return raft.Follower
+}
+
+// appendTime appends a time as a sint64 field of nanoseconds since
+// the Unix epoch, unless it is zero.
+func appendTime(buf []byte, num protowire.Number, t time.Time) []byte {
+    if t.IsZero() {
+        return buf
+    }
+    return appendVarint(buf, num, protowire.EncodeZigZag(t.UnixNano()))
+}
+
+func decodeTime(value uint64) time.Time {
+    if value == 0 {
+        return time.Time{}
+    }
+    return time.Unix(0, protowire.DecodeZigZag(value))
+}
+
+func appendBool(buf []byte, num protowire.Number, value bool) []byte {
+    if !value {
+        return buf
+    }
+    return appendVarint(buf, num, 1)
+}
+
+// appendVarint appends a varint field, unless value is zero, which
+// proto3 leaves out.
+func appendVarint(buf []byte, num protowire.Number, value uint64) []byte {
+    if value == 0 {
+        return buf
+    }
+    buf = protowire.AppendTag(buf, num, protowire.VarintType)
+    return protowire.AppendVarint(buf, value)
+}
+
+// appendBytes appends a length-delimited field, unless value is
+// empty, which proto3 leaves out.
+func appendBytes(buf []byte, num protowire.Number, value []byte) []byte {
+    if len(value) == 0 {
+        return buf
+    }
+    buf = protowire.AppendTag(buf, num, protowire.BytesType)
+    return protowire.AppendBytes(buf, value)
+}
+
+// decodeFields calls field with the number and value of every varint
+// and length-delimited field of the message in buf, skipping the
+// others.
+func decodeFields(buf []byte, field func(num protowire.Number, value uint64, bytes []byte) error) error {
+    for len(buf) > 0 {
+        num, typ, n := protowire.ConsumeTag(buf)
+        if n < 0 {
+            return protowire.ParseError(n)
+        }
+        buf = buf[n:]
+
+        var err error
+        switch typ {
+        case protowire.VarintType:
+            var value uint64
+            value, n = protowire.ConsumeVarint(buf)
+            if n >= 0 {
+                err = field(num, value, nil)
+            }
+        case protowire.BytesType:
+            var bytes []byte
+            bytes, n = protowire.ConsumeBytes(buf)
+            if n >= 0 {
+                err = field(num, 0, bytes)
+            }
+        default:
+            n = protowire.ConsumeFieldValue(num, typ, buf)
+        }
+        if n < 0 {
+            return protowire.ParseError(n)
+        }
+        if err != nil {
+            return err
+        }
+        buf = buf[n:]
+    }
+    return nil
+}
@@ -0,0 +1,49 @@
+package raft
+
+// dispatchCommitted queues the committed entries not yet admitted
+// for the applier, as many as fit in Config.ApplyQueue; the applier
+// queues the rest as it catches up. Must be called with this.mu
+// held.
+//
+// Entries are admitted, and their client sessions updated, as they
+// are queued, so lastApplied lags the sessions until the applier
+// has drained the queue.
+func (this *Node) dispatchCommitted() {
+    // Callers of Snapshot wait for the queue to drain.
+    if this.shutdown || len(this.snapshotRequests) > 0 {
+        return
+    }
+    index := minInt(this.commitIndex, this.lastApplied+this.config.ApplyQueue)
+    if index <= this.admitted {
+        return
+    }
+    // Each batch holds at least one entry, so the channel, sized
+    // to ApplyQueue, has room for it.
+    this.applyQueue <- this.admitCommitted(index)
+    this.reportLogGauges()
+}
+
+// runApplier applies the batches queued by dispatchCommitted until
+// Shutdown closes the queue. The state machine is called without
+// this.mu held, so the node keeps replicating and committing while
+// it applies.
+func (this *Node) runApplier() {
+    defer this.rpcs.Done()
+    for batch := range this.applyQueue {
+        this.mu.Lock()
+        stopped := this.shutdown
+        this.mu.Unlock()
+        if stopped {
+            continue
+        }
+
+        this.applyCommands(batch)
+
+        this.mu.Lock()
+        if !this.shutdown {
+            this.finishApplied(batch)
+            this.dispatchCommitted()
+        }
+        this.mu.Unlock()
+    }
+}
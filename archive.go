@@ -0,0 +1,244 @@
+package raft
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "sort"
+    "time"
+)
+
+// LogArchive receives the entries log compaction removes, so that
+// the full history of the log is kept outside the active log.
+type LogArchive interface {
+    // Archive stores entries, which are contiguous and follow the
+    // entries archived before, unless this node installed a
+    // snapshot from the leader in between: the entries the
+    // snapshot covers never reach this node's archive. It is
+    // called with the node's lock held and must not retain
+    // entries.
+    Archive(entries []Entry) error
+}
+
+// SnapshotArchive is implemented by a LogArchive that also keeps the
+// snapshots the compacted entries are replaced by, so that the state
+// at any archived index can be rebuilt from the snapshot before it
+// and the entries after.
+type SnapshotArchive interface {
+    // ArchiveSnapshot stores snapshot, as SnapshotFuture.Open reads
+    // it out, before the log is compacted up to meta.Index. The
+    // snapshot is not kept if it fails. It is called with the
+    // node's lock held and must not retain snapshot.
+    ArchiveSnapshot(meta SnapshotMeta, snapshot []byte) error
+}
+
+// archiveLog hands the entries up to and including index, which are
+// about to be compacted, to Config.Archive. Must be called with
+// this.mu held.
+func (this *Node) archiveLog(index int) error {
+    if this.config.Archive == nil || index <= this.log[0].Index {
+        return nil
+    }
+    entries := this.log[1 : index-this.log[0].Index+1]
+    if err := this.config.Archive.Archive(entries); err != nil {
+        this.logError("archiving compacted entries failed", "index", index, "err", err)
+        return err
+    }
+    return nil
+}
+
+// archiveSnapshot hands snapshot, about to replace the compacted
+// log, to Config.Archive if it keeps snapshots. Must be called with
+// this.mu held.
+func (this *Node) archiveSnapshot(snapshot []byte) error {
+    archive, ok := this.config.Archive.(SnapshotArchive)
+    if !ok {
+        return nil
+    }
+    meta, err := ReadSnapshotMeta(snapshot)
+    if err == nil {
+        err = archive.ArchiveSnapshot(meta, snapshot)
+    }
+    if err != nil {
+        this.logError("archiving snapshot failed", "index", meta.Index, "err", err)
+    }
+    return err
+}
+
+// DirArchive is a LogArchive keeping each batch of archived entries
+// in a segment file of its own, named after the first and last index
+// it holds, with one JSON-encoded entry per line, and each snapshot
+// in a file named after the index and term it covers.
+type DirArchive struct {
+    dir string
+}
+
+// NewDirArchive returns an archive in dir, creating it if needed.
+func NewDirArchive(dir string) (*DirArchive, error) {
+    if err := os.MkdirAll(dir, 0o755); err != nil {
+        return nil, err
+    }
+    return &DirArchive{dir: dir}, nil
+}
+
+func (this *DirArchive) Archive(entries []Entry) error {
+    if len(entries) == 0 {
+        return nil
+    }
+    segment, err := encodeSegment(entries)
+    if err != nil {
+        return err
+    }
+    return this.write(segmentName(entries), segment)
+}
+
+func (this *DirArchive) ArchiveSnapshot(meta SnapshotMeta, snapshot []byte) error {
+    return this.write(snapshotName(meta), snapshot)
+}
+
+// write creates the file name holding data, atomically.
+func (this *DirArchive) write(name string, data []byte) error {
+    file, err := os.CreateTemp(this.dir, name+".tmp*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(file.Name())
+
+    if _, err := file.Write(data); err != nil {
+        file.Close()
+        return err
+    }
+    if err := file.Sync(); err != nil {
+        file.Close()
+        return err
+    }
+    if err := file.Close(); err != nil {
+        return err
+    }
+    return os.Rename(file.Name(), filepath.Join(this.dir, name))
+}
+
+// Read passes every archived entry to fn in log order, stopping at
+// the first error fn returns.
+func (this *DirArchive) Read(fn func(Entry) error) error {
+    names, err := filepath.Glob(filepath.Join(this.dir, "*-*.log"))
+    if err != nil {
+        return err
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        if err := readSegment(name, fn); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func readSegment(name string, fn func(Entry) error) error {
+    file, err := os.Open(name)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    if err := ReadSegment(bufio.NewReader(file), fn); err != nil {
+        return fmt.Errorf("raft: archive segment %s: %w", filepath.Base(name), err)
+    }
+    return nil
+}
+
+// ReadSegment passes every entry of a segment written by DirArchive
+// or ObjectArchive to fn, in log order, stopping at the first error
+// fn returns.
+func ReadSegment(reader io.Reader, fn func(Entry) error) error {
+    decoder := json.NewDecoder(reader)
+    for decoder.More() {
+        var entry Entry
+        if err := decoder.Decode(&entry); err != nil {
+            return err
+        }
+        if err := fn(entry); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// encodeSegment encodes entries one JSON object per line.
+func encodeSegment(entries []Entry) ([]byte, error) {
+    var buf bytes.Buffer
+    encoder := json.NewEncoder(&buf)
+    for _, entry := range entries {
+        if err := encoder.Encode(entry); err != nil {
+            return nil, err
+        }
+    }
+    return buf.Bytes(), nil
+}
+
+// segmentName names a segment after the first and last index of
+// entries, so that segments sort in log order.
+func segmentName(entries []Entry) string {
+    return fmt.Sprintf("%020d-%020d.log", entries[0].Index, entries[len(entries)-1].Index)
+}
+
+// snapshotName names a snapshot after the index and term it covers.
+func snapshotName(meta SnapshotMeta) string {
+    return fmt.Sprintf("%020d-%020d.snap", meta.Index, meta.Term)
+}
+
+// ObjectStore is the part of an object storage service, such as S3
+// or GCS, an ObjectArchive needs.
+type ObjectStore interface {
+    // Put stores data under key, replacing any object there.
+    Put(ctx context.Context, key string, data []byte) error
+}
+
+// DefaultObjectArchiveTimeout bounds each upload of an ObjectArchive.
+const DefaultObjectArchiveTimeout = 30 * time.Second
+
+// ObjectArchive is a LogArchive and SnapshotArchive uploading
+// segments and snapshots, named as by DirArchive, to an object
+// store under a prefix, for point-in-time recovery and audit. Uploads
+// are made with the node's lock held, so a slow store holds up the
+// node until Timeout; a failed upload leaves the log uncompacted, to
+// be archived again with the next snapshot.
+type ObjectArchive struct {
+    store  ObjectStore
+    prefix string
+
+    // Bounds each upload; DefaultObjectArchiveTimeout unless set.
+    Timeout time.Duration
+}
+
+// NewObjectArchive returns an archive uploading to store, with keys
+// starting with prefix.
+func NewObjectArchive(store ObjectStore, prefix string) *ObjectArchive {
+    return &ObjectArchive{store: store, prefix: prefix, Timeout: DefaultObjectArchiveTimeout}
+}
+
+func (this *ObjectArchive) Archive(entries []Entry) error {
+    if len(entries) == 0 {
+        return nil
+    }
+    segment, err := encodeSegment(entries)
+    if err != nil {
+        return err
+    }
+    return this.put("log/"+segmentName(entries), segment)
+}
+
+func (this *ObjectArchive) ArchiveSnapshot(meta SnapshotMeta, snapshot []byte) error {
+    return this.put("snapshots/"+snapshotName(meta), snapshot)
+}
+
+func (this *ObjectArchive) put(key string, data []byte) error {
+    ctx, cancel := context.WithTimeout(context.Background(), this.Timeout)
+    defer cancel()
+    return this.store.Put(ctx, this.prefix+key, data)
+}
@@ -0,0 +1,154 @@
+package raft
+
+import (
+    "encoding/binary"
+    "hash/fnv"
+)
+
+// Number of applied entries whose checksums a node remembers.
+const auditHistory = 1024
+
+// AuditOptions configure the anti-entropy audit, in which the leader
+// periodically asks its followers for a rolling checksum of their
+// applied log prefix and compares it with its own. Committed entries
+// are identical on every node, so any difference means storage
+// corruption or a nondeterministic state machine.
+type AuditOptions struct {
+    // Ticks between audits. Zero disables auditing.
+    Interval int
+
+    // Called with every divergence found, besides logging it as an
+    // error. It runs with the node's lock held and must not call
+    // back into the Node.
+    OnDivergence func(Divergence)
+}
+
+// Divergence reports a follower whose applied log differs from the
+// leader's.
+type Divergence struct {
+    LeaderId ServerId
+    PeerId   ServerId
+
+    // The last index applied by the peer, and the checksums of the
+    // log up to it on both nodes.
+    Index        int
+    Checksum     uint64
+    PeerChecksum uint64
+}
+
+type AuditRequest struct {
+    ProtocolVersion int
+    Term            int
+    LeaderId        ServerId
+}
+
+type AuditResponse struct {
+    ProtocolVersion int
+    Term            int
+    Index           int
+    Checksum        uint64
+}
+
+// AuditRPC is invoked by the leader to collect the checksum of the
+// log applied by this node.
+func (this *Node) AuditRPC(term int, leaderId ServerId) (termResult, index int, checksum uint64) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    if this.shutdown {
+        return this.currentTerm, 0, 0
+    }
+    this.testToAbdicateLeadership(term, leaderId)
+    // A witness holds no commands to check; index 0 tells the
+    // leader to skip it.
+    if this.config.Witness {
+        return this.currentTerm, 0, 0
+    }
+    return this.currentTerm, this.lastApplied, this.checksum
+}
+
+// recordChecksum folds entry, the next entry applied, into the
+// rolling checksum. Must be called with this.mu held.
+func (this *Node) recordChecksum(entry Entry) {
+    var buf [17]byte
+    binary.BigEndian.PutUint64(buf[:8], this.checksum)
+    binary.BigEndian.PutUint64(buf[8:16], uint64(entry.TermNum))
+    buf[16] = byte(entry.Type)
+    hash := fnv.New64a()
+    hash.Write(buf[:])
+    hash.Write(entry.Command)
+    this.checksum = hash.Sum64()
+
+    this.checksums = append(this.checksums, this.checksum)
+    if len(this.checksums) > auditHistory {
+        this.checksums = this.checksums[len(this.checksums)-auditHistory:]
+    }
+}
+
+// resetChecksum restarts the rolling checksum from a snapshot.
+// Must be called with this.mu held.
+func (this *Node) resetChecksum(checksum uint64) {
+    this.checksum = checksum
+    this.checksums = nil
+}
+
+// checksumAt returns the rolling checksum of the log applied up to
+// index, if still remembered. Must be called with this.mu held.
+func (this *Node) checksumAt(index int) (uint64, bool) {
+    offset := len(this.checksums) - 1 - (this.lastApplied - index)
+    if index == this.lastApplied {
+        return this.checksum, true
+    }
+    if index > this.lastApplied || offset < 0 {
+        return 0, false
+    }
+    return this.checksums[offset], true
+}
+
+// broadcastAudit asks every follower for its checksum. Must be
+// called with this.mu held.
+func (this *Node) broadcastAudit() {
+    req := AuditRequest{Term: this.currentTerm, LeaderId: this.id}
+    for _, peer := range this.peers {
+        if peer == this.id {
+            continue
+        }
+        peerId := peer
+        this.transport.Audit(peerId, req, func(resp AuditResponse, err error) {
+            this.handleAuditReply(peerId, req, resp, err)
+        })
+    }
+}
+
+// handleAuditReply compares a follower's checksum with the leader's
+// for the same index.
+func (this *Node) handleAuditReply(peerId ServerId, req AuditRequest, resp AuditResponse, err error) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    if this.shutdown || err != nil {
+        return
+    }
+    this.testToAbdicateLeadership(resp.Term, "")
+    if this.nodeType != Leader || this.currentTerm != req.Term || resp.Index == 0 {
+        return
+    }
+    checksum, ok := this.checksumAt(resp.Index)
+    if !ok || checksum == resp.Checksum {
+        return
+    }
+
+    divergence := Divergence{
+        LeaderId:     this.id,
+        PeerId:       peerId,
+        Index:        resp.Index,
+        Checksum:     checksum,
+        PeerChecksum: resp.Checksum,
+    }
+    this.metrics.IncrCounter(MetricDivergences, this.labels(LabelPeer, string(peerId)), 1)
+    this.logError("peer's applied log diverges from leader's", "peer", peerId,
+        "index", resp.Index, "checksum", resp.Checksum, "leaderChecksum", checksum)
+    if this.config.Audit.OnDivergence != nil {
+        this.config.Audit.OnDivergence(divergence)
+    }
+}
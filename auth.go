@@ -0,0 +1,168 @@
+package raft
+
+import (
+    "bytes"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "io"
+    "net/http"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// ErrUnauthenticated is the reason an HMACAuth handler gives for
+// refusing a request that is unsigned, signed with an unknown key,
+// stale or replayed.
+var ErrUnauthenticated = errors.New("raft: request not authenticated")
+
+// DefaultMaxClockSkew is how far the timestamp of a signed request
+// may be from the receiver's clock, unless HMACAuth.MaxClockSkew
+// says otherwise.
+const DefaultMaxClockSkew = 30 * time.Second
+
+// The headers carrying the signature of a request.
+const (
+    headerTimestamp = "X-Raft-Timestamp"
+    headerNonce     = "X-Raft-Nonce"
+    headerSignature = "X-Raft-Signature"
+)
+
+// HMACAuth signs the RPCs an HTTPTransport sends with a key shared
+// by the cluster, and checks them at the handler, so that only
+// holders of the key can reach the nodes, where TLS cannot be run:
+//
+//    auth := raft.NewHMACAuth(key)
+//    transport := raft.NewHTTPTransport(addresses, nil, raft.WithHTTPAuth(auth))
+//    http.ListenAndServe(":8300", auth.Handler(raft.NewHTTPHandler(node)))
+//
+// A signature covers the path, the body as sent, a timestamp and a
+// random nonce. A handler refuses requests whose timestamp is more
+// than MaxClockSkew away from its clock, and nonces it has already
+// seen within that time, so that a recorded request cannot be
+// played again. Responses are not signed, and nothing is encrypted.
+//
+// To change keys without downtime, give every node the new key as a
+// previous one, then make it the key everywhere, then drop the old.
+type HMACAuth struct {
+    key      []byte
+    previous [][]byte
+
+    // How far the clocks of the nodes may drift apart; zero is
+    // DefaultMaxClockSkew.
+    MaxClockSkew time.Duration
+
+    // The nonces seen, and until when each is remembered.
+    mu     sync.Mutex
+    nonces map[string]time.Time
+    sweep  time.Time
+}
+
+// NewHMACAuth returns an HMACAuth signing with key, and accepting
+// requests signed with key or any of previous.
+func NewHMACAuth(key []byte, previous ...[]byte) *HMACAuth {
+    return &HMACAuth{key: key, previous: previous, nonces: make(map[string]time.Time)}
+}
+
+// WithHTTPAuth signs every request with auth.
+func WithHTTPAuth(auth *HMACAuth) HTTPOption {
+    return func(this *HTTPTransport) {
+        this.auth = auth
+    }
+}
+
+// sign adds the signature of body, the request's body as sent, to
+// req.
+func (this *HMACAuth) sign(req *http.Request, body []byte) error {
+    nonce := make([]byte, 16)
+    if _, err := rand.Read(nonce); err != nil {
+        return err
+    }
+    timestamp := strconv.FormatInt(time.Now().UnixNano(), 10)
+    req.Header.Set(headerTimestamp, timestamp)
+    req.Header.Set(headerNonce, hex.EncodeToString(nonce))
+    req.Header.Set(headerSignature, hex.EncodeToString(
+        signature(this.key, req.URL.Path, timestamp, req.Header.Get(headerNonce), body)))
+    return nil
+}
+
+// Handler returns a handler passing requests signed by a holder of
+// the key on to next, and refusing others with status 401.
+func (this *HMACAuth) Handler(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        body, err := io.ReadAll(r.Body)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        if err := this.verify(r, body, time.Now()); err != nil {
+            http.Error(w, err.Error(), http.StatusUnauthorized)
+            return
+        }
+        r.Body = io.NopCloser(bytes.NewReader(body))
+        next.ServeHTTP(w, r)
+    })
+}
+
+// verify checks the signature of r, whose body is body, as of now.
+func (this *HMACAuth) verify(r *http.Request, body []byte, now time.Time) error {
+    timestamp, nonce := r.Header.Get(headerTimestamp), r.Header.Get(headerNonce)
+    given, err := hex.DecodeString(r.Header.Get(headerSignature))
+    if err != nil || len(given) == 0 || nonce == "" {
+        return ErrUnauthenticated
+    }
+    valid := false
+    for _, key := range append([][]byte{this.key}, this.previous...) {
+        if hmac.Equal(given, signature(key, r.URL.Path, timestamp, nonce, body)) {
+            valid = true
+            break
+        }
+    }
+    if !valid {
+        return ErrUnauthenticated
+    }
+
+    // Only a signed timestamp is worth checking: the nonces of
+    // requests signed at most skew ago are remembered for as long
+    // again, so a request can never be replayed.
+    nanos, err := strconv.ParseInt(timestamp, 10, 64)
+    if err != nil {
+        return ErrUnauthenticated
+    }
+    skew := this.MaxClockSkew
+    if skew <= 0 {
+        skew = DefaultMaxClockSkew
+    }
+    if sent := time.Unix(0, nanos); sent.Before(now.Add(-skew)) || sent.After(now.Add(skew)) {
+        return ErrUnauthenticated
+    }
+
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    if now.After(this.sweep) {
+        for nonce, expiry := range this.nonces {
+            if now.After(expiry) {
+                delete(this.nonces, nonce)
+            }
+        }
+        this.sweep = now.Add(skew)
+    }
+    if _, seen := this.nonces[nonce]; seen {
+        return ErrUnauthenticated
+    }
+    this.nonces[nonce] = now.Add(2 * skew)
+    return nil
+}
+
+// signature is the HMAC-SHA256, under key, of a request to path
+// with body, signed at timestamp with nonce.
+func signature(key []byte, path, timestamp, nonce string, body []byte) []byte {
+    bodyHash := sha256.Sum256(body)
+    mac := hmac.New(sha256.New, key)
+    io.WriteString(mac, path+"\n"+timestamp+"\n"+nonce+"\n")
+    mac.Write(bodyHash[:])
+    return mac.Sum(nil)
+}
@@ -0,0 +1,27 @@
+package raft
+
+// backoffTicks returns the ticks a leader waits before sending again
+// to a peer that has failed to answer failures RPCs in a row.
+func (this *Node) backoffTicks(failures int) int {
+    if this.config.MaxBackoffTicks == 0 {
+        return 0
+    }
+    wait := this.config.HeartbeatTicks << minInt(failures-1, 16)
+    wait = minInt(wait, this.config.MaxBackoffTicks)
+    return minInt(wait, this.config.ElectionTicksMin-this.config.HeartbeatTicks)
+}
+
+// tickBackoff counts down the backoff of the peers that failed to
+// answer, retrying each once its backoff has elapsed. Must be called
+// with this.mu held, as leader.
+func (this *Node) tickBackoff() {
+    for i := range this.peers {
+        if this.backoff[i] == 0 {
+            continue
+        }
+        this.backoff[i]--
+        if this.backoff[i] == 0 {
+            this.replicateTo(i, true)
+        }
+    }
+}
@@ -0,0 +1,97 @@
+package raft
+
+import (
+    "bytes"
+    "errors"
+    "io"
+)
+
+// ErrNoSnapshotter is returned by Snapshot on a node configured
+// without a Snapshotter.
+var ErrNoSnapshotter = errors.New("raft: no Snapshotter configured")
+
+// SnapshotFuture tracks a snapshot requested with Snapshot.
+type SnapshotFuture struct {
+    snapshot []byte
+    err      error
+    done     chan struct{}
+}
+
+// Error blocks until the snapshot has been taken, or has failed,
+// and returns nil or the reason it failed.
+func (this *SnapshotFuture) Error() error {
+    <-this.done
+    return this.err
+}
+
+// Done is closed once Error would no longer block.
+func (this *SnapshotFuture) Done() <-chan struct{} {
+    return this.done
+}
+
+// Open returns the metadata of the snapshot and a reader of the
+// snapshot itself, as sent with InstallSnapshot and decoded by
+// ReadSnapshotMeta, for operators to back it up. It blocks until
+// the snapshot has been taken.
+func (this *SnapshotFuture) Open() (SnapshotMeta, io.ReadCloser, error) {
+    if err := this.Error(); err != nil {
+        return SnapshotMeta{}, nil, err
+    }
+    meta, err := ReadSnapshotMeta(this.snapshot)
+    if err != nil {
+        return SnapshotMeta{}, nil, err
+    }
+    return meta, io.NopCloser(bytes.NewReader(this.snapshot)), nil
+}
+
+// respond resolves the future. Must be called at most once.
+func (this *SnapshotFuture) respond(err error) {
+    this.err = err
+    close(this.done)
+}
+
+// Snapshot snapshots the state machine now and compacts the log,
+// regardless of Config.SnapshotThreshold, the SnapshotSchedule and
+// the SnapshotLimiter. If nothing has been applied since the latest
+// snapshot, the future holds that one. With Config.ApplyQueue, the
+// node stops queueing entries until the applier has drained the
+// queue and the snapshot is taken.
+func (this *Node) Snapshot() *SnapshotFuture {
+    future := &SnapshotFuture{done: make(chan struct{})}
+
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    switch {
+    case this.shutdown:
+        future.respond(ErrRaftShutdown)
+    case this.config.Snapshotter == nil:
+        future.respond(ErrNoSnapshotter)
+    default:
+        this.snapshotRequests = append(this.snapshotRequests, future)
+        this.serveSnapshotRequests()
+    }
+    return future
+}
+
+// serveSnapshotRequests takes the snapshot callers of Snapshot are
+// waiting for, once no entries are queued for the applier. Must be
+// called with this.mu held.
+func (this *Node) serveSnapshotRequests() {
+    if len(this.snapshotRequests) == 0 || this.admitted > this.lastApplied {
+        return
+    }
+    var err error
+    if this.snapshot == nil || this.lastApplied > this.log[0].Index {
+        err = this.takeSnapshot()
+    }
+    for _, future := range this.snapshotRequests {
+        future.snapshot = this.snapshot
+        future.respond(err)
+    }
+    this.snapshotRequests = nil
+
+    if this.applyQueue != nil {
+        this.dispatchCommitted()
+    }
+}
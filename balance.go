@@ -0,0 +1,157 @@
+package raft
+
+import (
+    "context"
+    "errors"
+    "time"
+)
+
+// GroupLeadership is the leadership of a group hosted by a
+// MultiNode, as its node there sees it.
+type GroupLeadership struct {
+    Group string
+
+    // The process leading the group; empty if unknown.
+    Leader ServerId
+
+    // The processes that could lead the group: its members, less
+    // the witnesses the leader knows of.
+    Candidates []ServerId
+}
+
+// LeadershipMove asks for the leadership of a group this process
+// leads to be handed to another process.
+type LeadershipMove struct {
+    Group string
+    To    ServerId
+}
+
+// LeaseholderBalancer chooses which of the groups led by the process
+// with ID self should be handed to other processes, given the
+// leadership of every group the process hosts, so that leaders, and
+// the load of serving writes, are spread evenly across processes.
+// Each process decides for its own groups only, so balancers should
+// move leadership away from a process rather than towards it.
+type LeaseholderBalancer func(self ServerId, groups []GroupLeadership) []LeadershipMove
+
+// BalanceLeaders is a LeaseholderBalancer evening out the number of
+// groups each process leads. While this process leads more than its
+// share, it hands groups to the candidates leading the fewest, as
+// long as that narrows the gap between them.
+func BalanceLeaders(self ServerId, groups []GroupLeadership) []LeadershipMove {
+    counts := make(map[ServerId]int)
+    leaders := 0
+    for _, group := range groups {
+        for _, candidate := range group.Candidates {
+            counts[candidate] += 0
+        }
+        if group.Leader != "" {
+            counts[group.Leader]++
+            leaders++
+        }
+    }
+    if len(counts) == 0 {
+        return nil
+    }
+    share := (leaders + len(counts) - 1) / len(counts)
+
+    var moves []LeadershipMove
+    for _, group := range groups {
+        if counts[self] <= share {
+            break
+        }
+        if group.Leader != self {
+            continue
+        }
+        to := ServerId("")
+        for _, candidate := range group.Candidates {
+            if candidate != self && (to == "" || counts[candidate] < counts[to]) {
+                to = candidate
+            }
+        }
+        if to == "" || counts[to]+1 >= counts[self] {
+            continue
+        }
+        counts[self]--
+        counts[to]++
+        moves = append(moves, LeadershipMove{Group: group.Group, To: to})
+    }
+    return moves
+}
+
+// Leadership returns the leadership of every group hosted, ordered
+// by group.
+func (this *MultiNode) Leadership() []GroupLeadership {
+    var groups []GroupLeadership
+    for _, group := range this.Groups() {
+        node := this.Group(group)
+        if node == nil {
+            continue
+        }
+        node.mu.Lock()
+        leadership := GroupLeadership{Group: group, Leader: node.leaderId}
+        for _, peer := range node.peers {
+            if !node.witnesses[peer] {
+                leadership.Candidates = append(leadership.Candidates, peer)
+            }
+        }
+        node.mu.Unlock()
+        groups = append(groups, leadership)
+    }
+    return groups
+}
+
+// Rebalance asks balancer which groups to hand to other processes
+// and transfers their leadership, one group at a time, returning
+// the errors of the transfers that failed.
+func (this *MultiNode) Rebalance(ctx context.Context, balancer LeaseholderBalancer) error {
+    var errs []error
+    for _, move := range balancer(this.id, this.Leadership()) {
+        node := this.Group(move.Group)
+        if node == nil {
+            continue
+        }
+        if err := node.LeadershipTransferTo(ctx, move.To); err != nil {
+            errs = append(errs, err)
+        }
+        if ctx.Err() != nil {
+            break
+        }
+    }
+    return errors.Join(errs...)
+}
+
+// SetBalancer has the ticker started by Start call Rebalance with
+// balancer every interval, in a goroutine of its own, skipping
+// rounds while the last one is still under way. A nil balancer
+// stops rebalancing.
+func (this *MultiNode) SetBalancer(balancer LeaseholderBalancer, interval time.Duration) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    this.balancer = balancer
+    this.balanceInterval = interval
+}
+
+// maybeRebalance starts a round of rebalancing if one is due as of
+// now. Called by the ticker.
+func (this *MultiNode) maybeRebalance(now time.Time) {
+    this.mu.Lock()
+    balancer := this.balancer
+    if balancer == nil || this.balancing || now.Before(this.lastBalance.Add(this.balanceInterval)) {
+        this.mu.Unlock()
+        return
+    }
+    this.balancing = true
+    this.lastBalance = now
+    this.mu.Unlock()
+
+    go func() {
+        ctx, cancel := context.WithTimeout(context.Background(), this.balanceInterval)
+        defer cancel()
+        this.Rebalance(ctx, balancer)
+
+        this.mu.Lock()
+        this.balancing = false
+        this.mu.Unlock()
+    }()
+}
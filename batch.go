@@ -0,0 +1,131 @@
+package raft
+
+import (
+    "time"
+)
+
+// BatchOptions controls how a leader coalesces Propose calls into
+// a single log append and AppendEntries round.
+type BatchOptions struct {
+    // Maximum number of proposals appended to the log together.
+    MaxEntries int
+
+    // Maximum total command bytes appended to the log together. A
+    // single command larger than this still forms its own batch.
+    MaxBytes int
+
+    // How long a proposal may wait for others to join its batch,
+    // rounded up to whole ticks. Zero appends every proposal as soon
+    // as it arrives; proposals still coalesce while a follower's
+    // in-flight window is full.
+    FlushInterval time.Duration
+}
+
+// DefaultBatchOptions appends proposals immediately.
+var DefaultBatchOptions = BatchOptions{
+    MaxEntries:    256,
+    MaxBytes:      1 << 20,
+    FlushInterval: 0,
+}
+
+// flushTicks is the FlushInterval expressed in ticks.
+func (this *Node) flushTicks() int {
+    interval := this.config.TickInterval
+    return int((this.config.Batch.FlushInterval + interval - 1) / interval)
+}
+
+// batchFull reports whether the queued proposals reached a batch
+// limit and should be appended without waiting.
+func (this *Node) batchFull() bool {
+    return this.config.Batch.FlushInterval <= 0 ||
+        len(this.proposals) >= this.config.Batch.MaxEntries ||
+        this.proposalBytes >= this.config.Batch.MaxBytes
+}
+
+// flushProposals appends every queued proposal to the log in one
+// batch and replicates it, unless a restore is waiting for the log
+// to be applied. Must be called with this.mu held.
+func (this *Node) flushProposals() {
+    if len(this.proposals) == 0 || this.restore != nil {
+        return
+    }
+
+    entries := make([]Entry, len(this.proposals))
+    for i, future := range this.proposals {
+        future.index = this.lastLogIndex() + 1 + i
+        future.term = this.currentTerm
+        entries[i] = Entry{
+            Type:     future.entryType,
+            Command:  future.command,
+            Index:    future.index,
+            TermNum:  future.term,
+            Priority: future.priority,
+            ClientId: future.clientId,
+            Sequence: future.sequence,
+        }
+    }
+    err := this.appendLog(entries...)
+    for _, future := range this.proposals {
+        if err != nil {
+            future.respond(err)
+        } else {
+            this.pending[future.index] = future
+        }
+    }
+    this.proposals = nil
+    this.proposalBytes = 0
+    this.proposalWait = 0
+    if err != nil {
+        this.abdicate(err)
+        return
+    }
+
+    this.advanceCommitIndex()
+    this.broadcastAppendEntries()
+    this.reportLogGauges()
+}
+
+// expireProposals fails the queued proposals whose deadline has
+// passed with ErrEnqueueTimeout. Must be called with this.mu held.
+func (this *Node) expireProposals(now time.Time) {
+    queued := this.proposals[:0]
+    for _, future := range this.proposals {
+        if future.deadline.IsZero() || now.Before(future.deadline) {
+            queued = append(queued, future)
+            continue
+        }
+        this.proposalBytes -= len(future.command)
+        future.respond(ErrEnqueueTimeout)
+    }
+    for i := len(queued); i < len(this.proposals); i++ {
+        this.proposals[i] = nil
+    }
+    this.proposals = queued
+}
+
+// failProposals fails every queued proposal with err. Must be
+// called with this.mu held.
+func (this *Node) failProposals(err error) {
+    for _, future := range this.proposals {
+        future.respond(err)
+    }
+    this.proposals = nil
+    this.proposalBytes = 0
+    this.proposalWait = 0
+}
+
+// failPending fails the proposals appended to the log but not yet
+// applied, as a leader stepping down cannot tell whether they will
+// be: a later leader may still commit them. A change of membership
+// fails with ErrConfigurationChangeInterrupted. Must be called with
+// this.mu held.
+func (this *Node) failPending() {
+    for index, future := range this.pending {
+        delete(this.pending, index)
+        if future.entryType == EntryConfiguration {
+            future.respond(ErrConfigurationChangeInterrupted)
+        } else {
+            future.respond(this.leadershipLost(true))
+        }
+    }
+}
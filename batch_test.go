@@ -0,0 +1,92 @@
+package raft
+
+import (
+    "context"
+    "testing"
+    "time"
+)
+
+// BenchmarkPropose proposes from many goroutines at once to the
+// leader of a three-node cluster whose nodes sync every append to a
+// WALStore, appending each proposal on its own or coalescing those
+// queued within a tick into one append, one sync and one
+// AppendEntries per follower.
+func BenchmarkPropose(b *testing.B) {
+    batches := []struct {
+        name  string
+        batch BatchOptions
+    }{
+        {"Unbatched", BatchOptions{MaxEntries: 1, MaxBytes: 1 << 20}},
+        {"Batched", BatchOptions{MaxEntries: 256, MaxBytes: 1 << 20, FlushInterval: time.Millisecond}},
+    }
+    for _, bench := range batches {
+        b.Run(bench.name, func(b *testing.B) {
+            withWAL := func(config *Config) {
+                wal, err := NewWALStore(b.TempDir(), DefaultWALOptions())
+                if err != nil {
+                    b.Fatal(err)
+                }
+                b.Cleanup(func() { wal.Close() })
+                config.LogStore = wal
+                config.Batch = bench.batch
+            }
+            // Syncing delays heartbeats: give elections time.
+            cluster := startCluster(b, 3, withWAL, WithTimeouts(5, 100, 200))
+            leader := cluster.leader(b)
+            command := make([]byte, 64)
+            b.SetParallelism(32)
+            b.ResetTimer()
+            b.RunParallel(func(pb *testing.PB) {
+                for pb.Next() {
+                    if err := leader.Propose(context.Background(), command).Error(); err != nil {
+                        b.Error(err)
+                        return
+                    }
+                }
+            })
+        })
+    }
+}
+
+func TestProposalsShareAnAppend(t *testing.T) {
+    node, err := NewRegistry().NewNode("a", nil, func([]byte) {}, WithTimeouts(3, 10, 20), func(config *Config) {
+        config.TickInterval = time.Millisecond
+        config.Batch = BatchOptions{MaxEntries: 3, MaxBytes: 1 << 20, FlushInterval: time.Hour}
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := node.BootstrapCluster(Configuration{Servers: []Server{{Id: "a"}}}); err != nil {
+        t.Fatal(err)
+    }
+    node.Start()
+    defer node.Shutdown(context.Background())
+    for node.Role() != Leader {
+        time.Sleep(time.Millisecond)
+    }
+    node.mu.Lock()
+    last := node.lastLogIndex()
+    node.mu.Unlock()
+
+    // Proposals wait for the batch to fill, then are appended
+    // together.
+    var futures []*ProposeFuture
+    for i := 0; i < 3; i++ {
+        node.mu.Lock()
+        if appended := node.lastLogIndex() - last; appended != 0 {
+            t.Fatalf("%d proposals appended before the batch was full", appended)
+        }
+        node.mu.Unlock()
+        futures = append(futures, node.Propose(context.Background(), []byte("x")))
+    }
+    for _, future := range futures {
+        if err := future.Error(); err != nil {
+            t.Fatal(err)
+        }
+    }
+    node.mu.Lock()
+    defer node.mu.Unlock()
+    if appended := node.lastLogIndex() - last; appended != 3 {
+        t.Fatalf("%d proposals appended, want 3", appended)
+    }
+}
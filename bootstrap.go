@@ -0,0 +1,120 @@
+package raft
+
+import (
+    "errors"
+    "fmt"
+)
+
+// ErrCantBootstrap is returned by BootstrapCluster on a node that
+// already has state: a term, log entries or a configuration. Only a
+// new cluster can be bootstrapped, and only once.
+var ErrCantBootstrap = errors.New("raft: bootstrap only works on new clusters")
+
+// BootstrapCluster gives a new cluster its initial membership, by
+// writing configuration as a configuration entry at index 1 of this
+// node's log. It is called on one node only, which must be among
+// the servers of configuration; the others are created without
+// peers, and join the cluster when its first leader replicates the
+// entry to them. Bootstrapping the same node twice, or a node that
+// already has state, fails with ErrCantBootstrap, so it is safe to
+// call on every start of the node that bootstraps.
+//
+// Only the servers of configuration are used; their Addresses are
+// kept in the log, and reported by Configuration unless an
+// AddressProvider resolves them.
+func (this *Node) BootstrapCluster(configuration Configuration) error {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    if this.shutdown {
+        return ErrRaftShutdown
+    }
+    servers := append([]Server(nil), configuration.Servers...)
+    if err := this.checkBootstrap(servers); err != nil {
+        return err
+    }
+    hasState, err := this.hasExistingState()
+    if err != nil {
+        return err
+    }
+    if hasState {
+        return ErrCantBootstrap
+    }
+
+    this.currentTerm = 1
+    if err := this.persistState(); err != nil {
+        this.currentTerm = 0
+        return err
+    }
+    err = this.appendLog(Entry{
+        Type:    EntryConfiguration,
+        Command: encodeMembers(Configuration{Servers: servers}),
+        Index:   1,
+        TermNum: 1,
+    })
+    if err != nil {
+        // Undo the term too, so that bootstrapping can be retried.
+        this.currentTerm = 0
+        this.persistState()
+        return err
+    }
+    this.logInfo("bootstrapped cluster", "members", this.peers)
+    return nil
+}
+
+// checkBootstrap validates the servers of an initial configuration,
+// which must include this node, sorting them by ID. Must be called
+// with this.mu held.
+func (this *Node) checkBootstrap(servers []Server) error {
+    if err := checkServers(servers); err != nil {
+        return err
+    }
+    for _, server := range servers {
+        if server.Id == this.id {
+            return nil
+        }
+    }
+    return fmt.Errorf("%w: the configuration does not include node %q", ErrInvalidConfig, this.id)
+}
+
+// checkServers validates the servers of a configuration, sorting
+// them by ID.
+func checkServers(servers []Server) error {
+    if len(servers) == 0 {
+        return fmt.Errorf("%w: the configuration has no servers", ErrInvalidConfig)
+    }
+    if len(voters(servers)) == 0 {
+        return fmt.Errorf("%w: the configuration has no voters", ErrInvalidConfig)
+    }
+    sortMembers(servers)
+    for i, server := range servers {
+        if server.Id == "" {
+            return fmt.Errorf("%w: server ID is empty", ErrInvalidConfig)
+        }
+        if i > 0 && server.Id == servers[i-1].Id {
+            return fmt.Errorf("%w: server ID %q is listed twice", ErrInvalidConfig, server.Id)
+        }
+    }
+    return nil
+}
+
+// hasExistingState reports whether the node, or its stores, hold a
+// term, log entries or a configuration. Must be called with this.mu
+// held.
+func (this *Node) hasExistingState() (bool, error) {
+    if this.currentTerm > 0 || this.lastLogIndex() > 0 || len(this.peers) > 0 {
+        return true, nil
+    }
+    term, err := this.stable.GetInt(keyCurrentTerm)
+    if err != nil && !errors.Is(err, ErrNotFound) {
+        return false, err
+    }
+    if term > 0 {
+        return true, nil
+    }
+    last, err := this.logs.LastIndex()
+    if err != nil {
+        return false, err
+    }
+    return last > 0, nil
+}
@@ -0,0 +1,274 @@
+// Package client submits commands to whichever node of a cluster
+// is the leader, following leadership as it moves.
+package client
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sort"
+    "time"
+
+    "github.com/tawawhite/raft"
+)
+
+// ErrConfigurationMismatch is returned by CheckConfiguration when
+// nodes disagree on the membership of their cluster.
+var ErrConfigurationMismatch = errors.New("client: nodes disagree on the cluster configuration")
+
+// DefaultMaxAttempts is how many times a command is submitted
+// before Propose gives up.
+const DefaultMaxAttempts = 5
+
+// Client proposes commands to a cluster. It must not be used from
+// several goroutines at once.
+type Client struct {
+    nodes map[raft.ServerId]*raft.Node
+
+    // Submissions per command, including the first.
+    MaxAttempts int
+
+    // Node believed to be the leader, or empty.
+    leader raft.ServerId
+
+    // Token of the last write, for reads to wait for.
+    token raft.ConsistencyToken
+}
+
+func New(nodes []*raft.Node) *Client {
+    this := &Client{
+        nodes:       make(map[raft.ServerId]*raft.Node, len(nodes)),
+        MaxAttempts: DefaultMaxAttempts,
+    }
+    for _, node := range nodes {
+        this.nodes[node.Id()] = node
+    }
+    return this
+}
+
+// Propose submits command to the leader and waits until it has been
+// applied. If the command is idempotent, it is resubmitted when the
+// leader loses leadership before committing it, or is not the
+// leader at all; otherwise the error is returned for the caller to
+// handle. Commands whose outcome is unknown are never resubmitted.
+func (this *Client) Propose(ctx context.Context, command []byte, idempotent bool) (*raft.ProposeFuture, error) {
+    var err error
+    for attempt := 0; attempt < this.MaxAttempts; attempt++ {
+        node := this.findLeader()
+        if node == nil {
+            return nil, raft.ErrNotLeader
+        }
+
+        future := node.Propose(ctx, command)
+        err = future.Error()
+        if err == nil {
+            this.token = future.Token()
+            return future, nil
+        }
+
+        var lost *raft.LeadershipLostError
+        var notLeader *raft.NotLeaderError
+        switch {
+        case errors.As(err, &lost):
+            this.leader = lost.LeaderId
+        case errors.As(err, &notLeader):
+            this.leader = notLeader.LeaderId
+        case errors.Is(err, raft.ErrLeadershipLostWhileCommitting):
+            this.leader = ""
+        default:
+            return nil, err
+        }
+        if !idempotent {
+            return nil, err
+        }
+    }
+    return nil, err
+}
+
+// findLeader returns the believed leader, or the leader it knows of
+// if it is no longer, or else the first node that reports being the
+// leader.
+func (this *Client) findLeader() *raft.Node {
+    if node, ok := this.nodes[this.leader]; ok {
+        if node.Role() == raft.Leader {
+            return node
+        }
+        id, _ := node.Leader()
+        if next, ok := this.nodes[id]; ok && next.Role() == raft.Leader {
+            this.leader = id
+            return next
+        }
+    }
+    for id, node := range this.nodes {
+        if node.Role() == raft.Leader {
+            this.leader = id
+            return node
+        }
+    }
+    return nil
+}
+
+// Token returns the consistency token of the last command the client
+// proposed, which Read waits for.
+func (this *Client) Token() raft.ConsistencyToken {
+    return this.token
+}
+
+// Read calls read on the node with the given ID once it has applied
+// every command the client proposed, so that the client reads its
+// own writes on any node, not only the leader it wrote to.
+func (this *Client) Read(ctx context.Context, id raft.ServerId, read func(*raft.Node) error) error {
+    node, ok := this.nodes[id]
+    if !ok {
+        return fmt.Errorf("client: unknown node %s", id)
+    }
+    if err := node.WaitForToken(ctx, this.token); err != nil {
+        return err
+    }
+    return read(node)
+}
+
+// ReadStale calls read on a node that lags the leader by no more
+// than maxStaleness, preferring followers to spare the leader. The
+// node's own Config.MaxStaleness applies too. If every node is too
+// stale, the *raft.StaleReadError of the freshest is returned. It
+// does not wait for the client's own writes, which Read does.
+func (this *Client) ReadStale(maxStaleness time.Duration, read func(*raft.Node) error) error {
+    var followers, leaders []raft.ServerId
+    for id, node := range this.nodes {
+        if node.Role() == raft.Leader {
+            leaders = append(leaders, id)
+        } else {
+            followers = append(followers, id)
+        }
+    }
+    sortIds(followers)
+
+    var freshest *raft.StaleReadError
+    for _, id := range append(followers, leaders...) {
+        node := this.nodes[id]
+        err := node.CheckStaleRead()
+        if err == nil {
+            if staleness := node.Staleness(); staleness.Lag > maxStaleness {
+                err = &raft.StaleReadError{Staleness: staleness, MaxStaleness: maxStaleness}
+            }
+        }
+        var stale *raft.StaleReadError
+        if errors.As(err, &stale) {
+            if freshest == nil || stale.Lag < freshest.Lag {
+                freshest = stale
+            }
+            continue
+        }
+        return read(node)
+    }
+    if freshest == nil {
+        return raft.ErrStaleRead
+    }
+    return freshest
+}
+
+// CheckConfiguration compares the configuration checksums of the
+// nodes, returning ErrConfigurationMismatch if two nodes that have
+// applied the same configuration report different checksums.
+// Nodes still catching up on a newer configuration are not
+// mismatches.
+func (this *Client) CheckConfiguration() error {
+    ids := make([]raft.ServerId, 0, len(this.nodes))
+    for id := range this.nodes {
+        ids = append(ids, id)
+    }
+    sortIds(ids)
+
+    // The first node seen at each configuration index.
+    seen := make(map[int]raft.Status)
+    for _, id := range ids {
+        status := this.nodes[id].Status()
+        first, ok := seen[status.ConfigurationIndex]
+        if !ok {
+            seen[status.ConfigurationIndex] = status
+            continue
+        }
+        if first.ConfigurationChecksum != status.ConfigurationChecksum {
+            return fmt.Errorf("%w: node %s has %x and node %s has %x at index %d",
+                ErrConfigurationMismatch, first.Id, first.ConfigurationChecksum,
+                status.Id, status.ConfigurationChecksum, status.ConfigurationIndex)
+        }
+    }
+    return nil
+}
+
+// sortIds sorts ids in ascending order.
+func sortIds(ids []raft.ServerId) {
+    sort.Slice(ids, func(i, j int) bool {
+        return ids[i] < ids[j]
+    })
+}
+
+// Session proposes commands through a client session, so that each
+// is applied exactly once however often it is resubmitted. Like the
+// Client it belongs to, it must not be used from several goroutines
+// at once.
+type Session struct {
+    client *Client
+
+    // The session's ID, and the sequence number of the last command
+    // proposed.
+    id       int
+    sequence int
+}
+
+// Register opens a session on the cluster.
+func (this *Client) Register(ctx context.Context) (*Session, error) {
+    future, err := this.submit(ctx, (*raft.Node).RegisterClient)
+    if err != nil {
+        return nil, err
+    }
+    return &Session{client: this, id: future.Index()}, nil
+}
+
+// Propose submits command and waits until it has been applied,
+// resubmitting it on any failure short of an expired session until
+// the client's MaxAttempts are used up.
+func (this *Session) Propose(ctx context.Context, command []byte) (*raft.ProposeFuture, error) {
+    this.sequence++
+    sequence := this.sequence
+    return this.client.submit(ctx, func(node *raft.Node, ctx context.Context) *raft.ProposeFuture {
+        return node.ProposeSession(ctx, this.id, sequence, command)
+    })
+}
+
+// submit calls propose on the leader until its future succeeds,
+// following leadership hints, or fails for good. Resubmitting is
+// safe because the commands it submits are deduplicated.
+func (this *Client) submit(ctx context.Context, propose func(*raft.Node, context.Context) *raft.ProposeFuture) (*raft.ProposeFuture, error) {
+    err := raft.ErrNotLeader
+    for attempt := 0; attempt < this.MaxAttempts; attempt++ {
+        node := this.findLeader()
+        if node == nil {
+            return nil, raft.ErrNotLeader
+        }
+
+        future := propose(node, ctx)
+        err = future.Error()
+        var lost *raft.LeadershipLostError
+        var notLeader *raft.NotLeaderError
+        switch {
+        case err == nil:
+            this.token = future.Token()
+            return future, nil
+        case ctx.Err() != nil,
+            errors.Is(err, raft.ErrSessionExpired),
+            errors.Is(err, raft.ErrStaleSequence),
+            errors.Is(err, raft.ErrCommandTooLarge):
+            return nil, err
+        case errors.As(err, &lost):
+            this.leader = lost.LeaderId
+        case errors.As(err, &notLeader):
+            this.leader = notLeader.LeaderId
+        default:
+            this.leader = ""
+        }
+    }
+    return nil, err
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+    "context"
+    "errors"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/tawawhite/raft"
+)
+
+// counter is a state machine counting the commands it applied.
+type counter struct {
+    mu sync.Mutex
+    n  int
+}
+
+func (this *counter) apply([]byte) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    this.n++
+}
+
+func (this *counter) count() int {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    return this.n
+}
+
+func TestReadYourWrites(t *testing.T) {
+    registry := raft.NewRegistry()
+    ids := []raft.ServerId{"a", "b", "c"}
+    counters := make(map[raft.ServerId]*counter)
+    var nodes []*raft.Node
+    for _, id := range ids {
+        var peers []raft.ServerId
+        for _, peer := range ids {
+            if peer != id {
+                peers = append(peers, peer)
+            }
+        }
+        counters[id] = &counter{}
+        node, err := registry.NewNode(id, peers, counters[id].apply,
+            raft.WithTimeouts(3, 10, 20),
+            func(config *raft.Config) { config.TickInterval = time.Millisecond })
+        if err != nil {
+            t.Fatal(err)
+        }
+        nodes = append(nodes, node)
+    }
+    for _, node := range nodes {
+        node.Start()
+    }
+    defer func() {
+        for _, node := range nodes {
+            node.Shutdown(context.Background())
+        }
+        registry.Close()
+    }()
+
+    client := New(nodes)
+    for deadline := time.Now().Add(5 * time.Second); client.findLeader() == nil; time.Sleep(time.Millisecond) {
+        if time.Now().After(deadline) {
+            t.Fatal("no leader elected")
+        }
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    if _, err := client.Propose(ctx, []byte("x"), true); err != nil {
+        t.Fatal(err)
+    }
+
+    // Cut a follower off from the leader, so that it misses the
+    // next write, which the other follower lets commit.
+    leader := client.findLeader()
+    var lagging raft.ServerId
+    for _, id := range ids {
+        if id != leader.Id() {
+            lagging = id
+            break
+        }
+    }
+    registry.SetLinkFilter(func(from, to raft.ServerId) error {
+        if from == lagging || to == lagging {
+            return errors.New("link down")
+        }
+        return nil
+    })
+    if _, err := client.Propose(ctx, []byte("y"), false); err != nil {
+        t.Fatal(err)
+    }
+
+    read := make(chan int, 1)
+    go func() {
+        err := client.Read(ctx, lagging, func(*raft.Node) error {
+            read <- counters[lagging].count()
+            return nil
+        })
+        if err != nil {
+            close(read)
+        }
+    }()
+    select {
+    case n := <-read:
+        t.Fatalf("read %d commands on a node that missed the last write", n)
+    case <-time.After(50 * time.Millisecond):
+    }
+
+    registry.SetLinkFilter(nil)
+    if n, ok := <-read; !ok || n != 2 {
+        t.Fatalf("read %d commands after catching up, want 2", n)
+    }
+}
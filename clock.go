@@ -0,0 +1,175 @@
+package raft
+
+import (
+    "sort"
+    "sync"
+    "time"
+)
+
+// Clock tells the time and makes timers, so that a node's timing can
+// be driven by a MockClock in tests instead of by real time.
+type Clock interface {
+    Now() time.Time
+    NewTimer(d time.Duration) Timer
+    NewTicker(d time.Duration) Ticker
+}
+
+// Timer is the part of a time.Timer a Clock provides.
+type Timer interface {
+    C() <-chan time.Time
+    Stop() bool
+    Reset(d time.Duration) bool
+}
+
+// Ticker is the part of a time.Ticker a Clock provides.
+type Ticker interface {
+    C() <-chan time.Time
+    Stop()
+}
+
+// SystemClock is the Clock of the time package.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+    return time.Now()
+}
+
+func (SystemClock) NewTimer(d time.Duration) Timer {
+    return systemTimer{time.NewTimer(d)}
+}
+
+func (SystemClock) NewTicker(d time.Duration) Ticker {
+    return systemTicker{time.NewTicker(d)}
+}
+
+type systemTimer struct {
+    *time.Timer
+}
+
+func (this systemTimer) C() <-chan time.Time {
+    return this.Timer.C
+}
+
+type systemTicker struct {
+    *time.Ticker
+}
+
+func (this systemTicker) C() <-chan time.Time {
+    return this.Ticker.C
+}
+
+// MockClock is a Clock whose time only moves when Advance is called,
+// firing the timers and tickers that fall due on the way.
+type MockClock struct {
+    mu      sync.Mutex
+    now     time.Time
+    waiters []*mockWaiter
+}
+
+// NewMockClock returns a MockClock set to start.
+func NewMockClock(start time.Time) *MockClock {
+    return &MockClock{now: start}
+}
+
+// mockWaiter is a timer, or a ticker if period is set.
+type mockWaiter struct {
+    clock  *MockClock
+    c      chan time.Time
+    when   time.Time
+    period time.Duration
+}
+
+func (this *MockClock) Now() time.Time {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    return this.now
+}
+
+func (this *MockClock) NewTimer(d time.Duration) Timer {
+    return this.add(d, 0)
+}
+
+func (this *MockClock) NewTicker(d time.Duration) Ticker {
+    if d <= 0 {
+        panic("raft: non-positive interval for NewTicker")
+    }
+    return mockTicker{this.add(d, d)}
+}
+
+func (this *MockClock) add(d, period time.Duration) *mockWaiter {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    waiter := &mockWaiter{clock: this, c: make(chan time.Time, 1), when: this.now.Add(d), period: period}
+    this.waiters = append(this.waiters, waiter)
+    return waiter
+}
+
+// Advance moves the time forward by d. Timers and tickers due by
+// then fire in order of their due time; like those of the time
+// package, a ticker whose receiver falls behind drops ticks.
+func (this *MockClock) Advance(d time.Duration) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    end := this.now.Add(d)
+    for {
+        sort.SliceStable(this.waiters, func(i, j int) bool {
+            return this.waiters[i].when.Before(this.waiters[j].when)
+        })
+        if len(this.waiters) == 0 || this.waiters[0].when.After(end) {
+            break
+        }
+        waiter := this.waiters[0]
+        this.now = waiter.when
+        select {
+        case waiter.c <- this.now:
+        default:
+        }
+        if waiter.period > 0 {
+            waiter.when = waiter.when.Add(waiter.period)
+        } else {
+            this.waiters = this.waiters[1:]
+        }
+    }
+    this.now = end
+}
+
+func (this *mockWaiter) C() <-chan time.Time {
+    return this.c
+}
+
+// Stop reports whether the timer was still pending.
+func (this *mockWaiter) Stop() bool {
+    clock := this.clock
+    clock.mu.Lock()
+    defer clock.mu.Unlock()
+
+    for i, waiter := range clock.waiters {
+        if waiter == this {
+            clock.waiters = append(clock.waiters[:i], clock.waiters[i+1:]...)
+            return true
+        }
+    }
+    return false
+}
+
+// mockTicker is a mockWaiter with the Stop of a Ticker.
+type mockTicker struct {
+    *mockWaiter
+}
+
+func (this mockTicker) Stop() {
+    this.mockWaiter.Stop()
+}
+
+func (this *mockWaiter) Reset(d time.Duration) bool {
+    active := this.Stop()
+    clock := this.clock
+    clock.mu.Lock()
+    defer clock.mu.Unlock()
+
+    this.when = clock.now.Add(d)
+    clock.waiters = append(clock.waiters, this)
+    return active
+}
@@ -0,0 +1,204 @@
+// Command raftadmin manages a cluster through the admin service of
+// one of its members.
+//
+// Usage:
+//
+//    raftadmin [-addr host:port] [-timeout duration] command [arguments]
+//
+// The commands are:
+//
+//    status                        the status of the member
+//    configuration                 the membership of the cluster
+//    add-voter id address          add a voter, or change its address
+//    add-replica id address        add a read-only replica, or demote a voter
+//    remove-server id              remove a member
+//    set-locality id region [zone] record where a member runs
+//    transfer-leadership [id]      hand leadership to id, or the most up-to-date follower
+//    snapshot                      snapshot the member and compact its log
+//    drain                         drain the member for maintenance
+//    undrain                       end the maintenance of the member
+//
+// Changes of membership and leadership must be made on the leader;
+// made on another member, they fail naming the leader.
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "os"
+    "text/tabwriter"
+    "time"
+
+    "github.com/tawawhite/raft"
+    "github.com/tawawhite/raft/admin"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/credentials/insecure"
+)
+
+func main() {
+    address := flag.String("addr", "localhost:7000", "address of the member's admin service")
+    timeout := flag.Duration("timeout", 30*time.Second, "how long to wait for the command")
+    flag.Usage = func() {
+        fmt.Fprintln(os.Stderr, "usage: raftadmin [flags] status | configuration | add-voter id address |")
+        fmt.Fprintln(os.Stderr, "                 add-replica id address | remove-server id |")
+        fmt.Fprintln(os.Stderr, "                 set-locality id region [zone] | transfer-leadership [id] | snapshot |")
+        fmt.Fprintln(os.Stderr, "                 drain | undrain")
+        flag.PrintDefaults()
+    }
+    flag.Parse()
+    if flag.NArg() == 0 {
+        flag.Usage()
+        os.Exit(2)
+    }
+
+    conn, err := grpc.NewClient(*address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "raftadmin:", err)
+        os.Exit(1)
+    }
+    defer conn.Close()
+    ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+    defer cancel()
+
+    if err := run(ctx, admin.NewClient(conn), flag.Arg(0), flag.Args()[1:]); err != nil {
+        fmt.Fprintln(os.Stderr, "raftadmin:", err)
+        os.Exit(1)
+    }
+}
+
+func run(ctx context.Context, client *admin.Client, command string, args []string) error {
+    arity := map[string][2]int{
+        "status":              {0, 0},
+        "configuration":       {0, 0},
+        "add-voter":           {2, 2},
+        "add-replica":         {2, 2},
+        "remove-server":       {1, 1},
+        "set-locality":        {2, 3},
+        "transfer-leadership": {0, 1},
+        "snapshot":            {0, 0},
+        "drain":               {0, 0},
+        "undrain":             {0, 0},
+    }
+    bounds, ok := arity[command]
+    if !ok {
+        flag.Usage()
+        os.Exit(2)
+    }
+    if len(args) < bounds[0] || len(args) > bounds[1] {
+        return fmt.Errorf("%s takes %d to %d arguments", command, bounds[0], bounds[1])
+    }
+
+    switch command {
+    case "status":
+        status, err := client.Status(ctx)
+        if err != nil {
+            return err
+        }
+        printStatus(status)
+    case "configuration":
+        configuration, err := client.Configuration(ctx)
+        if err != nil {
+            return err
+        }
+        fmt.Printf("index %d, term %d\n", configuration.Index, configuration.Term)
+        printServers(configuration.Servers)
+        if configuration.Joint() {
+            fmt.Println("moving to:")
+            printServers(configuration.NextServers)
+        }
+    case "add-voter":
+        index, err := client.AddVoter(ctx, raft.ServerId(args[0]), raft.ServerAddress(args[1]))
+        if err != nil {
+            return err
+        }
+        fmt.Printf("added %s at index %d\n", args[0], index)
+    case "add-replica":
+        index, err := client.AddReplica(ctx, raft.ServerId(args[0]), raft.ServerAddress(args[1]))
+        if err != nil {
+            return err
+        }
+        fmt.Printf("added replica %s at index %d\n", args[0], index)
+    case "remove-server":
+        index, err := client.RemoveServer(ctx, raft.ServerId(args[0]))
+        if err != nil {
+            return err
+        }
+        fmt.Printf("removed %s at index %d\n", args[0], index)
+    case "set-locality":
+        locality := raft.Locality{Region: args[1]}
+        if len(args) > 2 {
+            locality.Zone = args[2]
+        }
+        index, err := client.SetLocality(ctx, raft.ServerId(args[0]), locality)
+        if err != nil {
+            return err
+        }
+        fmt.Printf("set the locality of %s at index %d\n", args[0], index)
+    case "transfer-leadership":
+        var id raft.ServerId
+        if len(args) > 0 {
+            id = raft.ServerId(args[0])
+        }
+        if err := client.LeadershipTransfer(ctx, id); err != nil {
+            return err
+        }
+        fmt.Println("leadership transferred")
+    case "snapshot":
+        index, term, err := client.Snapshot(ctx)
+        if err != nil {
+            return err
+        }
+        fmt.Printf("snapshot at index %d, term %d\n", index, term)
+    case "drain":
+        if err := client.Drain(ctx); err != nil {
+            return err
+        }
+        fmt.Println("drained")
+    case "undrain":
+        if err := client.Undrain(ctx); err != nil {
+            return err
+        }
+        fmt.Println("undrained")
+    }
+    return nil
+}
+
+func printStatus(status raft.Status) {
+    fmt.Printf("id %s, %v in term %d, leader %q\n", status.Id, status.Role, status.Term, status.LeaderId)
+    fmt.Printf("log ends at %d/%d, commit %d, applied %d\n",
+        status.LastLogIndex, status.LastLogTerm, status.CommitIndex, status.LastApplied)
+    fmt.Printf("configuration at %d, checksum %x\n", status.ConfigurationIndex, status.ConfigurationChecksum)
+    if status.Draining {
+        fmt.Println("draining")
+    }
+    if !status.LastContact.IsZero() {
+        fmt.Printf("last contact with the leader %v ago\n", time.Since(status.LastContact).Round(time.Millisecond))
+    }
+    if len(status.Peers) == 0 {
+        return
+    }
+    w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    fmt.Fprintln(w, "PEER\tNEXT\tMATCH\tBEHIND\tREACHABLE\tFAILURES")
+    for _, peer := range status.Peers {
+        fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%v\t%d\n",
+            peer.Id, peer.NextIndex, peer.MatchIndex, peer.EntriesBehind, peer.Reachable, peer.Failures)
+    }
+    w.Flush()
+}
+
+func printServers(servers []raft.Server) {
+    w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    for _, server := range servers {
+        suffrage := "voter"
+        switch {
+        case server.Demoted:
+            suffrage = "demoted"
+        case server.Replica:
+            suffrage = "replica"
+        }
+        fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+            server.Id, server.Address, suffrage, server.Locality.Region, server.Locality.Zone)
+    }
+    w.Flush()
+}
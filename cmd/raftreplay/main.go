@@ -0,0 +1,56 @@
+// Command raftreplay replays traces recorded by RawNodes with
+// Config.Trace set, and reports where a replay departs from its
+// trace.
+//
+// Usage:
+//
+//    raftreplay trace...
+package main
+
+import (
+    "errors"
+    "flag"
+    "fmt"
+    "os"
+
+    "github.com/tawawhite/raft"
+)
+
+func main() {
+    flag.Usage = func() {
+        fmt.Fprintln(os.Stderr, "usage: raftreplay trace...")
+        flag.PrintDefaults()
+    }
+    flag.Parse()
+    if flag.NArg() == 0 {
+        flag.Usage()
+        os.Exit(2)
+    }
+
+    status := 0
+    for _, name := range flag.Args() {
+        if err := replay(name); err != nil {
+            fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+            status = 1
+            continue
+        }
+        fmt.Printf("%s: replayed\n", name)
+    }
+    os.Exit(status)
+}
+
+func replay(name string) error {
+    file, err := os.Open(name)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    err = raft.ReplayTrace(file)
+    var divergence *raft.TraceDivergence
+    if errors.As(err, &divergence) {
+        return fmt.Errorf("replay departs at event %d\n  recorded: %s\n  replayed: %s",
+            divergence.Event, divergence.Recorded, divergence.Replayed)
+    }
+    return err
+}
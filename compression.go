@@ -0,0 +1,159 @@
+package raft
+
+import (
+    "bytes"
+    "compress/gzip"
+    "fmt"
+    "io"
+    "sort"
+    "strings"
+    "sync"
+)
+
+// Compression is a content coding an HTTPTransport may compress
+// request bodies with, named as in the Content-Encoding header.
+type Compression string
+
+const (
+    // Gzip is built in.
+    CompressionGzip Compression = "gzip"
+
+    // Snappy is fast, and compresses less. Importing the compression
+    // package registers it.
+    CompressionSnappy Compression = "snappy"
+
+    // Zstandard compresses better, at more CPU cost. Importing the
+    // compression package registers it.
+    CompressionZstd Compression = "zstd"
+)
+
+// Compressor implements a Compression.
+type Compressor interface {
+    Compress(data []byte) []byte
+
+    // Decompress decodes data, failing with ErrBodyTooLarge rather
+    // than decoding more than limit bytes.
+    Decompress(data []byte, limit int) ([]byte, error)
+}
+
+// compressors are the registered Compressors by Compression.
+var compressors sync.Map
+
+func init() {
+    RegisterCompression(CompressionGzip, gzipCompressor{})
+}
+
+// RegisterCompression makes compressor implement compression, for
+// HTTPTransports to compress with and NewHTTPHandler to accept,
+// replacing any compressor registered for it before. Codings beyond
+// the standard library's live in their own packages, so that the
+// core does not depend on them: importing the compression package
+// registers snappy and zstd.
+func RegisterCompression(compression Compression, compressor Compressor) {
+    compressors.Store(compression, compressor)
+}
+
+// compressor returns the Compressor registered for compression, or
+// nil.
+func compressor(compression Compression) Compressor {
+    if compressor, ok := compressors.Load(compression); ok {
+        return compressor.(Compressor)
+    }
+    return nil
+}
+
+// supportedCompressions returns the registered codings, which
+// NewHTTPHandler accepts and advertises in the Accept-Encoding
+// header of its responses, in order of name.
+func supportedCompressions() []Compression {
+    var supported []Compression
+    compressors.Range(func(compression, _ any) bool {
+        supported = append(supported, compression.(Compression))
+        return true
+    })
+    sort.Slice(supported, func(i, j int) bool { return supported[i] < supported[j] })
+    return supported
+}
+
+// compressMinSize is the size below which request bodies are sent
+// as they are: compressing heartbeats and votes saves nothing.
+const compressMinSize = 512
+
+// decompress decodes data, failing with ErrBodyTooLarge rather than
+// decoding more than limit bytes.
+func decompress(compression Compression, data []byte, limit int) ([]byte, error) {
+    if compression == "" || compression == "identity" {
+        return data, nil
+    }
+    if compressor := compressor(compression); compressor != nil {
+        return compressor.Decompress(data, limit)
+    }
+    return nil, fmt.Errorf("raft: unsupported content encoding %q", compression)
+}
+
+// gzipCompressor is CompressionGzip.
+type gzipCompressor struct{}
+
+func (gzipCompressor) Compress(data []byte) []byte {
+    var buf bytes.Buffer
+    writer := gzip.NewWriter(&buf)
+    writer.Write(data)
+    writer.Close()
+    return buf.Bytes()
+}
+
+func (gzipCompressor) Decompress(data []byte, limit int) ([]byte, error) {
+    reader, err := gzip.NewReader(bytes.NewReader(data))
+    if err != nil {
+        return nil, err
+    }
+    decoded, err := io.ReadAll(io.LimitReader(reader, int64(limit)+1))
+    if err != nil {
+        return nil, err
+    }
+    if len(decoded) > limit {
+        return nil, fmt.Errorf("%w: decodes to over %d bytes", ErrBodyTooLarge, limit)
+    }
+    return decoded, nil
+}
+
+// negotiateCompression returns the first of preferred that is
+// registered and that a peer lists in accepted, the value of an
+// Accept-Encoding header, or "" if there is none.
+func negotiateCompression(preferred []Compression, accepted string) Compression {
+    for _, compression := range preferred {
+        if compressor(compression) == nil {
+            continue
+        }
+        for _, coding := range strings.Split(accepted, ",") {
+            coding, _, _ = strings.Cut(coding, ";")
+            if strings.EqualFold(strings.TrimSpace(coding), string(compression)) {
+                return compression
+            }
+        }
+    }
+    return ""
+}
+
+// HTTPOption adjusts an HTTPTransport.
+type HTTPOption func(*HTTPTransport)
+
+// WithHTTPCompression compresses request bodies with the first of
+// preferred each peer accepts, as its handler tells in responses:
+// the first request to a peer goes out uncompressed. Handlers accept
+// every registered Compression; codings not registered on this side
+// are skipped.
+func WithHTTPCompression(preferred ...Compression) HTTPOption {
+    return func(this *HTTPTransport) {
+        this.compressions = preferred
+    }
+}
+
+// WithHTTPMetrics reports the bytes of request bodies to metrics,
+// before and after compression, under labels plus the peer.
+func WithHTTPMetrics(metrics Metrics, labels Labels) HTTPOption {
+    return func(this *HTTPTransport) {
+        this.metrics = metrics
+        this.labels = labels
+    }
+}
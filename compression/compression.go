@@ -0,0 +1,79 @@
+// Package compression registers the snappy and zstd codings with
+// the raft package, for HTTPTransports to compress request bodies
+// with. They live apart from the core so that it builds with the
+// standard library only; import the package for its side effect:
+//
+//    import _ "github.com/tawawhite/raft/compression"
+package compression
+
+import (
+    "errors"
+    "fmt"
+    "sync"
+
+    "github.com/klauspost/compress/snappy"
+    "github.com/klauspost/compress/zstd"
+    "github.com/tawawhite/raft"
+)
+
+func init() {
+    raft.RegisterCompression(raft.CompressionSnappy, Snappy{})
+    raft.RegisterCompression(raft.CompressionZstd, Zstd{})
+}
+
+// Snappy is raft.CompressionSnappy.
+type Snappy struct{}
+
+func (Snappy) Compress(data []byte) []byte {
+    return snappy.Encode(nil, data)
+}
+
+func (Snappy) Decompress(data []byte, limit int) ([]byte, error) {
+    size, err := snappy.DecodedLen(data)
+    if err != nil {
+        return nil, err
+    }
+    if size > limit {
+        return nil, fmt.Errorf("%w: decodes to %d bytes", raft.ErrBodyTooLarge, size)
+    }
+    return snappy.Decode(nil, data)
+}
+
+// Zstd is raft.CompressionZstd.
+type Zstd struct{}
+
+var (
+    zstdEncoder = sync.OnceValue(func() *zstd.Encoder {
+        encoder, _ := zstd.NewWriter(nil)
+        return encoder
+    })
+
+    // Decoders by the most bytes they decode a body to.
+    zstdDecoders sync.Map
+)
+
+// zstdDecoder returns a decoder refusing to decode more than limit
+// bytes.
+func zstdDecoder(limit int) *zstd.Decoder {
+    if decoder, ok := zstdDecoders.Load(limit); ok {
+        return decoder.(*zstd.Decoder)
+    }
+    decoder, _ := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(limit)))
+    if existing, loaded := zstdDecoders.LoadOrStore(limit, decoder); loaded {
+        decoder.Close()
+        return existing.(*zstd.Decoder)
+    }
+    return decoder
+}
+
+func (Zstd) Compress(data []byte) []byte {
+    return zstdEncoder().EncodeAll(data, nil)
+}
+
+func (Zstd) Decompress(data []byte, limit int) ([]byte, error) {
+    decoded, err := zstdDecoder(limit).DecodeAll(data, nil)
+    if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+        return nil, fmt.Errorf("%w: %v", raft.ErrBodyTooLarge, err)
+    }
+    return decoded, err
+}
@@ -0,0 +1,29 @@
+package compression
+
+import (
+    "bytes"
+    "errors"
+    "testing"
+
+    "github.com/tawawhite/raft"
+)
+
+func TestCompressors(t *testing.T) {
+    data := bytes.Repeat([]byte("raft"), 1<<18)
+    for _, compressor := range []raft.Compressor{Snappy{}, Zstd{}} {
+        compressed := compressor.Compress(data)
+        if len(compressed) >= len(data) {
+            t.Fatalf("%T: compressed %d bytes to %d", compressor, len(data), len(compressed))
+        }
+        decoded, err := compressor.Decompress(compressed, len(data))
+        if err != nil {
+            t.Fatalf("%T: %v", compressor, err)
+        }
+        if !bytes.Equal(decoded, data) {
+            t.Fatalf("%T: decoded differs", compressor)
+        }
+        if _, err := compressor.Decompress(compressed, len(data)/2); !errors.Is(err, raft.ErrBodyTooLarge) {
+            t.Fatalf("%T: decoding past the limit: %v", compressor, err)
+        }
+    }
+}
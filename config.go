@@ -0,0 +1,480 @@
+package raft
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "time"
+)
+
+// ErrInvalidConfig is returned by NewNode for a Config that fails
+// validation.
+var ErrInvalidConfig = errors.New("raft: invalid config")
+
+// Config holds a node's tunables and collaborators. Start from
+// DefaultConfig; zero collaborators are replaced by defaults.
+type Config struct {
+    // Wall-clock time one Tick stands for when the node is driven
+    // by Start.
+    TickInterval time.Duration
+
+    // Ticks between leader heartbeats.
+    HeartbeatTicks int
+
+    // Bounds of the randomized election timeout, in ticks
+    // (see §5.2 of the raft paper). The minimum must exceed
+    // HeartbeatTicks, or followers time out between heartbeats.
+    ElectionTicksMin int
+    ElectionTicksMax int
+
+    // How much the node should be preferred as leader, such as for
+    // being in the primary region. A node whose priority is below
+    // the highest among the members it has handshaken with, as a
+    // leader does with every follower, waits one more election
+    // timeout range for each step of difference before it
+    // campaigns, so that, all else equal, higher-priority nodes
+    // win elections. Zero everywhere leaves elections to the
+    // randomized timeout alone.
+    ElectionPriority int
+
+    // Consulted before the node campaigns or grants a vote, for
+    // topology-aware elections; nil leaves them to the protocol.
+    ElectionPolicy ElectionPolicy
+
+    // How long a voter may go without answering the leader before
+    // the leader demotes it to a replica, so that it no longer holds
+    // back commits; the leader promotes it back once it has caught
+    // up. Zero never demotes voters.
+    DemoteAfter time.Duration
+
+    // The profile the timings above were set from by
+    // WithTimeoutProfile, if any.
+    TimeoutProfile TimeoutProfile
+
+    // Most entries sent in one AppendEntries. Zero means no limit
+    // besides the message size.
+    MaxAppendEntries int
+
+    // Most bytes of entries sent in one AppendEntries, so that a
+    // follower catching up on a long log receives it in requests
+    // of bounded size rather than in one the size of the message
+    // limit. An entry larger than this is still sent, on its own.
+    // Zero means no limit besides the message size.
+    MaxAppendEntriesBytes int
+
+    // AppendEntries requests kept in flight to a follower that is
+    // accepting entries. One disables pipelining: every request
+    // then waits for the previous reply.
+    MaxInflight int
+
+    // Bytes of entries kept in flight to a follower, which bounds
+    // what a slow follower makes the leader and its transport
+    // hold on to. The window closes once it is full, and opens
+    // again as the follower answers; a single request may exceed
+    // it. Zero means no limit besides MaxInflight messages.
+    MaxInflightBytes int
+
+    // Longest a leader waits, in ticks, before sending again to a
+    // peer that failed to answer. The wait starts at HeartbeatTicks
+    // and doubles with every consecutive failure. It never exceeds
+    // ElectionTicksMin less HeartbeatTicks, so that a peer that
+    // comes back hears from the leader before it times out and
+    // starts an election. Zero retries at every heartbeat.
+    MaxBackoffTicks int
+
+    // Largest RPC message, in bytes, this node accepts and sends.
+    // Peers learn each other's limits with a handshake and size
+    // AppendEntries batches and snapshot chunks to fit the smaller
+    // one, so nodes with different limits can be mixed.
+    MaxMessageSize int
+
+    // The protocol versions this node speaks, between
+    // ProtocolVersionMin and ProtocolVersionMax. It talks to each
+    // peer in the highest version both speak, as agreed by
+    // handshake, and refuses RPCs in versions outside the range.
+    MinProtocolVersion int
+    MaxProtocolVersion int
+
+    // How proposals are coalesced into log appends.
+    Batch BatchOptions
+
+    // How many voters must store an entry for it to be committed;
+    // nil is a majority. Elections always need a majority.
+    CommitQuorum QuorumPolicy
+
+    // Applies the commands of committed EntryNormal entries in
+    // place of the state machine given to NewNode, under the same
+    // contract, returning a result for each. The ProposeFuture of a
+    // command on the node it was proposed to reports the result, and
+    // the error, which is an outcome of the command, such as a
+    // failed precondition, rather than a failure to apply it.
+    Apply func(command []byte) (result any, err error)
+
+    // Declares that the state machine may apply the commands
+    // committed together in any order. The commands of each batch
+    // are then applied highest priority first, in log order among
+    // equal priorities, so control commands proposed with
+    // ProposeWithPriority overtake bulk data committed with them.
+    // Replicas may batch differently, so only set this if the
+    // commands of a batch commute.
+    ReorderApply bool
+
+    // Committed entries a node may queue for the state machine,
+    // which then applies them in a goroutine of its own, without
+    // the node's lock held, while the node goes on committing.
+    // Proposals resolve once their entries are applied. Zero
+    // applies entries synchronously as they are committed, which
+    // keeps a node driven by Tick deterministic.
+    ApplyQueue int
+
+    // How far a node may lag the leader and still serve stale
+    // reads, as checked by CheckStaleRead. Zero allows any lag.
+    MaxStaleness time.Duration
+
+    // Enables log compaction: once more than SnapshotThreshold
+    // applied entries are in the log, the state machine is
+    // snapshotted and those entries are discarded. Every node of a
+    // cluster should use the same policy, as followers that fall
+    // behind the leader's log are brought up to date with
+    // InstallSnapshot.
+    Snapshotter       Snapshotter
+    SnapshotThreshold int
+
+    // Receives the entries compaction removes from the log, which
+    // are otherwise deleted. If it fails, the snapshot is not
+    // taken and the entries are kept.
+    Archive LogArchive
+
+    // Bounds snapshot work shared with other nodes; nil is
+    // unlimited.
+    SnapshotLimiter *SnapshotLimiter
+
+    // When snapshots may be taken.
+    SnapshotSchedule SnapshotSchedule
+
+    // Largest chunk of a snapshot sent in one InstallSnapshot.
+    // Zero sends chunks as large as the peer's message limit
+    // allows.
+    SnapshotChunkSize int
+
+    // Most bytes of snapshot per second a leader sends each
+    // follower, in bursts of up to a second's worth, so that
+    // snapshots do not crowd out heartbeats. Chunks shrink to fit,
+    // so a follower receiving a snapshot still hears from the
+    // leader every heartbeat. Zero means no limit.
+    SnapshotRateLimit int
+
+    // Where the log and the term and vote are persisted, and
+    // recovered from by NewNode. Both default to a fresh
+    // InmemStore.
+    LogStore    LogStore
+    StableStore StableStore
+
+    // Where the latest snapshot is persisted, for the node to
+    // restart from. Defaults to the StableStore.
+    SnapshotStore SnapshotStore
+
+    // Encrypts the commands of the entries written to the log
+    // store, including the stores MigrateStorage moves to, the
+    // persisted snapshot, and the entries and snapshots handed to
+    // Archive. The term and vote are not encrypted. nil stores
+    // everything in the clear.
+    Encryption EncryptionProvider
+
+    // Recent entries to cache in front of the log store, and of
+    // the stores MigrateStorage moves to, with a LogCache: the node
+    // reads back from the store the commands of the entries it
+    // applies and replicates. Zero caches none.
+    LogCacheSize int
+
+    // Carries RPCs to the peers. Required: a Registry provides one
+    // for nodes running in the same process.
+    Transport Transport
+
+    // Tells the time and drives Start's ticker. Defaults to
+    // SystemClock; tests may use a MockClock.
+    Clock Clock
+
+    // Receive the node's measurements and log events. By default
+    // metrics are discarded and warnings and errors are logged to
+    // slog.Default().
+    Metrics Metrics
+    Logger  Logger
+
+    // Added to the labels of every metric the node reports, such
+    // as LabelGroup to tell apart Raft groups sharing a process.
+    MetricLabels Labels
+
+    // Warns when leadership flaps; nil disables the check. One
+    // detector may be shared by several nodes, each of which counts
+    // its own short tenures.
+    FlapDetector *FlapDetector
+
+    // Called with every committed entry, in log order, before the
+    // entry is applied, so that external indexers and caches can
+    // start on it while the state machine catches up. It runs with
+    // the node's lock held and must not call back into the Node or
+    // retain the entry's Command.
+    OnCommit func(Entry)
+
+    // The anti-entropy audit run while the node is leader.
+    Audit AuditOptions
+
+    // Most client sessions remembered; the least recently used
+    // are evicted beyond it. Zero means no limit.
+    MaxClientSessions int
+
+    // Whether Shutdown first hands leadership to another node
+    // when this node is the leader.
+    TransferLeadershipOnShutdown bool
+
+    // Whether Start asks the peers for the leader they follow and
+    // holds off elections, for up to ElectionTicksMax ticks, until
+    // they answer. A node restarted into a healthy cluster then
+    // joins it as a follower rather than disrupting it.
+    Prewarm bool
+
+    // Whether followers forward proposals to the leader they know
+    // of, instead of failing them with ErrNotLeader, so that
+    // clients may propose through any node. The future of a
+    // forwarded proposal resolves once the follower has applied
+    // it. Barriers are never forwarded.
+    ForwardProposals bool
+
+    // Whether the cluster starts as a standby, which a Mirror keeps
+    // a copy of another cluster until it is promoted. Every member
+    // of a standby cluster must set it.
+    Standby bool
+
+    // Makes the node a witness, which votes and counts toward
+    // commit quorums but keeps no commands and has no state
+    // machine; see witness.go. A witness compacts its log without
+    // a Snapshotter.
+    Witness bool
+
+    // Names the cluster the node belongs to. It is folded into
+    // the configuration checksum, so that members of clusters
+    // that were meant to be separate can be told apart.
+    ClusterId string
+
+    // Resolves the IDs of the members to the addresses reported
+    // in the node's Configuration. It is called with the node's
+    // lock held, so must answer from a cache rather than block.
+    AddressProvider AddressProvider
+
+    // Seeds the election timeout jitter, making elections
+    // reproducible when the node is driven by Tick. Zero seeds
+    // it from the clock.
+    Seed int64
+
+    // Receives a trace of a RawNode's inputs, of the Readys it
+    // hands out and of the changes of state they bring, one JSON
+    // object per line, which ReplayTrace replays. A RawNode
+    // recording a trace without a Seed picks one and records it.
+    // Writes are made as the RawNode is driven, so should not
+    // block. Nodes other than RawNodes record no trace.
+    Trace io.Writer
+
+    // Receives the node's state transitions and messages as a
+    // trace the Raft TLA+ specification can validate; see
+    // TLATracer.
+    TLATracer *TLATracer
+}
+
+// DefaultConfig returns the default configuration.
+func DefaultConfig() Config {
+    return Config{
+        TickInterval:          TickInterval,
+        HeartbeatTicks:        5,
+        ElectionTicksMin:      15,
+        ElectionTicksMax:      30,
+        MaxAppendEntries:      64,
+        MaxAppendEntriesBytes: DefaultMaxAppendEntriesBytes,
+        MaxInflight:           DefaultMaxInflight,
+        MaxInflightBytes:      DefaultMaxInflightBytes,
+        MaxBackoffTicks:       10,
+        MaxMessageSize:        DefaultMaxMessageSize,
+        LogCacheSize:          DefaultLogCacheSize,
+        MinProtocolVersion:    ProtocolVersionMin,
+        MaxProtocolVersion:    ProtocolVersionMax,
+        Batch:                 DefaultBatchOptions,
+        SnapshotThreshold:     8192,
+        MaxClientSessions:     4096,
+    }
+}
+
+// Validate reports the first problem with the configuration.
+func (this Config) Validate() error {
+    if this.TimeoutProfile != (TimeoutProfile{}) {
+        if err := this.TimeoutProfile.Validate(); err != nil {
+            return err
+        }
+    }
+    switch {
+    case this.TickInterval <= 0:
+        return fmt.Errorf("%w: TickInterval must be positive", ErrInvalidConfig)
+    case this.HeartbeatTicks <= 0:
+        return fmt.Errorf("%w: HeartbeatTicks must be positive", ErrInvalidConfig)
+    case this.ElectionTicksMin <= this.HeartbeatTicks:
+        return fmt.Errorf("%w: ElectionTicksMin (%d) must exceed HeartbeatTicks (%d)",
+            ErrInvalidConfig, this.ElectionTicksMin, this.HeartbeatTicks)
+    case this.ElectionTicksMax < this.ElectionTicksMin:
+        return fmt.Errorf("%w: ElectionTicksMax (%d) is below ElectionTicksMin (%d)",
+            ErrInvalidConfig, this.ElectionTicksMax, this.ElectionTicksMin)
+    case this.ElectionPriority < 0:
+        return fmt.Errorf("%w: ElectionPriority is negative", ErrInvalidConfig)
+    case this.MaxAppendEntries < 0:
+        return fmt.Errorf("%w: MaxAppendEntries is negative", ErrInvalidConfig)
+    case this.MaxAppendEntriesBytes < 0:
+        return fmt.Errorf("%w: MaxAppendEntriesBytes is negative", ErrInvalidConfig)
+    case this.MaxInflight < 1:
+        return fmt.Errorf("%w: MaxInflight must be at least 1", ErrInvalidConfig)
+    case this.MaxInflightBytes < 0:
+        return fmt.Errorf("%w: MaxInflightBytes is negative", ErrInvalidConfig)
+    case this.MaxBackoffTicks < 0:
+        return fmt.Errorf("%w: MaxBackoffTicks is negative", ErrInvalidConfig)
+    case this.MaxMessageSize <= appendEntriesOverhead+entryOverhead:
+        return fmt.Errorf("%w: MaxMessageSize (%d) cannot fit an entry", ErrInvalidConfig, this.MaxMessageSize)
+    case this.MinProtocolVersion < ProtocolVersionMin || this.MaxProtocolVersion > ProtocolVersionMax:
+        return fmt.Errorf("%w: protocol versions must be between %d and %d",
+            ErrInvalidConfig, ProtocolVersionMin, ProtocolVersionMax)
+    case this.MinProtocolVersion > this.MaxProtocolVersion:
+        return fmt.Errorf("%w: MinProtocolVersion (%d) exceeds MaxProtocolVersion (%d)",
+            ErrInvalidConfig, this.MinProtocolVersion, this.MaxProtocolVersion)
+    case this.Batch.MaxEntries < 1 || this.Batch.MaxBytes < 1 || this.Batch.FlushInterval < 0:
+        return fmt.Errorf("%w: Batch limits must be positive", ErrInvalidConfig)
+    case this.Witness && this.Snapshotter != nil:
+        return fmt.Errorf("%w: a witness has no state machine to snapshot", ErrInvalidConfig)
+    case this.Witness && this.Apply != nil:
+        return fmt.Errorf("%w: a witness has no state machine to apply to", ErrInvalidConfig)
+    case this.LogCacheSize < 0:
+        return fmt.Errorf("%w: LogCacheSize is negative", ErrInvalidConfig)
+    case this.ApplyQueue < 0:
+        return fmt.Errorf("%w: ApplyQueue is negative", ErrInvalidConfig)
+    case this.MaxStaleness < 0:
+        return fmt.Errorf("%w: MaxStaleness is negative", ErrInvalidConfig)
+    case this.SnapshotThreshold < 0:
+        return fmt.Errorf("%w: SnapshotThreshold is negative", ErrInvalidConfig)
+    case this.SnapshotChunkSize < 0:
+        return fmt.Errorf("%w: SnapshotChunkSize is negative", ErrInvalidConfig)
+    case this.SnapshotRateLimit < 0:
+        return fmt.Errorf("%w: SnapshotRateLimit is negative", ErrInvalidConfig)
+    case this.MaxClientSessions < 0:
+        return fmt.Errorf("%w: MaxClientSessions is negative", ErrInvalidConfig)
+    case this.Audit.Interval < 0:
+        return fmt.Errorf("%w: Audit.Interval is negative", ErrInvalidConfig)
+    }
+    return nil
+}
+
+// Option adjusts the Config a node is created with.
+type Option func(*Config)
+
+// WithConfig replaces the whole configuration.
+func WithConfig(config Config) Option {
+    return func(this *Config) {
+        *this = config
+    }
+}
+
+// WithTimeouts sets the heartbeat interval and the bounds of the
+// election timeout, in ticks.
+func WithTimeouts(heartbeatTicks, electionTicksMin, electionTicksMax int) Option {
+    return func(this *Config) {
+        this.HeartbeatTicks = heartbeatTicks
+        this.ElectionTicksMin = electionTicksMin
+        this.ElectionTicksMax = electionTicksMax
+    }
+}
+
+// WithTransport sets the transport used to reach the peers.
+func WithTransport(transport Transport) Option {
+    return func(this *Config) {
+        this.Transport = transport
+    }
+}
+
+// WithStorage sets where the log and the term and vote are
+// persisted.
+func WithStorage(logs LogStore, stable StableStore) Option {
+    return func(this *Config) {
+        this.LogStore = logs
+        this.StableStore = stable
+    }
+}
+
+// WithSnapshotStore sets where the latest snapshot is persisted.
+func WithSnapshotStore(store SnapshotStore) Option {
+    return func(this *Config) {
+        this.SnapshotStore = store
+    }
+}
+
+// WithEncryption encrypts the log, and what is archived, with
+// provider.
+func WithEncryption(provider EncryptionProvider) Option {
+    return func(this *Config) {
+        this.Encryption = provider
+    }
+}
+
+// WithSnapshotter enables log compaction past threshold applied
+// entries.
+func WithSnapshotter(snapshotter Snapshotter, threshold int) Option {
+    return func(this *Config) {
+        this.Snapshotter = snapshotter
+        this.SnapshotThreshold = threshold
+    }
+}
+
+// WithApply applies commands with apply, whose results proposals
+// report, in place of the state machine given to NewNode.
+func WithApply(apply func(command []byte) (any, error)) Option {
+    return func(this *Config) {
+        this.Apply = apply
+    }
+}
+
+// WithLogger sets the logger.
+func WithLogger(logger Logger) Option {
+    return func(this *Config) {
+        this.Logger = logger
+    }
+}
+
+// WithMetrics sets where measurements are reported.
+func WithMetrics(metrics Metrics) Option {
+    return func(this *Config) {
+        this.Metrics = metrics
+    }
+}
+
+// WithClock sets the clock the node reads the time from.
+func WithClock(clock Clock) Option {
+    return func(this *Config) {
+        this.Clock = clock
+    }
+}
+
+// WithAddressProvider sets where the addresses of members are
+// resolved.
+func WithAddressProvider(provider AddressProvider) Option {
+    return func(this *Config) {
+        this.AddressProvider = provider
+    }
+}
+
+// WithAutoDemotion demotes voters that have not answered the leader
+// for after to replicas, until they catch up.
+func WithAutoDemotion(after time.Duration) Option {
+    return func(this *Config) {
+        this.DemoteAfter = after
+    }
+}
+
+// WithSeed seeds the election timeout jitter.
+func WithSeed(seed int64) Option {
+    return func(this *Config) {
+        this.Seed = seed
+    }
+}
@@ -0,0 +1,357 @@
+package raft
+
+import (
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "hash/fnv"
+    "time"
+)
+
+// errCorruptConfiguration is returned when the servers of a
+// configuration cannot be decoded.
+var errCorruptConfiguration = errors.New("raft: corrupt configuration")
+
+// Server is a member of a cluster.
+type Server struct {
+    Id ServerId
+
+    // Where the server was last known to be reachable, resolved
+    // through Config.AddressProvider; empty without one. Addresses
+    // may change while the membership does not, so they are left
+    // out of the checksum.
+    Address ServerAddress
+
+    // Whether the server is a read-only replica, which receives and
+    // applies the log but never votes or stands for election, and
+    // counts toward no quorum.
+    Replica bool
+
+    // Set on a replica that Config.DemoteAfter demoted from voter,
+    // which the leader promotes back once it has caught up.
+    Demoted bool
+
+    // Where the server runs. Like addresses, localities are left out
+    // of the checksum.
+    Locality Locality
+}
+
+// Locality labels the failure domains a server runs in, for higher
+// layers to spread replicas across zones or keep leadership close to
+// clients; the cluster itself only stores and reports them. Either
+// may be empty.
+type Locality struct {
+    Region string
+    Zone   string
+}
+
+// locality returns the locality of the member id, as the membership
+// the node follows records it. Must be called with this.mu held.
+func (this *Node) locality(id ServerId) Locality {
+    for _, servers := range [][]Server{this.members.NextServers, this.members.Servers} {
+        for _, server := range servers {
+            if server.Id == id {
+                return server.Locality
+            }
+        }
+    }
+    return Locality{}
+}
+
+// Configuration is the membership of a cluster, as committed at
+// Index in Term. A cluster whose membership was fixed when its
+// nodes were created has a configuration at index 0.
+type Configuration struct {
+    // Set by Config.ClusterId, telling apart clusters that happen
+    // to have the same members.
+    ClusterId string
+
+    // Members, ordered by ID.
+    Servers []Server
+
+    // During a change of membership, the members of the
+    // configuration being moved to, ordered by ID; nil otherwise.
+    // Until the change completes the configuration is joint
+    // (C_old,new): elections and commits need a majority of
+    // Servers and a majority of NextServers (see §6 of the raft
+    // paper).
+    NextServers []Server
+
+    Index int
+    Term  int
+}
+
+// Joint reports whether the configuration is in the middle of a
+// change of membership.
+func (this Configuration) Joint() bool {
+    return len(this.NextServers) > 0
+}
+
+// Checksum returns a digest of the configuration. Every member of a
+// healthy cluster reports the same checksum once it has applied the
+// latest configuration; members reporting different checksums at
+// the same index were configured into different clusters, such as
+// two clusters that each believe they are the whole.
+func (this Configuration) Checksum() uint64 {
+    hash := fnv.New64a()
+    var buf [8]byte
+    writeInt := func(value int) {
+        binary.BigEndian.PutUint64(buf[:], uint64(value))
+        hash.Write(buf[:])
+    }
+    writeInt(len(this.ClusterId))
+    hash.Write([]byte(this.ClusterId))
+    writeInt(this.Index)
+    writeInt(this.Term)
+    writeServers := func(servers []Server) {
+        writeInt(len(servers))
+        for _, server := range servers {
+            writeInt(len(server.Id))
+            hash.Write([]byte(server.Id))
+            if server.Replica {
+                writeInt(-1)
+            }
+        }
+    }
+    writeServers(this.Servers)
+    if this.Joint() {
+        writeServers(this.NextServers)
+    }
+    return hash.Sum64()
+}
+
+// Configuration returns the membership this node follows: the
+// latest in its log, which takes effect as soon as it is appended,
+// whether or not it has been committed yet.
+func (this *Node) Configuration() Configuration {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    return this.configuration()
+}
+
+// configuration returns the current membership. Must be called with
+// this.mu held.
+func (this *Node) configuration() Configuration {
+    configuration := this.members
+    configuration.ClusterId = this.config.ClusterId
+    configuration.Servers = this.resolve(this.members.Servers)
+    if this.members.Joint() {
+        configuration.NextServers = this.resolve(this.members.NextServers)
+    }
+    return configuration
+}
+
+// resolve returns a copy of servers, with the addresses the
+// AddressProvider resolves, if any. Must be called with this.mu
+// held.
+func (this *Node) resolve(servers []Server) []Server {
+    servers = append([]Server(nil), servers...)
+    if provider := this.config.AddressProvider; provider != nil {
+        for i, server := range servers {
+            if address, err := provider.ServerAddress(server.Id); err == nil {
+                servers[i].Address = address
+            }
+        }
+    }
+    return servers
+}
+
+// setMembers makes configuration the membership the node follows:
+// its peers become every server of the configuration, those of
+// both sides of a joint one. Must be called with this.mu held.
+func (this *Node) setMembers(configuration Configuration) {
+    old := this.peers
+    this.members = configuration
+    this.peers = nil
+    for _, servers := range [][]Server{configuration.Servers, configuration.NextServers} {
+        for _, server := range servers {
+            if _, ok := this.position(server.Id); !ok {
+                this.peers = append(this.peers, server.Id)
+            }
+        }
+    }
+    sortServers(this.peers)
+    if this.nodeType == Leader {
+        this.remapPeers(old)
+    }
+}
+
+// position returns the position of the peer with the given ID.
+// Must be called with this.mu held.
+func (this *Node) position(id ServerId) (int, bool) {
+    for i, peer := range this.peers {
+        if peer == id {
+            return i, true
+        }
+    }
+    return 0, false
+}
+
+// remapPeers carries the replication state of the leader over to
+// the peers of a new membership, by ID: peers that stay keep theirs,
+// new peers start from the end of the log, and the snapshot
+// transfers and handover to peers that left end. Must be called with
+// this.mu held, after this.peers has changed from old.
+func (this *Node) remapPeers(old []ServerId) {
+    nextIndex := make([]int, len(this.peers))
+    matchIndex := make([]int, len(this.peers))
+    inflight := make([]int, len(this.peers))
+    inflightBytes := make([]int, len(this.peers))
+    pipeline := make([]bool, len(this.peers))
+    epoch := make([]int, len(this.peers))
+    transfers := make([]*snapshotTransfer, len(this.peers))
+    lastContact := make([]time.Time, len(this.peers))
+    reachable := make([]bool, len(this.peers))
+    failures := make([]int, len(this.peers))
+    backoff := make([]int, len(this.peers))
+    for i, peer := range this.peers {
+        nextIndex[i] = this.lastLogIndex() + 1
+        reachable[i] = true
+        for j, oldPeer := range old {
+            if oldPeer == peer {
+                nextIndex[i], matchIndex[i] = this.nextIndex[j], this.matchIndex[j]
+                inflight[i], inflightBytes[i] = this.inflight[j], this.inflightBytes[j]
+                pipeline[i], epoch[i] = this.pipeline[j], this.epoch[j]
+                transfers[i], this.transfers[j] = this.transfers[j], nil
+                lastContact[i], reachable[i] = this.lastContact[j], this.reachable[j]
+                failures[i], backoff[i] = this.failures[j], this.backoff[j]
+            }
+        }
+    }
+    this.endTransfers()
+
+    if transfer := this.leaderTransfer; transfer != nil {
+        if i, ok := this.position(old[transfer.peer]); ok {
+            transfer.peer = i
+        } else {
+            this.endLeaderTransfer(fmt.Errorf("%w: %q left the cluster", ErrLeadershipTransferFailed, old[transfer.peer]))
+        }
+    }
+
+    this.nextIndex, this.matchIndex = nextIndex, matchIndex
+    this.inflight, this.inflightBytes = inflight, inflightBytes
+    this.pipeline, this.epoch = pipeline, epoch
+    this.transfers = transfers
+    this.lastContact, this.reachable = lastContact, reachable
+    this.failures, this.backoff = failures, backoff
+}
+
+// isMember reports whether the node belongs to the membership it
+// follows, and so may stand for election. Must be called with
+// this.mu held.
+func (this *Node) isMember() bool {
+    _, ok := this.position(this.id)
+    return ok
+}
+
+// isVoter reports whether the member id votes: whether it is a
+// member and not a replica, on either side of a change of
+// membership. Must be called with this.mu held.
+func (this *Node) isVoter(id ServerId) bool {
+    for _, voters := range this.voterSets() {
+        for _, server := range voters {
+            if server.Id == id {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// voterSets returns the sets of servers a decision needs a quorum
+// of: the voting members, and during a change of membership the
+// voting members being moved to as well. Must be called with
+// this.mu held.
+func (this *Node) voterSets() [][]Server {
+    if this.members.Joint() {
+        return [][]Server{voters(this.members.Servers), voters(this.members.NextServers)}
+    }
+    return [][]Server{voters(this.members.Servers)}
+}
+
+// voters returns the servers that are not replicas.
+func voters(servers []Server) []Server {
+    for i, server := range servers {
+        if server.Replica {
+            kept := append([]Server(nil), servers[:i]...)
+            for _, server := range servers[i+1:] {
+                if !server.Replica {
+                    kept = append(kept, server)
+                }
+            }
+            return kept
+        }
+    }
+    return servers
+}
+
+// hasQuorum reports whether the servers for which acked holds
+// include a majority of every voter set. Must be called with
+// this.mu held.
+func (this *Node) hasQuorum(acked func(id ServerId) bool) bool {
+    return this.hasQuorumOf(acked, func(voters int) int {
+        return voters/2 + 1
+    })
+}
+
+// hasQuorumOf reports whether the servers for which acked holds
+// include, of every voter set, as many as quorum requires of a set
+// of its size. A node without members has no quorum. Must be
+// called with this.mu held.
+func (this *Node) hasQuorumOf(acked func(id ServerId) bool, quorum func(voters int) int) bool {
+    if len(this.members.Servers) == 0 {
+        return false
+    }
+    for _, voters := range this.voterSets() {
+        count := 0
+        for _, server := range voters {
+            if acked(server.Id) {
+                count++
+            }
+        }
+        if count < quorum(len(voters)) {
+            return false
+        }
+    }
+    return true
+}
+
+// configurationAt returns the membership in force at index: the
+// latest configuration entry up to index, or else the one the log
+// starts from, which overrides the entries before it when left by
+// RecoverCluster. Must be called with this.mu held.
+func (this *Node) configurationAt(index int) Configuration {
+    for i := index - this.log[0].Index; i > 0 && this.log[i].Index > this.baseMembers.Index; i-- {
+        entry := this.log[i]
+        if entry.Type != EntryConfiguration {
+            continue
+        }
+        configuration, err := decodeMembers(entry.Command)
+        if err != nil {
+            continue
+        }
+        configuration.Index, configuration.Term = entry.Index, entry.TermNum
+        return configuration
+    }
+    return this.baseMembers
+}
+
+// appendMembers follows the membership of the configuration entries
+// among entries, just appended to the log. Must be called with
+// this.mu held.
+func (this *Node) appendMembers(entries []Entry) {
+    for _, entry := range entries {
+        if entry.Type != EntryConfiguration {
+            continue
+        }
+        configuration, err := decodeMembers(entry.Command)
+        if err != nil {
+            this.logError("decoding configuration failed", "index", entry.Index, "err", err)
+            continue
+        }
+        configuration.Index, configuration.Term = entry.Index, entry.TermNum
+        this.setMembers(configuration)
+        this.logInfo("following configuration", "index", entry.Index,
+            "members", this.peers, "joint", configuration.Joint())
+    }
+}
@@ -0,0 +1,151 @@
+package raft
+
+import (
+    "context"
+    "errors"
+    "fmt"
+)
+
+// ErrTokenMismatch is returned when a consistency token names an
+// entry this node's log holds with a different term, which means
+// the token was issued by a different cluster.
+var ErrTokenMismatch = errors.New("raft: consistency token does not match the log")
+
+// ConsistencyToken identifies a write that has been applied. A
+// client that passes it to a later read, on any node, can wait for
+// that node to have applied the write with WaitForToken and so
+// read its own writes. The zero token is satisfied immediately.
+type ConsistencyToken struct {
+    index int
+    term  int
+}
+
+// String encodes the token for handing to clients.
+func (this ConsistencyToken) String() string {
+    return fmt.Sprintf("%x.%x", this.index, this.term)
+}
+
+// ParseConsistencyToken decodes a token produced by String.
+func ParseConsistencyToken(s string) (ConsistencyToken, error) {
+    var token ConsistencyToken
+    if _, err := fmt.Sscanf(s, "%x.%x", &token.index, &token.term); err != nil {
+        return ConsistencyToken{}, fmt.Errorf("raft: malformed consistency token %q", s)
+    }
+    return token, nil
+}
+
+// Token returns the consistency token of the applied command. Only
+// valid once Error has returned nil.
+func (this *ProposeFuture) Token() ConsistencyToken {
+    <-this.done
+    return ConsistencyToken{index: this.index, term: this.term}
+}
+
+// WaitForToken blocks until this node has applied the write token
+// refers to, or ctx is done, or the node shuts down. It fails with
+// ErrWitness on a witness.
+func (this *Node) WaitForToken(ctx context.Context, token ConsistencyToken) error {
+    if err := this.WaitForAppliedIndex(ctx, token.index); err != nil {
+        return err
+    }
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    return this.checkToken(token)
+}
+
+// checkToken verifies the applied entry at the token's index is the
+// one the token names, where the log still holds it. Must be called
+// with this.mu held.
+func (this *Node) checkToken(token ConsistencyToken) error {
+    if term, ok := this.termAt(token.index); ok && token.index > 0 && term != token.term {
+        return ErrTokenMismatch
+    }
+    return nil
+}
+
+// WaitForIndex blocks until this node knows the entry at index to be
+// committed, or ctx is done, or the node shuts down. A follower
+// learns of commits from the leader's next AppendEntries, so it may
+// lag the leader by a heartbeat.
+func (this *Node) WaitForIndex(ctx context.Context, index int) error {
+    return this.waitFor(ctx, index, &this.committedWaiters, func() int { return this.commitIndex })
+}
+
+// WaitForAppliedIndex blocks until this node has applied the entry
+// at index to its state machine, or ctx is done, or the node shuts
+// down, so that a client that wrote through the leader can read its
+// write from a follower; WaitForToken also checks the entry is the
+// one written. It fails with ErrWitness on a witness.
+func (this *Node) WaitForAppliedIndex(ctx context.Context, index int) error {
+    if this.config.Witness {
+        return ErrWitness
+    }
+    return this.waitFor(ctx, index, &this.appliedWaiters, func() int { return this.lastApplied })
+}
+
+// waitFor blocks until reached, which is called with this.mu held,
+// returns index or more, queueing a waiter on waiters meanwhile.
+func (this *Node) waitFor(ctx context.Context, index int, waiters *[]appliedWaiter, reached func() int) error {
+    this.mu.Lock()
+    switch {
+    case reached() >= index:
+        this.mu.Unlock()
+        return nil
+    case this.shutdown:
+        this.mu.Unlock()
+        return ErrRaftShutdown
+    }
+    ch := make(chan struct{})
+    *waiters = append(*waiters, appliedWaiter{index: index, ch: ch})
+    this.mu.Unlock()
+
+    select {
+    case <-ch:
+        return nil
+    case <-this.closed:
+        return ErrRaftShutdown
+    case <-ctx.Done():
+        this.mu.Lock()
+        defer this.mu.Unlock()
+        for i, waiter := range *waiters {
+            if waiter.ch == ch {
+                *waiters = append((*waiters)[:i:i], (*waiters)[i+1:]...)
+                break
+            }
+        }
+        return ctx.Err()
+    }
+}
+
+// appliedWaiter is closed once lastApplied, or commitIndex for
+// committedWaiters, reaches index.
+type appliedWaiter struct {
+    index int
+    ch    chan struct{}
+}
+
+// notifyApplied wakes the waiters whose index has been applied.
+// Must be called with this.mu held.
+func (this *Node) notifyApplied() {
+    this.appliedWaiters = releaseWaiters(this.appliedWaiters, this.lastApplied)
+}
+
+// notifyCommitted wakes the waiters whose index has been committed.
+// Must be called with this.mu held.
+func (this *Node) notifyCommitted() {
+    this.committedWaiters = releaseWaiters(this.committedWaiters, this.commitIndex)
+}
+
+// releaseWaiters wakes the waiters whose index has been reached, and
+// returns the others.
+func releaseWaiters(waiters []appliedWaiter, reached int) []appliedWaiter {
+    waiting := waiters[:0]
+    for _, waiter := range waiters {
+        if waiter.index <= reached {
+            close(waiter.ch)
+        } else {
+            waiting = append(waiting, waiter)
+        }
+    }
+    return waiting
+}
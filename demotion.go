@@ -0,0 +1,59 @@
+package raft
+
+import (
+    "context"
+    "time"
+)
+
+// With Config.DemoteAfter, the leader demotes a voter it has not
+// heard from for that long to a replica marked Server.Demoted, so
+// that a voter that is down does not hold back commits, under a
+// commit quorum that needs it, or leave the cluster one failure away
+// from losing its majority. Once the replica answers again and its
+// log has caught up with the commit index, the leader promotes it
+// back. Either is a change of membership, made once the previous one
+// has completed, and never leaves the cluster without a voter.
+// Replicas an operator added, not marked Demoted, are left alone.
+
+// tickDemotion starts the change of membership that demotes an
+// unresponsive voter, or promotes back a demoted replica that has
+// caught up, if one is due. Must be called with this.mu held, as
+// leader.
+func (this *Node) tickDemotion() {
+    after := this.config.DemoteAfter
+    if after <= 0 || this.configurationChange != nil || this.members.Joint() ||
+        this.members.Index > this.commitIndex || this.leaderTransfer != nil || this.restore != nil {
+        return
+    }
+    now := this.clock.Now()
+    servers := append([]Server(nil), this.members.Servers...)
+    for k, server := range servers {
+        i, ok := this.position(server.Id)
+        if !ok || server.Id == this.id {
+            continue
+        }
+        switch {
+        case server.Demoted && this.reachable[i] && this.matchIndex[i] >= this.commitIndex:
+            servers[k].Replica, servers[k].Demoted = false, false
+            this.logInfo("promoting voter that caught up", "peer", server.Id, "term", this.currentTerm)
+        case !server.Replica && this.silence(i, now) >= after && len(voters(servers)) > 1:
+            servers[k].Replica, servers[k].Demoted = true, true
+            this.logWarn("demoting unresponsive voter", "peer", server.Id, "after", after, "term", this.currentTerm)
+        default:
+            continue
+        }
+        this.changeConfiguration(context.Background(), newProposeFuture(EntryConfiguration, nil), servers)
+        return
+    }
+}
+
+// silence returns how long the peer at position i has not answered
+// the leader, counted from when the node became leader if it has
+// not yet this term. Must be called with this.mu held, as leader.
+func (this *Node) silence(i int, now time.Time) time.Duration {
+    last := this.lastContact[i]
+    if last.Before(this.leaderSince) {
+        last = this.leaderSince
+    }
+    return now.Sub(last)
+}
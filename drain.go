@@ -0,0 +1,75 @@
+package raft
+
+import (
+    "context"
+    "errors"
+)
+
+// Drain readies the node to be taken down for maintenance. It stops
+// accepting proposals, which fail with a *NotLeaderError matching
+// ErrDraining that names the leader to resubmit them to; hands over
+// leadership if the node holds it; and then waits until the node has
+// applied every entry it knows to be committed. The node stops
+// standing for election, but goes on voting and replicating, so
+// that the cluster keeps its quorum until the node is shut down.
+//
+// Drain returns once the node is drained, or with the error of the
+// leadership transfer, or of ctx; the node stays draining either way
+// until Undrain.
+func (this *Node) Drain(ctx context.Context) error {
+    this.mu.Lock()
+    if this.shutdown {
+        this.mu.Unlock()
+        return ErrRaftShutdown
+    }
+    if !this.draining {
+        this.logInfo("draining", "term", this.currentTerm)
+    }
+    this.draining = true
+    leader := this.nodeType == Leader
+    this.mu.Unlock()
+
+    if leader {
+        err := this.LeadershipTransfer(ctx)
+        if err != nil && !errors.Is(err, ErrNotLeader) {
+            return err
+        }
+    }
+
+    // A witness has nothing to apply.
+    if this.config.Witness {
+        return nil
+    }
+    this.mu.Lock()
+    commitIndex := this.commitIndex
+    this.mu.Unlock()
+    return this.WaitForAppliedIndex(ctx, commitIndex)
+}
+
+// Undrain ends the maintenance Drain started: the node accepts
+// proposals and stands for election again.
+func (this *Node) Undrain() {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    if this.draining {
+        this.logInfo("no longer draining", "term", this.currentTerm)
+    }
+    this.draining = false
+}
+
+// Draining reports whether the node is being drained.
+func (this *Node) Draining() bool {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    return this.draining
+}
+
+// drainingError returns the error for proposals to a draining node,
+// naming the leader unless it is this node. Must be called with
+// this.mu held.
+func (this *Node) drainingError() error {
+    if this.leaderId == this.id {
+        return &NotLeaderError{Draining: true}
+    }
+    return &NotLeaderError{LeaderId: this.leaderId, LeaderAddress: this.leaderAddress(), Draining: true}
+}
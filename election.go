@@ -0,0 +1,182 @@
+package raft
+
+import (
+    "errors"
+    "fmt"
+)
+
+// ErrCampaignRefused is returned by Campaign when the node's
+// ElectionPolicy does not let it stand for election.
+var ErrCampaignRefused = errors.New("raft: election policy refused the campaign")
+
+// Election describes an election a node is about to stand in, or a
+// candidate asks it to vote in.
+type Election struct {
+    Term      int
+    Candidate ServerId
+
+    // The end of the candidate's log.
+    LastLogIndex int
+    LastLogTerm  int
+
+    // Whether the leader handed leadership over to the candidate.
+    LeadershipTransfer bool
+}
+
+// ElectionPolicy decides, on top of the rules of the protocol,
+// whether a node stands for election and whether it grants its vote,
+// so that deployments can keep leadership in a preferred zone, or
+// off nodes under maintenance. Refusing a campaign or a vote never
+// endangers safety, only availability: a policy that refuses too
+// much leaves the cluster without a leader. Its methods are called
+// with the node's lock held and must not call back into the Node.
+type ElectionPolicy interface {
+    // AllowCampaign reports whether the node may stand in election,
+    // of which it is the candidate. A node refused waits out another
+    // election timeout.
+    AllowCampaign(election Election) bool
+
+    // AllowVote reports whether the node may vote for the candidate
+    // of election, which the protocol would let it vote for.
+    AllowVote(election Election) bool
+}
+
+// WithElectionPolicy sets the policy consulted before the node
+// campaigns or votes.
+func WithElectionPolicy(policy ElectionPolicy) Option {
+    return func(this *Config) {
+        this.ElectionPolicy = policy
+    }
+}
+
+// Campaign starts an election at once, rather than when the node's
+// election timeout elapses, as for a failover planned by an
+// orchestrator, or in tests. Followers that have heard from a
+// leader within the minimum election timeout still refuse their
+// votes, unless told to ForgetLeader; LeadershipTransfer hands over
+// from a healthy leader instead. It does nothing on a leader, and
+// fails with ErrCampaignRefused if Config.ElectionPolicy refuses.
+func (this *Node) Campaign() error {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    switch {
+    case this.shutdown:
+        return ErrRaftShutdown
+    case this.nodeType == Leader:
+        return nil
+    case this.config.Witness:
+        return ErrWitness
+    case !this.isMember():
+        return fmt.Errorf("raft: %q is not a member", this.id)
+    case this.isReplica():
+        return ErrReplica
+    case this.draining:
+        return ErrDraining
+    }
+    return this.campaign(false)
+}
+
+// ForgetLeader makes a follower drop the leader it follows, without
+// changing term, so that it votes for candidates at once rather
+// than waiting out the minimum election timeout, as before taking
+// down a leader on purpose. The node learns of the leader again
+// from its next heartbeat. It does nothing on a leader or candidate.
+func (this *Node) ForgetLeader() {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    if this.nodeType == Follower {
+        this.setLeader("")
+    }
+}
+
+// campaign converts the node to a candidate and requests votes
+// from every peer, telling them whether the leader handed over
+// leadership, unless the ElectionPolicy refuses. Must be called with
+// this.mu held.
+func (this *Node) campaign(transfer bool) error {
+    if policy := this.config.ElectionPolicy; policy != nil {
+        election := Election{
+            Term:               this.currentTerm + 1,
+            Candidate:          this.id,
+            LastLogIndex:       this.lastLogIndex(),
+            LastLogTerm:        this.lastLogTerm(),
+            LeadershipTransfer: transfer,
+        }
+        if !policy.AllowCampaign(election) {
+            this.logDebug("election policy refused the campaign", "term", election.Term)
+            this.resetElectionTimer()
+            return ErrCampaignRefused
+        }
+    }
+    if err := this.becomeCandidate(); err != nil {
+        return err
+    }
+    if this.hasQuorum(func(id ServerId) bool { return this.votes[id] }) {
+        this.becomeLeader()
+        this.broadcastAppendEntries()
+        return nil
+    }
+
+    req := RequestVoteRequest{
+        Term:         this.currentTerm,
+        CandidateId:  this.id,
+        LastLogIndex: this.lastLogIndex(),
+        LastLogTerm:  this.lastLogTerm(),
+
+        LeadershipTransfer: transfer,
+    }
+    for _, peer := range this.peers {
+        if peer == this.id || !this.isVoter(peer) {
+            continue
+        }
+        from := peer
+        this.transport.RequestVote(from, req, func(resp RequestVoteResponse, err error) {
+            if err == nil {
+                this.handleRequestVoteReply(from, req.Term, resp)
+            }
+        })
+    }
+    return nil
+}
+
+// priorityDelay returns the ticks by which the node's election
+// priority holds off its campaigns: one election timeout range for
+// each step it is below the highest priority among the members it
+// has handshaken with, so that its timeouts fall after theirs.
+// Must be called with this.mu held.
+func (this *Node) priorityDelay() int {
+    highest := this.config.ElectionPriority
+    for _, peer := range this.peers {
+        highest = maxInt(highest, this.priorities[peer])
+    }
+    spread := this.config.ElectionTicksMax - this.config.ElectionTicksMin + 1
+    return (highest - this.config.ElectionPriority) * spread
+}
+
+// handleRequestVoteReply counts the vote of peer from for the
+// election held in term.
+func (this *Node) handleRequestVoteReply(from ServerId, term int, resp RequestVoteResponse) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    if this.shutdown {
+        return
+    }
+    if this.config.TLATracer != nil {
+        this.traceTLA("ReceiveRequestVoteResponse", requestVoteResponseTLA(from, this.id, resp.Term, resp.VoteGranted))
+    }
+    this.testToAbdicateLeadership(resp.Term, "")
+
+    // Ignore replies to elections we are no longer running.
+    if this.nodeType != Candidate || this.currentTerm != term || !resp.VoteGranted {
+        return
+    }
+
+    this.votes[from] = true
+    if this.hasQuorum(func(id ServerId) bool { return this.votes[id] }) {
+        this.becomeLeader()
+        this.broadcastAppendEntries()
+    }
+}
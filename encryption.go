@@ -0,0 +1,299 @@
+package raft
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "sync"
+)
+
+// ErrDecrypt is returned when stored data cannot be decrypted: it was
+// encrypted with a key the provider no longer has, or was altered.
+var ErrDecrypt = errors.New("raft: cannot decrypt")
+
+// EncryptionProvider encrypts what a node persists, so that commands
+// are not stored in the clear. Ciphertexts must be authenticated
+// along with associatedData, which is not stored with them, so that
+// a ciphertext cannot be moved to another entry undetected.
+type EncryptionProvider interface {
+    Encrypt(plaintext, associatedData []byte) ([]byte, error)
+    Decrypt(ciphertext, associatedData []byte) ([]byte, error)
+}
+
+// encryptionVersion is the first byte of ciphertexts made by
+// AESGCMProvider, followed by the key ID and the nonce.
+const encryptionVersion = 1
+
+// AESGCMProvider is an EncryptionProvider using AES-GCM under a ring
+// of keys identified by number. It encrypts with the active key and
+// decrypts with whichever key the ciphertext names, so keys can be
+// rotated without rewriting what was stored: Rotate to a new key,
+// and remove the old one once compaction has discarded everything
+// written under it. It is safe for concurrent use.
+type AESGCMProvider struct {
+    mu     sync.RWMutex
+    keys   map[uint32]cipher.AEAD
+    active uint32
+}
+
+// NewAESGCMProvider returns a provider encrypting with key, which
+// must be 16, 24 or 32 bytes long, under ID keyId.
+func NewAESGCMProvider(keyId uint32, key []byte) (*AESGCMProvider, error) {
+    this := &AESGCMProvider{keys: make(map[uint32]cipher.AEAD)}
+    if err := this.Rotate(keyId, key); err != nil {
+        return nil, err
+    }
+    return this, nil
+}
+
+// AddKey adds a key to decrypt with, without encrypting with it.
+func (this *AESGCMProvider) AddKey(keyId uint32, key []byte) error {
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return err
+    }
+    aead, err := cipher.NewGCM(block)
+    if err != nil {
+        return err
+    }
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    this.keys[keyId] = aead
+    return nil
+}
+
+// Rotate adds key and encrypts with it from now on. Older keys are
+// kept for decrypting.
+func (this *AESGCMProvider) Rotate(keyId uint32, key []byte) error {
+    if err := this.AddKey(keyId, key); err != nil {
+        return err
+    }
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    this.active = keyId
+    return nil
+}
+
+// RemoveKey forgets a key that is not the active one; what was
+// encrypted with it can no longer be read.
+func (this *AESGCMProvider) RemoveKey(keyId uint32) error {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    if keyId == this.active {
+        return fmt.Errorf("raft: key %d is the active key", keyId)
+    }
+    delete(this.keys, keyId)
+    return nil
+}
+
+func (this *AESGCMProvider) Encrypt(plaintext, associatedData []byte) ([]byte, error) {
+    this.mu.RLock()
+    keyId, aead := this.active, this.keys[this.active]
+    this.mu.RUnlock()
+
+    header := make([]byte, 5+aead.NonceSize())
+    header[0] = encryptionVersion
+    binary.BigEndian.PutUint32(header[1:5], keyId)
+    nonce := header[5:]
+    if _, err := rand.Read(nonce); err != nil {
+        return nil, err
+    }
+    return aead.Seal(header, nonce, plaintext, associatedData), nil
+}
+
+func (this *AESGCMProvider) Decrypt(ciphertext, associatedData []byte) ([]byte, error) {
+    if len(ciphertext) < 5 || ciphertext[0] != encryptionVersion {
+        return nil, fmt.Errorf("%w: unknown format", ErrDecrypt)
+    }
+    keyId := binary.BigEndian.Uint32(ciphertext[1:5])
+    this.mu.RLock()
+    aead, ok := this.keys[keyId]
+    this.mu.RUnlock()
+    if !ok {
+        return nil, fmt.Errorf("%w: no key %d", ErrDecrypt, keyId)
+    }
+    if len(ciphertext) < 5+aead.NonceSize() {
+        return nil, fmt.Errorf("%w: truncated", ErrDecrypt)
+    }
+    nonce, sealed := ciphertext[5:5+aead.NonceSize()], ciphertext[5+aead.NonceSize():]
+    plaintext, err := aead.Open(nil, nonce, sealed, associatedData)
+    if err != nil {
+        return nil, fmt.Errorf("%w: key %d: %v", ErrDecrypt, keyId, err)
+    }
+    return plaintext, nil
+}
+
+// EncryptedLogStore is a LogStore encrypting the commands of the
+// entries it stores in another. Indexes, terms and the other fields
+// stay in the clear, for the store to order entries by.
+type EncryptedLogStore struct {
+    store    LogStore
+    provider EncryptionProvider
+}
+
+// NewEncryptedLogStore returns a LogStore keeping entries in store,
+// with their commands encrypted by provider.
+func NewEncryptedLogStore(store LogStore, provider EncryptionProvider) *EncryptedLogStore {
+    return &EncryptedLogStore{store: store, provider: provider}
+}
+
+func (this *EncryptedLogStore) FirstIndex() (int, error) {
+    return this.store.FirstIndex()
+}
+
+func (this *EncryptedLogStore) LastIndex() (int, error) {
+    return this.store.LastIndex()
+}
+
+func (this *EncryptedLogStore) GetLog(index int) (Entry, error) {
+    entry, err := this.store.GetLog(index)
+    if err != nil {
+        return Entry{}, err
+    }
+    entry.Command, err = this.provider.Decrypt(entry.Command, entryAssociatedData(entry))
+    if err != nil {
+        return Entry{}, fmt.Errorf("raft: entry %d: %w", index, err)
+    }
+    return entry, nil
+}
+
+func (this *EncryptedLogStore) StoreLogs(entries []Entry) error {
+    encrypted := make([]Entry, len(entries))
+    for i, entry := range entries {
+        command, err := this.provider.Encrypt(entry.Command, entryAssociatedData(entry))
+        if err != nil {
+            return err
+        }
+        encrypted[i] = entry
+        encrypted[i].Command = command
+    }
+    return this.store.StoreLogs(encrypted)
+}
+
+func (this *EncryptedLogStore) DeleteRange(min, max int) error {
+    return this.store.DeleteRange(min, max)
+}
+
+func (this *EncryptedLogStore) String() string {
+    return "encrypted " + describe(this.store)
+}
+
+// entryAssociatedData binds the ciphertext of an entry's command to
+// the entry's place in the log and its type.
+func entryAssociatedData(entry Entry) []byte {
+    data := make([]byte, 0, 3*binary.MaxVarintLen64)
+    data = binary.AppendUvarint(data, uint64(entry.Index))
+    data = binary.AppendUvarint(data, uint64(entry.TermNum))
+    return binary.AppendUvarint(data, uint64(entry.Type))
+}
+
+// EncryptedArchive is a LogArchive, and a SnapshotArchive if the
+// archive it wraps is one, encrypting entries and snapshots before
+// handing them to another archive.
+type EncryptedArchive struct {
+    archive  LogArchive
+    provider EncryptionProvider
+}
+
+// NewEncryptedArchive returns an archive encrypting with provider
+// what it hands to archive.
+func NewEncryptedArchive(archive LogArchive, provider EncryptionProvider) *EncryptedArchive {
+    return &EncryptedArchive{archive: archive, provider: provider}
+}
+
+func (this *EncryptedArchive) Archive(entries []Entry) error {
+    encrypted := make([]Entry, len(entries))
+    for i, entry := range entries {
+        command, err := this.provider.Encrypt(entry.Command, entryAssociatedData(entry))
+        if err != nil {
+            return err
+        }
+        encrypted[i] = entry
+        encrypted[i].Command = command
+    }
+    return this.archive.Archive(encrypted)
+}
+
+// ArchiveSnapshot encrypts the snapshot whole, bound to the index
+// and term it covers; DecryptSnapshot reverses it. It does nothing
+// if the wrapped archive keeps no snapshots.
+func (this *EncryptedArchive) ArchiveSnapshot(meta SnapshotMeta, snapshot []byte) error {
+    archive, ok := this.archive.(SnapshotArchive)
+    if !ok {
+        return nil
+    }
+    encrypted, err := this.provider.Encrypt(snapshot, snapshotAssociatedData(meta.Index, meta.Term))
+    if err != nil {
+        return err
+    }
+    return archive.ArchiveSnapshot(meta, encrypted)
+}
+
+// DecryptSnapshot decrypts a snapshot archived by an EncryptedArchive
+// under the index and term it covers, as named in the archive.
+func DecryptSnapshot(provider EncryptionProvider, index, term int, encrypted []byte) ([]byte, error) {
+    return provider.Decrypt(encrypted, snapshotAssociatedData(index, term))
+}
+
+// DecryptEntry decrypts the command of an entry archived by an
+// EncryptedArchive.
+func DecryptEntry(provider EncryptionProvider, entry Entry) (Entry, error) {
+    command, err := provider.Decrypt(entry.Command, entryAssociatedData(entry))
+    if err != nil {
+        return Entry{}, err
+    }
+    entry.Command = command
+    return entry, nil
+}
+
+// encryptSnapshot encrypts snapshot, made by encodeSnapshot, bound to
+// the index and term it covers, which precede the ciphertext in the
+// clear for decryptSnapshot to find.
+func encryptSnapshot(provider EncryptionProvider, snapshot []byte) ([]byte, error) {
+    state, err := decodeSnapshot(snapshot)
+    if err != nil {
+        return nil, err
+    }
+    header := snapshotAssociatedData(state.index, state.term)
+    encrypted, err := provider.Encrypt(snapshot, header)
+    if err != nil {
+        return nil, err
+    }
+    return append(header, encrypted...), nil
+}
+
+// decryptSnapshot decrypts a snapshot encrypted by encryptSnapshot,
+// failing with ErrDecrypt unless it covers the index and term it was
+// stored under.
+func decryptSnapshot(provider EncryptionProvider, stored []byte) ([]byte, error) {
+    index, n := binary.Uvarint(stored)
+    if n <= 0 {
+        return nil, fmt.Errorf("%w: snapshot has no index", ErrDecrypt)
+    }
+    term, m := binary.Uvarint(stored[n:])
+    if m <= 0 {
+        return nil, fmt.Errorf("%w: snapshot has no term", ErrDecrypt)
+    }
+    snapshot, err := provider.Decrypt(stored[n+m:], stored[:n+m])
+    if err != nil {
+        return nil, err
+    }
+    state, err := decodeSnapshot(snapshot)
+    if err != nil {
+        return nil, err
+    }
+    if uint64(state.index) != index || uint64(state.term) != term {
+        return nil, fmt.Errorf("%w: snapshot of index %d, term %d stored as index %d, term %d",
+            ErrDecrypt, state.index, state.term, index, term)
+    }
+    return snapshot, nil
+}
+
+func snapshotAssociatedData(index, term int) []byte {
+    data := make([]byte, 0, 2*binary.MaxVarintLen64)
+    data = binary.AppendUvarint(data, uint64(index))
+    return binary.AppendUvarint(data, uint64(term))
+}
@@ -0,0 +1,152 @@
+package raft
+
+import "errors"
+
+// The errors returned by Propose, LeadershipTransfer and the other
+// operations clients call. They tell clients whether to redirect,
+// retry or give up:
+//
+//   - ErrNotLeader: resubmit to the leader the *NotLeaderError names.
+//   - ErrLeadershipLost and ErrLeadershipLostWhileCommitting: the
+//     command was not applied and may be resubmitted to the new
+//     leader, unless the *LeadershipLostError says it was appended,
+//     in which case only idempotent commands should be.
+//   - ErrLeadershipTransferInProgress and ErrEnqueueTimeout: retry
+//     later, on the same or the new leader.
+//   - ErrRaftShutdown, ErrCommandTooLarge, ErrUnsupportedProtocol:
+//     retrying the same request on the same node is pointless.
+//   - The error of the context an operation was given, once it is
+//     done: a command already appended may still be applied, so
+//     only idempotent commands should be resubmitted.
+//
+// Proposals also fail with the errors Config.Apply returns, for
+// commands that were applied.
+var (
+    // ErrNotLeader is returned when an operation that only the
+    // leader can perform is attempted on another node. Errors
+    // returned by a node matching it are *NotLeaderError.
+    ErrNotLeader = errors.New("raft: node is not the leader")
+
+    // ErrLeadershipLost is returned for proposals not yet applied
+    // when their node stopped being the leader. Proposals still
+    // queued were never appended to the log, so they may safely be
+    // resubmitted to the new leader; those appended may yet be
+    // committed by it. Errors matching it are *LeadershipLostError,
+    // whose Appended field tells the two apart.
+    ErrLeadershipLost = errors.New("raft: leadership lost before the command was appended")
+
+    // ErrLeadershipLostWhileCommitting is returned for a proposal
+    // that was appended to the log but replaced by a later leader
+    // before it was committed. It was never applied, so it may
+    // safely be resubmitted.
+    ErrLeadershipLostWhileCommitting = errors.New("raft: leadership lost while committing the command")
+
+    // ErrLeadershipTransferInProgress is returned while the leader
+    // is handing over leadership.
+    ErrLeadershipTransferInProgress = errors.New("raft: leadership transfer in progress")
+
+    // ErrRaftShutdown is returned by operations on a node that has
+    // been shut down.
+    ErrRaftShutdown = errors.New("raft: node is shut down")
+
+    // ErrEnqueueTimeout is returned by operations whose timeout, or
+    // context deadline, passed before their entry could be appended
+    // to the log. In the latter case the error also matches
+    // context.DeadlineExceeded.
+    ErrEnqueueTimeout = errors.New("raft: timed out enqueuing operation")
+
+    // ErrUnsupportedProtocol is returned when a peer speaks a
+    // version of the protocol this node does not support.
+    ErrUnsupportedProtocol = errors.New("raft: protocol version not supported")
+
+    // ErrCommandTooLarge is returned by Propose for a command that
+    // would not fit in a single message.
+    ErrCommandTooLarge = errors.New("raft: command exceeds the maximum message size")
+
+    // ErrDraining is returned for proposals to a node being drained
+    // for maintenance. Errors matching it are *NotLeaderError, and
+    // match ErrNotLeader too: the proposal may be resubmitted to the
+    // leader it names, or once one is elected.
+    ErrDraining = errors.New("raft: node is draining")
+)
+
+// NotLeaderError is the ErrNotLeader returned by a node, with a hint
+// of where to redirect the request.
+type NotLeaderError struct {
+    // The leader of the node's current term, if known, else empty.
+    LeaderId ServerId
+
+    // Where the leader can be reached, as Node.Leader returns it;
+    // empty if unknown.
+    LeaderAddress ServerAddress
+
+    // Set if the node refused because it is draining, even if it
+    // has yet to hand over leadership; the error then matches
+    // ErrDraining as well.
+    Draining bool
+}
+
+func (this *NotLeaderError) Error() string {
+    message := ErrNotLeader.Error()
+    if this.Draining {
+        message = ErrDraining.Error()
+    }
+    if this.LeaderId == "" {
+        return message
+    }
+    return message + " (leader " + leaderHint(this.LeaderId, this.LeaderAddress) + ")"
+}
+
+func (this *NotLeaderError) Is(target error) bool {
+    return target == ErrNotLeader || this.Draining && target == ErrDraining
+}
+
+// LeadershipLostError is the ErrLeadershipLost returned to a
+// proposal, with a hint of where to resubmit it.
+type LeadershipLostError struct {
+    // The new leader, if known when leadership was lost, else
+    // empty, and where it can be reached, if known.
+    LeaderId      ServerId
+    LeaderAddress ServerAddress
+
+    // Set if the command had been appended to the log, but not
+    // committed, when leadership was lost. A later leader may still
+    // commit and apply it, so it is only safe to resubmit if it is
+    // idempotent or proposed through a client session.
+    Appended bool
+}
+
+func (this *LeadershipLostError) Error() string {
+    message := ErrLeadershipLost.Error()
+    if this.Appended {
+        message = "raft: leadership lost before the command was committed"
+    }
+    if this.LeaderId == "" {
+        return message
+    }
+    return message + " (new leader " + leaderHint(this.LeaderId, this.LeaderAddress) + ")"
+}
+
+func (this *LeadershipLostError) Is(target error) bool {
+    return target == ErrLeadershipLost
+}
+
+// leaderHint describes a leader in the message of an error.
+func leaderHint(id ServerId, address ServerAddress) string {
+    if address == "" {
+        return string(id)
+    }
+    return string(id) + " at " + string(address)
+}
+
+// notLeader returns the error for an operation only the leader can
+// perform. Must be called with this.mu held.
+func (this *Node) notLeader() error {
+    return &NotLeaderError{LeaderId: this.leaderId, LeaderAddress: this.leaderAddress()}
+}
+
+// leadershipLost returns the error for proposals failed when the
+// node lost leadership. Must be called with this.mu held.
+func (this *Node) leadershipLost(appended bool) error {
+    return &LeadershipLostError{LeaderId: this.leaderId, LeaderAddress: this.leaderAddress(), Appended: appended}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "sync"
+)
+
+// command is a write to the store, as proposed to the cluster.
+type command struct {
+    Op    string
+    Key   string
+    Value string `json:",omitempty"`
+}
+
+// The operations of a command.
+const (
+    opPut    = "put"
+    opDelete = "delete"
+)
+
+// kv is the state machine: a map of keys to values. The node applies
+// commands to it and snapshots it; the HTTP API reads it.
+type kv struct {
+    mu   sync.RWMutex
+    data map[string]string
+}
+
+func newKV() *kv {
+    return &kv{data: make(map[string]string)}
+}
+
+// apply applies a command, as Config.Apply, returning whether the
+// key was present before it.
+func (this *kv) apply(data []byte) (any, error) {
+    var cmd command
+    if err := json.Unmarshal(data, &cmd); err != nil {
+        return nil, fmt.Errorf("kvstore: undecodable command: %v", err)
+    }
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    _, existed := this.data[cmd.Key]
+    switch cmd.Op {
+    case opPut:
+        this.data[cmd.Key] = cmd.Value
+    case opDelete:
+        delete(this.data, cmd.Key)
+    default:
+        return nil, fmt.Errorf("kvstore: unknown operation %q", cmd.Op)
+    }
+    return existed, nil
+}
+
+// get returns the value of key, and whether it is present.
+func (this *kv) get(key string) (string, bool) {
+    this.mu.RLock()
+    defer this.mu.RUnlock()
+    value, ok := this.data[key]
+    return value, ok
+}
+
+// Snapshot returns the whole map. encoding/json writes map keys in
+// order, so every replica snapshots the same state alike.
+func (this *kv) Snapshot() ([]byte, error) {
+    this.mu.RLock()
+    defer this.mu.RUnlock()
+    return json.Marshal(this.data)
+}
+
+// Restore replaces the map with a snapshot.
+func (this *kv) Restore(snapshot []byte) error {
+    data := make(map[string]string)
+    if err := json.Unmarshal(snapshot, &data); err != nil {
+        return err
+    }
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    this.data = data
+    return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/tawawhite/raft"
+)
+
+// startCluster starts a cluster of three members on local ports,
+// snapshotting every threshold entries, and returns them and their
+// addresses.
+func startCluster(t *testing.T, threshold int) ([]*server, []string) {
+    addresses := make(raft.StaticAddresses)
+    var listeners []net.Listener
+    for i := 1; i <= 3; i++ {
+        listener, err := net.Listen("tcp", "127.0.0.1:0")
+        if err != nil {
+            t.Fatal(err)
+        }
+        listeners = append(listeners, listener)
+        addresses[raft.ServerId(fmt.Sprint(i))] = raft.ServerAddress(listener.Addr().String())
+    }
+    var servers []*server
+    var urls []string
+    for i, listener := range listeners {
+        server, err := newServer(raft.ServerId(fmt.Sprint(i+1)), addresses,
+            raft.WithTimeouts(2, 10, 20),
+            func(config *raft.Config) {
+                config.TickInterval = 10 * time.Millisecond
+                config.SnapshotThreshold = threshold
+            })
+        if err != nil {
+            t.Fatal(err)
+        }
+        httpServer := &http.Server{Handler: server.handler()}
+        go httpServer.Serve(listener)
+        server.node.Start()
+        t.Cleanup(func() {
+            server.node.Shutdown(context.Background())
+            httpServer.Close()
+        })
+        servers = append(servers, server)
+        urls = append(urls, "http://"+listener.Addr().String())
+    }
+    return servers, urls
+}
+
+// do sends a request to the member at url, following redirects, and
+// returns the status and body of the answer, retrying while the
+// cluster has no leader.
+func do(t *testing.T, url, method, key, value string) (int, string) {
+    t.Helper()
+    url += "/kv/" + key
+    for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+        req, err := http.NewRequest(method, url, strings.NewReader(value))
+        if err != nil {
+            t.Fatal(err)
+        }
+        resp, err := http.DefaultClient.Do(req)
+        if err != nil {
+            t.Fatal(err)
+        }
+        body, _ := io.ReadAll(resp.Body)
+        resp.Body.Close()
+        if resp.StatusCode != http.StatusServiceUnavailable || time.Now().After(deadline) {
+            return resp.StatusCode, string(body)
+        }
+    }
+}
+
+func TestKVStore(t *testing.T) {
+    _, urls := startCluster(t, 1024)
+
+    // Every member serves every request, redirecting it to the
+    // leader.
+    for i, member := range urls {
+        key := fmt.Sprint("key", i)
+        if status, body := do(t, member, http.MethodPut, key, "one"); status != http.StatusCreated {
+            t.Fatalf("PUT %s to member %d: %d %s", key, i, status, body)
+        }
+        if status, _ := do(t, member, http.MethodPut, key, "two"); status != http.StatusNoContent {
+            t.Fatalf("PUT %s again: %d", key, status)
+        }
+        for _, reader := range urls {
+            if status, body := do(t, reader, http.MethodGet, key, ""); status != http.StatusOK || body != "two" {
+                t.Fatalf("GET %s: %d %q", key, status, body)
+            }
+        }
+    }
+    if status, _ := do(t, urls[1], http.MethodDelete, "key0", ""); status != http.StatusNoContent {
+        t.Fatalf("DELETE: %d", status)
+    }
+    if status, _ := do(t, urls[2], http.MethodGet, "key0", ""); status != http.StatusNotFound {
+        t.Fatalf("GET of a deleted key: %d", status)
+    }
+    if status, _ := do(t, urls[0], http.MethodDelete, "key0", ""); status != http.StatusNotFound {
+        t.Fatalf("DELETE of a deleted key: %d", status)
+    }
+}
+
+func TestKVStoreSnapshots(t *testing.T) {
+    servers, urls := startCluster(t, 5)
+    for i := 0; i < 20; i++ {
+        if status, body := do(t, urls[i%3], http.MethodPut, fmt.Sprint("key", i), fmt.Sprint(i)); status != http.StatusCreated {
+            t.Fatalf("PUT: %d %s", status, body)
+        }
+    }
+
+    // Members compact their logs every few entries, and a fresh store
+    // restored from a snapshot holds the same keys.
+    for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+        if _, ok := servers[0].kv.get("key19"); ok {
+            break
+        }
+        if time.Now().After(deadline) {
+            t.Fatal("member 1 never applied the writes")
+        }
+    }
+    if err := servers[0].node.Snapshot().Error(); err != nil {
+        t.Fatal(err)
+    }
+    snapshot, err := servers[0].kv.Snapshot()
+    if err != nil {
+        t.Fatal(err)
+    }
+    restored := newKV()
+    if err := restored.Restore(snapshot); err != nil {
+        t.Fatal(err)
+    }
+    for i := 0; i < 20; i++ {
+        if value, _ := restored.get(fmt.Sprint("key", i)); value != fmt.Sprint(i) {
+            t.Fatalf("restored key%d is %q", i, value)
+        }
+    }
+}
@@ -0,0 +1,72 @@
+// Command kvstore is an example key-value store replicated with the
+// raft package: every member serves the Raft RPCs and an HTTP API
+// of GET, PUT and DELETE on /kv/{key}, and redirects writes and
+// reads to the leader. Start three members with
+//
+//    kvstore -id 1 -cluster 1=127.0.0.1:8301,2=127.0.0.1:8302,3=127.0.0.1:8303
+//    kvstore -id 2 -cluster 1=127.0.0.1:8301,2=127.0.0.1:8302,3=127.0.0.1:8303
+//    kvstore -id 3 -cluster 1=127.0.0.1:8301,2=127.0.0.1:8302,3=127.0.0.1:8303
+//
+// then use any of them:
+//
+//    curl -L -X PUT -d world http://127.0.0.1:8302/kv/hello
+//    curl -L http://127.0.0.1:8303/kv/hello
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "os/signal"
+    "strings"
+
+    "github.com/tawawhite/raft"
+)
+
+func main() {
+    id := flag.String("id", "", "ID of this member")
+    cluster := flag.String("cluster", "", "members of the cluster, as id=host:port,...")
+    flag.Parse()
+
+    addresses, err := parseCluster(*cluster)
+    if err != nil {
+        log.Fatal(err)
+    }
+    address, ok := addresses[raft.ServerId(*id)]
+    if !ok {
+        log.Fatalf("kvstore: -id %q is not a member of -cluster", *id)
+    }
+    server, err := newServer(raft.ServerId(*id), addresses)
+    if err != nil {
+        log.Fatal(err)
+    }
+    httpServer := &http.Server{Addr: string(address), Handler: server.handler()}
+    server.node.Start()
+
+    go func() {
+        interrupt := make(chan os.Signal, 1)
+        signal.Notify(interrupt, os.Interrupt)
+        <-interrupt
+        server.node.Shutdown(context.Background())
+        httpServer.Shutdown(context.Background())
+    }()
+    if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+        log.Fatal(err)
+    }
+}
+
+// parseCluster parses the -cluster flag.
+func parseCluster(cluster string) (raft.StaticAddresses, error) {
+    addresses := make(raft.StaticAddresses)
+    for _, member := range strings.Split(cluster, ",") {
+        id, address, ok := strings.Cut(member, "=")
+        if !ok || id == "" || address == "" {
+            return nil, fmt.Errorf("kvstore: bad member %q in -cluster", member)
+        }
+        addresses[raft.ServerId(id)] = raft.ServerAddress(address)
+    }
+    return addresses, nil
+}
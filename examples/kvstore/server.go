@@ -0,0 +1,156 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "io"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/tawawhite/raft"
+)
+
+// snapshotThreshold is how many entries a node applies between
+// snapshots of the store, past which it compacts its log.
+const snapshotThreshold = 1024
+
+// maxValueSize is the largest value a PUT may store.
+const maxValueSize = 1 << 20
+
+// requestTimeout bounds how long a request waits for the cluster.
+const requestTimeout = 5 * time.Second
+
+// server is a member of the cluster, serving its Raft RPCs under
+// /raft/ and the key-value API under /kv/:
+//
+//    GET /kv/{key}       the value of key, or 404
+//    PUT /kv/{key}       stores the body as the value of key
+//    DELETE /kv/{key}    deletes key, or 404
+//
+// Writes are proposed to the cluster, and reads wait for a barrier
+// to be applied, so both see every write acknowledged before them.
+// Requests to a follower are redirected to the leader with a 307.
+type server struct {
+    node *raft.Node
+    kv   *kv
+}
+
+// newServer creates the member id of the cluster whose members serve
+// at addresses, configured by options after the defaults of the
+// example. The node keeps its log in memory, so a member that
+// restarts rejoins empty and catches up from the others.
+func newServer(id raft.ServerId, addresses raft.StaticAddresses, options ...raft.Option) (*server, error) {
+    this := &server{kv: newKV()}
+    var peers []raft.ServerId
+    for peer := range addresses {
+        if peer != id {
+            peers = append(peers, peer)
+        }
+    }
+    options = append([]raft.Option{
+        raft.WithTransport(raft.NewHTTPTransport(addresses, nil)),
+        raft.WithSnapshotter(this.kv, snapshotThreshold),
+        func(config *raft.Config) {
+            config.Apply = this.kv.apply
+            config.AddressProvider = addresses
+        },
+    }, options...)
+    node, err := raft.NewNode(id, peers, nil, options...)
+    if err != nil {
+        return nil, err
+    }
+    this.node = node
+    return this, nil
+}
+
+func (this *server) handler() http.Handler {
+    mux := http.NewServeMux()
+    mux.Handle("/raft/", raft.NewHTTPHandler(this.node))
+    mux.HandleFunc("GET /kv/{key}", this.get)
+    mux.HandleFunc("PUT /kv/{key}", this.put)
+    mux.HandleFunc("DELETE /kv/{key}", this.delete)
+    return mux
+}
+
+func (this *server) get(w http.ResponseWriter, r *http.Request) {
+    ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+    defer cancel()
+    if err := this.node.BarrierContext(ctx).Error(); err != nil {
+        this.fail(w, r, err)
+        return
+    }
+    value, ok := this.kv.get(r.PathValue("key"))
+    if !ok {
+        http.NotFound(w, r)
+        return
+    }
+    io.WriteString(w, value)
+}
+
+func (this *server) put(w http.ResponseWriter, r *http.Request) {
+    value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxValueSize))
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+        return
+    }
+    existed, ok := this.propose(w, r, command{Op: opPut, Key: r.PathValue("key"), Value: string(value)})
+    switch {
+    case !ok:
+    case existed:
+        w.WriteHeader(http.StatusNoContent)
+    default:
+        w.WriteHeader(http.StatusCreated)
+    }
+}
+
+func (this *server) delete(w http.ResponseWriter, r *http.Request) {
+    existed, ok := this.propose(w, r, command{Op: opDelete, Key: r.PathValue("key")})
+    switch {
+    case !ok:
+    case existed:
+        w.WriteHeader(http.StatusNoContent)
+    default:
+        http.NotFound(w, r)
+    }
+}
+
+// propose proposes cmd and returns whether its key existed before
+// it was applied, or answers the request with the error and returns
+// false.
+func (this *server) propose(w http.ResponseWriter, r *http.Request, cmd command) (existed bool, ok bool) {
+    ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+    defer cancel()
+    data, _ := json.Marshal(cmd)
+    future := this.node.Propose(ctx, data)
+    if err := future.Error(); err != nil {
+        this.fail(w, r, err)
+        return false, false
+    }
+    existed, _ = future.Result().(bool)
+    return existed, true
+}
+
+// fail answers a request the cluster could not serve: a follower
+// redirects it to the leader, if it knows of one.
+func (this *server) fail(w http.ResponseWriter, r *http.Request, err error) {
+    var notLeader *raft.NotLeaderError
+    var lost *raft.LeadershipLostError
+    switch {
+    case errors.As(err, &notLeader) && notLeader.LeaderAddress != "":
+        base := string(notLeader.LeaderAddress)
+        if !strings.Contains(base, "://") {
+            base = "http://" + base
+        }
+        http.Redirect(w, r, strings.TrimSuffix(base, "/")+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+    case errors.Is(err, raft.ErrNotLeader), errors.As(err, &lost),
+        errors.Is(err, raft.ErrLeadershipTransferInProgress):
+        w.Header().Set("Retry-After", "1")
+        http.Error(w, err.Error(), http.StatusServiceUnavailable)
+    case errors.Is(err, context.DeadlineExceeded):
+        http.Error(w, err.Error(), http.StatusGatewayTimeout)
+    default:
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+    }
+}
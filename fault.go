@@ -0,0 +1,159 @@
+package raft
+
+import "errors"
+
+// ErrInjectedFault is reported for RPCs lost to PauseNode or
+// DropNextN.
+var ErrInjectedFault = errors.New("raft: message dropped by injected fault")
+
+// PauseNode freezes the node as if its process had been suspended,
+// so tests can time failures precisely: it stops counting ticks,
+// its RPCs and the answers to RPCs already in flight are lost, and
+// the transports in this module treat it as unreachable. Proposals
+// are still accepted and queued. ResumeNode undoes it.
+func (this *Node) PauseNode() {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    this.syncTimer()
+    this.paused = true
+}
+
+// ResumeNode undoes PauseNode.
+func (this *Node) ResumeNode() {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    this.syncTimer()
+    this.paused = false
+    this.reschedule()
+}
+
+// Paused reports whether the node is paused by PauseNode.
+func (this *Node) Paused() bool {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    return this.paused
+}
+
+// DropNextN loses the next n RPCs of type msgType the node sends,
+// replacing any count left from an earlier call. Their senders see
+// ErrInjectedFault.
+func (this *Node) DropNextN(msgType MessageType, n int) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    this.drops[msgType] = n
+}
+
+// faultTransport carries a node's RPCs through its transport, in
+// the protocol version agreed with their target, unless PauseNode or
+// DropNextN loses them.
+type faultTransport struct {
+    node      *Node
+    transport Transport
+}
+
+// injectFault reports whether an RPC of type msgType about to be
+// sent is lost, in which case reply is failed, and otherwise
+// returns the reply to send it with, which loses the answer if the
+// node has been paused meanwhile. Must be called with node.mu held.
+func injectFault[Resp any](node *Node, msgType MessageType, reply func(Resp, error)) (func(Resp, error), bool) {
+    var zero Resp
+    if node.paused || node.drops[msgType] > 0 {
+        if !node.paused {
+            node.drops[msgType]--
+        }
+        go reply(zero, ErrInjectedFault)
+        return nil, true
+    }
+    return func(resp Resp, err error) {
+        if node.Paused() {
+            resp, err = zero, ErrInjectedFault
+        }
+        reply(resp, err)
+    }, false
+}
+
+func (this faultTransport) AppendEntries(
+    target ServerId,
+    req AppendEntriesRequest,
+    reply func(AppendEntriesResponse, error)) {
+    req.ProtocolVersion = this.node.protocolVersion(target)
+    if this.node.config.TLATracer != nil {
+        this.node.traceTLA("SendAppendEntriesRequest", appendEntriesTLA(this.node.id, target, req))
+    }
+    if reply, dropped := injectFault(this.node, MsgAppendEntries, reply); !dropped {
+        this.transport.AppendEntries(target, req, reply)
+    }
+}
+
+func (this faultTransport) RequestVote(
+    target ServerId,
+    req RequestVoteRequest,
+    reply func(RequestVoteResponse, error)) {
+    req.ProtocolVersion = this.node.protocolVersion(target)
+    if this.node.config.TLATracer != nil {
+        this.node.traceTLA("SendRequestVoteRequest", requestVoteTLA(this.node.id, target, req))
+    }
+    if reply, dropped := injectFault(this.node, MsgRequestVote, reply); !dropped {
+        this.transport.RequestVote(target, req, reply)
+    }
+}
+
+func (this faultTransport) InstallSnapshot(
+    target ServerId,
+    req InstallSnapshotRequest,
+    reply func(InstallSnapshotResponse, error)) {
+    req.ProtocolVersion = this.node.protocolVersion(target)
+    if reply, dropped := injectFault(this.node, MsgInstallSnapshot, reply); !dropped {
+        this.transport.InstallSnapshot(target, req, reply)
+    }
+}
+
+func (this faultTransport) Handshake(
+    target ServerId,
+    req HandshakeRequest,
+    reply func(HandshakeResponse, error)) {
+    req.ProtocolVersion = this.node.protocolVersion(target)
+    if reply, dropped := injectFault(this.node, MsgHandshake, reply); !dropped {
+        this.transport.Handshake(target, req, reply)
+    }
+}
+
+func (this faultTransport) Audit(
+    target ServerId,
+    req AuditRequest,
+    reply func(AuditResponse, error)) {
+    req.ProtocolVersion = this.node.protocolVersion(target)
+    if reply, dropped := injectFault(this.node, MsgAudit, reply); !dropped {
+        this.transport.Audit(target, req, reply)
+    }
+}
+
+func (this faultTransport) TimeoutNow(
+    target ServerId,
+    req TimeoutNowRequest,
+    reply func(TimeoutNowResponse, error)) {
+    req.ProtocolVersion = this.node.protocolVersion(target)
+    if reply, dropped := injectFault(this.node, MsgTimeoutNow, reply); !dropped {
+        this.transport.TimeoutNow(target, req, reply)
+    }
+}
+
+func (this faultTransport) Probe(
+    target ServerId,
+    req ProbeRequest,
+    reply func(ProbeResponse, error)) {
+    req.ProtocolVersion = this.node.protocolVersion(target)
+    if reply, dropped := injectFault(this.node, MsgProbe, reply); !dropped {
+        this.transport.Probe(target, req, reply)
+    }
+}
+
+func (this faultTransport) Forward(
+    target ServerId,
+    req ForwardRequest,
+    reply func(ForwardResponse, error)) {
+    req.ProtocolVersion = this.node.protocolVersion(target)
+    if reply, dropped := injectFault(this.node, MsgForward, reply); !dropped {
+        this.transport.Forward(target, req, reply)
+    }
+}
@@ -0,0 +1,119 @@
+package raft
+
+type ForwardRequest struct {
+    ProtocolVersion int
+    Type            EntryType
+    Command         []byte
+    Priority        int
+    ClientId        int
+    Sequence        int
+}
+
+// ForwardResponse carries where the leader appended a forwarded
+// proposal, or why it did not. Err is relayed to the proposer.
+type ForwardResponse struct {
+    ProtocolVersion int
+    Index           int
+    Term            int
+    Err             error
+}
+
+// ForwardRPC is invoked by a follower to append a proposal made to
+// it. The leader appends the entry at once rather than batching it,
+// since the proposer has already waited a round trip.
+func (this *Node) ForwardRPC(req ForwardRequest) ForwardResponse {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    if this.nodeType != Leader && !this.shutdown {
+        return ForwardResponse{Err: this.notLeader()}
+    }
+    future := newProposeFuture(req.Type, req.Command)
+    future.priority = req.Priority
+    future.clientId = req.ClientId
+    future.sequence = req.Sequence
+    this.enqueue(future)
+    select {
+    case <-future.Done():
+        return ForwardResponse{Err: future.err}
+    default:
+    }
+    this.flushProposals()
+    select {
+    case <-future.Done():
+        return ForwardResponse{Err: future.err}
+    default:
+    }
+    // A restore holds proposals back, and the proposer cannot wait
+    // for an index it has not been told, so the entry is dropped.
+    if future.index == 0 {
+        this.abandonProposal(future, ErrRestoreInProgress)
+        return ForwardResponse{Err: ErrRestoreInProgress}
+    }
+    return ForwardResponse{Index: future.index, Term: future.term}
+}
+
+// forward sends future's entry to the leader for appending, with
+// Config.ForwardProposals set. Once the leader answers with where it
+// appended the entry, the future waits for this node to apply it,
+// like a proposal made on the leader. Must be called with this.mu
+// held.
+func (this *Node) forward(future *ProposeFuture) {
+    req := ForwardRequest{
+        Type:     future.entryType,
+        Command:  future.command,
+        Priority: future.priority,
+        ClientId: future.clientId,
+        Sequence: future.sequence,
+    }
+    this.forwarded[future] = true
+    this.transport.Forward(this.leaderId, req, func(resp ForwardResponse, err error) {
+        this.handleForwardReply(future, resp, err)
+    })
+}
+
+// handleForwardReply tracks a forwarded proposal from where the
+// leader appended it.
+func (this *Node) handleForwardReply(future *ProposeFuture, resp ForwardResponse, err error) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    if !this.forwarded[future] {
+        return
+    }
+    delete(this.forwarded, future)
+    if err == nil {
+        err = resp.Err
+    }
+    if err != nil {
+        future.respond(err)
+        return
+    }
+
+    future.index, future.term = resp.Index, resp.Term
+    if resp.Index > this.lastApplied {
+        if replaced, ok := this.pending[resp.Index]; ok {
+            // Proposed while this node led an earlier term.
+            replaced.respond(ErrLeadershipLostWhileCommitting)
+        }
+        this.pending[resp.Index] = future
+        return
+    }
+    // Applied before the answer came back. An entry since
+    // compacted into a snapshot can no longer be told apart from
+    // one that replaced it, and is taken to be the proposal.
+    if term, ok := this.termAt(resp.Index); ok && term != resp.Term {
+        future.respond(ErrLeadershipLostWhileCommitting)
+        return
+    }
+    future.respond(nil)
+}
+
+// failForwarded fails every proposal still waiting for the leader's
+// answer with err. Must be called with this.mu held.
+func (this *Node) failForwarded(err error) {
+    for future := range this.forwarded {
+        future.respond(err)
+    }
+    this.forwarded = make(map[*ProposeFuture]bool)
+}
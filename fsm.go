@@ -0,0 +1,40 @@
+package raft
+
+// StateMachine is the contract the state machine a Node applies
+// committed commands to must honour. Every node of a cluster applies
+// the same commands in the same order, and their states only stay
+// identical if applying them is deterministic; a state machine that
+// breaks the contract does not fail loudly, its replicas silently
+// diverge.
+//
+// Apply must:
+//
+//   - depend only on the state and the command: no clocks, random
+//     numbers, environment, files or network, and no iteration over
+//     maps where the order shows in the state;
+//   - finish the command before returning, in the calling goroutine,
+//     rather than hand it to goroutines of its own;
+//   - treat a command it cannot apply as a command, recording the
+//     error in the state for clients to read, rather than panic;
+//   - not call back into the Node, which may hold its lock, nor retain
+//     command after returning.
+//
+// A StateMachine that also implements Snapshotter must return from
+// Snapshot exactly the state Restore rebuilds, under the same rules.
+//
+// The fsmcheck package is an analyzer that finds the commonest
+// violations at build time. NewNode takes the Apply method, or any
+// function meeting the contract.
+type StateMachine interface {
+    Apply(command []byte)
+}
+
+// StateMachineFunc adapts a function to StateMachine.
+type StateMachineFunc func(command []byte)
+
+// Apply calls this(command).
+func (this StateMachineFunc) Apply(command []byte) {
+    this(command)
+}
+
+var _ StateMachine = StateMachineFunc(nil)
@@ -0,0 +1,12 @@
+// Command fsmcheck runs the fsmcheck analyzer, standalone or as a
+// vet tool.
+package main
+
+import (
+    "github.com/tawawhite/raft/fsmcheck"
+    "golang.org/x/tools/go/analysis/singlechecker"
+)
+
+func main() {
+    singlechecker.Main(fsmcheck.Analyzer)
+}
@@ -0,0 +1,159 @@
+// Package fsmcheck defines an analyzer that reports the commonest
+// ways state machines break the contract of raft.StateMachine:
+// reading the clock, random numbers or the environment, and starting
+// goroutines. Any of them lets the replicas of a state machine drift
+// apart, which otherwise only surfaces as diverging state long after.
+//
+// It checks the Apply, Snapshot and Restore methods of every type,
+// and the function literals passed to raft as state machines. Run it
+// with go vet:
+//
+//    go install github.com/tawawhite/raft/fsmcheck/cmd/fsmcheck@latest
+//    go vet -vettool=$(which fsmcheck) ./...
+package fsmcheck
+
+import (
+    "go/ast"
+    "go/types"
+
+    "golang.org/x/tools/go/analysis"
+    "golang.org/x/tools/go/analysis/passes/inspect"
+    "golang.org/x/tools/go/ast/inspector"
+    "golang.org/x/tools/go/types/typeutil"
+)
+
+const raftPath = "github.com/tawawhite/raft"
+
+// Analyzer reports nondeterminism in raft state machines.
+var Analyzer = &analysis.Analyzer{
+    Name:     "fsmcheck",
+    Doc:      "report nondeterminism in raft state machines",
+    Requires: []*analysis.Analyzer{inspect.Analyzer},
+    Run:      run,
+}
+
+// The functions state machines must not call, by package, and why.
+// An empty set of names stands for every function of the package.
+var forbidden = map[string]struct {
+    names  map[string]bool
+    reason string
+}{
+    "time":         {map[string]bool{"Now": true, "Since": true, "Until": true}, "reads the clock"},
+    "math/rand":    {nil, "uses random numbers"},
+    "math/rand/v2": {nil, "uses random numbers"},
+    "crypto/rand":  {nil, "uses random numbers"},
+    "os":           {map[string]bool{"Getenv": true, "LookupEnv": true, "Environ": true, "Hostname": true}, "reads the environment"},
+}
+
+// Constructors of seeded random number generators, which are as
+// deterministic as their seed, and allowed.
+var seeded = map[string]bool{"New": true, "NewSource": true, "NewPCG": true, "NewChaCha8": true, "NewZipf": true}
+
+func run(pass *analysis.Pass) (any, error) {
+    inspect := pass.ResultOf[inspect.Analyzer].(*inspector.Inspector)
+    filter := []ast.Node{(*ast.FuncDecl)(nil), (*ast.CallExpr)(nil)}
+    inspect.Preorder(filter, func(node ast.Node) {
+        switch node := node.(type) {
+        case *ast.FuncDecl:
+            if node.Body != nil && isContractMethod(pass, node) {
+                check(pass, node.Name.Name, node.Body)
+            }
+        case *ast.CallExpr:
+            if lit, ok := stateMachineArg(pass, node).(*ast.FuncLit); ok {
+                check(pass, "state machine", lit.Body)
+            }
+        }
+    })
+    return nil, nil
+}
+
+// isContractMethod reports whether decl is the Apply, Snapshot or
+// Restore method of a state machine.
+func isContractMethod(pass *analysis.Pass, decl *ast.FuncDecl) bool {
+    if decl.Recv == nil {
+        return false
+    }
+    fn, ok := pass.TypesInfo.Defs[decl.Name].(*types.Func)
+    if !ok {
+        return false
+    }
+    sig := fn.Type().(*types.Signature)
+    bytes := types.NewSlice(types.Typ[types.Byte])
+    errorType := types.Universe.Lookup("error").Type()
+    switch fn.Name() {
+    case "Apply":
+        return matches(sig, []types.Type{bytes}, nil)
+    case "Snapshot":
+        return matches(sig, nil, []types.Type{bytes, errorType})
+    case "Restore":
+        return matches(sig, []types.Type{bytes}, []types.Type{errorType})
+    }
+    return false
+}
+
+// matches reports whether sig takes params and returns results.
+func matches(sig *types.Signature, params, results []types.Type) bool {
+    if sig.Params().Len() != len(params) || sig.Results().Len() != len(results) {
+        return false
+    }
+    for i, param := range params {
+        if !types.Identical(sig.Params().At(i).Type(), param) {
+            return false
+        }
+    }
+    for i, result := range results {
+        if !types.Identical(sig.Results().At(i).Type(), result) {
+            return false
+        }
+    }
+    return true
+}
+
+// stateMachineArg returns the argument of call that raft applies
+// commands to, if call is to NewNode, Registry.NewNode or
+// MultiNode.AddGroup, or a conversion to StateMachineFunc.
+func stateMachineArg(pass *analysis.Pass, call *ast.CallExpr) ast.Expr {
+    if tv, ok := pass.TypesInfo.Types[call.Fun]; ok && tv.IsType() {
+        named, ok := tv.Type.(*types.Named)
+        if ok && isRaft(named.Obj()) && named.Obj().Name() == "StateMachineFunc" && len(call.Args) == 1 {
+            return call.Args[0]
+        }
+        return nil
+    }
+    fn, ok := typeutil.Callee(pass.TypesInfo, call).(*types.Func)
+    if !ok || !isRaft(fn) || len(call.Args) < 3 {
+        return nil
+    }
+    switch fn.Name() {
+    case "NewNode", "AddGroup":
+        return call.Args[2]
+    }
+    return nil
+}
+
+func isRaft(obj types.Object) bool {
+    return obj.Pkg() != nil && obj.Pkg().Path() == raftPath
+}
+
+// check reports the calls and go statements in body that break the
+// contract.
+func check(pass *analysis.Pass, what string, body *ast.BlockStmt) {
+    ast.Inspect(body, func(node ast.Node) bool {
+        switch node := node.(type) {
+        case *ast.GoStmt:
+            pass.Reportf(node.Pos(), "%s starts a goroutine: state machines must apply commands synchronously", what)
+        case *ast.CallExpr:
+            fn, ok := typeutil.Callee(pass.TypesInfo, node).(*types.Func)
+            if !ok || fn.Pkg() == nil || fn.Type().(*types.Signature).Recv() != nil {
+                return true
+            }
+            rule, ok := forbidden[fn.Pkg().Path()]
+            if !ok || (rule.names != nil && !rule.names[fn.Name()]) || (rule.names == nil && seeded[fn.Name()]) {
+                return true
+            }
+            pass.Reportf(node.Pos(), "%s %s by calling %s.%s: state machines must be deterministic",
+                what, rule.reason, fn.Pkg().Name(), fn.Name())
+        }
+        return true
+    })
+}
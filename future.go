@@ -0,0 +1,136 @@
+package raft
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "time"
+)
+
+// ProposeFuture tracks a proposed command, or a barrier, until it
+// has been applied to the state machine.
+type ProposeFuture struct {
+    entryType EntryType
+    command   []byte
+    priority  int
+    clientId  int
+    sequence  int
+
+    // When the entry must have been appended by, if ever.
+    deadline time.Time
+
+    // Set when the command is appended to the log.
+    index int
+    term  int
+
+    // Set when the command is applied, by Config.Apply.
+    result any
+
+    err  error
+    done chan struct{}
+}
+
+func newProposeFuture(entryType EntryType, command []byte) *ProposeFuture {
+    return &ProposeFuture{
+        entryType: entryType,
+        command:   command,
+        done:      make(chan struct{}),
+    }
+}
+
+// Error blocks until the command has been applied, or has failed,
+// and returns nil, the error Config.Apply returned for it, or the
+// reason it failed.
+func (this *ProposeFuture) Error() error {
+    <-this.done
+    return this.err
+}
+
+// Done is closed once Error would no longer block.
+func (this *ProposeFuture) Done() <-chan struct{} {
+    return this.done
+}
+
+// Index returns the log index the command was applied at. Only
+// valid once Error has returned nil.
+func (this *ProposeFuture) Index() int {
+    <-this.done
+    return this.index
+}
+
+// Term returns the term the command was appended in. Only valid
+// once Error has returned nil.
+func (this *ProposeFuture) Term() int {
+    <-this.done
+    return this.term
+}
+
+// Result returns what Config.Apply returned for the command, or nil
+// if it is not set. A command retried in a client session and
+// found already applied has no result. Only valid once Done is
+// closed.
+func (this *ProposeFuture) Result() any {
+    <-this.done
+    return this.result
+}
+
+// respond resolves the future. Must be called at most once.
+func (this *ProposeFuture) respond(err error) {
+    this.err = err
+    close(this.done)
+}
+
+// watchProposal fails future with the error of ctx if ctx is done
+// before the future resolves. Must be called with this.mu held,
+// before future is enqueued.
+func (this *Node) watchProposal(ctx context.Context, future *ProposeFuture) {
+    if ctx.Done() == nil {
+        return
+    }
+    if err := ctx.Err(); err != nil {
+        future.respond(err)
+        return
+    }
+    go func() {
+        select {
+        case <-future.done:
+        case <-ctx.Done():
+            this.mu.Lock()
+            defer this.mu.Unlock()
+            this.abandonProposal(future, ctx.Err())
+        }
+    }()
+}
+
+// abandonProposal fails future with err, the error of its caller's
+// context, and stops tracking it. A proposal still queued is never
+// appended, and fails with ErrEnqueueTimeout if its deadline passed;
+// one already appended may yet be committed and applied. Must be
+// called with this.mu held.
+func (this *Node) abandonProposal(future *ProposeFuture, err error) {
+    if isClosed(future.done) {
+        return
+    }
+    for i, queued := range this.proposals {
+        if queued != future {
+            continue
+        }
+        this.proposals = append(this.proposals[:i], this.proposals[i+1:]...)
+        this.proposalBytes -= len(future.command)
+        if errors.Is(err, context.DeadlineExceeded) {
+            err = fmt.Errorf("%w: %w", ErrEnqueueTimeout, err)
+        }
+        future.respond(err)
+        return
+    }
+    if this.pending[future.index] == future {
+        delete(this.pending, future.index)
+    }
+    delete(this.forwarded, future)
+
+    // The change of membership goes on without its caller.
+    if this.configurationChange == future {
+        this.configurationChange = newProposeFuture(EntryConfiguration, nil)
+    }
+    future.respond(err)
+}
@@ -0,0 +1,128 @@
+package raft
+
+import "testing"
+
+// fuzzNode returns a follower of "b" holding entries 1 to 5, of
+// terms 1, 1, 2, 2 and 3, of which 2 are committed.
+func fuzzNode(t *testing.T) *Node {
+    node, err := NewRegistry().NewNode("a", []ServerId{"b", "c"}, func([]byte) {})
+    if err != nil {
+        t.Fatal(err)
+    }
+    var entries []Entry
+    for i, term := range []int{1, 1, 2, 2, 3} {
+        entries = append(entries, Entry{Type: EntryNormal, Index: i + 1, TermNum: term, Command: []byte("x")})
+    }
+    if _, success, err := node.AppendEntriesRPC(3, "b", 0, 0, entries, 2); err != nil || !success {
+        t.Fatalf("seeding the log: success %v, %v", success, err)
+    }
+    return node
+}
+
+// checkLog fails t unless the node's log is contiguous, its terms
+// never decrease and none exceeds the node's term, and its commit
+// index, no lower than before, is within the log.
+func checkLog(t *testing.T, node *Node, commitIndex int) {
+    t.Helper()
+    node.mu.Lock()
+    defer node.mu.Unlock()
+    for i := 1; i < len(node.log); i++ {
+        previous, entry := node.log[i-1], node.log[i]
+        if entry.Index != previous.Index+1 || entry.TermNum < previous.TermNum || entry.TermNum > node.currentTerm {
+            t.Fatalf("log has %+v after %+v in term %d", entry, previous, node.currentTerm)
+        }
+    }
+    if node.commitIndex < commitIndex || node.commitIndex > node.lastLogIndex() {
+        t.Fatalf("commit index went from %d to %d, with the log ending at %d",
+            commitIndex, node.commitIndex, node.lastLogIndex())
+    }
+}
+
+// FuzzAppendEntriesRPC sends a follower an AppendEntries of entries
+// from index first on, of the terms given, and checks that the log
+// the follower is left with is well formed and, if it accepted the
+// request, holds the entries after a matching prevLogIndex.
+func FuzzAppendEntriesRPC(f *testing.F) {
+    f.Add(3, 5, 3, 6, []byte{3, 3}, 7)
+    f.Add(4, 3, 2, 4, []byte{4}, 4)
+    f.Add(3, 0, 0, 1, []byte{1, 1, 2, 2, 3}, 5)
+    f.Add(3, 2, 1, 3, []byte{}, 1)
+    f.Add(5, 7, 3, 8, []byte{5}, 8)
+    f.Add(-1, -1, -1, -1, []byte{0}, -1)
+    f.Add(3, 4, 2, 3, []byte{3, 2}, 9)
+    f.Add(3, 0, 0, 1, []byte{3}, 5)
+    f.Fuzz(func(t *testing.T, term, prevLogIndex, prevLogTerm, first int, terms []byte, leaderCommit int) {
+        node := fuzzNode(t)
+        var entries []Entry
+        for i, entryTerm := range terms {
+            entries = append(entries, Entry{Type: EntryNormal, Index: first + i, TermNum: int(entryTerm), Command: []byte("y")})
+        }
+        node.mu.Lock()
+        before := append([]Entry(nil), node.log...)
+        commitIndex := node.commitIndex
+        node.mu.Unlock()
+
+        _, success, err := node.AppendEntriesRPC(term, "b", prevLogIndex, prevLogTerm, entries, leaderCommit)
+        checkLog(t, node, commitIndex)
+        if err != nil || !success {
+            return
+        }
+
+        // Log Matching: an accepted request matched the entry at
+        // prevLogIndex, kept the log up to it, and left the entries
+        // it carried after it.
+        node.mu.Lock()
+        defer node.mu.Unlock()
+        if term, ok := node.termAt(prevLogIndex); !ok || term != prevLogTerm {
+            t.Fatalf("accepted a request not matching the log at %d", prevLogIndex)
+        }
+        for _, entry := range before[1:minInt(prevLogIndex+1, len(before))] {
+            if term, ok := node.termAt(entry.Index); !ok || term != entry.TermNum {
+                t.Fatalf("entry %d before prevLogIndex %d changed", entry.Index, prevLogIndex)
+            }
+        }
+        for _, entry := range entries {
+            if term, ok := node.termAt(entry.Index); !ok || term != entry.TermNum {
+                t.Fatalf("accepted entry %+v missing from the log", entry)
+            }
+        }
+    })
+}
+
+// FuzzRequestVoteRPC asks a follower for its vote, and checks that
+// it grants it only to a candidate whose log is as up to date as its
+// own, in a term it has not voted in for another, and that its log
+// is left as it was.
+func FuzzRequestVoteRPC(f *testing.F) {
+    f.Add(4, "c", 5, 3, false)
+    f.Add(4, "c", 4, 3, false)
+    f.Add(3, "b", 9, 9, true)
+    f.Add(-1, "", -1, -1, false)
+    f.Add(9, "d", 6, 2, false)
+    f.Fuzz(func(t *testing.T, term int, candidateId string, lastLogIndex, lastLogTerm int, transfer bool) {
+        node := fuzzNode(t)
+        node.mu.Lock()
+        commitIndex := node.commitIndex
+        currentTerm, votedFor := node.currentTerm, node.votedFor
+        upToDate := node.isUpToDate(lastLogIndex, lastLogTerm)
+        node.mu.Unlock()
+
+        resultTerm, granted, err := node.RequestVoteRPC(term, ServerId(candidateId), lastLogIndex, lastLogTerm, transfer)
+        checkLog(t, node, commitIndex)
+        if err != nil {
+            return
+        }
+        if resultTerm < currentTerm {
+            t.Fatalf("answered in term %d, below the node's %d", resultTerm, currentTerm)
+        }
+        if !granted {
+            return
+        }
+        if !upToDate || term < currentTerm {
+            t.Fatalf("granted a vote in term %d for a log ending at %d, %d", term, lastLogIndex, lastLogTerm)
+        }
+        if term == currentTerm && votedFor != "" && votedFor != ServerId(candidateId) {
+            t.Fatalf("voted for %q in term %d, having voted for %q", candidateId, term, votedFor)
+        }
+    })
+}
@@ -0,0 +1,453 @@
+package raft
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+// The paths under which an HTTP handler serves each RPC.
+const (
+    PathAppendEntries   = "/raft/append-entries"
+    PathRequestVote     = "/raft/request-vote"
+    PathInstallSnapshot = "/raft/install-snapshot"
+    PathHandshake       = "/raft/handshake"
+    PathAudit           = "/raft/audit"
+    PathTimeoutNow      = "/raft/timeout-now"
+    PathProbe           = "/raft/probe"
+    PathForward         = "/raft/forward"
+)
+
+// ErrBodyTooLarge is answered with status 413 to a request whose
+// body, or what it decompresses to, could not hold a message within
+// the handler node's Config.MaxMessageSize.
+var ErrBodyTooLarge = errors.New("raft: request body too large")
+
+// DefaultHTTPTimeout bounds each RPC of an HTTPTransport created
+// without an http.Client of its own.
+const DefaultHTTPTimeout = 5 * time.Second
+
+// HTTPTransport is a Transport posting each RPC as a JSON body to
+// the peer's NewHTTPHandler, which answers with the JSON response.
+// Plain HTTP passes load balancers and firewalls that would stop
+// other protocols, and can be driven with curl when debugging:
+//
+//    curl -d '{"NodeId": "debug"}' http://10.0.0.1:8300/raft/probe
+//
+// It is slower and larger on the wire than a binary transport,
+// which WithHTTPCompression makes up for in part.
+type HTTPTransport struct {
+    addresses AddressProvider
+    client    *http.Client
+
+    // Set by WithHTTPCompression, WithHTTPMetrics and
+    // WithHTTPAuth.
+    compressions []Compression
+    metrics      Metrics
+    labels       Labels
+    auth         *HMACAuth
+
+    // The compression agreed with each peer.
+    mu         sync.Mutex
+    negotiated map[ServerId]Compression
+}
+
+var _ Transport = (*HTTPTransport)(nil)
+
+// NewHTTPTransport returns a transport reaching peers at the
+// addresses the provider resolves their IDs to: host:port, or a
+// base URL such as https://10.0.0.1:8300. client may be nil, for
+// one that gives up after DefaultHTTPTimeout.
+func NewHTTPTransport(addresses AddressProvider, client *http.Client, options ...HTTPOption) *HTTPTransport {
+    if client == nil {
+        client = &http.Client{Timeout: DefaultHTTPTimeout}
+    }
+    transport := &HTTPTransport{
+        addresses:  addresses,
+        client:     client,
+        metrics:    NoopMetrics{},
+        negotiated: make(map[ServerId]Compression),
+    }
+    for _, option := range options {
+        option(transport)
+    }
+    return transport
+}
+
+func (this *HTTPTransport) AppendEntries(
+    target ServerId,
+    req AppendEntriesRequest,
+    reply func(AppendEntriesResponse, error)) {
+    go func() {
+        var resp AppendEntriesResponse
+        reply(resp, this.post(target, PathAppendEntries, req, &resp))
+    }()
+}
+
+func (this *HTTPTransport) RequestVote(
+    target ServerId,
+    req RequestVoteRequest,
+    reply func(RequestVoteResponse, error)) {
+    go func() {
+        var resp RequestVoteResponse
+        reply(resp, this.post(target, PathRequestVote, req, &resp))
+    }()
+}
+
+func (this *HTTPTransport) InstallSnapshot(
+    target ServerId,
+    req InstallSnapshotRequest,
+    reply func(InstallSnapshotResponse, error)) {
+    go func() {
+        var resp InstallSnapshotResponse
+        reply(resp, this.post(target, PathInstallSnapshot, req, &resp))
+    }()
+}
+
+func (this *HTTPTransport) Handshake(
+    target ServerId,
+    req HandshakeRequest,
+    reply func(HandshakeResponse, error)) {
+    go func() {
+        var resp HandshakeResponse
+        reply(resp, this.post(target, PathHandshake, req, &resp))
+    }()
+}
+
+func (this *HTTPTransport) Audit(
+    target ServerId,
+    req AuditRequest,
+    reply func(AuditResponse, error)) {
+    go func() {
+        var resp AuditResponse
+        reply(resp, this.post(target, PathAudit, req, &resp))
+    }()
+}
+
+func (this *HTTPTransport) TimeoutNow(
+    target ServerId,
+    req TimeoutNowRequest,
+    reply func(TimeoutNowResponse, error)) {
+    go func() {
+        var resp TimeoutNowResponse
+        reply(resp, this.post(target, PathTimeoutNow, req, &resp))
+    }()
+}
+
+func (this *HTTPTransport) Probe(
+    target ServerId,
+    req ProbeRequest,
+    reply func(ProbeResponse, error)) {
+    go func() {
+        var resp ProbeResponse
+        reply(resp, this.post(target, PathProbe, req, &resp))
+    }()
+}
+
+func (this *HTTPTransport) Forward(
+    target ServerId,
+    req ForwardRequest,
+    reply func(ForwardResponse, error)) {
+    go func() {
+        var resp httpForwardResponse
+        err := this.post(target, PathForward, req, &resp)
+        reply(ForwardResponse{ProtocolVersion: resp.ProtocolVersion, Index: resp.Index, Term: resp.Term, Err: resp.Err.err()}, err)
+    }()
+}
+
+// Close closes the idle connections to peers.
+func (this *HTTPTransport) Close() error {
+    this.client.CloseIdleConnections()
+    return nil
+}
+
+// post sends req to the handler at path on target, and decodes its
+// answer into resp.
+func (this *HTTPTransport) post(target ServerId, path string, req, resp any) error {
+    address, err := this.addresses.ServerAddress(target)
+    if err != nil {
+        return err
+    }
+    body, err := json.Marshal(req)
+    if err != nil {
+        return err
+    }
+    url := string(address)
+    if !strings.Contains(url, "://") {
+        url = "http://" + url
+    }
+    httpReq, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(url, "/")+path, nil)
+    if err != nil {
+        return err
+    }
+    httpReq.Header.Set("Content-Type", "application/json")
+
+    labels := Labels{LabelPeer: string(target)}
+    for name, value := range this.labels {
+        labels[name] = value
+    }
+    this.metrics.IncrCounter(MetricTransportRawBytes, labels, float64(len(body)))
+    this.mu.Lock()
+    compression := this.negotiated[target]
+    this.mu.Unlock()
+    if compressor := compressor(compression); compressor != nil && len(body) >= compressMinSize {
+        body = compressor.Compress(body)
+        httpReq.Header.Set("Content-Encoding", string(compression))
+    }
+    this.metrics.IncrCounter(MetricTransportCompressedBytes, labels, float64(len(body)))
+    httpReq.Body = io.NopCloser(bytes.NewReader(body))
+    httpReq.ContentLength = int64(len(body))
+    if this.auth != nil {
+        if err := this.auth.sign(httpReq, body); err != nil {
+            return err
+        }
+    }
+
+    httpResp, err := this.client.Do(httpReq)
+    if err != nil {
+        return err
+    }
+    defer httpResp.Body.Close()
+
+    // Follow the peer's codings, which change when it is upgraded
+    // or downgraded.
+    if len(this.compressions) > 0 {
+        this.mu.Lock()
+        this.negotiated[target] = negotiateCompression(this.compressions, httpResp.Header.Get("Accept-Encoding"))
+        this.mu.Unlock()
+    }
+    if httpResp.StatusCode != http.StatusOK {
+        message, _ := io.ReadAll(io.LimitReader(httpResp.Body, 1024))
+        return fmt.Errorf("raft: %s%s: %s: %s", address, path, httpResp.Status, strings.TrimSpace(string(message)))
+    }
+    return json.NewDecoder(httpResp.Body).Decode(resp)
+}
+
+// NewHTTPHandler returns a handler serving the RPCs an HTTPTransport
+// sends to node, under the Path constants. Mount it at the root of
+// the address peers resolve the node's ID to. An RPC the node fails,
+// such as with ErrPersist, is answered with status 500.
+func NewHTTPHandler(node *Node) http.Handler {
+    // JSON encodes the bytes of entries and snapshot chunks in
+    // base64, a third larger, and adds field names.
+    limit := 2 * node.config.MaxMessageSize
+
+    mux := http.NewServeMux()
+    handle := func(path string, rpc func(decode func(req any) error) (any, error)) {
+        mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+            if r.Method != http.MethodPost {
+                w.Header().Set("Allow", http.MethodPost)
+                http.Error(w, "POST a JSON request", http.StatusMethodNotAllowed)
+                return
+            }
+            supported := supportedCompressions()
+            codings := make([]string, len(supported))
+            for i, compression := range supported {
+                codings[i] = string(compression)
+            }
+            w.Header().Set("Accept-Encoding", strings.Join(codings, ", "))
+            body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(limit)))
+            if err != nil {
+                var tooLarge *http.MaxBytesError
+                if errors.As(err, &tooLarge) {
+                    http.Error(w, fmt.Sprintf("%v: over %d bytes", ErrBodyTooLarge, limit), http.StatusRequestEntityTooLarge)
+                    return
+                }
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+            }
+            if body, err = decompress(Compression(r.Header.Get("Content-Encoding")), body, limit); err != nil {
+                status := http.StatusUnsupportedMediaType
+                if errors.Is(err, ErrBodyTooLarge) {
+                    status = http.StatusRequestEntityTooLarge
+                }
+                http.Error(w, err.Error(), status)
+                return
+            }
+            resp, err := rpc(func(req any) error {
+                return json.Unmarshal(body, req)
+            })
+            if err != nil {
+                http.Error(w, err.Error(), http.StatusInternalServerError)
+                return
+            }
+            w.Header().Set("Content-Type", "application/json")
+            json.NewEncoder(w).Encode(resp)
+        })
+    }
+
+    handle(PathAppendEntries, func(decode func(any) error) (any, error) {
+        var req AppendEntriesRequest
+        if err := decode(&req); err != nil {
+            return nil, err
+        }
+        if err := node.acceptProtocolVersion(req.LeaderId, req.ProtocolVersion); err != nil {
+            return nil, err
+        }
+        term, success, err := node.AppendEntriesRPC(
+            req.Term, req.LeaderId, req.PrevLogIndex, req.PrevLogTerm, req.Entries, req.LeaderCommit)
+        return AppendEntriesResponse{ProtocolVersion: req.ProtocolVersion, Term: term, Success: success}, err
+    })
+    handle(PathRequestVote, func(decode func(any) error) (any, error) {
+        var req RequestVoteRequest
+        if err := decode(&req); err != nil {
+            return nil, err
+        }
+        if err := node.acceptProtocolVersion(req.CandidateId, req.ProtocolVersion); err != nil {
+            return nil, err
+        }
+        term, granted, err := node.RequestVoteRPC(
+            req.Term, req.CandidateId, req.LastLogIndex, req.LastLogTerm, req.LeadershipTransfer)
+        return RequestVoteResponse{ProtocolVersion: req.ProtocolVersion, Term: term, VoteGranted: granted}, err
+    })
+    handle(PathInstallSnapshot, func(decode func(any) error) (any, error) {
+        var req InstallSnapshotRequest
+        if err := decode(&req); err != nil {
+            return nil, err
+        }
+        if err := node.acceptProtocolVersion(req.LeaderId, req.ProtocolVersion); err != nil {
+            return nil, err
+        }
+        term, success, err := node.InstallSnapshotRPC(
+            req.Term, req.LeaderId, req.LastIncludedIndex, req.LastIncludedTerm,
+            req.Offset, req.Checksum, req.Data, req.Done)
+        return InstallSnapshotResponse{ProtocolVersion: req.ProtocolVersion, Term: term, Success: success}, err
+    })
+    handle(PathHandshake, func(decode func(any) error) (any, error) {
+        var req HandshakeRequest
+        if err := decode(&req); err != nil {
+            return nil, err
+        }
+        return node.HandshakeRPC(req), nil
+    })
+    handle(PathAudit, func(decode func(any) error) (any, error) {
+        var req AuditRequest
+        if err := decode(&req); err != nil {
+            return nil, err
+        }
+        if err := node.acceptProtocolVersion(req.LeaderId, req.ProtocolVersion); err != nil {
+            return nil, err
+        }
+        term, index, checksum := node.AuditRPC(req.Term, req.LeaderId)
+        return AuditResponse{ProtocolVersion: req.ProtocolVersion, Term: term, Index: index, Checksum: checksum}, nil
+    })
+    handle(PathTimeoutNow, func(decode func(any) error) (any, error) {
+        var req TimeoutNowRequest
+        if err := decode(&req); err != nil {
+            return nil, err
+        }
+        if err := node.acceptProtocolVersion(req.LeaderId, req.ProtocolVersion); err != nil {
+            return nil, err
+        }
+        return TimeoutNowResponse{ProtocolVersion: req.ProtocolVersion, Term: node.TimeoutNowRPC(req.Term, req.LeaderId)}, nil
+    })
+    handle(PathProbe, func(decode func(any) error) (any, error) {
+        var req ProbeRequest
+        if err := decode(&req); err != nil {
+            return nil, err
+        }
+        if err := node.acceptProtocolVersion(req.NodeId, req.ProtocolVersion); err != nil {
+            return nil, err
+        }
+        term, leaderId := node.ProbeRPC(req.NodeId)
+        return ProbeResponse{ProtocolVersion: req.ProtocolVersion, Term: term, LeaderId: leaderId}, nil
+    })
+    handle(PathForward, func(decode func(any) error) (any, error) {
+        var req ForwardRequest
+        if err := decode(&req); err != nil {
+            return nil, err
+        }
+        // Forwarded proposals do not name their sender.
+        if err := node.acceptProtocolVersion("", req.ProtocolVersion); err != nil {
+            return nil, err
+        }
+        resp := node.ForwardRPC(req)
+        return httpForwardResponse{
+            ProtocolVersion: req.ProtocolVersion,
+            Index:           resp.Index,
+            Term:            resp.Term,
+            Err:             newHTTPError(resp.Err),
+        }, nil
+    })
+    return mux
+}
+
+// httpForwardResponse is a ForwardResponse as JSON, which has no
+// encoding for errors.
+type httpForwardResponse struct {
+    ProtocolVersion int
+    Index           int
+    Term            int
+    Err             *httpError `json:",omitempty"`
+}
+
+// httpError is an error relayed to the proposer of a forwarded
+// proposal, which tells the errors of this package apart with
+// errors.Is and errors.As.
+type httpError struct {
+    Message       string
+    LeaderId      ServerId      `json:",omitempty"`
+    LeaderAddress ServerAddress `json:",omitempty"`
+    Appended      bool          `json:",omitempty"`
+}
+
+// relayedErrors are the errors a forwarded proposal can fail with
+// that proposers test for.
+var relayedErrors = []error{
+    ErrLeadershipLostWhileCommitting,
+    ErrLeadershipTransferInProgress,
+    ErrRaftShutdown,
+    ErrEnqueueTimeout,
+    ErrCommandTooLarge,
+    ErrSessionExpired,
+    ErrStaleSequence,
+    ErrStandby,
+    ErrRestoreInProgress,
+}
+
+func newHTTPError(err error) *httpError {
+    if err == nil {
+        return nil
+    }
+    result := &httpError{Message: err.Error()}
+    var notLeader *NotLeaderError
+    var lost *LeadershipLostError
+    switch {
+    case errors.As(err, &notLeader):
+        result.Message, result.LeaderId, result.LeaderAddress = ErrNotLeader.Error(), notLeader.LeaderId, notLeader.LeaderAddress
+    case errors.As(err, &lost):
+        result.Message, result.LeaderId, result.LeaderAddress = ErrLeadershipLost.Error(), lost.LeaderId, lost.LeaderAddress
+        result.Appended = lost.Appended
+    }
+    return result
+}
+
+// err returns the error this stands for: the error of this package
+// with the same message, if any. Errors wrapping ErrPersist still
+// match it.
+func (this *httpError) err() error {
+    if this == nil {
+        return nil
+    }
+    switch this.Message {
+    case ErrNotLeader.Error():
+        return &NotLeaderError{LeaderId: this.LeaderId, LeaderAddress: this.LeaderAddress}
+    case ErrLeadershipLost.Error():
+        return &LeadershipLostError{LeaderId: this.LeaderId, LeaderAddress: this.LeaderAddress, Appended: this.Appended}
+    }
+    for _, err := range relayedErrors {
+        if this.Message == err.Error() {
+            return err
+        }
+    }
+    if prefix := ErrPersist.Error() + ": "; strings.HasPrefix(this.Message, prefix) {
+        return fmt.Errorf("%w: %s", ErrPersist, strings.TrimPrefix(this.Message, prefix))
+    }
+    return errors.New(this.Message)
+}
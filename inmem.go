@@ -0,0 +1,113 @@
+package raft
+
+import (
+    "sync"
+)
+
+// InmemStore is a LogStore and StableStore kept in memory, for
+// tests and for nodes that need no durability.
+type InmemStore struct {
+    mu     sync.Mutex
+    first  int
+    last   int
+    logs   map[int]Entry
+    values map[string][]byte
+    ints   map[string]int
+}
+
+func NewInmemStore() *InmemStore {
+    return &InmemStore{
+        logs:   make(map[int]Entry),
+        values: make(map[string][]byte),
+        ints:   make(map[string]int),
+    }
+}
+
+func (this *InmemStore) FirstIndex() (int, error) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    return this.first, nil
+}
+
+func (this *InmemStore) LastIndex() (int, error) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    return this.last, nil
+}
+
+func (this *InmemStore) GetLog(index int) (Entry, error) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    entry, ok := this.logs[index]
+    if !ok {
+        return Entry{}, ErrNotFound
+    }
+    return entry, nil
+}
+
+func (this *InmemStore) StoreLogs(entries []Entry) error {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    for _, entry := range entries {
+        this.logs[entry.Index] = entry
+        if this.first == 0 || entry.Index < this.first {
+            this.first = entry.Index
+        }
+        if entry.Index > this.last {
+            this.last = entry.Index
+        }
+    }
+    return nil
+}
+
+func (this *InmemStore) DeleteRange(min, max int) error {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    for index := min; index <= max; index++ {
+        delete(this.logs, index)
+    }
+    if min <= this.first {
+        this.first = max + 1
+    }
+    if max >= this.last {
+        this.last = min - 1
+    }
+    if this.first > this.last {
+        this.first, this.last = 0, 0
+    }
+    return nil
+}
+
+func (this *InmemStore) Set(key string, value []byte) error {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    this.values[key] = append([]byte(nil), value...)
+    return nil
+}
+
+func (this *InmemStore) Get(key string) ([]byte, error) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    value, ok := this.values[key]
+    if !ok {
+        return nil, ErrNotFound
+    }
+    return append([]byte(nil), value...), nil
+}
+
+func (this *InmemStore) SetInt(key string, value int) error {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    this.ints[key] = value
+    return nil
+}
+
+func (this *InmemStore) GetInt(key string) (int, error) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    value, ok := this.ints[key]
+    if !ok {
+        return 0, ErrNotFound
+    }
+    return value, nil
+}
@@ -0,0 +1,249 @@
+package inmem
+
+import (
+    "context"
+    "errors"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/tawawhite/raft"
+)
+
+var ids = []raft.ServerId{"a", "b", "c"}
+
+// counter is a state machine counting the commands it applied.
+type counter struct {
+    mu sync.Mutex
+    n  int
+}
+
+func (this *counter) apply([]byte) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    this.n++
+}
+
+func (this *counter) count() int {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    return this.n
+}
+
+func (this *counter) Snapshot() ([]byte, error) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    return []byte{byte(this.n)}, nil
+}
+
+func (this *counter) Restore(snapshot []byte) error {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    this.n = int(snapshot[0])
+    return nil
+}
+
+// cluster is a set of nodes on one network, each over its own
+// stores, which outlive restarts.
+type cluster struct {
+    t         *testing.T
+    network   *Network
+    stores    map[raft.ServerId]*Store
+    snapshots map[raft.ServerId]*SnapshotStore
+    counters  map[raft.ServerId]*counter
+    nodes     map[raft.ServerId]*raft.Node
+}
+
+func newCluster(t *testing.T) *cluster {
+    this := &cluster{
+        t:         t,
+        network:   NewNetwork(),
+        stores:    make(map[raft.ServerId]*Store),
+        snapshots: make(map[raft.ServerId]*SnapshotStore),
+        counters:  make(map[raft.ServerId]*counter),
+        nodes:     make(map[raft.ServerId]*raft.Node),
+    }
+    for _, id := range ids {
+        this.start(id)
+    }
+    t.Cleanup(func() {
+        for _, node := range this.nodes {
+            node.Shutdown(context.Background())
+        }
+        this.network.Close()
+    })
+    return this
+}
+
+// start starts the node with the given ID, over the stores it had
+// if it ran before.
+func (this *cluster) start(id raft.ServerId) *raft.Node {
+    if this.stores[id] == nil {
+        this.stores[id] = NewStore()
+        this.snapshots[id] = NewSnapshotStore()
+    }
+    this.counters[id] = &counter{}
+    var peers []raft.ServerId
+    for _, peer := range ids {
+        if peer != id {
+            peers = append(peers, peer)
+        }
+    }
+    node, err := this.network.NewNode(id, peers, this.counters[id].apply,
+        raft.WithTimeouts(3, 10, 20),
+        func(config *raft.Config) { config.TickInterval = time.Millisecond },
+        raft.WithStorage(this.stores[id], this.stores[id]),
+        raft.WithSnapshotStore(this.snapshots[id]),
+        raft.WithSnapshotter(this.counters[id], 5))
+    if err != nil {
+        this.t.Fatal(err)
+    }
+    this.nodes[id] = node
+    node.Start()
+    return node
+}
+
+// leader waits for a node other than except to lead.
+func (this *cluster) leader(except raft.ServerId) *raft.Node {
+    for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+        for id, node := range this.nodes {
+            if id != except && node.Role() == raft.Leader {
+                return node
+            }
+        }
+    }
+    this.t.Fatal("no leader elected")
+    return nil
+}
+
+func (this *cluster) propose(node *raft.Node, n int) {
+    for i := 0; i < n; i++ {
+        if err := node.Propose(context.Background(), []byte("x")).Error(); err != nil {
+            this.t.Fatal(err)
+        }
+    }
+}
+
+func TestIsolatedLeaderIsReplaced(t *testing.T) {
+    cluster := newCluster(t)
+    leader := cluster.leader("")
+    cluster.propose(leader, 3)
+
+    cluster.network.Isolate(leader.Id())
+    next := cluster.leader(leader.Id())
+    cluster.propose(next, 1)
+
+    ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+    defer cancel()
+    if err := leader.Propose(ctx, []byte("x")).Error(); err == nil {
+        t.Fatal("isolated leader committed a command")
+    }
+    cluster.network.Heal()
+}
+
+func TestPartitionedMinorityCannotCommit(t *testing.T) {
+    cluster := newCluster(t)
+    leader := cluster.leader("")
+
+    var majority []raft.ServerId
+    for _, id := range ids {
+        if id != leader.Id() {
+            majority = append(majority, id)
+        }
+    }
+    cluster.network.Partition([]raft.ServerId{leader.Id()}, majority)
+    cluster.propose(cluster.leader(leader.Id()), 1)
+
+    ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+    defer cancel()
+    if err := leader.Propose(ctx, []byte("x")).Error(); err == nil {
+        t.Fatal("minority committed a command")
+    }
+}
+
+func TestRestartRecoversFromStores(t *testing.T) {
+    cluster := newCluster(t)
+    leader := cluster.leader("")
+    cluster.propose(leader, 10)
+
+    var follower raft.ServerId
+    for _, id := range ids {
+        if id != leader.Id() {
+            follower = id
+            break
+        }
+    }
+    // The commands are committed once the leader and one follower
+    // store them, and the other may still be far behind: the network
+    // delivers each RPC on its own goroutine, so the AppendEntries the
+    // leader pipelines can reach a follower out of order, and one
+    // that skips past its log is rejected, leaving the leader to
+    // probe the follower a request per heartbeat. Wait for it to
+    // apply them all and snapshot.
+    for deadline := time.Now().Add(5 * time.Second); cluster.counters[follower].count() != 10 ||
+        cluster.snapshots[follower].Saves() == 0; time.Sleep(time.Millisecond) {
+        if time.Now().After(deadline) {
+            t.Fatalf("follower applied %d commands and saved %d snapshots",
+                cluster.counters[follower].count(), cluster.snapshots[follower].Saves())
+        }
+    }
+    if err := cluster.nodes[follower].Shutdown(context.Background()); err != nil {
+        t.Fatal(err)
+    }
+
+    cluster.start(follower)
+    if cluster.counters[follower].count() == 0 {
+        t.Fatal("restarted node did not restore its snapshot")
+    }
+    cluster.propose(cluster.leader(""), 1)
+    for deadline := time.Now().Add(5 * time.Second); cluster.counters[follower].count() != 11; time.Sleep(time.Millisecond) {
+        if time.Now().After(deadline) {
+            t.Fatalf("restarted node applied %d commands, want 11", cluster.counters[follower].count())
+        }
+    }
+}
+
+func TestShutdownKeepsReplacement(t *testing.T) {
+    network := NewNetwork()
+    defer network.Close()
+    old, err := network.NewNode("a", nil, func([]byte) {})
+    if err != nil {
+        t.Fatal(err)
+    }
+    network.Remove("a")
+    replacement, err := network.NewNode("a", nil, func([]byte) {})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer replacement.Shutdown(context.Background())
+
+    old.Shutdown(context.Background())
+    if network.Registry().Node("a") != replacement {
+        t.Fatal("shutting down a removed node deregistered its replacement")
+    }
+}
+
+func TestFailWrites(t *testing.T) {
+    store := NewStore()
+    entry := raft.Entry{Index: 1, TermNum: 1}
+    broken := errors.New("disk full")
+
+    store.FailWrites(broken)
+    if err := store.StoreLogs([]raft.Entry{entry}); !errors.Is(err, broken) {
+        t.Fatalf("StoreLogs returned %v, want %v", err, broken)
+    }
+    if err := store.SetInt("term", 1); !errors.Is(err, broken) {
+        t.Fatalf("SetInt returned %v, want %v", err, broken)
+    }
+    if last, _ := store.LastIndex(); last != 0 {
+        t.Fatalf("failed write stored entries up to %d", last)
+    }
+
+    store.FailWrites(nil)
+    if err := store.StoreLogs([]raft.Entry{entry}); err != nil {
+        t.Fatal(err)
+    }
+    if last, _ := store.LastIndex(); last != 1 {
+        t.Fatalf("last index is %d, want 1", last)
+    }
+}
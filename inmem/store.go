@@ -0,0 +1,117 @@
+// Package inmem provides storage and a transport kept in memory, so
+// that applications embedding raft can unit test their state
+// machines and cluster logic without disks or a network:
+//
+//    network := inmem.NewNetwork()
+//    for _, id := range ids {
+//        store := inmem.NewStore()
+//        node, err := network.NewNode(id, others(id), apply,
+//            raft.WithStorage(store, store),
+//            raft.WithSnapshotStore(inmem.NewSnapshotStore()))
+//        ...
+//    }
+//    network.Isolate(leader)
+//
+// A node restarted over the same stores recovers its state from
+// them, as it would from disk, and a Store can be made to fail its
+// writes, as a full or broken disk would.
+package inmem
+
+import (
+    "sync"
+
+    "github.com/tawawhite/raft"
+)
+
+// Store is a raft.LogStore and raft.StableStore kept in memory,
+// whose writes can be made to fail. It is safe for concurrent use.
+type Store struct {
+    *raft.InmemStore
+
+    mu  sync.Mutex
+    err error
+}
+
+// NewStore returns an empty Store.
+func NewStore() *Store {
+    return &Store{InmemStore: raft.NewInmemStore()}
+}
+
+// FailWrites makes every later write fail with err, leaving the
+// store as it was, until it is called again with nil.
+func (this *Store) FailWrites(err error) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    this.err = err
+}
+
+func (this *Store) writeErr() error {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    return this.err
+}
+
+func (this *Store) StoreLogs(entries []raft.Entry) error {
+    if err := this.writeErr(); err != nil {
+        return err
+    }
+    return this.InmemStore.StoreLogs(entries)
+}
+
+func (this *Store) DeleteRange(min, max int) error {
+    if err := this.writeErr(); err != nil {
+        return err
+    }
+    return this.InmemStore.DeleteRange(min, max)
+}
+
+func (this *Store) Set(key string, value []byte) error {
+    if err := this.writeErr(); err != nil {
+        return err
+    }
+    return this.InmemStore.Set(key, value)
+}
+
+func (this *Store) SetInt(key string, value int) error {
+    if err := this.writeErr(); err != nil {
+        return err
+    }
+    return this.InmemStore.SetInt(key, value)
+}
+
+// SnapshotStore is a raft.SnapshotStore kept in memory. It is safe
+// for concurrent use.
+type SnapshotStore struct {
+    mu       sync.Mutex
+    snapshot []byte
+    saves    int
+}
+
+// NewSnapshotStore returns an empty SnapshotStore.
+func NewSnapshotStore() *SnapshotStore {
+    return &SnapshotStore{}
+}
+
+func (this *SnapshotStore) SaveSnapshot(snapshot []byte) error {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    this.snapshot = append([]byte(nil), snapshot...)
+    this.saves++
+    return nil
+}
+
+func (this *SnapshotStore) LoadSnapshot() ([]byte, error) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    if this.snapshot == nil {
+        return nil, raft.ErrNotFound
+    }
+    return append([]byte(nil), this.snapshot...), nil
+}
+
+// Saves returns the number of snapshots saved so far.
+func (this *SnapshotStore) Saves() int {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    return this.saves
+}
@@ -0,0 +1,160 @@
+package inmem
+
+import (
+    "errors"
+    "fmt"
+    "sync"
+
+    "github.com/tawawhite/raft"
+)
+
+// ErrUnreachable is reported for RPCs lost to a partition.
+var ErrUnreachable = errors.New("inmem: peer unreachable")
+
+// Network connects the nodes of one process through a raft.Registry,
+// and can partition them to test how an application copes with
+// failures. It is safe for concurrent use.
+type Network struct {
+    registry *raft.Registry
+
+    mu sync.Mutex
+
+    // The group of each node while partitioned, or nil. Nodes in
+    // no group reach no one.
+    groups map[raft.ServerId]int
+
+    // Nodes cut off from every other, and directed links that lose
+    // every message.
+    isolated map[raft.ServerId]bool
+    cut      map[link]bool
+}
+
+type link struct {
+    from, to raft.ServerId
+}
+
+// NewNetwork returns a network with no nodes and every link working.
+func NewNetwork() *Network {
+    this := &Network{
+        registry: raft.NewRegistry(),
+        isolated: make(map[raft.ServerId]bool),
+        cut:      make(map[link]bool),
+    }
+    this.registry.SetLinkFilter(this.checkLink)
+    return this
+}
+
+// Registry returns the registry the network delivers messages
+// through.
+func (this *Network) Registry() *raft.Registry {
+    return this.registry
+}
+
+// NewNode creates a node on the network, as raft.Registry.NewNode.
+func (this *Network) NewNode(id raft.ServerId, peers []raft.ServerId, statemachine func(command []byte), options ...raft.Option) (*raft.Node, error) {
+    return this.registry.NewNode(id, peers, statemachine, options...)
+}
+
+// Transport returns the transport for the node with ID from, which
+// reaches the other nodes added to the network. Shutting the node
+// down removes it.
+func (this *Network) Transport(from raft.ServerId) raft.Transport {
+    return this.registry.Transport(from)
+}
+
+// Add makes node reachable by its ID. It fails if another node with
+// the same ID was added and not removed.
+func (this *Network) Add(node *raft.Node) error {
+    return this.registry.Register(node)
+}
+
+// Remove makes the node with the given ID unreachable, so that
+// another node may be added in its place.
+func (this *Network) Remove(id raft.ServerId) {
+    this.registry.Deregister(id)
+}
+
+// Close waits for the messages in flight to be delivered, and their
+// responses returned.
+func (this *Network) Close() error {
+    return this.registry.Close()
+}
+
+// Partition splits the network into the given groups of nodes.
+// Nodes in different groups cannot reach each other; nodes not
+// listed are cut off from everyone.
+func (this *Network) Partition(groups ...[]raft.ServerId) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    this.groups = make(map[raft.ServerId]int)
+    for g, ids := range groups {
+        for _, id := range ids {
+            this.groups[id] = g + 1
+        }
+    }
+}
+
+// Isolate cuts the node with the given ID off from every other.
+func (this *Network) Isolate(id raft.ServerId) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    this.isolated[id] = true
+}
+
+// Cut loses every message sent from one node to another, leaving
+// the opposite direction working: an asymmetric partition.
+func (this *Network) Cut(from, to raft.ServerId) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    this.cut[link{from, to}] = true
+}
+
+// Disconnect cuts the links between two nodes in both directions.
+func (this *Network) Disconnect(a, b raft.ServerId) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    this.cut[link{a, b}] = true
+    this.cut[link{b, a}] = true
+}
+
+// Connect restores the links between two nodes in both directions,
+// as cut by Cut or Disconnect.
+func (this *Network) Connect(a, b raft.ServerId) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    delete(this.cut, link{a, b})
+    delete(this.cut, link{b, a})
+}
+
+// Heal undoes every Partition, Isolate, Cut and Disconnect.
+func (this *Network) Heal() {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    this.groups = nil
+    this.isolated = make(map[raft.ServerId]bool)
+    this.cut = make(map[link]bool)
+}
+
+// checkLink is the registry's link filter, failing messages between
+// nodes that cannot reach each other with ErrUnreachable.
+func (this *Network) checkLink(from, to raft.ServerId) error {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    if !this.reachable(from, to) {
+        return fmt.Errorf("%w: %q", ErrUnreachable, to)
+    }
+    return nil
+}
+
+// reachable reports whether messages from one node reach another.
+// Must be called with this.mu held.
+func (this *Network) reachable(from, to raft.ServerId) bool {
+    if this.isolated[from] || this.isolated[to] || this.cut[link{from, to}] {
+        return false
+    }
+    if this.groups != nil {
+        group := this.groups[from]
+        return group != 0 && group == this.groups[to]
+    }
+    return true
+}
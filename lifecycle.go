@@ -0,0 +1,169 @@
+package raft
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sort"
+    "sync"
+    "time"
+)
+
+// ErrStopTimeout is returned by Lifecycle.Stop when a component did
+// not stop in time. Its goroutines may still be running.
+var ErrStopTimeout = errors.New("raft: component did not stop in time")
+
+// Stage orders the components of an embedding application. They
+// start in increasing stage order and stop in the reverse order, so
+// the node never runs without its transport and storage.
+type Stage int
+
+const (
+    StageStorage Stage = iota
+    StageTransport
+    StageNode
+)
+
+func (this Stage) String() string {
+    switch this {
+    case StageStorage:
+        return "storage"
+    case StageTransport:
+        return "transport"
+    case StageNode:
+        return "node"
+    }
+    return fmt.Sprintf("Stage(%d)", int(this))
+}
+
+// Component is one piece of an embedding application managed by a
+// Lifecycle. Either function may be nil.
+type Component struct {
+    Name  string
+    Stage Stage
+
+    Start func() error
+
+    // Stop should return once all of the component's goroutines
+    // have exited, or when ctx is done.
+    Stop func(ctx context.Context) error
+}
+
+// Lifecycle starts components in stage order (storage, transport,
+// node) and stops them in reverse, bounding how long each one may
+// take to stop.
+type Lifecycle struct {
+    mu sync.Mutex
+
+    // Longest a single component may take to stop.
+    stopTimeout time.Duration
+
+    // Registered components, and those started, in start order.
+    components []Component
+    started    []Component
+}
+
+// NewLifecycle returns a Lifecycle that gives every component up to
+// stopTimeout to stop.
+func NewLifecycle(stopTimeout time.Duration) *Lifecycle {
+    return &Lifecycle{stopTimeout: stopTimeout}
+}
+
+// Add registers a component. Components of the same stage start in
+// the order they were added.
+func (this *Lifecycle) Add(component Component) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    this.components = append(this.components, component)
+}
+
+// AddNode registers node in the node stage. Stopping the lifecycle
+// shuts the node down for good.
+func (this *Lifecycle) AddNode(name string, node *Node) {
+    this.Add(Component{
+        Name:  name,
+        Stage: StageNode,
+        Start: func() error {
+            node.Start()
+            return nil
+        },
+        Stop: node.Shutdown,
+    })
+}
+
+// Start starts every component in stage order. If one fails, those
+// already started are stopped again and the failure is returned.
+func (this *Lifecycle) Start() error {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    if len(this.started) > 0 {
+        return errors.New("raft: lifecycle already started")
+    }
+
+    components := append([]Component(nil), this.components...)
+    sort.SliceStable(components, func(i, j int) bool {
+        return components[i].Stage < components[j].Stage
+    })
+
+    for _, component := range components {
+        if component.Start != nil {
+            if err := component.Start(); err != nil {
+                startErr := fmt.Errorf("raft: starting %s %q: %w",
+                    component.Stage, component.Name, err)
+                stopErr := this.stop(context.Background())
+                return errors.Join(startErr, stopErr)
+            }
+        }
+        this.started = append(this.started, component)
+    }
+    return nil
+}
+
+// Stop stops every started component in reverse start order. A
+// component that does not stop within the stop timeout, or before
+// ctx is done, is reported with ErrStopTimeout and the remaining
+// components are still stopped.
+func (this *Lifecycle) Stop(ctx context.Context) error {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    return this.stop(ctx)
+}
+
+func (this *Lifecycle) stop(ctx context.Context) error {
+    var errs []error
+    for i := len(this.started) - 1; i >= 0; i-- {
+        component := this.started[i]
+        if component.Stop == nil {
+            continue
+        }
+        if err := this.stopComponent(ctx, component); err != nil {
+            errs = append(errs, fmt.Errorf("raft: stopping %s %q: %w",
+                component.Stage, component.Name, err))
+        }
+    }
+    this.started = nil
+    return errors.Join(errs...)
+}
+
+// stopComponent runs component.Stop, abandoning it if it outlives
+// the stop timeout or ctx.
+func (this *Lifecycle) stopComponent(ctx context.Context, component Component) error {
+    if this.stopTimeout > 0 {
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithTimeout(ctx, this.stopTimeout)
+        defer cancel()
+    }
+
+    result := make(chan error, 1)
+    go func() {
+        result <- component.Stop(ctx)
+    }()
+
+    select {
+    case err := <-result:
+        return err
+    case <-ctx.Done():
+        return fmt.Errorf("%w: %v", ErrStopTimeout, ctx.Err())
+    }
+}
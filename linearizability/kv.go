@@ -0,0 +1,49 @@
+package linearizability
+
+import (
+    "maps"
+)
+
+type KVOp int
+
+const (
+    Get KVOp = iota
+    Put
+)
+
+// KVInput is the input of an operation on a key-value store.
+type KVInput struct {
+    Op    KVOp
+    Key   string
+    Value string
+}
+
+// KVOutput is the output of an operation on a key-value store. Gets
+// of a missing key return the empty string; puts return nothing.
+type KVOutput struct {
+    Value string
+}
+
+// KVModel is a key-value store of strings.
+var KVModel = Model{
+    Init: func() any {
+        return map[string]string{}
+    },
+    Step: func(state, input, output any) (bool, any) {
+        kv := state.(map[string]string)
+        in := input.(KVInput)
+        switch in.Op {
+        case Get:
+            out, ok := output.(KVOutput)
+            return ok && out.Value == kv[in.Key], kv
+        case Put:
+            next := maps.Clone(kv)
+            next[in.Key] = in.Value
+            return true, next
+        }
+        return false, kv
+    },
+    Equal: func(a, b any) bool {
+        return maps.Equal(a.(map[string]string), b.(map[string]string))
+    },
+}
@@ -0,0 +1,225 @@
+// Package linearizability checks that a history of concurrent
+// operations is linearizable with respect to a sequential model, in
+// the style of Knossos and Porcupine.
+//
+// Each operation has a call time and a return time. The history is
+// linearizable if every operation can be assigned a point between
+// its call and return such that applying the operations in that
+// order to the model produces the outputs that were observed.
+package linearizability
+
+import (
+    "math"
+    "math/bits"
+    "reflect"
+    "sort"
+)
+
+// Never is the return time of an operation whose outcome is unknown,
+// such as a write whose client gave up waiting. It may take effect
+// at any point after its call, or not at all.
+const Never = math.MaxInt64
+
+// Operation is one client request and its observed response.
+type Operation struct {
+    ClientId int
+    Input    any
+    Output   any
+    Call     int64
+    Return   int64
+}
+
+// Model is the sequential specification operations are checked
+// against.
+type Model struct {
+    // Init returns the initial state.
+    Init func() any
+
+    // Step applies input to state and reports whether output is a
+    // valid result, returning the new state. It must not modify
+    // state in place.
+    Step func(state, input, output any) (bool, any)
+
+    // Equal compares states. Defaults to reflect.DeepEqual.
+    Equal func(a, b any) bool
+}
+
+// Check reports whether history is linearizable with respect to
+// model. The search is exponential in the worst case; keep histories
+// to a few hundred operations with modest concurrency.
+func Check(model Model, history []Operation) bool {
+    equal := model.Equal
+    if equal == nil {
+        equal = reflect.DeepEqual
+    }
+
+    head := buildList(history)
+    linearized := newBitset(len(history))
+    cache := make(map[uint64][]cached)
+    type frame struct {
+        entry *node
+        state any
+    }
+    var calls []frame
+
+    state := model.Init()
+    entry := head.next
+    for head.next != nil {
+        if entry.match != nil {
+            ok, next := model.Step(state, entry.value, entry.match.value)
+            if ok {
+                candidate := linearized.clone().set(entry.id)
+                if !seen(cache, candidate, next, equal) {
+                    hash := candidate.hash()
+                    cache[hash] = append(cache[hash], cached{candidate, next})
+                    calls = append(calls, frame{entry, state})
+                    state = next
+                    linearized.set(entry.id)
+                    entry.lift()
+                    entry = head.next
+                    continue
+                }
+            }
+            entry = entry.next
+            continue
+        }
+
+        // Reached a return whose call could not be linearized:
+        // backtrack to the most recent choice.
+        if len(calls) == 0 {
+            return false
+        }
+        top := calls[len(calls)-1]
+        calls = calls[:len(calls)-1]
+        entry, state = top.entry, top.state
+        linearized.clear(entry.id)
+        entry.unlift()
+        entry = entry.next
+    }
+    return true
+}
+
+// node is a call or return event in the doubly linked history list.
+// A call's match is its return; a return's match is nil.
+type node struct {
+    value      any
+    match      *node
+    id         int
+    prev, next *node
+}
+
+// lift removes a call and its return from the list.
+func (this *node) lift() {
+    this.prev.next = this.next
+    this.next.prev = this.prev
+    match := this.match
+    match.prev.next = match.next
+    if match.next != nil {
+        match.next.prev = match.prev
+    }
+}
+
+// unlift restores a call and its return removed by lift.
+func (this *node) unlift() {
+    match := this.match
+    match.prev.next = match
+    if match.next != nil {
+        match.next.prev = match
+    }
+    this.prev.next = this
+    this.next.prev = this
+}
+
+// buildList orders the events of history by time, calls before
+// returns at the same time, behind a sentinel head.
+func buildList(history []Operation) *node {
+    type event struct {
+        time   int64
+        isCall bool
+        id     int
+    }
+    events := make([]event, 0, 2*len(history))
+    for id, op := range history {
+        events = append(events, event{op.Call, true, id}, event{op.Return, false, id})
+    }
+    sort.SliceStable(events, func(i, j int) bool {
+        if events[i].time != events[j].time {
+            return events[i].time < events[j].time
+        }
+        return events[i].isCall && !events[j].isCall
+    })
+
+    head := &node{id: -1}
+    returns := make(map[int]*node)
+    tail := head
+    for _, event := range events {
+        n := &node{id: event.id}
+        if event.isCall {
+            n.value = history[event.id].Input
+        } else {
+            n.value = history[event.id].Output
+            returns[event.id] = n
+        }
+        n.prev = tail
+        tail.next = n
+        tail = n
+    }
+    for n := head.next; n != nil; n = n.next {
+        if ret, ok := returns[n.id]; ok && ret != n {
+            n.match = ret
+        }
+    }
+    return head
+}
+
+// cached is a (linearized set, state) pair already explored.
+type cached struct {
+    linearized bitset
+    state      any
+}
+
+func seen(cache map[uint64][]cached, linearized bitset, state any, equal func(a, b any) bool) bool {
+    for _, c := range cache[linearized.hash()] {
+        if c.linearized.equals(linearized) && equal(c.state, state) {
+            return true
+        }
+    }
+    return false
+}
+
+type bitset []uint64
+
+func newBitset(n int) bitset {
+    return make(bitset, (n+63)/64)
+}
+
+func (this bitset) clone() bitset {
+    return append(bitset(nil), this...)
+}
+
+func (this bitset) set(i int) bitset {
+    this[i/64] |= 1 << uint(i%64)
+    return this
+}
+
+func (this bitset) clear(i int) bitset {
+    this[i/64] &^= 1 << uint(i%64)
+    return this
+}
+
+func (this bitset) equals(other bitset) bool {
+    for i := range this {
+        if this[i] != other[i] {
+            return false
+        }
+    }
+    return true
+}
+
+func (this bitset) hash() uint64 {
+    hash := uint64(len(this))
+    for _, word := range this {
+        hash = bits.RotateLeft64(hash, 13) ^ word*0x9e3779b97f4a7c15
+    }
+    return hash
+}
@@ -0,0 +1,95 @@
+package raft
+
+import (
+    "fmt"
+    "math"
+    "sync"
+)
+
+// DefaultLogCacheSize is the number of recent entries a node caches
+// in front of its LogStore.
+const DefaultLogCacheSize = 512
+
+// LogCache is a LogStore keeping the most recently stored entries
+// of another in a ring buffer, so that reads of the tail of the log
+// do not reach the disk. The node holds its log in memory without
+// the commands of normal entries, and reads them back from the
+// store to apply them and to send them to followers: with a cache,
+// followers that keep up, and entries applied soon after they are
+// committed, are served from memory.
+type LogCache struct {
+    store LogStore
+
+    mu    sync.Mutex
+    cache []Entry
+}
+
+// NewLogCache returns a LogStore caching the last capacity entries
+// stored in store.
+func NewLogCache(capacity int, store LogStore) (*LogCache, error) {
+    if capacity <= 0 {
+        return nil, fmt.Errorf("%w: log cache capacity must be positive", ErrInvalidConfig)
+    }
+    return &LogCache{store: store, cache: make([]Entry, capacity)}, nil
+}
+
+func (this *LogCache) FirstIndex() (int, error) {
+    return this.store.FirstIndex()
+}
+
+func (this *LogCache) LastIndex() (int, error) {
+    return this.store.LastIndex()
+}
+
+func (this *LogCache) GetLog(index int) (Entry, error) {
+    if index > 0 {
+        this.mu.Lock()
+        entry := this.cache[index%len(this.cache)]
+        this.mu.Unlock()
+        if entry.Index == index {
+            return entry, nil
+        }
+    }
+    return this.store.GetLog(index)
+}
+
+// StoreLogs caches entries once store has them. The cached entries
+// from the first of them on, which they replace, are evicted first,
+// even if storing fails.
+func (this *LogCache) StoreLogs(entries []Entry) error {
+    if len(entries) == 0 {
+        return this.store.StoreLogs(entries)
+    }
+    this.evict(entries[0].Index, math.MaxInt)
+    if err := this.store.StoreLogs(entries); err != nil {
+        return err
+    }
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    for _, entry := range entries {
+        this.cache[entry.Index%len(this.cache)] = entry
+    }
+    return nil
+}
+
+// DeleteRange evicts the entries from min to max, then deletes them
+// from store.
+func (this *LogCache) DeleteRange(min, max int) error {
+    this.evict(min, max)
+    return this.store.DeleteRange(min, max)
+}
+
+// evict drops the cached entries from min to max.
+func (this *LogCache) evict(min, max int) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    for i, entry := range this.cache {
+        if entry.Index >= min && entry.Index <= max {
+            this.cache[i] = Entry{}
+        }
+    }
+}
+
+func (this *LogCache) String() string {
+    return "cached " + describe(this.store)
+}
@@ -0,0 +1,49 @@
+package raft
+
+import (
+    "log/slog"
+)
+
+// Logger receives a node's leveled, structured log events. Arguments
+// are alternating keys and values, as with log/slog; a *slog.Logger
+// satisfies Logger. Implementations must not call back into the
+// Node, as they are called with its lock held.
+type Logger interface {
+    Debug(msg string, args ...any)
+    Info(msg string, args ...any)
+    Warn(msg string, args ...any)
+    Error(msg string, args ...any)
+}
+
+// defaultLogger forwards warnings and errors to slog.Default().
+type defaultLogger struct{}
+
+func (defaultLogger) Debug(string, ...any) {}
+func (defaultLogger) Info(string, ...any)  {}
+
+func (defaultLogger) Warn(msg string, args ...any) {
+    slog.Default().Warn(msg, args...)
+}
+
+func (defaultLogger) Error(msg string, args ...any) {
+    slog.Default().Error(msg, args...)
+}
+
+// The helpers below log with the node's ID attached. They must be
+// called with this.mu held.
+
+func (this *Node) logDebug(msg string, args ...any) {
+    this.logger.Debug(msg, append([]any{"node", this.id}, args...)...)
+}
+
+func (this *Node) logInfo(msg string, args ...any) {
+    this.logger.Info(msg, append([]any{"node", this.id}, args...)...)
+}
+
+func (this *Node) logWarn(msg string, args ...any) {
+    this.logger.Warn(msg, append([]any{"node", this.id}, args...)...)
+}
+
+func (this *Node) logError(msg string, args ...any) {
+    this.logger.Error(msg, append([]any{"node", this.id}, args...)...)
+}
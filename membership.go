@@ -0,0 +1,221 @@
+package raft
+
+import (
+    "context"
+    "errors"
+    "fmt"
+)
+
+var (
+    // ErrConfigurationChangeInProgress is returned by
+    // ChangeConfiguration while an earlier change has yet to
+    // complete. Retry once it has.
+    ErrConfigurationChangeInProgress = errors.New("raft: configuration change in progress")
+
+    // ErrConfigurationChangeInterrupted is returned for a change of
+    // membership its leader lost leadership in the middle of. The
+    // next leader completes the change if the joint configuration
+    // reached it, so the outcome is only known from the
+    // Configuration of the new leader.
+    ErrConfigurationChangeInterrupted = errors.New("raft: leadership lost during configuration change")
+)
+
+// ChangeConfiguration moves the cluster to the membership servers,
+// which may add and remove any number of servers at once, in two
+// phases (see §6 of the raft paper): the leader first appends the
+// joint configuration C_old,new, under which elections and commits
+// need a majority of both the old and the new members, and once that
+// is committed appends C_new. The future resolves when C_new is
+// committed, at its index. A leader that is not among servers keeps
+// leading until then, and steps down on its next heartbeat.
+//
+// Only the leader can change the configuration, and only once the
+// previous change is committed; it fails with
+// ErrConfigurationChangeInProgress otherwise. If ctx is done before
+// the change completes, the future fails with its error, but the
+// change goes on.
+func (this *Node) ChangeConfiguration(ctx context.Context, servers []Server) *ProposeFuture {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    future := newProposeFuture(EntryConfiguration, nil)
+    this.changeConfiguration(ctx, future, append([]Server(nil), servers...))
+    return future
+}
+
+// AddVoter adds the server id, reachable at address, to the cluster
+// through ChangeConfiguration, and is bound by ctx the same way. If
+// id is already a member, only its address is changed, and a
+// replica, demoted or not, is promoted to a voter.
+func (this *Node) AddVoter(ctx context.Context, id ServerId, address ServerAddress) *ProposeFuture {
+    return this.addServer(ctx, Server{Id: id, Address: address})
+}
+
+// AddReplica adds the server id, reachable at address, to the
+// cluster as a read-only replica through ChangeConfiguration, and is
+// bound by ctx the same way. If id is already a member, only its
+// address is changed, and a voter is demoted to a replica; a leader
+// demoting itself steps down once the change is committed.
+func (this *Node) AddReplica(ctx context.Context, id ServerId, address ServerAddress) *ProposeFuture {
+    return this.addServer(ctx, Server{Id: id, Address: address, Replica: true})
+}
+
+// addServer adds added to the cluster, or replaces the member with
+// its ID, keeping its locality, through ChangeConfiguration.
+func (this *Node) addServer(ctx context.Context, added Server) *ProposeFuture {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    servers := []Server{}
+    for _, server := range this.members.Servers {
+        if server.Id != added.Id {
+            servers = append(servers, server)
+        } else {
+            added.Locality = server.Locality
+        }
+    }
+    servers = append(servers, added)
+
+    future := newProposeFuture(EntryConfiguration, nil)
+    this.changeConfiguration(ctx, future, servers)
+    return future
+}
+
+// RemoveServer removes the member id from the cluster through
+// ChangeConfiguration, and is bound by ctx the same way. A leader
+// removing itself steps down once the change is committed.
+func (this *Node) RemoveServer(ctx context.Context, id ServerId) *ProposeFuture {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    servers := []Server{}
+    for _, server := range this.members.Servers {
+        if server.Id != id {
+            servers = append(servers, server)
+        }
+    }
+
+    future := newProposeFuture(EntryConfiguration, nil)
+    if this.nodeType == Leader && len(servers) == len(this.members.Servers) {
+        future.respond(fmt.Errorf("%w: %q is not a member", ErrInvalidConfig, id))
+        return future
+    }
+    this.changeConfiguration(ctx, future, servers)
+    return future
+}
+
+// SetLocality records the locality of the member id through
+// ChangeConfiguration, and is bound by ctx the same way.
+func (this *Node) SetLocality(ctx context.Context, id ServerId, locality Locality) *ProposeFuture {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    servers := append([]Server(nil), this.members.Servers...)
+    found := false
+    for i, server := range servers {
+        if server.Id == id {
+            servers[i].Locality, found = locality, true
+        }
+    }
+
+    future := newProposeFuture(EntryConfiguration, nil)
+    if this.nodeType == Leader && !found {
+        future.respond(fmt.Errorf("%w: %q is not a member", ErrInvalidConfig, id))
+        return future
+    }
+    this.changeConfiguration(ctx, future, servers)
+    return future
+}
+
+// changeConfiguration starts the change of membership to servers
+// that future tracks. Must be called with this.mu held.
+func (this *Node) changeConfiguration(ctx context.Context, future *ProposeFuture, servers []Server) {
+    this.watchProposal(ctx, future)
+    if isClosed(future.done) {
+        return
+    }
+    if this.shutdown {
+        future.respond(ErrRaftShutdown)
+        return
+    }
+    if this.nodeType != Leader {
+        future.respond(this.notLeader())
+        return
+    }
+    if this.leaderTransfer != nil {
+        future.respond(ErrLeadershipTransferInProgress)
+        return
+    }
+    if err := checkServers(servers); err != nil {
+        future.respond(err)
+        return
+    }
+    if this.configurationChange != nil || this.members.Joint() || this.members.Index > this.commitIndex {
+        future.respond(ErrConfigurationChangeInProgress)
+        return
+    }
+    if this.restore != nil {
+        future.respond(ErrRestoreInProgress)
+        return
+    }
+
+    // Proposals queued before the change go out first.
+    this.flushProposals()
+    if this.nodeType != Leader {
+        future.respond(this.notLeader())
+        return
+    }
+    joint := Configuration{Servers: this.members.Servers, NextServers: servers}
+    this.logInfo("changing configuration", "from", joint.Servers, "to", joint.NextServers)
+    err := this.appendLog(Entry{
+        Type:    EntryConfiguration,
+        Command: encodeMembers(joint),
+        Index:   this.lastLogIndex() + 1,
+        TermNum: this.currentTerm,
+    })
+    if err != nil {
+        future.respond(err)
+        this.abdicate(err)
+        return
+    }
+    this.configurationChange = future
+    this.advanceCommitIndex()
+    this.broadcastAppendEntries()
+}
+
+// advanceMembership appends C_new once the leader has committed the
+// joint configuration of a change of membership, whether it started
+// the change or inherited it from an earlier leader. Must be called
+// with this.mu held, after commitIndex advanced.
+func (this *Node) advanceMembership() {
+    if this.nodeType != Leader || !this.members.Joint() || this.members.Index > this.commitIndex {
+        return
+    }
+
+    next := Configuration{Servers: this.members.NextServers}
+    entry := Entry{
+        Type:    EntryConfiguration,
+        Command: encodeMembers(next),
+        Index:   this.lastLogIndex() + 1,
+        TermNum: this.currentTerm,
+    }
+    if err := this.appendLog(entry); err != nil {
+        this.abdicate(err)
+        return
+    }
+    if future := this.configurationChange; future != nil {
+        this.configurationChange = nil
+        future.index, future.term = entry.Index, entry.TermNum
+        this.pending[entry.Index] = future
+    }
+    this.advanceCommitIndex()
+    this.broadcastAppendEntries()
+}
+
+// removed reports whether the node leads a cluster it has committed
+// its own removal from, or its demotion to a replica, and so should
+// step down. Must be called with this.mu held.
+func (this *Node) removed() bool {
+    return this.nodeType == Leader && !this.members.Joint() &&
+        this.members.Index <= this.commitIndex && !this.isVoter(this.id)
+}
@@ -0,0 +1,347 @@
+package raft
+
+import (
+    "errors"
+    "fmt"
+    "strconv"
+)
+
+// MessageType names the RPCs nodes exchange.
+type MessageType int
+
+const (
+    MsgAppendEntries MessageType = iota
+    MsgRequestVote
+    MsgInstallSnapshot
+    MsgHandshake
+    MsgAudit
+    MsgTimeoutNow
+    MsgProbe
+    MsgForward
+)
+
+func (this MessageType) String() string {
+    switch this {
+    case MsgAppendEntries:
+        return "AppendEntries"
+    case MsgRequestVote:
+        return "RequestVote"
+    case MsgInstallSnapshot:
+        return "InstallSnapshot"
+    case MsgHandshake:
+        return "Handshake"
+    case MsgAudit:
+        return "Audit"
+    case MsgTimeoutNow:
+        return "TimeoutNow"
+    case MsgProbe:
+        return "Probe"
+    case MsgForward:
+        return "Forward"
+    default:
+        return "MessageType(" + strconv.Itoa(int(this)) + ")"
+    }
+}
+
+// Message is an RPC request or response between two nodes, in a
+// single form for every type of RPC. The fields a type does not use
+// are left zero.
+type Message struct {
+    Type MessageType
+
+    // Whether the message answers the request with the same Id.
+    Response bool
+    Id       uint64
+
+    // The Raft group the message belongs to, when a MultiNode
+    // hosts several.
+    Group string
+
+    From ServerId
+    To   ServerId
+    Term int
+
+    // The protocol version the message is in.
+    ProtocolVersion int
+
+    // The log position the request refers to: PrevLogIndex and
+    // PrevLogTerm for AppendEntries, LastLogIndex and LastLogTerm
+    // for RequestVote, LastIncludedIndex and LastIncludedTerm for
+    // InstallSnapshot. In responses, where an Audit was taken or a
+    // Forward appended.
+    Index   int
+    LogTerm int
+
+    // The entries to append, or the single proposal forwarded.
+    Entries []Entry
+    Commit  int
+
+    // Whether an AppendEntries or InstallSnapshot succeeded, or a
+    // vote was granted.
+    Success bool
+
+    // Whether a RequestVote is for a leadership transfer.
+    Transfer bool
+
+    // An InstallSnapshot chunk, or the checksum of an Audit.
+    Checksum uint64
+    Offset   int
+    Data     []byte
+    Done     bool
+
+    // The sender's limit, the protocol versions it speaks,
+    // whether it is a witness and its election priority, in a
+    // Handshake.
+    MaxMessageSize     int
+    MinProtocolVersion int
+    MaxProtocolVersion int
+    Witness            bool
+    ElectionPriority   int
+
+    // The leader the sender follows, in a Probe response.
+    LeaderId ServerId
+
+    // Why a forwarded proposal failed.
+    Err error
+}
+
+// errStepResponse is returned by Step for a response, which only
+// the transport that sent the request can match to it.
+var errStepResponse = errors.New("raft: Step takes requests, not responses")
+
+// Step handles msg, a request from another node, by passing it to
+// the handler of its type, and returns the response to send back,
+// or the error the handler failed with, such as ErrPersist.
+// A transport built on Step need only carry Messages; the Transport
+// returned by NewMessageTransport turns a node's RPCs into them.
+func (this *Node) Step(msg Message) (Message, error) {
+    if msg.Response {
+        return Message{}, errStepResponse
+    }
+    if msg.Type != MsgHandshake {
+        if err := this.acceptProtocolVersion(msg.From, msg.ProtocolVersion); err != nil {
+            return Message{}, err
+        }
+    }
+    resp := Message{
+        Type:            msg.Type,
+        Response:        true,
+        Id:              msg.Id,
+        Group:           msg.Group,
+        From:            msg.To,
+        To:              msg.From,
+        ProtocolVersion: msg.ProtocolVersion,
+    }
+    var err error
+    switch msg.Type {
+    case MsgAppendEntries:
+        resp.Term, resp.Success, err = this.AppendEntriesRPC(
+            msg.Term, msg.From, msg.Index, msg.LogTerm, msg.Entries, msg.Commit)
+    case MsgRequestVote:
+        resp.Term, resp.Success, err = this.RequestVoteRPC(msg.Term, msg.From, msg.Index, msg.LogTerm, msg.Transfer)
+    case MsgInstallSnapshot:
+        resp.Term, resp.Success, err = this.InstallSnapshotRPC(
+            msg.Term, msg.From, msg.Index, msg.LogTerm, msg.Offset, msg.Checksum, msg.Data, msg.Done)
+    case MsgHandshake:
+        handshake := this.HandshakeRPC(HandshakeRequest{
+            ProtocolVersion:    msg.ProtocolVersion,
+            NodeId:             msg.From,
+            MaxMessageSize:     msg.MaxMessageSize,
+            MinProtocolVersion: msg.MinProtocolVersion,
+            MaxProtocolVersion: msg.MaxProtocolVersion,
+            Witness:            msg.Witness,
+            ElectionPriority:   msg.ElectionPriority,
+        })
+        resp.MaxMessageSize = handshake.MaxMessageSize
+        resp.MinProtocolVersion, resp.MaxProtocolVersion = handshake.MinProtocolVersion, handshake.MaxProtocolVersion
+        resp.Witness, resp.ElectionPriority = handshake.Witness, handshake.ElectionPriority
+    case MsgAudit:
+        resp.Term, resp.Index, resp.Checksum = this.AuditRPC(msg.Term, msg.From)
+    case MsgTimeoutNow:
+        resp.Term = this.TimeoutNowRPC(msg.Term, msg.From)
+    case MsgProbe:
+        resp.Term, resp.LeaderId = this.ProbeRPC(msg.From)
+    case MsgForward:
+        if len(msg.Entries) != 1 {
+            return Message{}, fmt.Errorf("raft: Forward carries %d entries, want 1", len(msg.Entries))
+        }
+        entry := msg.Entries[0]
+        forwarded := this.ForwardRPC(ForwardRequest{
+            Type:     entry.Type,
+            Command:  entry.Command,
+            Priority: entry.Priority,
+            ClientId: entry.ClientId,
+            Sequence: entry.Sequence,
+        })
+        resp.Index, resp.LogTerm, resp.Err = forwarded.Index, forwarded.Term, forwarded.Err
+    default:
+        return Message{}, fmt.Errorf("raft: unknown message type %v", msg.Type)
+    }
+    if err != nil {
+        return Message{}, err
+    }
+    return resp, nil
+}
+
+// NewMessageTransport returns a Transport for the node with ID from
+// that turns its RPCs into Messages and has send deliver them, for
+// instance to the receiver's Step. send follows the rules of a
+// Transport: it must not block, and must call reply exactly once,
+// with the response or the reason there is none, and not before it
+// returns.
+func NewMessageTransport(from ServerId, send func(msg Message, reply func(Message, error))) Transport {
+    return messageTransport{from: from, send: send}
+}
+
+// messageTransport turns a node's RPCs into Messages, which send
+// delivers, and the response Messages back into replies.
+type messageTransport struct {
+    from ServerId
+    send func(msg Message, reply func(Message, error))
+}
+
+func (this messageTransport) AppendEntries(
+    target ServerId,
+    req AppendEntriesRequest,
+    reply func(AppendEntriesResponse, error)) {
+    msg := Message{
+        Type:            MsgAppendEntries,
+        From:            this.from,
+        To:              target,
+        Term:            req.Term,
+        ProtocolVersion: req.ProtocolVersion,
+        Index:           req.PrevLogIndex,
+        LogTerm:         req.PrevLogTerm,
+        Entries:         req.Entries,
+        Commit:          req.LeaderCommit,
+    }
+    this.send(msg, func(resp Message, err error) {
+        reply(AppendEntriesResponse{ProtocolVersion: resp.ProtocolVersion, Term: resp.Term, Success: resp.Success}, err)
+    })
+}
+
+func (this messageTransport) RequestVote(
+    target ServerId,
+    req RequestVoteRequest,
+    reply func(RequestVoteResponse, error)) {
+    msg := Message{
+        Type:            MsgRequestVote,
+        From:            this.from,
+        To:              target,
+        Term:            req.Term,
+        ProtocolVersion: req.ProtocolVersion,
+        Index:           req.LastLogIndex,
+        LogTerm:         req.LastLogTerm,
+        Transfer:        req.LeadershipTransfer,
+    }
+    this.send(msg, func(resp Message, err error) {
+        reply(RequestVoteResponse{ProtocolVersion: resp.ProtocolVersion, Term: resp.Term, VoteGranted: resp.Success}, err)
+    })
+}
+
+func (this messageTransport) InstallSnapshot(
+    target ServerId,
+    req InstallSnapshotRequest,
+    reply func(InstallSnapshotResponse, error)) {
+    msg := Message{
+        Type:            MsgInstallSnapshot,
+        From:            this.from,
+        To:              target,
+        Term:            req.Term,
+        ProtocolVersion: req.ProtocolVersion,
+        Index:           req.LastIncludedIndex,
+        LogTerm:         req.LastIncludedTerm,
+        Checksum:        req.Checksum,
+        Offset:          req.Offset,
+        Data:            req.Data,
+        Done:            req.Done,
+    }
+    this.send(msg, func(resp Message, err error) {
+        reply(InstallSnapshotResponse{ProtocolVersion: resp.ProtocolVersion, Term: resp.Term, Success: resp.Success}, err)
+    })
+}
+
+func (this messageTransport) Handshake(
+    target ServerId,
+    req HandshakeRequest,
+    reply func(HandshakeResponse, error)) {
+    msg := Message{
+        Type:               MsgHandshake,
+        From:               this.from,
+        To:                 target,
+        ProtocolVersion:    req.ProtocolVersion,
+        MaxMessageSize:     req.MaxMessageSize,
+        MinProtocolVersion: req.MinProtocolVersion,
+        MaxProtocolVersion: req.MaxProtocolVersion,
+        Witness:            req.Witness,
+        ElectionPriority:   req.ElectionPriority,
+    }
+    this.send(msg, func(resp Message, err error) {
+        reply(HandshakeResponse{
+            ProtocolVersion:    resp.ProtocolVersion,
+            MaxMessageSize:     resp.MaxMessageSize,
+            MinProtocolVersion: resp.MinProtocolVersion,
+            MaxProtocolVersion: resp.MaxProtocolVersion,
+            Witness:            resp.Witness,
+            ElectionPriority:   resp.ElectionPriority,
+        }, err)
+    })
+}
+
+func (this messageTransport) Audit(
+    target ServerId,
+    req AuditRequest,
+    reply func(AuditResponse, error)) {
+    msg := Message{Type: MsgAudit, From: this.from, To: target, Term: req.Term, ProtocolVersion: req.ProtocolVersion}
+    this.send(msg, func(resp Message, err error) {
+        reply(AuditResponse{
+            ProtocolVersion: resp.ProtocolVersion,
+            Term:            resp.Term,
+            Index:           resp.Index,
+            Checksum:        resp.Checksum,
+        }, err)
+    })
+}
+
+func (this messageTransport) TimeoutNow(
+    target ServerId,
+    req TimeoutNowRequest,
+    reply func(TimeoutNowResponse, error)) {
+    msg := Message{Type: MsgTimeoutNow, From: this.from, To: target, Term: req.Term, ProtocolVersion: req.ProtocolVersion}
+    this.send(msg, func(resp Message, err error) {
+        reply(TimeoutNowResponse{ProtocolVersion: resp.ProtocolVersion, Term: resp.Term}, err)
+    })
+}
+
+func (this messageTransport) Probe(
+    target ServerId,
+    req ProbeRequest,
+    reply func(ProbeResponse, error)) {
+    msg := Message{Type: MsgProbe, From: this.from, To: target, ProtocolVersion: req.ProtocolVersion}
+    this.send(msg, func(resp Message, err error) {
+        reply(ProbeResponse{ProtocolVersion: resp.ProtocolVersion, Term: resp.Term, LeaderId: resp.LeaderId}, err)
+    })
+}
+
+func (this messageTransport) Forward(
+    target ServerId,
+    req ForwardRequest,
+    reply func(ForwardResponse, error)) {
+    entry := Entry{
+        Type:     req.Type,
+        Command:  req.Command,
+        Priority: req.Priority,
+        ClientId: req.ClientId,
+        Sequence: req.Sequence,
+    }
+    msg := Message{
+        Type:            MsgForward,
+        From:            this.from,
+        To:              target,
+        ProtocolVersion: req.ProtocolVersion,
+        Entries:         []Entry{entry},
+    }
+    this.send(msg, func(resp Message, err error) {
+        reply(ForwardResponse{ProtocolVersion: resp.ProtocolVersion, Index: resp.Index, Term: resp.LogTerm, Err: resp.Err}, err)
+    })
+}
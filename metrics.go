@@ -0,0 +1,181 @@
+package raft
+
+import (
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Metric names emitted by nodes. Durations are in seconds.
+const (
+    // Counter: elections this node started.
+    MetricElectionsStarted = "raft_elections_started_total"
+
+    // Counter: times this node became leader. Summed over a
+    // cluster it counts leader changes.
+    MetricLeaderChanges = "raft_leader_changes_total"
+
+    // Histogram, by peer: AppendEntries round-trip time.
+    MetricAppendLatency = "raft_append_entries_latency_seconds"
+
+    // Gauge: entries in this node's log not yet known committed.
+    MetricCommitLag = "raft_commit_lag_entries"
+
+    // Gauge: committed entries the state machine has yet to apply.
+    MetricApplyLag = "raft_apply_lag_entries"
+
+    // Gauge: entries in this node's log since the last snapshot.
+    MetricLogSize = "raft_log_entries"
+
+    // Histogram: time taken to snapshot the state machine.
+    MetricSnapshotDuration = "raft_snapshot_duration_seconds"
+
+    // Gauge: this node's current term.
+    MetricTerm = "raft_term"
+
+    // Counter, by peer: audits in which the peer's applied log
+    // differed from the leader's.
+    MetricDivergences = "raft_divergences_total"
+
+    // Counters, by peer: bytes of request bodies an HTTPTransport
+    // sent, before and after compression.
+    MetricTransportRawBytes        = "raft_transport_raw_bytes_total"
+    MetricTransportCompressedBytes = "raft_transport_compressed_bytes_total"
+
+    // Counter: corrupt records a WALStore truncated its log at on
+    // opening.
+    MetricWALCorruptions = "raft_wal_corruptions_total"
+)
+
+// Label names attached to metrics. LabelGroup is conventionally
+// set through Config.MetricLabels by processes hosting several Raft
+// groups.
+const (
+    LabelNode  = "node"
+    LabelPeer  = "peer"
+    LabelGroup = "group"
+)
+
+// Labels qualify a metric sample.
+type Labels map[string]string
+
+// Metrics receives the measurements a node makes. Implementations
+// must be safe for concurrent use and must not call back into the
+// Node, as they are called with its lock held.
+type Metrics interface {
+    IncrCounter(name string, labels Labels, delta float64)
+    SetGauge(name string, labels Labels, value float64)
+    Observe(name string, labels Labels, value float64)
+}
+
+// NoopMetrics discards every measurement.
+type NoopMetrics struct{}
+
+func (NoopMetrics) IncrCounter(string, Labels, float64) {}
+func (NoopMetrics) SetGauge(string, Labels, float64)    {}
+func (NoopMetrics) Observe(string, Labels, float64)     {}
+
+// labels returns the labels identifying this node, plus the given
+// name/value pairs.
+func (this *Node) labels(pairs ...string) Labels {
+    labels := Labels{LabelNode: string(this.id)}
+    for name, value := range this.config.MetricLabels {
+        labels[name] = value
+    }
+    for i := 0; i+1 < len(pairs); i += 2 {
+        labels[pairs[i]] = pairs[i+1]
+    }
+    return labels
+}
+
+// reportLogGauges updates the gauges describing the log. Must be
+// called with this.mu held.
+func (this *Node) reportLogGauges() {
+    this.metrics.SetGauge(MetricLogSize, this.labels(), float64(len(this.log)-1))
+    this.metrics.SetGauge(MetricCommitLag, this.labels(), float64(this.lastLogIndex()-this.commitIndex))
+    this.metrics.SetGauge(MetricApplyLag, this.labels(), float64(this.commitIndex-this.lastApplied))
+    this.metrics.SetGauge(MetricTerm, this.labels(), float64(this.currentTerm))
+}
+
+// observeDuration reports the time elapsed since start.
+func (this *Node) observeDuration(name string, labels Labels, start time.Time) {
+    this.metrics.Observe(name, labels, this.clock.Now().Sub(start).Seconds())
+}
+
+// AggregateMetrics returns Metrics that forward to metrics with the
+// named labels removed, merging the series that differed only in
+// them. A process hosting thousands of Raft groups can drop
+// LabelGroup and LabelPeer to keep the number of series exported
+// bounded. Merged counters and observations add up; a merged gauge
+// reports the largest value among its series, such as the longest
+// log or the worst commit lag.
+func AggregateMetrics(metrics Metrics, drop ...string) Metrics {
+    return &aggregateMetrics{
+        metrics: metrics,
+        drop:    drop,
+        gauges:  make(map[string]map[string]float64),
+    }
+}
+
+type aggregateMetrics struct {
+    metrics Metrics
+    drop    []string
+
+    // Latest value of every original gauge series, by the key of
+    // the merged series and then of the original.
+    mu     sync.Mutex
+    gauges map[string]map[string]float64
+}
+
+func (this *aggregateMetrics) IncrCounter(name string, labels Labels, delta float64) {
+    this.metrics.IncrCounter(name, this.strip(labels), delta)
+}
+
+func (this *aggregateMetrics) Observe(name string, labels Labels, value float64) {
+    this.metrics.Observe(name, this.strip(labels), value)
+}
+
+func (this *aggregateMetrics) SetGauge(name string, labels Labels, value float64) {
+    stripped := this.strip(labels)
+    merged := seriesKey(name, stripped)
+
+    this.mu.Lock()
+    series, ok := this.gauges[merged]
+    if !ok {
+        series = make(map[string]float64)
+        this.gauges[merged] = series
+    }
+    series[seriesKey(name, labels)] = value
+    max := value
+    for _, value := range series {
+        if value > max {
+            max = value
+        }
+    }
+    this.mu.Unlock()
+
+    this.metrics.SetGauge(name, stripped, max)
+}
+
+func (this *aggregateMetrics) strip(labels Labels) Labels {
+    stripped := make(Labels, len(labels))
+    for name, value := range labels {
+        stripped[name] = value
+    }
+    for _, name := range this.drop {
+        delete(stripped, name)
+    }
+    return stripped
+}
+
+// seriesKey identifies the series of a metric with the given labels.
+func seriesKey(name string, labels Labels) string {
+    pairs := make([]string, 0, len(labels))
+    for label, value := range labels {
+        pairs = append(pairs, label+"="+strconv.Quote(value))
+    }
+    sort.Strings(pairs)
+    return name + "{" + strings.Join(pairs, ",") + "}"
+}
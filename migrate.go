@@ -0,0 +1,190 @@
+package raft
+
+import (
+    "context"
+    "errors"
+    "fmt"
+)
+
+// ErrMigrationInProgress is returned by MigrateStorage while another
+// migration of the same node is under way.
+var ErrMigrationInProgress = errors.New("raft: storage migration in progress")
+
+// ErrMigrationFailed is returned by MigrateStorage when the new
+// stores could not be written or do not match the node's log. The
+// node keeps using its old stores.
+var ErrMigrationFailed = errors.New("raft: storage migration failed")
+
+// Entries copied to the new log store per acquisition of the lock.
+const migrationChunk = 256
+
+// storageMigration is a move to new stores under way. Until the
+// switch, every write goes to both the old and the new stores.
+type storageMigration struct {
+    logs   LogStore
+    stable StableStore
+
+    // The first write to the new stores that failed.
+    err error
+}
+
+func (this *storageMigration) fail(err error) {
+    if this.err == nil {
+        this.err = err
+    }
+}
+
+// MigrateStorage moves a running node onto new, empty stores without
+// stopping it:
+//
+//  1. Every write from now on goes to both the old and the new
+//     stores.
+//  2. The term, vote, latest snapshot and the entries already in
+//     the log are copied to the new stores, a chunk at a time so
+//     the node keeps serving in between. A snapshot kept in a
+//     SnapshotStore of its own stays there.
+//  3. Once the copy is complete, the node's last log index becomes
+//     the barrier. When everything up to it has been applied, the
+//     new stores are checked against the log and the node switches
+//     to them.
+//
+// The old stores are no longer written once MigrateStorage returns
+// nil, and may be closed by the caller. If ctx is done first, or a
+// write to the new stores fails, the migration is abandoned, the
+// node keeps its old stores and whatever was copied is left behind.
+func (this *Node) MigrateStorage(ctx context.Context, logs LogStore, stable StableStore) error {
+    if last, err := logs.LastIndex(); err != nil || last != 0 {
+        return fmt.Errorf("%w: the new log store is not empty", ErrMigrationFailed)
+    }
+    if this.config.Encryption != nil {
+        logs = NewEncryptedLogStore(logs, this.config.Encryption)
+    }
+    if this.config.LogCacheSize > 0 {
+        logs, _ = NewLogCache(this.config.LogCacheSize, logs)
+    }
+
+    this.mu.Lock()
+    if this.shutdown {
+        this.mu.Unlock()
+        return ErrRaftShutdown
+    }
+    if this.migration != nil {
+        this.mu.Unlock()
+        return ErrMigrationInProgress
+    }
+    migration := &storageMigration{logs: logs, stable: stable}
+    this.migration = migration
+    this.logInfo("migrating storage", "logStore", describe(logs), "stableStore", describe(stable))
+    this.persistState()
+    if this.snapshot != nil {
+        this.persistSnapshot(this.snapshot)
+    }
+    if recovered, err := this.stable.Get(keyRecoveredConfiguration); err == nil {
+        migration.fail(stable.Set(keyRecoveredConfiguration, recovered))
+    }
+    next := this.log[0].Index + 1
+    this.mu.Unlock()
+
+    // Copy the log, dropping the lock between chunks. Whatever
+    // changes in the meantime is written to both stores.
+    for {
+        this.mu.Lock()
+        if err := this.checkMigration(migration); err != nil {
+            this.mu.Unlock()
+            return err
+        }
+        next = maxInt(next, this.log[0].Index+1)
+        end := minInt(next+migrationChunk-1, this.lastLogIndex())
+        if next > end {
+            break
+        }
+        entries, err := this.readEntries(next, end)
+        if err == nil {
+            err = logs.StoreLogs(entries)
+        }
+        migration.fail(err)
+        next = end + 1
+        this.mu.Unlock()
+    }
+
+    barrier := this.lastLogIndex()
+    var applied chan struct{}
+    if this.lastApplied < barrier {
+        applied = make(chan struct{})
+        this.appliedWaiters = append(this.appliedWaiters, appliedWaiter{index: barrier, ch: applied})
+    }
+    this.mu.Unlock()
+
+    if applied != nil {
+        select {
+        case <-applied:
+        case <-this.closed:
+        case <-ctx.Done():
+            this.mu.Lock()
+            defer this.mu.Unlock()
+            if this.migration == migration {
+                this.migration = nil
+                this.logWarn("storage migration abandoned", "err", ctx.Err())
+            }
+            return ctx.Err()
+        }
+    }
+
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    if err := this.checkMigration(migration); err != nil {
+        return err
+    }
+    if err := this.verifyMigration(migration); err != nil {
+        this.migration = nil
+        this.logError("storage migration failed", "err", err)
+        return err
+    }
+    this.logs, this.stable = logs, stable
+    this.migration = nil
+    this.logInfo("storage migrated", "barrier", barrier)
+    return nil
+}
+
+// checkMigration reports whether migration was abandoned or has
+// failed, in which case it is abandoned. Must be called with
+// this.mu held.
+func (this *Node) checkMigration(migration *storageMigration) error {
+    if this.shutdown {
+        return ErrRaftShutdown
+    }
+    if migration.err != nil {
+        if this.migration == migration {
+            this.migration = nil
+            this.logError("storage migration failed", "err", migration.err)
+        }
+        return fmt.Errorf("%w: %v", ErrMigrationFailed, migration.err)
+    }
+    return nil
+}
+
+// verifyMigration checks the new stores hold the node's log and
+// persistent state. Must be called with this.mu held.
+func (this *Node) verifyMigration(migration *storageMigration) error {
+    if this.lastLogIndex() > this.log[0].Index {
+        first, err := migration.logs.FirstIndex()
+        if err == nil && first != this.log[0].Index+1 {
+            err = fmt.Errorf("first index is %d, want %d", first, this.log[0].Index+1)
+        }
+        last, err2 := migration.logs.LastIndex()
+        if err == nil && err2 == nil && last != this.lastLogIndex() {
+            err2 = fmt.Errorf("last index is %d, want %d", last, this.lastLogIndex())
+        }
+        entry, err3 := migration.logs.GetLog(this.lastLogIndex())
+        if err3 == nil && entry.TermNum != this.lastLogTerm() {
+            err3 = fmt.Errorf("last term is %d, want %d", entry.TermNum, this.lastLogTerm())
+        }
+        if err := errors.Join(err, err2, err3); err != nil {
+            return fmt.Errorf("%w: %v", ErrMigrationFailed, err)
+        }
+    }
+    if term, err := migration.stable.GetInt(keyCurrentTerm); err != nil || term != this.currentTerm {
+        return fmt.Errorf("%w: term is %d, want %d (%v)", ErrMigrationFailed, term, this.currentTerm, err)
+    }
+    return nil
+}
@@ -0,0 +1,217 @@
+package raft
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sort"
+    "sync"
+    "time"
+)
+
+// ErrUnknownGroup is returned for a Raft group a MultiNode does not
+// host.
+var ErrUnknownGroup = errors.New("raft: unknown group")
+
+// MultiNode hosts many independent Raft groups in one process, as
+// needed to shard a database across groups. The groups share a
+// single ticker, rather than a goroutine and timer each, and a
+// single way of sending messages: every Message carries the ID of
+// its group, and the receiving MultiNode hands it to that group's
+// node with Step.
+//
+// Groups are not ticked every tick: a timing wheel ticks each one
+// only when its next heartbeat or election timeout is due, or every
+// tick while it has proposals, transfers or checks under way, so
+// that tens of thousands of mostly idle groups cost little.
+type MultiNode struct {
+    id           ServerId
+    tickInterval time.Duration
+    send         func(msg Message, reply func(Message, error))
+
+    mu     sync.Mutex
+    groups map[string]*Node
+
+    // Schedules the ticks of the groups' nodes.
+    wheel timingWheel
+
+    // Set by SetBalancer; balancing while a round is under way.
+    balancer        LeaseholderBalancer
+    balanceInterval time.Duration
+    lastBalance     time.Time
+    balancing       bool
+
+    // Close to stop the ticker, which closes done once it has.
+    stop chan struct{}
+    done chan struct{}
+}
+
+// NewMultiNode returns a MultiNode for the process with node ID id,
+// which ticks its groups every tickInterval once started, and sends
+// their messages with send. send follows the rules of
+// NewMessageTransport.
+func NewMultiNode(
+    id ServerId,
+    tickInterval time.Duration,
+    send func(msg Message, reply func(Message, error))) *MultiNode {
+    return &MultiNode{
+        id:           id,
+        tickInterval: tickInterval,
+        send:         send,
+        groups:       make(map[string]*Node),
+    }
+}
+
+// AddGroup creates the node of this process in a Raft group, as
+// NewNode does. The node is driven by the MultiNode, so it must not
+// be started itself; its transport is replaced by the MultiNode's,
+// and its metrics are labelled with LabelGroup.
+func (this *MultiNode) AddGroup(
+    group string,
+    peers []ServerId,
+    statemachine func(command []byte),
+    options ...Option) (*Node, error) {
+    options = append(options, func(config *Config) {
+        config.Transport = NewMessageTransport(this.id, func(msg Message, reply func(Message, error)) {
+            msg.Group = group
+            this.send(msg, reply)
+        })
+        labels := Labels{LabelGroup: group}
+        for name, value := range config.MetricLabels {
+            if name != LabelGroup {
+                labels[name] = value
+            }
+        }
+        config.MetricLabels = labels
+    })
+    node, err := NewNode(this.id, peers, statemachine, options...)
+    if err != nil {
+        return nil, err
+    }
+
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    if _, ok := this.groups[group]; ok {
+        return nil, fmt.Errorf("%w: group %q already exists", ErrInvalidConfig, group)
+    }
+    this.groups[group] = node
+    node.mu.Lock()
+    node.timer = this.wheel.newTimer(node)
+    node.ticked = this.wheel.ticks()
+    node.begin()
+    node.reschedule()
+    node.mu.Unlock()
+    return node, nil
+}
+
+// RemoveGroup shuts down and forgets the node of a group.
+func (this *MultiNode) RemoveGroup(ctx context.Context, group string) error {
+    this.mu.Lock()
+    node, ok := this.groups[group]
+    delete(this.groups, group)
+    this.mu.Unlock()
+
+    if !ok {
+        return fmt.Errorf("%w: %q", ErrUnknownGroup, group)
+    }
+    return node.Shutdown(ctx)
+}
+
+// Group returns the node of a group, or nil.
+func (this *MultiNode) Group(group string) *Node {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    return this.groups[group]
+}
+
+// Groups returns the IDs of the groups hosted, in order.
+func (this *MultiNode) Groups() []string {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    groups := make([]string, 0, len(this.groups))
+    for group := range this.groups {
+        groups = append(groups, group)
+    }
+    sort.Strings(groups)
+    return groups
+}
+
+// Step hands msg, a request received from another process, to the
+// node of its group, and returns the response.
+func (this *MultiNode) Step(msg Message) (Message, error) {
+    node := this.Group(msg.Group)
+    if node == nil {
+        return Message{}, fmt.Errorf("%w: %q", ErrUnknownGroup, msg.Group)
+    }
+    return node.Step(msg)
+}
+
+// Tick advances the logical clock of every group by one tick,
+// ticking the nodes of the groups that have work due.
+func (this *MultiNode) Tick() {
+    tick, due := this.wheel.advance()
+    for _, timer := range due {
+        timer.node.tickTo(tick)
+    }
+}
+
+// Start ticks every group from a single background goroutine until
+// Stop is called.
+func (this *MultiNode) Start() {
+    this.mu.Lock()
+    if this.stop != nil {
+        this.mu.Unlock()
+        return
+    }
+    this.stop = make(chan struct{})
+    this.done = make(chan struct{})
+    stop, done := this.stop, this.done
+    this.mu.Unlock()
+
+    go func() {
+        defer close(done)
+        ticker := time.NewTicker(this.tickInterval)
+        defer ticker.Stop()
+        for {
+            select {
+            case now := <-ticker.C:
+                this.Tick()
+                this.maybeRebalance(now)
+            case <-stop:
+                return
+            }
+        }
+    }()
+}
+
+// Stop halts the goroutine started by Start and waits for it to
+// return.
+func (this *MultiNode) Stop() {
+    this.mu.Lock()
+    stop, done := this.stop, this.done
+    this.stop, this.done = nil, nil
+    this.mu.Unlock()
+
+    if stop == nil {
+        return
+    }
+    close(stop)
+    <-done
+}
+
+// Shutdown stops the ticker and shuts down every group.
+func (this *MultiNode) Shutdown(ctx context.Context) error {
+    this.Stop()
+
+    this.mu.Lock()
+    nodes := this.groups
+    this.groups = make(map[string]*Node)
+    this.mu.Unlock()
+
+    var errs []error
+    for _, node := range nodes {
+        errs = append(errs, node.Shutdown(ctx))
+    }
+    return errors.Join(errs...)
+}
@@ -0,0 +1,180 @@
+package raft
+
+import "fmt"
+
+// DefaultMaxMessageSize is the largest RPC message a node accepts
+// unless configured otherwise.
+const DefaultMaxMessageSize = 4 << 20
+
+// Estimated encoded sizes of the fixed parts of messages.
+const (
+    entryOverhead           = 32
+    appendEntriesOverhead   = 64
+    installSnapshotOverhead = 64
+)
+
+// The protocol versions this package speaks. Version 0 is spoken by
+// nodes that predate versioning, whose messages carry no version;
+// version 1 adds the versions themselves. Changes to the wire or
+// log formats take a new version, and a node only uses them with
+// peers that have agreed to it, so that a cluster can be upgraded a
+// node at a time: first every node is restarted with the new
+// MaxProtocolVersion, then, once none runs the old code, with the
+// new MinProtocolVersion.
+const (
+    ProtocolVersionMin = 0
+    ProtocolVersionMax = 1
+)
+
+type HandshakeRequest struct {
+    ProtocolVersion int
+    NodeId          ServerId
+    MaxMessageSize  int
+
+    // The protocol versions the sender speaks.
+    MinProtocolVersion int
+    MaxProtocolVersion int
+
+    // Whether the sender is a witness, and its election priority.
+    Witness          bool
+    ElectionPriority int
+}
+
+type HandshakeResponse struct {
+    ProtocolVersion    int
+    MaxMessageSize     int
+    MinProtocolVersion int
+    MaxProtocolVersion int
+    Witness            bool
+    ElectionPriority   int
+}
+
+// HandshakeRPC is invoked by a node before it replicates to or
+// otherwise talks to this node, to agree on a maximum message size
+// and a protocol version. Each side learns the other's limit,
+// versions, whether it is a witness and its election priority: they
+// talk in the highest version both speak.
+func (this *Node) HandshakeRPC(req HandshakeRequest) HandshakeResponse {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    if !this.shutdown {
+        this.messageLimits[req.NodeId] = minInt(this.config.MaxMessageSize, req.MaxMessageSize)
+        this.witnesses[req.NodeId] = req.Witness
+        this.priorities[req.NodeId] = req.ElectionPriority
+        this.agreeProtocolVersion(req.NodeId, req.MinProtocolVersion, req.MaxProtocolVersion)
+    }
+    return HandshakeResponse{
+        ProtocolVersion:    req.ProtocolVersion,
+        MaxMessageSize:     this.config.MaxMessageSize,
+        MinProtocolVersion: this.config.MinProtocolVersion,
+        MaxProtocolVersion: this.config.MaxProtocolVersion,
+        Witness:            this.config.Witness,
+        ElectionPriority:   this.config.ElectionPriority,
+    }
+}
+
+// messageLimit returns the largest message that may be sent to the
+// peer with the given ID, and false if no handshake has completed
+// yet. Must be called with this.mu held.
+func (this *Node) messageLimit(peerId ServerId) (int, bool) {
+    limit, ok := this.messageLimits[peerId]
+    return limit, ok
+}
+
+// agreeProtocolVersion settles the protocol version to talk to the
+// peer with the given ID in, the highest both it, speaking min to
+// max, and this node speak. Must be called with this.mu held.
+func (this *Node) agreeProtocolVersion(peerId ServerId, min, max int) {
+    version := minInt(max, this.config.MaxProtocolVersion)
+    if version < maxInt(min, this.config.MinProtocolVersion) {
+        if this.protocolVersions[peerId] != -1 {
+            this.logError("peer speaks no protocol version in common",
+                "peer", peerId, "peerVersions", fmt.Sprintf("%d-%d", min, max),
+                "versions", fmt.Sprintf("%d-%d", this.config.MinProtocolVersion, this.config.MaxProtocolVersion))
+        }
+        // Keep handshaking until one of them is upgraded.
+        delete(this.messageLimits, peerId)
+        version = -1
+    } else if previous, ok := this.protocolVersions[peerId]; !ok || previous != version {
+        this.logInfo("agreed protocol version", "peer", peerId, "version", version)
+    }
+    this.protocolVersions[peerId] = version
+}
+
+// protocolVersion returns the protocol version to talk to the peer
+// with the given ID in: the one agreed with it, or this node's
+// lowest if none has been yet. Must be called with this.mu held.
+func (this *Node) protocolVersion(peerId ServerId) int {
+    if version, ok := this.protocolVersions[peerId]; ok && version >= 0 {
+        return version
+    }
+    return this.config.MinProtocolVersion
+}
+
+// acceptProtocolVersion fails RPCs from the node with ID from in a
+// protocol version this node does not speak, and handshakes with the
+// sender, which has then agreed on a version this node speaks, if
+// they share one, by the time it retries.
+func (this *Node) acceptProtocolVersion(from ServerId, version int) error {
+    if version >= this.config.MinProtocolVersion && version <= this.config.MaxProtocolVersion {
+        return nil
+    }
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    if !this.shutdown && from != "" {
+        this.sendHandshake(from)
+    }
+    return fmt.Errorf("%w: %d, not %d to %d",
+        ErrUnsupportedProtocol, version, this.config.MinProtocolVersion, this.config.MaxProtocolVersion)
+}
+
+// sendHandshake negotiates a message size and a protocol version
+// with the peer with the given ID unless a handshake is already
+// under way. Must be called with this.mu held.
+func (this *Node) sendHandshake(peerId ServerId) {
+    if this.handshaking[peerId] {
+        return
+    }
+    this.handshaking[peerId] = true
+
+    req := HandshakeRequest{
+        ProtocolVersion:    this.protocolVersion(peerId),
+        NodeId:             this.id,
+        MaxMessageSize:     this.config.MaxMessageSize,
+        MinProtocolVersion: this.config.MinProtocolVersion,
+        MaxProtocolVersion: this.config.MaxProtocolVersion,
+        Witness:            this.config.Witness,
+        ElectionPriority:   this.config.ElectionPriority,
+    }
+    this.transport.Handshake(peerId, req, func(resp HandshakeResponse, err error) {
+        this.mu.Lock()
+        defer this.mu.Unlock()
+
+        delete(this.handshaking, peerId)
+        if this.shutdown || err != nil {
+            return
+        }
+        this.messageLimits[peerId] = minInt(this.config.MaxMessageSize, resp.MaxMessageSize)
+        this.witnesses[peerId] = resp.Witness
+        this.priorities[peerId] = resp.ElectionPriority
+        this.agreeProtocolVersion(peerId, resp.MinProtocolVersion, resp.MaxProtocolVersion)
+        if i, ok := this.position(peerId); ok && this.nodeType == Leader && this.protocolVersions[peerId] >= 0 {
+            this.replicateTo(i, false)
+        }
+    })
+}
+
+// entrySize estimates the encoded size of entry.
+func entrySize(entry Entry) int {
+    return len(entry.Command) + entryOverhead
+}
+
+// entriesSize estimates the encoded size of entries.
+func entriesSize(entries []Entry) int {
+    size := 0
+    for _, entry := range entries {
+        size += entrySize(entry)
+    }
+    return size
+}
@@ -0,0 +1,199 @@
+package raft
+
+import (
+    "sync/atomic"
+    "time"
+)
+
+// Observation is an event delivered to observers.
+type Observation struct {
+    NodeId ServerId
+
+    // One of LeaderObservation, RoleChange, PeerObservation,
+    // FailedHeartbeat or ResumedHeartbeat.
+    Data any
+}
+
+// LeaderObservation is sent when the node learns of a new leader,
+// or forgets the old one on starting an election.
+type LeaderObservation struct {
+    // The new leader, or empty if unknown.
+    LeaderId ServerId
+    Term     int
+}
+
+// RoleChange is sent when the node changes role.
+type RoleChange struct {
+    Previous NodeType
+    Role     NodeType
+    Term     int
+}
+
+// PeerObservation is sent by a leader when a peer stops or starts
+// answering its RPCs again.
+type PeerObservation struct {
+    PeerId    ServerId
+    Reachable bool
+}
+
+// FailedHeartbeat is sent by a leader for every AppendEntries a
+// peer fails to answer.
+type FailedHeartbeat struct {
+    PeerId      ServerId
+    LastContact time.Time
+
+    // The RPCs the peer has failed to answer in a row, this one
+    // included, and the ticks the leader waits before retrying it.
+    Failures int
+    Backoff  int
+}
+
+// ResumedHeartbeat is sent by a leader when a peer answers again
+// after failing to.
+type ResumedHeartbeat struct {
+    PeerId   ServerId
+    Failures int
+}
+
+// FilterFn selects the observations an Observer receives.
+type FilterFn func(*Observation) bool
+
+// Observer delivers a node's observations to a channel. Sends never
+// block, as observations are made with the node's lock held:
+// observations that do not fit in the channel's buffer are dropped
+// and counted.
+type Observer struct {
+    channel chan Observation
+    filter  FilterFn
+
+    numObserved atomic.Uint64
+    numDropped  atomic.Uint64
+}
+
+// NewObserver returns an observer sending to channel every
+// observation for which filter, if not nil, returns true.
+func NewObserver(channel chan Observation, filter FilterFn) *Observer {
+    return &Observer{channel: channel, filter: filter}
+}
+
+// NumObserved returns the number of observations delivered.
+func (this *Observer) NumObserved() uint64 {
+    return this.numObserved.Load()
+}
+
+// NumDropped returns the number of observations dropped because
+// the channel was full.
+func (this *Observer) NumDropped() uint64 {
+    return this.numDropped.Load()
+}
+
+// RegisterObserver starts delivering observations to observer.
+func (this *Node) RegisterObserver(observer *Observer) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    this.observers = append(this.observers, observer)
+}
+
+// DeregisterObserver stops delivering observations to observer.
+func (this *Node) DeregisterObserver(observer *Observer) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    for i, registered := range this.observers {
+        if registered == observer {
+            this.observers = append(this.observers[:i:i], this.observers[i+1:]...)
+            return
+        }
+    }
+}
+
+// LeaderCh returns a channel that receives true when this node
+// becomes leader and false when it stops being leader. It holds
+// only the latest change, so a slow reader sees the current state
+// rather than a backlog.
+func (this *Node) LeaderCh() <-chan bool {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    if this.leaderCh == nil {
+        this.leaderCh = make(chan bool, 1)
+    }
+    return this.leaderCh
+}
+
+// observe delivers data to every interested observer. Must be
+// called with this.mu held.
+func (this *Node) observe(data any) {
+    observation := Observation{NodeId: this.id, Data: data}
+    for _, observer := range this.observers {
+        if observer.filter != nil && !observer.filter(&observation) {
+            continue
+        }
+        select {
+        case observer.channel <- observation:
+            observer.numObserved.Add(1)
+        default:
+            observer.numDropped.Add(1)
+        }
+    }
+}
+
+// setRole changes the node's role, notifying observers and
+// LeaderCh. Must be called with this.mu held.
+func (this *Node) setRole(role NodeType) {
+    previous := this.nodeType
+    this.nodeType = role
+    if previous == role {
+        return
+    }
+    this.observe(RoleChange{Previous: previous, Role: role, Term: this.currentTerm})
+
+    if this.leaderCh != nil && (previous == Leader || role == Leader) {
+        // Replace any change the reader has not yet seen.
+        select {
+        case <-this.leaderCh:
+        default:
+        }
+        this.leaderCh <- role == Leader
+    }
+}
+
+// setLeader records the leader of the current term, notifying
+// observers. Must be called with this.mu held.
+func (this *Node) setLeader(leaderId ServerId) {
+    if this.leaderId == leaderId {
+        return
+    }
+    this.leaderId = leaderId
+    this.observe(LeaderObservation{LeaderId: leaderId, Term: this.currentTerm})
+}
+
+// observeReply records whether the peer at position i answered a
+// replication RPC, backing off from a peer that fails to and
+// notifying observers of failures and of changes in reachability.
+// Must be called with this.mu held, as leader.
+func (this *Node) observeReply(i int, err error) {
+    peerId := this.peers[i]
+    if err == nil {
+        this.lastContact[i] = this.clock.Now()
+        if this.failures[i] > 0 {
+            this.observe(ResumedHeartbeat{PeerId: peerId, Failures: this.failures[i]})
+        }
+        this.failures[i], this.backoff[i] = 0, 0
+    } else {
+        // Requests sent before the peer was backed off from fail
+        // together, and count as one failure.
+        if this.backoff[i] == 0 {
+            this.failures[i]++
+            this.backoff[i] = this.backoffTicks(this.failures[i])
+        }
+        this.observe(FailedHeartbeat{
+            PeerId:      peerId,
+            LastContact: this.lastContact[i],
+            Failures:    this.failures[i],
+            Backoff:     this.backoff[i],
+        })
+    }
+    if reachable := err == nil; reachable != this.reachable[i] {
+        this.reachable[i] = reachable
+        this.observe(PeerObservation{PeerId: peerId, Reachable: reachable})
+    }
+}
@@ -0,0 +1,84 @@
+package raft
+
+type ProbeRequest struct {
+    ProtocolVersion int
+    NodeId          ServerId
+}
+
+type ProbeResponse struct {
+    ProtocolVersion int
+    Term            int
+    LeaderId        ServerId
+}
+
+// ProbeRPC is invoked by a starting node to learn the leader this
+// node follows, if any.
+func (this *Node) ProbeRPC(nodeId ServerId) (term int, leaderId ServerId) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    if this.shutdown {
+        return this.currentTerm, ""
+    }
+    return this.currentTerm, this.leaderId
+}
+
+// prewarm asks every peer which leader it follows, holding off
+// elections until they answer, so that a node restarted into a
+// cluster with a healthy leader joins it as a follower instead of
+// timing out and disrupting it. Must be called with this.mu held.
+func (this *Node) prewarm() {
+    this.prewarmPending = 0
+    this.prewarmElapsed = 0
+    for _, peer := range this.peers {
+        if peer != this.id {
+            this.prewarmPending++
+        }
+    }
+    this.reschedule()
+    req := ProbeRequest{NodeId: this.id}
+    for _, peer := range this.peers {
+        if peer == this.id {
+            continue
+        }
+        this.transport.Probe(peer, req, func(resp ProbeResponse, err error) {
+            this.handleProbeReply(resp, err)
+        })
+    }
+}
+
+// handleProbeReply adopts the leader a peer reports following.
+func (this *Node) handleProbeReply(resp ProbeResponse, err error) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    if this.shutdown || this.prewarmPending == 0 {
+        return
+    }
+    this.prewarmPending--
+    if err != nil || resp.LeaderId == "" {
+        return
+    }
+
+    this.testToAbdicateLeadership(resp.Term, resp.LeaderId)
+    if resp.Term == this.currentTerm && this.nodeType == Follower {
+        this.logInfo("found leader on startup", "leader", resp.LeaderId, "term", resp.Term)
+        this.setLeader(resp.LeaderId)
+        this.resetElectionTimer()
+        this.prewarmPending = 0
+    }
+}
+
+// tickPrewarm gives up on probes unanswered after the longest
+// election timeout. It reports whether elections are still held
+// off. Must be called with this.mu held.
+func (this *Node) tickPrewarm() bool {
+    if this.prewarmPending == 0 {
+        return false
+    }
+    this.prewarmElapsed++
+    if this.prewarmElapsed >= this.config.ElectionTicksMax {
+        this.prewarmPending = 0
+    }
+    return this.prewarmPending > 0
+}
@@ -0,0 +1,98 @@
+package raft
+
+import (
+    "fmt"
+    "time"
+)
+
+// TimeoutProfile is a set of timing tunables suited to a class of
+// network, so that they need not be derived by hand from the round
+// trip time. Every member of a cluster should use the same profile.
+type TimeoutProfile struct {
+    Name             string
+    TickInterval     time.Duration
+    HeartbeatTicks   int
+    ElectionTicksMin int
+    ElectionTicksMax int
+}
+
+var (
+    // ProfileLAN suits members in one datacenter, with round trips
+    // under a few milliseconds: heartbeats every 50ms, elections
+    // after 150–300ms, as suggested in §5.6 of the raft paper.
+    ProfileLAN = TimeoutProfile{
+        Name:             "lan",
+        TickInterval:     10 * time.Millisecond,
+        HeartbeatTicks:   5,
+        ElectionTicksMin: 15,
+        ElectionTicksMax: 30,
+    }
+
+    // ProfileMixed suits members spread over datacenters in one
+    // region, with round trips of tens of milliseconds: heartbeats
+    // every 200ms, elections after 1–2s.
+    ProfileMixed = TimeoutProfile{
+        Name:             "mixed",
+        TickInterval:     25 * time.Millisecond,
+        HeartbeatTicks:   8,
+        ElectionTicksMin: 40,
+        ElectionTicksMax: 80,
+    }
+
+    // ProfileWAN suits members spread over regions, with round
+    // trips of up to a few hundred milliseconds: heartbeats every
+    // 500ms, elections after 2.5–5s.
+    ProfileWAN = TimeoutProfile{
+        Name:             "wan",
+        TickInterval:     50 * time.Millisecond,
+        HeartbeatTicks:   10,
+        ElectionTicksMin: 50,
+        ElectionTicksMax: 100,
+    }
+)
+
+// TimeoutProfiles lists the predefined profiles.
+var TimeoutProfiles = []TimeoutProfile{ProfileLAN, ProfileMixed, ProfileWAN}
+
+// TimeoutProfileByName returns the predefined profile with the given
+// name, for choosing one in a configuration file.
+func TimeoutProfileByName(name string) (TimeoutProfile, error) {
+    for _, profile := range TimeoutProfiles {
+        if profile.Name == name {
+            return profile, nil
+        }
+    }
+    return TimeoutProfile{}, fmt.Errorf("%w: unknown timeout profile %q", ErrInvalidConfig, name)
+}
+
+// Validate reports the first problem with the profile. Besides what
+// Config.Validate checks, the election timeout must span at least
+// three heartbeats, so that a single lost heartbeat does not start
+// an election, and must be randomized.
+func (this TimeoutProfile) Validate() error {
+    switch {
+    case this.TickInterval <= 0 || this.HeartbeatTicks <= 0:
+        return fmt.Errorf("%w: profile %q: TickInterval and HeartbeatTicks must be positive",
+            ErrInvalidConfig, this.Name)
+    case this.ElectionTicksMin < 3*this.HeartbeatTicks:
+        return fmt.Errorf("%w: profile %q: ElectionTicksMin (%d) is below three heartbeats (%d)",
+            ErrInvalidConfig, this.Name, this.ElectionTicksMin, 3*this.HeartbeatTicks)
+    case this.ElectionTicksMax <= this.ElectionTicksMin:
+        return fmt.Errorf("%w: profile %q: ElectionTicksMax (%d) must exceed ElectionTicksMin (%d)",
+            ErrInvalidConfig, this.Name, this.ElectionTicksMax, this.ElectionTicksMin)
+    }
+    return nil
+}
+
+// WithTimeoutProfile sets the tick interval, heartbeat interval and
+// election timeout from profile. NewNode rejects a profile that
+// fails Validate.
+func WithTimeoutProfile(profile TimeoutProfile) Option {
+    return func(this *Config) {
+        this.TimeoutProfile = profile
+        this.TickInterval = profile.TickInterval
+        this.HeartbeatTicks = profile.HeartbeatTicks
+        this.ElectionTicksMin = profile.ElectionTicksMin
+        this.ElectionTicksMax = profile.ElectionTicksMax
+    }
+}
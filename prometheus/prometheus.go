@@ -0,0 +1,138 @@
+// Package prometheus exports raft metrics to Prometheus.
+//
+//    collector := prometheus.NewCollector()
+//    registry.MustRegister(collector)
+//    node, err := raft.NewNode(id, peers, apply,
+//        raft.WithTransport(transport), raft.WithMetrics(collector))
+//
+// Processes hosting many Raft groups can bound the number of series
+// by merging groups and peers before they reach the collector:
+//
+//    metrics := raft.AggregateMetrics(collector, raft.LabelGroup, raft.LabelPeer)
+package prometheus
+
+import (
+    "sort"
+    "strings"
+    "sync"
+
+    prom "github.com/prometheus/client_golang/prometheus"
+    "github.com/tawawhite/raft"
+)
+
+var help = map[string]string{
+    raft.MetricElectionsStarted: "Elections started by the node.",
+    raft.MetricLeaderChanges:    "Times the node became leader.",
+    raft.MetricAppendLatency:    "AppendEntries round-trip time in seconds.",
+    raft.MetricCommitLag:        "Log entries not yet known to be committed.",
+    raft.MetricApplyLag:         "Committed log entries not yet applied to the state machine.",
+    raft.MetricLogSize:          "Log entries held since the last snapshot.",
+    raft.MetricSnapshotDuration: "Time taken to snapshot the state machine in seconds.",
+    raft.MetricTerm:             "The node's current term.",
+    raft.MetricDivergences:      "Audits in which a follower's applied log differed from the leader's.",
+
+    raft.MetricTransportRawBytes:        "Bytes of requests sent over HTTP, before compression.",
+    raft.MetricTransportCompressedBytes: "Bytes of requests sent over HTTP, after compression.",
+}
+
+// Collector implements both raft.Metrics and prometheus.Collector.
+// Metric vectors are created the first time a name is reported, so
+// it registers as an unchecked collector.
+type Collector struct {
+    mu         sync.Mutex
+    counters   map[string]*prom.CounterVec
+    gauges     map[string]*prom.GaugeVec
+    histograms map[string]*prom.HistogramVec
+}
+
+func NewCollector() *Collector {
+    return &Collector{
+        counters:   map[string]*prom.CounterVec{},
+        gauges:     map[string]*prom.GaugeVec{},
+        histograms: map[string]*prom.HistogramVec{},
+    }
+}
+
+func (this *Collector) IncrCounter(name string, labels raft.Labels, delta float64) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    vec, ok := this.counters[key(name, labels)]
+    if !ok {
+        vec = prom.NewCounterVec(opts(name).CounterOpts(), names(labels))
+        this.counters[key(name, labels)] = vec
+    }
+    vec.With(prom.Labels(labels)).Add(delta)
+}
+
+func (this *Collector) SetGauge(name string, labels raft.Labels, value float64) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    vec, ok := this.gauges[key(name, labels)]
+    if !ok {
+        vec = prom.NewGaugeVec(opts(name).GaugeOpts(), names(labels))
+        this.gauges[key(name, labels)] = vec
+    }
+    vec.With(prom.Labels(labels)).Set(value)
+}
+
+func (this *Collector) Observe(name string, labels raft.Labels, value float64) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    vec, ok := this.histograms[key(name, labels)]
+    if !ok {
+        o := opts(name)
+        vec = prom.NewHistogramVec(prom.HistogramOpts{
+            Name:    o.Name,
+            Help:    o.Help,
+            Buckets: prom.ExponentialBuckets(0.0005, 2, 16),
+        }, names(labels))
+        this.histograms[key(name, labels)] = vec
+    }
+    vec.With(prom.Labels(labels)).Observe(value)
+}
+
+// Describe sends nothing, making this an unchecked collector.
+func (this *Collector) Describe(chan<- *prom.Desc) {}
+
+func (this *Collector) Collect(ch chan<- prom.Metric) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    for _, vec := range this.counters {
+        vec.Collect(ch)
+    }
+    for _, vec := range this.gauges {
+        vec.Collect(ch)
+    }
+    for _, vec := range this.histograms {
+        vec.Collect(ch)
+    }
+}
+
+type metricOpts prom.Opts
+
+func (this metricOpts) CounterOpts() prom.CounterOpts { return prom.CounterOpts(this) }
+func (this metricOpts) GaugeOpts() prom.GaugeOpts     { return prom.GaugeOpts(this) }
+
+func opts(name string) metricOpts {
+    h, ok := help[name]
+    if !ok {
+        h = name
+    }
+    return metricOpts{Name: name, Help: h}
+}
+
+// names returns the sorted label names of labels.
+func names(labels raft.Labels) []string {
+    names := make([]string, 0, len(labels))
+    for name := range labels {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// key identifies a vector by metric name and label names, since a
+// vector's label names are fixed.
+func key(name string, labels raft.Labels) string {
+    return name + "{" + strings.Join(names(labels), ",") + "}"
+}
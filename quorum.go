@@ -0,0 +1,57 @@
+package raft
+
+// QuorumPolicy decides how many voters, the leader included, must
+// store an entry before it is committed, for deployments that want
+// writes more durable than a majority makes them. Elections and
+// leadership checks always use a majority, which is only safe if
+// every commit quorum overlaps every election quorum: a policy
+// asking for fewer than a majority is raised to one. During a change
+// of membership the policy applies to the old and the new members
+// alike.
+type QuorumPolicy interface {
+    CommitQuorum(voters int) int
+}
+
+// MajorityQuorum commits entries stored on a majority of the
+// voters (see §5.3 of the raft paper). It is the default.
+type MajorityQuorum struct{}
+
+func (this MajorityQuorum) CommitQuorum(voters int) int {
+    return voters/2 + 1
+}
+
+// AllQuorum commits entries only once every voter stores them, so
+// that an acknowledged write survives the loss of all but one
+// voter. Any voter being down stalls commits until it is back.
+type AllQuorum struct{}
+
+func (this AllQuorum) CommitQuorum(voters int) int {
+    return voters
+}
+
+// FixedQuorum commits entries stored on that many voters, or on all
+// of them if there are fewer.
+type FixedQuorum int
+
+func (this FixedQuorum) CommitQuorum(voters int) int {
+    return minInt(int(this), voters)
+}
+
+// WithCommitQuorum sets the policy deciding when entries are
+// committed.
+func WithCommitQuorum(policy QuorumPolicy) Option {
+    return func(this *Config) {
+        this.CommitQuorum = policy
+    }
+}
+
+// commitQuorum returns the number of voters of a set of the given
+// size that must store an entry for it to be committed. Must be
+// called with this.mu held.
+func (this *Node) commitQuorum(voters int) int {
+    quorum := voters/2 + 1
+    if this.config.CommitQuorum != nil {
+        quorum = maxInt(quorum, minInt(this.config.CommitQuorum.CommitQuorum(voters), voters))
+    }
+    return quorum
+}
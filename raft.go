@@ -1,7 +1,12 @@
 package raft
 
 import (
-    "github.com/google/go-cmp/cmp"
+    "errors"
+    "fmt"
+    "math/rand"
+    "strconv"
+    "sync"
+    "time"
 )
 
 type NodeType int
@@ -12,18 +17,58 @@ const (
     Candidate
 )
 
+func (this NodeType) String() string {
+    switch this {
+    case Leader:
+        return "Leader"
+    case Follower:
+        return "Follower"
+    case Candidate:
+        return "Candidate"
+    }
+    return "NodeType(" + strconv.Itoa(int(this)) + ")"
+}
+
 type Node struct {
+    // Serializes every read and write of the state below.
+    // RPC handlers, Propose, Tick and the role transitions
+    // all take it, so they may be called concurrently.
+    // The transport never answers while it is held, so two
+    // nodes can safely RPC each other at the same time.
+    mu sync.Mutex
+
     // Node ID
-    id int
+    id ServerId
 
     // Role of the node.
     nodeType NodeType
 
     // State Machine
-    stateMachine func(string)
+    stateMachine func([]byte) (any, error)
+
+    // IDs of the nodes participating in the protocol, in ascending
+    // order: the members of the configuration the node follows,
+    // which include it unless it has yet to join.
+    peers []ServerId
+
+    // The configuration the node follows, the latest in its log,
+    // and the one in force at the start of its log, which the log
+    // falls back on when later ones are truncated.
+    members     Configuration
+    baseMembers Configuration
+
+    // The configuration the node was created with.
+    config Config
 
-    // List of other nodes participating in the protocol.
-    peers []*Node
+    // Carries RPCs to the peers.
+    transport Transport
+
+    // Tells the time.
+    clock Clock
+
+    // Persist the log, term and vote.
+    logs   LogStore
+    stable StableStore
 
     // The following values are from the states
     // described in the raft paper:
@@ -35,12 +80,22 @@ type Node struct {
     currentTerm int
 
     // CandidateId that received vote in current
-    // term (or null if none).
-    votedFor int
+    // term (or empty if none).
+    votedFor ServerId
 
     // Log entries; each entry contains command
     // for state machine, and term when entry
     // was received by leader (first index is 1).
+    //
+    // log[0] is a sentinel that is never applied: it
+    // stands in for the (empty) prefix before the first
+    // real entry, so an empty log has lastIndex=0 and
+    // lastTerm=0 and the log slice is never empty. Once
+    // the log is compacted the sentinel carries the index
+    // and term of the last compacted entry.
+    //
+    // Normal entries are held without their commands, which
+    // stay in the LogStore; see heldEntry.
     log []Entry
 
     // VOLATILE STATE ON ALL SERVERS:
@@ -55,6 +110,19 @@ type Node struct {
     // monotonically).
     lastApplied int
 
+    // Index of highest log entry admitted for application. It
+    // equals lastApplied, unless Config.ApplyQueue is set and the
+    // applier has yet to apply the entries queued for it.
+    admitted int
+
+    // Leader of the current term, if known, else empty.
+    leaderId ServerId
+
+    // Highest commit index announced by a leader, and when this
+    // node last had everything it announced applied.
+    leaderCommit int
+    caughtUp     time.Time
+
     // VOLATILE STATE ON LEADERS
 
     //  For each server, index of the next log entry
@@ -66,46 +134,477 @@ type Node struct {
     // known to be replicated on server
     // (initialized to 0, increases monotonically).
     matchIndex []int
+
+    // For each server, the number of requests awaiting a
+    // reply and the size of their entries, whether it is in
+    // pipeline mode, and the epoch of its window, bumped
+    // whenever replication falls back to probing so that older
+    // replies can be told apart.
+    inflight      []int
+    inflightBytes []int
+    pipeline      []bool
+    epoch         []int
+
+    // For each server, the snapshot being sent to it, if any.
+    transfers []*snapshotTransfer
+
+    // For each server, when it last answered a replication RPC,
+    // and whether the last one was answered.
+    lastContact []time.Time
+    reachable   []bool
+
+    // For each server, the replication RPCs it has failed to
+    // answer in a row, and the ticks left before it is sent
+    // another.
+    failures []int
+    backoff  []int
+
+    // HANDSHAKES:
+
+    // The limits and protocol versions agreed with peers by ID,
+    // a version of -1 for peers sharing none with this node, the
+    // peers that are witnesses, their election priorities, and the
+    // handshakes under way.
+    messageLimits    map[ServerId]int
+    protocolVersions map[ServerId]int
+    witnesses        map[ServerId]bool
+    priorities       map[ServerId]int
+    handshaking      map[ServerId]bool
+
+    // PROPOSALS:
+
+    // Proposals waiting to be appended to the log, their
+    // total command size and the ticks the oldest has waited.
+    proposals     []*ProposeFuture
+    proposalBytes int
+    proposalWait  int
+
+    // Appended proposals awaiting application, by log index.
+    pending map[int]*ProposeFuture
+
+    // Proposals forwarded to the leader, awaiting its answer.
+    forwarded map[*ProposeFuture]bool
+
+    // Faults injected by PauseNode and DropNextN: whether the node
+    // is paused, and how many RPCs of each type remain to drop.
+    paused bool
+    drops  map[MessageType]int
+
+    // Client sessions, by client ID.
+    sessions map[int]*clientSession
+
+    // Number of the latest promotion or demotion the cluster has
+    // applied, which fences a demoted primary.
+    clusterEpoch int
+
+    // Callers of WaitForToken and WaitForAppliedIndex waiting for
+    // entries to be applied, and of WaitForIndex waiting for them to
+    // be committed.
+    appliedWaiters   []appliedWaiter
+    committedWaiters []appliedWaiter
+
+    // Watches of the commands applied, see WatchApplied.
+    watches []*AppliedWatch
+
+    // Callers of WaitForStaleRead waiting for the node to catch up.
+    freshWaiters []chan struct{}
+
+    // SNAPSHOTS:
+
+    // Latest snapshot, covering the log up to the sentinel.
+    snapshot []byte
+
+    // Snapshot being received from the leader.
+    incoming *snapshotTransfer
+
+    // Callers of Snapshot waiting for the applier to drain.
+    snapshotRequests []*SnapshotFuture
+
+    // Snapshot Restore is waiting to install, if any.
+    restore *pendingRestore
+
+    // LEADERSHIP TENURE:
+
+    // When this node last became leader, how long its recent
+    // tenures lasted, and the short ones in a row for the
+    // FlapDetector.
+    leaderSince  time.Time
+    tenures      []time.Duration
+    shortTenures []time.Duration
+
+    // Receives the node's measurements and log events.
+    metrics Metrics
+    logger  Logger
+
+    // Receive role and leadership changes.
+    observers []*Observer
+    leaderCh  chan bool
+
+    // ANTI-ENTROPY AUDIT:
+
+    // Ticks since the last audit.
+    auditElapsed int
+
+    // Rolling checksum of the log up to lastApplied, and of the
+    // snapshot's last included entry.
+    checksum         uint64
+    snapshotChecksum uint64
+
+    // Checksums of the most recently applied entries, oldest first.
+    checksums []uint64
+
+    // TIMERS:
+
+    // Ticks since the election timer was last reset.
+    electionElapsed int
+
+    // When the leader of the current term was last heard from.
+    leaderContact time.Time
+
+    // Randomized number of ticks without hearing from a leader
+    // after which a follower starts an election.
+    electionTimeout int
+
+    // Ticks since the leader last sent heartbeats.
+    heartbeatElapsed int
+
+    // The timer of the MultiNode driving the node, if any, and the
+    // tick of its wheel up to which the node has counted ticks.
+    timer  *wheelTimer
+    ticked int
+
+    // Votes received in the current election, by peer id.
+    votes map[ServerId]bool
+
+    // Probes for the leader sent by Start still unanswered, which
+    // hold off elections, and the ticks they have done so.
+    prewarmPending int
+    prewarmElapsed int
+
+    // Source of election timeout jitter.
+    rand *rand.Rand
+
+    // Closed to stop the goroutine started by Start,
+    // which closes done once it has returned.
+    stop chan struct{}
+    done chan struct{}
+
+    // Report made by the last call to Start.
+    startupReport *StartupReport
+
+    // Leadership handover under way, if any.
+    leaderTransfer *leadershipTransfer
+
+    // Change of membership awaiting the commit of its joint
+    // configuration, if any.
+    configurationChange *ProposeFuture
+
+    // Checks of leadership awaiting a quorum, and the latest
+    // heartbeat round sent for them.
+    verifications []*VerifyFuture
+    verifyRound   int
+
+    // Move to new stores under way, if any.
+    migration *storageMigration
+
+    // SHUTDOWN:
+
+    // Set by Drain, until Undrain.
+    draining bool
+
+    // Set once Shutdown has begun. closed is closed, and closeErr
+    // set, once it has finished; rpcs counts the goroutines of
+    // RPCs still running.
+    shutdown bool
+    closed   chan struct{}
+    closeErr error
+    rpcs     sync.WaitGroup
+
+    // Batches of committed entries waiting for the applier, with
+    // Config.ApplyQueue.
+    applyQueue chan []appliedEntry
+}
+
+// EntryType tells user commands apart from the entries the node
+// appends for its own purposes.
+type EntryType uint8
+
+const (
+    // A command for the state machine.
+    EntryNormal EntryType = iota
+
+    // An empty entry committed by a new leader.
+    EntryNoOp
+
+    // A change of the cluster's membership.
+    EntryConfiguration
+
+    // Marks a point in the log that callers wait to be applied.
+    EntryBarrier
+
+    // Opens a client session, identified by the entry's index.
+    EntryRegisterClient
+
+    // Ends a standby cluster's standby mode.
+    EntryPromote
+
+    // Turns a cluster into a standby.
+    EntryDemote
+)
+
+func (this EntryType) String() string {
+    switch this {
+    case EntryNormal:
+        return "Normal"
+    case EntryNoOp:
+        return "NoOp"
+    case EntryConfiguration:
+        return "Configuration"
+    case EntryBarrier:
+        return "Barrier"
+    case EntryRegisterClient:
+        return "RegisterClient"
+    case EntryPromote:
+        return "Promote"
+    case EntryDemote:
+        return "Demote"
+    }
+    return "EntryType(" + strconv.Itoa(int(this)) + ")"
 }
 
 type Entry struct {
-    Command string
+    Type    EntryType
+    Command []byte
     Index   int
     TermNum int
+
+    // Set by ProposeWithPriority; see Config.ReorderApply.
+    Priority int
+
+    // Set by ProposeSession: the client's session, and the
+    // command's sequence number within it.
+    ClientId int
+    Sequence int
 }
 
-func NewNode(id int, peers []*Node, statemachine func(string)) (this *Node) {
-    this = new(Node)
+// NewNode creates a follower that applies the commands of committed
+// EntryNormal entries to statemachine, configured by DefaultConfig
+// adjusted by options. peers are the IDs of the other members of the
+// cluster, which the node reaches through the configured Transport.
+// The state machine is called with the node's lock held, unless
+// Config.ApplyQueue is set, and must honour the contract of
+// StateMachine. A witness, set by Config.Witness, has no state
+// machine, and statemachine may be nil, as it may with Config.Apply.
+//
+// Without peers, the node starts with no configuration at all: it
+// never stands for election, until BootstrapCluster gives it one or
+// it learns one from a leader. A configuration RecoverCluster left
+// in the node's StableStore takes the place of peers.
+func NewNode(id ServerId, peers []ServerId, statemachine func(command []byte), options ...Option) (*Node, error) {
+    config := DefaultConfig()
+    for _, option := range options {
+        option(&config)
+    }
+    if err := config.Validate(); err != nil {
+        return nil, err
+    }
+    if config.Transport == nil {
+        return nil, fmt.Errorf("%w: a Transport is required", ErrInvalidConfig)
+    }
+    members := append([]ServerId{id}, peers...)
+    sortServers(members)
+    for i, member := range members {
+        if member == "" {
+            return nil, fmt.Errorf("%w: member ID is empty", ErrInvalidConfig)
+        }
+        if i > 0 && member == members[i-1] {
+            return nil, fmt.Errorf("%w: member ID %q is listed twice", ErrInvalidConfig, member)
+        }
+    }
 
+    this := new(Node)
     this.id = id
-    this.stateMachine = statemachine
+    this.stateMachine = config.Apply
+    if this.stateMachine == nil && !config.Witness {
+        this.stateMachine = func(command []byte) (any, error) {
+            statemachine(command)
+            return nil, nil
+        }
+    }
+    if this.stateMachine == nil {
+        this.stateMachine = func([]byte) (any, error) { return nil, nil }
+    }
     this.nodeType = Follower
+    this.config = config
+
+    this.transport = faultTransport{node: this, transport: config.Transport}
+    this.logs, this.stable = config.LogStore, config.StableStore
+    if this.logs == nil {
+        this.logs = NewInmemStore()
+    }
+    if this.stable == nil {
+        this.stable = NewInmemStore()
+    }
+    if config.Encryption != nil {
+        this.logs = NewEncryptedLogStore(this.logs, config.Encryption)
+        if config.Archive != nil {
+            this.config.Archive = NewEncryptedArchive(config.Archive, config.Encryption)
+        }
+    }
+    if config.LogCacheSize > 0 {
+        this.logs, _ = NewLogCache(config.LogCacheSize, this.logs)
+    }
+
+    // The peers are the configuration of a fresh node; one that
+    // restarts follows its snapshot and log instead.
+    if len(peers) > 0 {
+        for _, member := range members {
+            this.baseMembers.Servers = append(this.baseMembers.Servers, Server{Id: member})
+        }
+    }
+    this.clock = config.Clock
+    if this.clock == nil {
+        this.clock = SystemClock{}
+    }
+    this.metrics = config.Metrics
+    if this.metrics == nil {
+        this.metrics = NoopMetrics{}
+    }
+    this.logger = config.Logger
+    if this.logger == nil {
+        this.logger = defaultLogger{}
+    }
+    seed := config.Seed
+    if seed == 0 {
+        seed = time.Now().UnixNano() + int64(id.hash())
+    }
+    this.rand = rand.New(rand.NewSource(seed))
+
+    this.messageLimits = make(map[ServerId]int)
+    this.protocolVersions = make(map[ServerId]int)
+    this.witnesses = make(map[ServerId]bool)
+    this.priorities = make(map[ServerId]int)
+    this.handshaking = make(map[ServerId]bool)
+    this.pending = make(map[int]*ProposeFuture)
+    this.forwarded = make(map[*ProposeFuture]bool)
+    this.drops = make(map[MessageType]int)
+    this.sessions = make(map[int]*clientSession)
+    if config.Standby {
+        this.sessions[MirrorClientId] = &clientSession{}
+    }
+    this.closed = make(chan struct{})
+    this.resetElectionTimer()
 
     // Initialize (non-leader)State described in the Raft paper:
     this.currentTerm = 0
-    this.votedFor = -1
-    this.log = make([]Entry, 0) // TODO: Initialize to 1?
+    this.votedFor = ""
+    this.leaderId = ""
+    this.log = []Entry{{Index: 0, TermNum: 0}}
     this.commitIndex = 0
     this.lastApplied = 0
+    this.admitted = 0
+    if err := this.recoverState(); err != nil {
+        return nil, fmt.Errorf("raft: recovering state: %w", err)
+    }
+    this.traceTLA("InitState", nil)
 
-    // Distribute knowledge to peers.
-    // In a real-world scenario, this would be handled by a
-    // configuration manager, such as Zookeeper.
-    peers = append(peers, this)
-    for _, node := range peers {
-        node.peers = peers
+    if config.ApplyQueue > 0 {
+        this.applyQueue = make(chan []appliedEntry, config.ApplyQueue)
+        this.rpcs.Add(1)
+        go this.runApplier()
     }
-    return
+    return this, nil
+}
+
+// Id returns the node's ID.
+func (this *Node) Id() ServerId {
+    return this.id
+}
+
+// Role returns the node's current role.
+func (this *Node) Role() NodeType {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    return this.nodeType
+}
+
+// Term returns the latest term the node has seen.
+func (this *Node) Term() int {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    return this.currentTerm
+}
+
+// Leader returns the leader of the current term, as the node learnt
+// it from the leader's AppendEntries or from winning the election,
+// and where it can be reached, resolved through
+// Config.AddressProvider or else taken from the configuration. Both
+// are empty while the node knows of no leader, such as during an
+// election.
+func (this *Node) Leader() (ServerId, ServerAddress) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    return this.leaderId, this.leaderAddress()
+}
+
+// leaderAddress returns where the leader can be reached, if known.
+// Must be called with this.mu held.
+func (this *Node) leaderAddress() ServerAddress {
+    if this.leaderId == "" {
+        return ""
+    }
+    if provider := this.config.AddressProvider; provider != nil {
+        if address, err := provider.ServerAddress(this.leaderId); err == nil {
+            return address
+        }
+    }
+    for _, servers := range [][]Server{this.members.Servers, this.members.NextServers} {
+        for _, server := range servers {
+            if server.Id == this.leaderId {
+                return server.Address
+            }
+        }
+    }
+    return ""
 }
 
 func (this *Node) BecomeLeader() {
-    this.nodeType = Leader
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    this.becomeLeader()
+}
+
+func (this *Node) BecomeFollower() {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    this.becomeFollower()
+}
+
+func (this *Node) BecomeCandidate() error {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    return this.becomeCandidate()
+}
+
+func (this *Node) becomeLeader() {
+    this.endTransfers()
+    this.setRole(Leader)
+    this.setLeader(this.id)
+    this.traceTLA("BecomeLeader", nil)
+    this.leaderSince = this.clock.Now()
+    this.metrics.IncrCounter(MetricLeaderChanges, this.labels(), 1)
+    this.logInfo("became leader", "term", this.currentTerm)
+    this.syncTimer()
+    this.heartbeatElapsed = 0
+    this.reschedule()
+    this.votes = nil
+    this.notifyFresh()
 
     // Initialize all nextIndex values to the index value just
     // after the last index in the log. (The log starts at 1.)
     this.nextIndex = make([]int, len(this.peers))
     for i := range this.nextIndex {
-        this.nextIndex[i] = len(this.log) + 1
+        this.nextIndex[i] = this.lastLogIndex() + 1
     }
 
     // For each server, index of highest log entry
@@ -113,82 +612,316 @@ func (this *Node) BecomeLeader() {
     // (initialized to 0, increases monotonically).
     this.matchIndex = make([]int, len(this.peers))
     for i := range this.matchIndex {
-        this.matchIndex[i] = 0 //TODO: ensure this is correct, will need to iteratively increment values to match followers later
+        this.matchIndex[i] = 0
     }
+
+    this.inflight = make([]int, len(this.peers))
+    this.inflightBytes = make([]int, len(this.peers))
+    this.pipeline = make([]bool, len(this.peers))
+    this.epoch = make([]int, len(this.peers))
+    this.transfers = make([]*snapshotTransfer, len(this.peers))
+    this.lastContact = make([]time.Time, len(this.peers))
+    this.reachable = make([]bool, len(this.peers))
+    this.failures = make([]int, len(this.peers))
+    this.backoff = make([]int, len(this.peers))
+    for i := range this.reachable {
+        this.reachable[i] = true
+    }
+
+    // Settle message sizes afresh with every peer, which may
+    // have been reconfigured since they were last agreed.
+    this.messageLimits = make(map[ServerId]int)
+
+    // A leader cannot count replicas of entries from earlier terms
+    // to commit them, so it appends a no-op entry of its own term
+    // whose commitment carries them along (see §5.4.2 and §8 of
+    // the raft paper).
+    err := this.appendLog(Entry{
+        Type:    EntryNoOp,
+        Index:   this.lastLogIndex() + 1,
+        TermNum: this.currentTerm,
+    })
+    if err != nil {
+        this.abdicate(err)
+        return
+    }
+    this.advanceCommitIndex()
 }
 
-func (this *Node) BecomeFollower() {
-    this.nodeType = Follower
+// stepDown discards the leader-only state, failing queued proposals
+// with a hint to resubmit them to the new leader, and a leader's
+// proposals yet to be committed. Must be called with this.mu held,
+// before changing role.
+func (this *Node) stepDown() {
+    this.endTenure()
+    this.failProposals(this.leadershipLost(false))
+    if this.nodeType == Leader {
+        this.failPending()
+    }
+    if this.leaderTransfer != nil {
+        this.endLeaderTransfer(nil)
+    }
+    if this.configurationChange != nil {
+        this.configurationChange.respond(ErrConfigurationChangeInterrupted)
+        this.configurationChange = nil
+    }
+    if this.restore != nil {
+        this.restore.future.respond(this.leadershipLost(false))
+        this.restore = nil
+    }
+    this.failVerifications(this.notLeader())
+    if this.nodeType == Leader {
+        this.endTransfers()
+    }
     this.nextIndex = nil
     this.matchIndex = nil
+    this.inflight = nil
+    this.inflightBytes = nil
+    this.pipeline = nil
+    this.epoch = nil
+    this.transfers = nil
+    this.lastContact = nil
+    this.reachable = nil
+    this.failures = nil
+    this.backoff = nil
 }
 
-func (this *Node) BecomeCandidate() {
-    this.nodeType = Candidate
-    this.nextIndex = nil
-    this.matchIndex = nil
+// becomeFollower restarts the election timer only when the node was
+// not already a follower: a follower's timer is reset by its leader
+// and the votes it grants alone, not by the terms other nodes report.
+func (this *Node) becomeFollower() {
+    wasFollower := this.nodeType == Follower
+    if !wasFollower {
+        this.logInfo("became follower", "term", this.currentTerm, "from", this.nodeType)
+    }
+    this.stepDown()
+    this.setRole(Follower)
+    this.traceTLA("BecomeFollower", nil)
+    this.votes = nil
+    if !wasFollower {
+        this.resetElectionTimer()
+    }
+}
+
+// becomeCandidate fails if the new term and vote cannot be
+// persisted, in which case the node must not request votes; it
+// retries at its next election timeout.
+func (this *Node) becomeCandidate() error {
+    this.stepDown()
+    this.setRole(Candidate)
+
+    // On conversion to candidate, start election: increment
+    // currentTerm, vote for self, reset election timer
+    // (see §5.2 of the raft paper).
+    this.currentTerm++
+    this.votedFor = this.id
+    this.setLeader("")
+    this.resetElectionTimer()
+    if err := this.persistState(); err != nil {
+        this.votes = nil
+        return err
+    }
+    this.votes = map[ServerId]bool{this.id: true}
+    this.traceTLA("BecomeCandidate", nil)
+    this.metrics.IncrCounter(MetricElectionsStarted, this.labels(), 1)
+    this.logInfo("starting election", "term", this.currentTerm)
+    return nil
 }
 
 func (this *Node) AppendEntriesRPC(
-    term,
-    leaderId,
+    term int,
+    leaderId ServerId,
     prevLogIndex,
     prevLogTerm int,
     newEntries []Entry,
-    leaderCommit int) (termResult int, success bool) {
-    // TODO: Sort newEntries?
+    leaderCommit int) (termResult int, success bool, err error) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    if this.shutdown {
+        return this.currentTerm, false, nil
+    }
+    if err := checkAppendEntries(term, prevLogIndex, prevLogTerm, newEntries, leaderCommit); err != nil {
+        return this.currentTerm, false, err
+    }
+    if this.config.TLATracer != nil {
+        req := AppendEntriesRequest{
+            Term:         term,
+            PrevLogIndex: prevLogIndex,
+            PrevLogTerm:  prevLogTerm,
+            Entries:      newEntries,
+            LeaderCommit: leaderCommit,
+        }
+        this.traceTLA("ReceiveAppendEntriesRequest", appendEntriesTLA(leaderId, this.id, req))
+        defer func() {
+            if err == nil {
+                resp := appendEntriesResponseTLA(this.id, leaderId, req, termResult, success)
+                this.traceTLA("SendAppendEntriesResponse", resp)
+            }
+        }()
+    }
 
     // Abdicate leadership if requester has higher term.
-    this.testToAbdicateLeadership(term)
+    if err := this.testToAbdicateLeadership(term, leaderId); err != nil {
+        return this.currentTerm, false, err
+    }
 
     // 1. Reply false if term < currentTerm.
     if term < this.currentTerm {
-        return this.currentTerm, false
+        this.logDebug("rejected AppendEntries from stale leader",
+            "leader", leaderId, "term", term, "currentTerm", this.currentTerm)
+        return this.currentTerm, false, nil
+    }
+
+    // The sender is the legitimate leader of this term: a
+    // candidate that lost the race steps down, and nobody
+    // should start an election while the leader is alive.
+    this.setLeader(leaderId)
+    if this.nodeType != Follower {
+        this.becomeFollower()
+    }
+    this.resetElectionTimer()
+    this.leaderContact = this.clock.Now()
+
+    // Entries up to the sentinel are committed, so they match
+    // the leader's log and only the rest need checking.
+    if base := this.log[0]; prevLogIndex < base.Index {
+        skip := minInt(base.Index-prevLogIndex, len(newEntries))
+        newEntries = newEntries[skip:]
+        prevLogIndex, prevLogTerm = base.Index, base.TermNum
     }
 
     // 2. Reply false if log doesn’t contain an entry at prevLogIndex
     //    whose term matches prevLogTerm (see §5.3 of the raft paper).
-    if this.log[prevLogIndex].TermNum != prevLogTerm {
-        return this.currentTerm, false
+    //    prevLogIndex 0 always matches the sentinel, so an empty
+    //    follower accepts entries starting at index 1.
+    if termAt, ok := this.termAt(prevLogIndex); !ok || termAt != prevLogTerm {
+        this.logDebug("rejected AppendEntries with mismatched log",
+            "leader", leaderId, "prevLogIndex", prevLogIndex, "prevLogTerm", prevLogTerm)
+        return this.currentTerm, false, nil
+    }
+
+    // A witness keeps no commands, even from a leader that does
+    // not know it is one.
+    if this.config.Witness {
+        newEntries = witnessEntries(newEntries)
     }
 
     // 3. If an existing entry conflicts with a new one (same index
     //    but different terms), delete the existing entry and all that
     //    follow it (see §5.3 of the raft paper).
-    for _, newEntry := range newEntries {
-        indexIsInRange := len(this.log) <= newEntry.Index
-        if indexIsInRange {
-            entryIsUnequal := !cmp.Equal(this.log[newEntry.Index], newEntry)
-            if entryIsUnequal {
-                this.log = this.log[:newEntry.Index] // todo: check to ensure this works.
-            }
+    // 4. Append any new entries not already in the log
+    //    Entries with the same index and term are identical
+    //    (see §5.3 of the raft paper), so the terms suffice to
+    //    tell a conflict.
+    for i, newEntry := range newEntries {
+        term, ok := this.termAt(newEntry.Index)
+        if ok && term == newEntry.TermNum {
+            continue
         }
-
+        // A leader holds every committed entry (see §5.4 of the
+        // raft paper), so a request conflicting with one is not
+        // from a leader following the protocol.
+        if ok && newEntry.Index <= this.commitIndex {
+            this.logError("AppendEntries conflicts with a committed entry",
+                "leader", leaderId, "index", newEntry.Index, "term", newEntry.TermNum, "commitIndex", this.commitIndex)
+            return this.currentTerm, false, fmt.Errorf("%w: entry %d of term %d conflicts with a committed entry",
+                ErrMalformedRequest, newEntry.Index, newEntry.TermNum)
+        }
+        if ok {
+            this.truncateLog(newEntry.Index)
+        }
+        if err := this.appendLog(newEntries[i:]...); err != nil {
+            return this.currentTerm, false, err
+        }
+        break
     }
 
-    // 4. Append any new entries not already in the log
-    this.log = append(this.log, newEntries...)
-
     // 5. If leaderCommit > commitIndex, set commitIndex =
     //    min(leaderCommit, index of last new entry).
+    //    The last new entry is prevLogIndex itself when the
+    //    request is a heartbeat carrying no entries.
     if leaderCommit > this.commitIndex {
-        this.commitIndex = minInt(leaderCommit, lastEntry(newEntries).Index)
+        newCommitIndex := minInt(leaderCommit, prevLogIndex+len(newEntries))
+        if newCommitIndex > this.commitIndex {
+            this.commitIndex = newCommitIndex
+            this.applyCommitted()
+        }
     }
+    this.noteLeaderCommit(leaderCommit)
 
-    return this.currentTerm, true
+    return this.currentTerm, true, nil
+}
+
+// checkAppendEntries fails with ErrMalformedRequest unless the
+// entries of an AppendEntries follow on from prevLogIndex, in terms
+// that never decrease, from prevLogTerm up to the leader's term.
+func checkAppendEntries(term, prevLogIndex, prevLogTerm int, entries []Entry, leaderCommit int) error {
+    if term < 0 || prevLogIndex < 0 || prevLogTerm < 0 || prevLogTerm > term || leaderCommit < 0 {
+        return fmt.Errorf("%w: AppendEntries of term %d after %d, %d, committing %d",
+            ErrMalformedRequest, term, prevLogIndex, prevLogTerm, leaderCommit)
+    }
+    previous := Entry{Index: prevLogIndex, TermNum: prevLogTerm}
+    for _, entry := range entries {
+        if entry.Index != previous.Index+1 || entry.TermNum < previous.TermNum || entry.TermNum > term {
+            return fmt.Errorf("%w: AppendEntries of term %d has entry %d of term %d after %d of term %d",
+                ErrMalformedRequest, term, entry.Index, entry.TermNum, previous.Index, previous.TermNum)
+        }
+        previous = entry
+    }
+    return nil
 }
 
 func (this *Node) RequestVoteRPC(
-    term,
-    candidateId,
+    term int,
+    candidateId ServerId,
     lastLogIndex,
-    lastLogTerm int) (termResult int, voteGranted bool) {
+    lastLogTerm int,
+    leadershipTransfer bool) (termResult int, voteGranted bool, err error) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    if this.shutdown {
+        return this.currentTerm, false, nil
+    }
+    if this.config.TLATracer != nil {
+        req := RequestVoteRequest{Term: term, LastLogIndex: lastLogIndex, LastLogTerm: lastLogTerm}
+        this.traceTLA("ReceiveRequestVoteRequest", requestVoteTLA(candidateId, this.id, req))
+        defer func() {
+            if err == nil {
+                resp := requestVoteResponseTLA(this.id, candidateId, termResult, voteGranted)
+                this.traceTLA("SendRequestVoteResponse", resp)
+            }
+        }()
+    }
+
+    // The leader, and a follower that has heard from it within the
+    // minimum election timeout, ignore the request without adopting
+    // its term, so that servers removed from the cluster or cut off
+    // from the leader cannot depose a healthy one (see §4.2.3 of
+    // the Raft dissertation). Leadership transfers are exempt.
+    if this.leaderIsSticky() && !leadershipTransfer {
+        this.logDebug("rejected vote while the leader is alive",
+            "candidate", candidateId, "term", term, "leader", this.leaderId)
+        return this.currentTerm, false, nil
+    }
+
     // Abdicate leadership if requester has higher term.
-    this.testToAbdicateLeadership(term)
+    if err := this.testToAbdicateLeadership(term, ""); err != nil {
+        return this.currentTerm, false, err
+    }
 
     //1. Reply false if term < currentTerm (see §5.1 of the raft paper)
     if term < this.currentTerm {
-        return this.currentTerm, false
+        this.logDebug("rejected vote for stale candidate",
+            "candidate", candidateId, "term", term, "currentTerm", this.currentTerm)
+        return this.currentTerm, false, nil
+    }
+
+    // A replica never votes.
+    if this.isReplica() {
+        this.logDebug("rejected vote as a replica", "candidate", candidateId, "term", term)
+        return this.currentTerm, false, nil
     }
 
     // 2. If votedFor is null or candidateId, and candidate’s log
@@ -199,17 +932,54 @@ func (this *Node) RequestVoteRPC(
     //    then the log with the later term is more up-to-date.
     //    If the logs end with the same term, then whichever
     //    log is longer is more up-to-date.
-    notYetVoted := this.votedFor == -1
+    notYetVoted := this.votedFor == ""
     votedSameBefore := this.votedFor == candidateId
-    requesterMoreUpToDate := lastEntry(this.log).TermNum <= term
+    requesterMoreUpToDate := this.isUpToDate(lastLogIndex, lastLogTerm)
     if (notYetVoted || votedSameBefore) && requesterMoreUpToDate {
-        return this.currentTerm, true
+        if policy := this.config.ElectionPolicy; policy != nil {
+            election := Election{
+                Term:               term,
+                Candidate:          candidateId,
+                LastLogIndex:       lastLogIndex,
+                LastLogTerm:        lastLogTerm,
+                LeadershipTransfer: leadershipTransfer,
+            }
+            if !policy.AllowVote(election) {
+                this.logDebug("election policy refused the vote", "candidate", candidateId, "term", term)
+                return this.currentTerm, false, nil
+            }
+        }
+        this.votedFor = candidateId
+        if err := this.persistState(); err != nil {
+            return this.currentTerm, false, err
+        }
+        this.resetElectionTimer()
+        this.logDebug("granted vote", "candidate", candidateId, "term", term)
+        return this.currentTerm, true, nil
     }
 
-    return this.currentTerm, false
+    this.logDebug("rejected vote", "candidate", candidateId, "term", term,
+        "votedFor", this.votedFor, "upToDate", requesterMoreUpToDate)
+    return this.currentTerm, false, nil
 }
 
-func (this *Node) testToAbdicateLeadership(term int) {
+// leaderIsSticky reports whether the node is the leader, or a
+// follower that has heard from its leader within the minimum
+// election timeout. Must be called with this.mu held.
+func (this *Node) leaderIsSticky() bool {
+    if this.nodeType == Leader {
+        return true
+    }
+    this.syncTimer()
+    return this.nodeType == Follower && this.leaderId != "" &&
+        this.electionElapsed < this.config.ElectionTicksMin
+}
+
+// testToAbdicateLeadership adopts term if it is newer than this
+// node's. leaderId is the sender when it is the leader of term,
+// else empty. It fails if the term cannot be persisted, in which case
+// the node must not reply to the RPC that carried it.
+func (this *Node) testToAbdicateLeadership(term int, leaderId ServerId) error {
     // Ensure the following property:
     // If RPC request or response contains
     // term T > currentTerm: set currentTerm = T,
@@ -217,9 +987,69 @@ func (this *Node) testToAbdicateLeadership(term int) {
     // paper)
 
     if term > this.currentTerm {
+        this.logInfo("observed higher term", "term", term, "previousTerm", this.currentTerm)
         this.currentTerm = term
-        this.nodeType = Follower
+        this.votedFor = ""
+        this.setLeader(leaderId)
+        this.becomeFollower()
+        return this.persistState()
+    }
+    return nil
+}
+
+// isUpToDate reports whether a log ending at lastLogIndex/lastLogTerm
+// is at least as up-to-date as this node's log. An empty log ends at
+// index 0, term 0.
+func (this *Node) isUpToDate(lastLogIndex, lastLogTerm int) bool {
+    if lastLogTerm != this.lastLogTerm() {
+        return lastLogTerm > this.lastLogTerm()
+    }
+    return lastLogIndex >= this.lastLogIndex()
+}
+
+// lastLogIndex returns the index of the last entry in the log
+// (0 for an empty log).
+func (this *Node) lastLogIndex() int {
+    return lastEntry(this.log).Index
+}
+
+// lastLogTerm returns the term of the last entry in the log
+// (0 for an empty log).
+func (this *Node) lastLogTerm() int {
+    return lastEntry(this.log).TermNum
+}
+
+// entryAt returns the entry at index, and false if the log does
+// not hold it or it cannot be read back from the LogStore. The
+// sentinel is returned for the base index.
+func (this *Node) entryAt(index int) (Entry, bool) {
+    entry, err := this.readEntry(index)
+    if errors.Is(err, ErrNotFound) {
+        return Entry{}, false
+    }
+    if err != nil {
+        this.logError("reading entry failed", "index", index, "err", err)
+        return Entry{}, false
+    }
+    return entry, true
+}
+
+// termAt returns the term of the entry at index, and false if
+// the log does not hold it.
+func (this *Node) termAt(index int) (int, bool) {
+    offset := index - this.log[0].Index
+    if offset < 0 || offset >= len(this.log) {
+        return 0, false
     }
+    return this.log[offset].TermNum, true
+}
+
+// maxInt finds Max of ints.
+func maxInt(a, b int) int {
+    if a > b {
+        return a
+    }
+    return b
 }
 
 // minInt finds Min of ints.
@@ -230,7 +1060,11 @@ func minInt(a, b int) int {
     return b
 }
 
-// lastEntry find last Entry in slice of Entries.
+// lastEntry find last Entry in slice of Entries, or the zero Entry
+// if there is none, as in a heartbeat.
 func lastEntry(ents []Entry) Entry {
+    if len(ents) == 0 {
+        return Entry{}
+    }
     return ents[len(ents)-1]
 }
@@ -0,0 +1,602 @@
+package raft
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// testCluster is a cluster of nodes started from empty state on one
+// Registry, recording the commands each applies.
+type testCluster struct {
+    registry *Registry
+    nodes    []*Node
+
+    mu      sync.Mutex
+    applied map[ServerId][]string
+}
+
+// startCluster starts size nodes with empty logs and stores, which
+// know each other as peers from the start.
+func startCluster(t testing.TB, size int, options ...Option) *testCluster {
+    this := &testCluster{registry: NewRegistry(), applied: make(map[ServerId][]string)}
+    var ids []ServerId
+    for i := 1; i <= size; i++ {
+        ids = append(ids, ServerId(fmt.Sprint(i)))
+    }
+    options = append([]Option{
+        WithTimeouts(3, 10, 20),
+        func(config *Config) { config.TickInterval = time.Millisecond },
+    }, options...)
+    for _, id := range ids {
+        var peers []ServerId
+        for _, peer := range ids {
+            if peer != id {
+                peers = append(peers, peer)
+            }
+        }
+        node, err := this.registry.NewNode(id, peers, this.recorder(id), options...)
+        if err != nil {
+            t.Fatal(err)
+        }
+        this.nodes = append(this.nodes, node)
+    }
+    for _, node := range this.nodes {
+        node.Start()
+    }
+    t.Cleanup(func() {
+        for _, node := range this.nodes {
+            node.Shutdown(context.Background())
+        }
+        this.registry.Close()
+    })
+    return this
+}
+
+func (this *testCluster) recorder(id ServerId) func([]byte) {
+    return func(command []byte) {
+        this.mu.Lock()
+        defer this.mu.Unlock()
+        this.applied[id] = append(this.applied[id], string(command))
+    }
+}
+
+// leader waits for a node to lead, failing t if none does.
+func (this *testCluster) leader(t testing.TB) *Node {
+    for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+        for _, node := range this.nodes {
+            if node.Role() == Leader {
+                return node
+            }
+        }
+    }
+    t.Fatal("no leader elected")
+    return nil
+}
+
+// waitApplied waits for every node to have applied want, failing t
+// if one does not.
+func (this *testCluster) waitApplied(t testing.TB, want []string) {
+    t.Helper()
+    for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+        this.mu.Lock()
+        done := true
+        for _, node := range this.nodes {
+            done = done && fmt.Sprint(this.applied[node.Id()]) == fmt.Sprint(want)
+        }
+        applied := fmt.Sprint(this.applied)
+        this.mu.Unlock()
+        if done {
+            return
+        }
+        if time.Now().After(deadline) {
+            t.Fatalf("nodes applied %s, want %v each", applied, want)
+        }
+    }
+}
+
+func TestEmptyLog(t *testing.T) {
+    node, err := NewRegistry().NewNode("a", []ServerId{"b", "c"}, func([]byte) {})
+    if err != nil {
+        t.Fatal(err)
+    }
+    node.mu.Lock()
+    if node.lastLogIndex() != 0 || node.lastLogTerm() != 0 {
+        t.Fatalf("empty log ends at index %d, term %d", node.lastLogIndex(), node.lastLogTerm())
+    }
+    if sentinel, ok := node.entryAt(0); !ok || sentinel.Index != 0 || sentinel.TermNum != 0 {
+        t.Fatalf("index 0 holds %+v, %v", sentinel, ok)
+    }
+    if _, ok := node.entryAt(1); ok {
+        t.Fatal("empty log holds index 1")
+    }
+    node.mu.Unlock()
+
+    // A candidate with an empty log is as up to date as the node.
+    if _, granted, err := node.RequestVoteRPC(1, "b", 0, 0, false); err != nil || !granted {
+        t.Fatalf("vote for an empty log: granted %v, %v", granted, err)
+    }
+
+    // A heartbeat matches the empty prefix of the log, and nothing
+    // past it.
+    if _, success, err := node.AppendEntriesRPC(1, "b", 0, 0, nil, 0); err != nil || !success {
+        t.Fatalf("heartbeat on an empty log: success %v, %v", success, err)
+    }
+    if _, success, _ := node.AppendEntriesRPC(1, "b", 1, 1, nil, 0); success {
+        t.Fatal("empty log matched an entry at index 1")
+    }
+    entries := []Entry{{Type: EntryNormal, Index: 1, TermNum: 1, Command: []byte("x")}}
+    if _, success, err := node.AppendEntriesRPC(1, "b", 0, 0, entries, 0); err != nil || !success {
+        t.Fatalf("first entry: success %v, %v", success, err)
+    }
+    node.mu.Lock()
+    defer node.mu.Unlock()
+    if node.lastLogIndex() != 1 || node.lastLogTerm() != 1 {
+        t.Fatalf("log ends at index %d, term %d, want 1, 1", node.lastLogIndex(), node.lastLogTerm())
+    }
+}
+
+func TestHeartbeat(t *testing.T) {
+    for _, test := range []struct {
+        name            string
+        entries         int
+        prevLogIndex    int
+        leaderCommit    int
+        wantCommitIndex int
+    }{
+        {"sentinel only", 0, 0, 3, 0},
+        {"behind the leader", 3, 2, 5, 2},
+        {"ahead of the commit", 3, 3, 2, 2},
+    } {
+        t.Run(test.name, func(t *testing.T) {
+            node, err := NewRegistry().NewNode("a", []ServerId{"b", "c"}, func([]byte) {})
+            if err != nil {
+                t.Fatal(err)
+            }
+            var entries []Entry
+            for i := 1; i <= test.entries; i++ {
+                entries = append(entries, Entry{Type: EntryNormal, Index: i, TermNum: 1, Command: []byte("x")})
+            }
+            if _, success, err := node.AppendEntriesRPC(1, "b", 0, 0, entries, 0); err != nil || !success {
+                t.Fatalf("entries: success %v, %v", success, err)
+            }
+            prevLogTerm := 0
+            if test.prevLogIndex > 0 {
+                prevLogTerm = 1
+            }
+            _, success, err := node.AppendEntriesRPC(1, "b", test.prevLogIndex, prevLogTerm, nil, test.leaderCommit)
+            if err != nil || !success {
+                t.Fatalf("heartbeat: success %v, %v", success, err)
+            }
+            node.mu.Lock()
+            defer node.mu.Unlock()
+            if node.commitIndex != test.wantCommitIndex {
+                t.Fatalf("commit index %d after a heartbeat after %d committing %d, want %d",
+                    node.commitIndex, test.prevLogIndex, test.leaderCommit, test.wantCommitIndex)
+            }
+        })
+    }
+}
+
+func TestEmptyClusterCommits(t *testing.T) {
+    cluster := startCluster(t, 3)
+    leader := cluster.leader(t)
+    var want []string
+    for i := 0; i < 5; i++ {
+        command := fmt.Sprint("command ", i)
+        if err := leader.Propose(context.Background(), []byte(command)).Error(); err != nil {
+            t.Fatal(err)
+        }
+        want = append(want, command)
+    }
+    cluster.waitApplied(t, want)
+}
+
+func TestNoOpCommitsEntriesOfEarlierTerms(t *testing.T) {
+    var applied []string
+    node, err := NewRegistry().NewNode("a", []ServerId{"b", "c"}, func(command []byte) {
+        applied = append(applied, string(command))
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    stale := []Entry{{Type: EntryNormal, Index: 1, TermNum: 1, Command: []byte("stale")}}
+    if _, success, err := node.AppendEntriesRPC(1, "b", 0, 0, stale, 0); err != nil || !success {
+        t.Fatalf("appending the stale entry: success %v, %v", success, err)
+    }
+
+    node.mu.Lock()
+    node.currentTerm = 2
+    node.becomeLeader()
+    noOp, _ := node.entryAt(node.lastLogIndex())
+    i, _ := node.position("b")
+    epoch := node.epoch[i]
+    node.mu.Unlock()
+    if noOp.Type != EntryNoOp || noOp.Index != 2 || noOp.TermNum != 2 {
+        t.Fatalf("new leader appended %+v, want a no-op at index 2 of term 2", noOp)
+    }
+
+    // A majority storing the entry of term 1 does not commit it.
+    ack := func(prevLogIndex int, entries ...Entry) int {
+        req := AppendEntriesRequest{Term: 2, LeaderId: "a", PrevLogIndex: prevLogIndex, Entries: entries}
+        node.handleAppendEntriesReply("b", epoch, time.Now(), req, AppendEntriesResponse{Term: 2, Success: true}, nil)
+        return node.Status().CommitIndex
+    }
+    if commitIndex := ack(0, stale...); commitIndex != 0 {
+        t.Fatalf("entry of term 1 committed by counting replicas, commit index %d", commitIndex)
+    }
+
+    // Committing the no-op carries it along.
+    if commitIndex := ack(1, noOp); commitIndex != 2 {
+        t.Fatalf("commit index is %d, want 2", commitIndex)
+    }
+    node.mu.Lock()
+    defer node.mu.Unlock()
+    if fmt.Sprint(applied) != "[stale]" {
+        t.Fatalf("applied %v, want [stale]", applied)
+    }
+}
+
+func TestLeaderHint(t *testing.T) {
+    addresses := StaticAddresses{"1": "host1:7000", "2": "host2:7000", "3": "host3:7000"}
+    cluster := startCluster(t, 3, WithAddressProvider(addresses))
+    leader := cluster.leader(t)
+    var follower *Node
+    for deadline := time.Now().Add(5 * time.Second); follower == nil; time.Sleep(time.Millisecond) {
+        for _, node := range cluster.nodes {
+            if id, _ := node.Leader(); node != leader && id == leader.Id() {
+                follower = node
+            }
+        }
+        if time.Now().After(deadline) {
+            t.Fatal("no follower learnt the leader")
+        }
+    }
+
+    // A follower knows where the leader is, and says so when asked
+    // to do what only the leader can.
+    if id, address := follower.Leader(); id != leader.Id() || address != addresses[leader.Id()] {
+        t.Fatalf("follower knows the leader as %s at %s", id, address)
+    }
+    err := follower.Propose(context.Background(), []byte("x")).Error()
+    var notLeader *NotLeaderError
+    if !errors.As(err, &notLeader) || notLeader.LeaderId != leader.Id() || notLeader.LeaderAddress != addresses[leader.Id()] {
+        t.Fatalf("proposing to a follower: %v", err)
+    }
+    if !strings.Contains(err.Error(), string(addresses[leader.Id()])) {
+        t.Fatalf("error %q does not name the leader's address", err)
+    }
+    if id, address := leader.Leader(); id != leader.Id() || address != addresses[leader.Id()] {
+        t.Fatalf("leader knows the leader as %s at %s", id, address)
+    }
+}
+
+func TestWaitForIndex(t *testing.T) {
+    cluster := startCluster(t, 3)
+    leader := cluster.leader(t)
+    future := leader.Propose(context.Background(), []byte("x"))
+    if err := future.Error(); err != nil {
+        t.Fatal(err)
+    }
+
+    // Every node, followers included, gets to commit and apply the
+    // entry a client wrote through the leader.
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    for _, node := range cluster.nodes {
+        if err := node.WaitForIndex(ctx, future.Index()); err != nil {
+            t.Fatalf("node %s waiting for commit: %v", node.Id(), err)
+        }
+        if err := node.WaitForAppliedIndex(ctx, future.Index()); err != nil {
+            t.Fatalf("node %s waiting for apply: %v", node.Id(), err)
+        }
+        node.mu.Lock()
+        applied := node.lastApplied
+        node.mu.Unlock()
+        if applied < future.Index() {
+            t.Fatalf("node %s applied %d, want %d", node.Id(), applied, future.Index())
+        }
+    }
+
+    // Waiting for an entry not yet written ends with ctx, and with the
+    // node.
+    short, cancelShort := context.WithTimeout(context.Background(), 20*time.Millisecond)
+    defer cancelShort()
+    if err := leader.WaitForIndex(short, future.Index()+100); err != context.DeadlineExceeded {
+        t.Fatalf("waiting past the log: %v", err)
+    }
+    done := make(chan error, 1)
+    go func() { done <- leader.WaitForAppliedIndex(context.Background(), future.Index()+100) }()
+    leader.Shutdown(context.Background())
+    select {
+    case err := <-done:
+        if err != ErrRaftShutdown {
+            t.Fatalf("waiting on a node shut down: %v", err)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("wait outlived the node")
+    }
+}
+
+func TestReplica(t *testing.T) {
+    cluster := startCluster(t, 3)
+    leader := cluster.leader(t)
+    var replica, voter *Node
+    for _, node := range cluster.nodes {
+        switch {
+        case node == leader:
+        case replica == nil:
+            replica = node
+        default:
+            voter = node
+        }
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    if err := leader.AddReplica(ctx, replica.Id(), "").Error(); err != nil {
+        t.Fatal(err)
+    }
+    future := leader.Propose(ctx, []byte("x"))
+    if err := future.Error(); err != nil {
+        t.Fatal(err)
+    }
+
+    // The replica applies the log like any follower, but never
+    // stands for election or takes over leadership.
+    cluster.waitApplied(t, []string{"x"})
+    if !replica.Replica() || voter.Replica() || leader.Replica() {
+        t.Fatalf("replicas: %v %v %v", replica.Replica(), voter.Replica(), leader.Replica())
+    }
+    if err := replica.Campaign(); !errors.Is(err, ErrReplica) {
+        t.Fatalf("campaigning as a replica: %v", err)
+    }
+    if err := leader.LeadershipTransferTo(ctx, replica.Id()); !errors.Is(err, ErrLeadershipTransferFailed) {
+        t.Fatalf("transferring leadership to a replica: %v", err)
+    }
+    if status := leader.Status(); len(status.Peers) != 2 ||
+        status.Peers[0].Replica == status.Peers[1].Replica {
+        t.Fatalf("peers %+v", status.Peers)
+    }
+
+    // Nor does it count toward the quorum: without the other voter,
+    // nothing commits.
+    voter.Shutdown(context.Background())
+    short, cancelShort := context.WithTimeout(context.Background(), 100*time.Millisecond)
+    defer cancelShort()
+    if err := leader.Propose(short, []byte("y")).Error(); err == nil {
+        t.Fatal("committed with a voter and a replica")
+    }
+
+    // A configuration without voters is refused.
+    servers := []Server{{Id: leader.Id(), Replica: true}, {Id: replica.Id(), Replica: true}}
+    if err := checkServers(servers); !errors.Is(err, ErrInvalidConfig) {
+        t.Fatalf("configuration of replicas only: %v", err)
+    }
+}
+
+// electionPolicy is an ElectionPolicy made of functions.
+type electionPolicy struct {
+    campaign func(Election) bool
+    vote     func(Election) bool
+}
+
+func (this electionPolicy) AllowCampaign(election Election) bool { return this.campaign(election) }
+
+func (this electionPolicy) AllowVote(election Election) bool { return this.vote(election) }
+
+func TestElectionPolicy(t *testing.T) {
+    // Nodes 2 and 3 may stand for election, but only 3 gets votes.
+    cluster := startCluster(t, 3, WithElectionPolicy(electionPolicy{
+        campaign: func(election Election) bool { return election.Candidate != "1" },
+        vote:     func(election Election) bool { return election.Candidate == "3" },
+    }))
+    leader := cluster.leader(t)
+    if leader.Id() != "3" {
+        t.Fatalf("node %s leads", leader.Id())
+    }
+    for _, node := range cluster.nodes {
+        if node.Id() == "1" {
+            if err := node.Campaign(); !errors.Is(err, ErrCampaignRefused) {
+                t.Fatalf("campaign refused by the policy: %v", err)
+            }
+        }
+    }
+    if err := leader.Propose(context.Background(), []byte("x")).Error(); err != nil {
+        t.Fatal(err)
+    }
+    cluster.waitApplied(t, []string{"x"})
+}
+
+func TestLocality(t *testing.T) {
+    cluster := startCluster(t, 3)
+    leader := cluster.leader(t)
+    var follower *Node
+    for _, node := range cluster.nodes {
+        if node != leader {
+            follower = node
+        }
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    locality := Locality{Region: "eu-west", Zone: "eu-west-1a"}
+    future := leader.SetLocality(ctx, follower.Id(), locality)
+    if err := future.Error(); err != nil {
+        t.Fatal(err)
+    }
+
+    // The locality is recorded in the configuration, and reported by
+    // the member itself and by the leader.
+    if err := follower.WaitForIndex(ctx, future.Index()); err != nil {
+        t.Fatal(err)
+    }
+    if status := follower.Status(); status.Locality != locality {
+        t.Fatalf("follower locality %+v", status.Locality)
+    }
+    for _, peer := range leader.Status().Peers {
+        if want := (Locality{}); peer.Id == follower.Id() {
+            want = locality
+            if peer.Locality != want {
+                t.Fatalf("peer %s locality %+v", peer.Id, peer.Locality)
+            }
+        } else if peer.Locality != want {
+            t.Fatalf("peer %s locality %+v", peer.Id, peer.Locality)
+        }
+    }
+
+    // Changing the member's address keeps it.
+    if err := leader.AddVoter(ctx, follower.Id(), "elsewhere").Error(); err != nil {
+        t.Fatal(err)
+    }
+    for _, server := range leader.Configuration().Servers {
+        if server.Id == follower.Id() && (server.Locality != locality || server.Address != "elsewhere") {
+            t.Fatalf("server %+v", server)
+        }
+    }
+    if err := leader.SetLocality(ctx, "nobody", locality).Error(); !errors.Is(err, ErrInvalidConfig) {
+        t.Fatalf("locality of a non-member: %v", err)
+    }
+}
+
+func TestDrain(t *testing.T) {
+    cluster := startCluster(t, 3)
+    drained := cluster.leader(t)
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    if err := drained.Propose(ctx, []byte("x")).Error(); err != nil {
+        t.Fatal(err)
+    }
+
+    // A leader being drained hands over leadership, and has applied
+    // what it committed by the time it is drained.
+    if err := drained.Drain(ctx); err != nil {
+        t.Fatal(err)
+    }
+    status := drained.Status()
+    if status.Role == Leader || !status.Draining || status.LastApplied < status.CommitIndex {
+        t.Fatalf("drained node %+v", status)
+    }
+
+    // It then refuses proposals, naming the leader, and elections.
+    err := drained.Propose(ctx, []byte("y")).Error()
+    var notLeader *NotLeaderError
+    if !errors.Is(err, ErrDraining) || !errors.As(err, &notLeader) || notLeader.LeaderId == drained.Id() {
+        t.Fatalf("proposing to a drained node: %v", err)
+    }
+    if err := drained.Campaign(); !errors.Is(err, ErrDraining) {
+        t.Fatalf("campaigning while drained: %v", err)
+    }
+    leader := cluster.leader(t)
+    if err := leader.Propose(ctx, []byte("y")).Error(); err != nil {
+        t.Fatal(err)
+    }
+    cluster.waitApplied(t, []string{"x", "y"})
+
+    // Once undrained, it is an ordinary follower again.
+    drained.Undrain()
+    if err := drained.Propose(ctx, []byte("z")).Error(); errors.Is(err, ErrDraining) || !errors.Is(err, ErrNotLeader) {
+        t.Fatalf("proposing to an undrained follower: %v", err)
+    }
+    if drained.Draining() {
+        t.Fatal("still draining")
+    }
+}
+
+func TestAutoDemotion(t *testing.T) {
+    cluster := startCluster(t, 3, WithAutoDemotion(30*time.Millisecond))
+    leader := cluster.leader(t)
+    var failing ServerId
+    for _, node := range cluster.nodes {
+        if node != leader {
+            failing = node.Id()
+        }
+    }
+    member := func() Server {
+        for _, server := range cluster.leader(t).Configuration().Servers {
+            if server.Id == failing {
+                return server
+            }
+        }
+        t.Fatalf("%s is not a member", failing)
+        return Server{}
+    }
+    waitFor := func(what string, done func(Server) bool) {
+        t.Helper()
+        for deadline := time.Now().Add(5 * time.Second); !done(member()); time.Sleep(time.Millisecond) {
+            if time.Now().After(deadline) {
+                t.Fatalf("%s not %s: %+v", failing, what, member())
+            }
+        }
+    }
+
+    // A voter cut off from the leader is demoted, and the rest of the
+    // cluster goes on committing.
+    cluster.registry.SetLinkFilter(func(from, to ServerId) error {
+        if from == failing || to == failing {
+            return errors.New("link down")
+        }
+        return nil
+    })
+    waitFor("demoted", func(server Server) bool { return server.Replica && server.Demoted })
+    if err := cluster.leader(t).Propose(context.Background(), []byte("x")).Error(); err != nil {
+        t.Fatal(err)
+    }
+
+    // Once it is back and has caught up, it is promoted.
+    cluster.registry.SetLinkFilter(nil)
+    waitFor("promoted", func(server Server) bool { return !server.Replica && !server.Demoted })
+    cluster.waitApplied(t, []string{"x"})
+}
+
+func TestUnsupportedProtocol(t *testing.T) {
+    node, err := NewRegistry().NewNode("a", []ServerId{"b", "c"}, func([]byte) {})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := node.acceptProtocolVersion("", ProtocolVersionMax); err != nil {
+        t.Fatalf("version %d: %v", ProtocolVersionMax, err)
+    }
+    if err := node.acceptProtocolVersion("", ProtocolVersionMax+1); !errors.Is(err, ErrUnsupportedProtocol) {
+        t.Fatalf("version %d: %v, want %v", ProtocolVersionMax+1, err, ErrUnsupportedProtocol)
+    }
+}
+
+func TestFlapDetectorCountsPerNode(t *testing.T) {
+    var warnings []FlapWarning
+    detector := &FlapDetector{
+        MinTenure:       time.Hour,
+        MaxShortTenures: 2,
+        OnFlap:          func(warning FlapWarning) { warnings = append(warnings, warning) },
+    }
+    registry := NewRegistry()
+    var nodes []*Node
+    for _, id := range []ServerId{"a", "b"} {
+        node, err := registry.NewNode(id, nil, func([]byte) {}, func(config *Config) { config.FlapDetector = detector })
+        if err != nil {
+            t.Fatal(err)
+        }
+        nodes = append(nodes, node)
+    }
+    endTenure := func(node *Node) {
+        node.mu.Lock()
+        defer node.mu.Unlock()
+        node.nodeType = Leader
+        node.leaderSince = node.clock.Now()
+        node.endTenure()
+        node.nodeType = Follower
+    }
+
+    // A short tenure on each node is no streak.
+    endTenure(nodes[0])
+    endTenure(nodes[1])
+    if len(warnings) != 0 {
+        t.Fatalf("warned of %+v", warnings)
+    }
+    endTenure(nodes[0])
+    if len(warnings) != 1 || warnings[0].NodeId != "a" || len(warnings[0].Tenures) != 2 {
+        t.Fatalf("warned of %+v, want two short tenures of a", warnings)
+    }
+}
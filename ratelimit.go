@@ -0,0 +1,33 @@
+package raft
+
+import (
+    "math"
+    "time"
+)
+
+// tokenBucket paces a flow of bytes to rate per second, letting up
+// to a second's worth through at once. A zero rate is unlimited.
+type tokenBucket struct {
+    rate   int
+    tokens float64
+    last   time.Time
+}
+
+// take returns how many of want bytes may go at now, and counts
+// them as gone.
+func (this *tokenBucket) take(now time.Time, want int) int {
+    if this.rate == 0 {
+        return want
+    }
+    if this.last.IsZero() {
+        this.tokens = float64(this.rate)
+    } else {
+        elapsed := now.Sub(this.last).Seconds()
+        this.tokens = math.Min(this.tokens+elapsed*float64(this.rate), float64(this.rate))
+    }
+    this.last = now
+
+    n := minInt(want, int(this.tokens))
+    this.tokens -= float64(n)
+    return n
+}
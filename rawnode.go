@@ -0,0 +1,429 @@
+package raft
+
+import (
+    "context"
+    "errors"
+    "io"
+    "sync"
+    "time"
+)
+
+// ErrUnreachable is reported for the RPCs of a RawNode to a peer
+// the caller reported unreachable.
+var ErrUnreachable = errors.New("raft: peer unreachable")
+
+// HardState is the state a node must persist before answering RPCs.
+type HardState struct {
+    Term     int
+    VotedFor ServerId
+}
+
+// Ready is the work a RawNode hands to its caller. The caller must
+// persist HardState, Truncate, Entries and Compact, in that order,
+// before sending Messages, then apply CommittedEntries and call
+// Advance.
+type Ready struct {
+    // The term and vote, if they changed.
+    HardState *HardState
+
+    // If nonzero, the stored entries from this index on must be
+    // deleted before Entries are stored.
+    Truncate int
+
+    // Entries to append to stable storage.
+    Entries []Entry
+
+    // If nonzero, the stored entries up to and including this
+    // index are covered by a snapshot and may be discarded.
+    Compact int
+
+    // Messages to send to other nodes.
+    Messages []Message
+
+    // Entries committed since the previous Ready, in log order.
+    CommittedEntries []Entry
+}
+
+// RawNode is the Raft core of a Node without any IO: it keeps no
+// goroutines or timers, it is driven by Tick and Step, and instead
+// of persisting, sending and applying it hands its caller a Ready
+// describing what to persist, send and apply. It suits embedding
+// Raft in an event loop of one's own, and runs deterministically
+// given a Seed. With Config.Trace set, it records a trace of what
+// it is given and hands out, which ReplayTrace replays to reproduce
+// a bug. A RawNode is not safe for concurrent use.
+type RawNode struct {
+    node *Node
+
+    mu sync.Mutex
+
+    // The requests sent and not yet answered, by message Id.
+    nextId  uint64
+    replies map[uint64]rawReply
+
+    // The Ready being built, and whether the previous one is
+    // awaiting Advance.
+    ready    Ready
+    inflight bool
+
+    // Indexes of the log as the caller will have stored it, and
+    // of the last entry handed to the caller.
+    first, last int
+    handed      int
+
+    // Records the node's events, if Config.Trace is set.
+    trace *tracer
+}
+
+type rawReply struct {
+    to    ServerId
+    reply func(Message, error)
+}
+
+// NewRawNode creates a RawNode configured like NewNode. Its
+// transport and stores are replaced by the Ready it hands out, so
+// the Transport, LogStore and StableStore options are ignored.
+func NewRawNode(id ServerId, peers []ServerId, options ...Option) (*RawNode, error) {
+    this := &RawNode{replies: make(map[uint64]rawReply)}
+    var start traceEvent
+    var trace io.Writer
+    options = append(options, func(config *Config) {
+        if trace = config.Trace; trace != nil {
+            if config.Seed == 0 {
+                config.Seed = time.Now().UnixNano() + int64(id.hash())
+            }
+            start = traceEvent{
+                Type:             traceStart,
+                Id:               id,
+                Peers:            peers,
+                Seed:             config.Seed,
+                HeartbeatTicks:   config.HeartbeatTicks,
+                ElectionTicksMin: config.ElectionTicksMin,
+                ElectionTicksMax: config.ElectionTicksMax,
+            }
+        }
+        config.Transport = messageTransport{from: id, send: this.send}
+        config.LogStore = rawLogStore{this}
+        config.StableStore = rawStableStore{this}
+        config.ApplyQueue = 0
+        config.Apply = nil
+        onCommit := config.OnCommit
+        config.OnCommit = func(entry Entry) {
+            if onCommit != nil {
+                onCommit(entry)
+            }
+            this.commit(entry)
+        }
+    })
+    node, err := NewNode(id, peers, func([]byte) {}, options...)
+    if err != nil {
+        return nil, err
+    }
+    this.node = node
+    if trace != nil {
+        this.trace = newTraceWriter(trace, id, node.logger)
+        this.trace.record(start)
+    }
+    return this, nil
+}
+
+// Tick advances the node's logical clock by one tick.
+func (this *RawNode) Tick() {
+    this.node.Tick()
+    this.traced(traceEvent{Type: traceTick})
+}
+
+// Propose proposes command, as Node.Propose. The future resolves
+// once the entry has been handed out in CommittedEntries.
+func (this *RawNode) Propose(ctx context.Context, command []byte) *ProposeFuture {
+    future := this.node.Propose(ctx, command)
+    this.traced(traceEvent{Type: tracePropose, Command: command})
+    return future
+}
+
+// Campaign starts an election at once, as Node.Campaign.
+func (this *RawNode) Campaign() error {
+    err := this.node.Campaign()
+    this.traced(traceEvent{Type: traceCampaign})
+    return err
+}
+
+// ForgetLeader drops the leader the node follows, as
+// Node.ForgetLeader.
+func (this *RawNode) ForgetLeader() {
+    this.node.ForgetLeader()
+    this.traced(traceEvent{Type: traceForgetLeader})
+}
+
+// Status returns a snapshot of the node's state.
+func (this *RawNode) Status() Status {
+    return this.node.Status()
+}
+
+// Step passes the node a message received from another node: a
+// request, answered in a later Ready, or the response to one of its
+// own requests.
+func (this *RawNode) Step(msg Message) error {
+    err := this.step(msg)
+    if this.trace != nil {
+        this.traced(traceEvent{Type: traceStep, Message: newTracedMessage(msg)})
+    }
+    return err
+}
+
+func (this *RawNode) step(msg Message) error {
+    if msg.Response {
+        this.mu.Lock()
+        reply, ok := this.replies[msg.Id]
+        ok = ok && reply.to == msg.From
+        if ok {
+            delete(this.replies, msg.Id)
+        }
+        this.mu.Unlock()
+
+        // Responses to unknown requests are duplicates, or arrived
+        // after ReportUnreachable.
+        if ok {
+            reply.reply(msg, nil)
+        }
+        return nil
+    }
+
+    resp, err := this.node.Step(msg)
+    if err != nil {
+        return err
+    }
+    this.mu.Lock()
+    this.ready.Messages = append(this.ready.Messages, resp)
+    this.mu.Unlock()
+    return nil
+}
+
+// ReportUnreachable fails the node's requests to peer that have not
+// been answered, as a transport does when a peer cannot be reached.
+func (this *RawNode) ReportUnreachable(peer ServerId) {
+    this.mu.Lock()
+    var failed []rawReply
+    for id, reply := range this.replies {
+        if reply.to == peer {
+            failed = append(failed, reply)
+            delete(this.replies, id)
+        }
+    }
+    this.mu.Unlock()
+
+    for _, reply := range failed {
+        reply.reply(Message{}, ErrUnreachable)
+    }
+    this.traced(traceEvent{Type: traceUnreachable, Peer: peer})
+}
+
+// HasReady reports whether Ready has work to hand out.
+func (this *RawNode) HasReady() bool {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    ready := this.ready
+    return !this.inflight && (ready.HardState != nil || ready.Truncate != 0 || ready.Compact != 0 ||
+        len(ready.Entries) > 0 || len(ready.Messages) > 0 || len(ready.CommittedEntries) > 0)
+}
+
+// Ready returns the work accumulated since the previous Ready, which
+// must have been acknowledged with Advance; until then it returns an
+// empty Ready.
+func (this *RawNode) Ready() Ready {
+    ready := this.takeReady()
+    this.tracedReady(ready)
+    return ready
+}
+
+func (this *RawNode) takeReady() Ready {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    if this.inflight {
+        return Ready{}
+    }
+    ready := this.ready
+    this.ready = Ready{}
+    this.inflight = true
+    if len(ready.Entries) > 0 {
+        this.handed = ready.Entries[len(ready.Entries)-1].Index
+    }
+    return ready
+}
+
+// Advance acknowledges that the last Ready has been persisted, sent
+// and applied.
+func (this *RawNode) Advance() {
+    this.mu.Lock()
+    this.inflight = false
+    this.mu.Unlock()
+    this.traced(traceEvent{Type: traceAdvance})
+}
+
+// send queues msg, sent by the node, for the next Ready. Called
+// with the node's lock held.
+func (this *RawNode) send(msg Message, reply func(Message, error)) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    this.nextId++
+    msg.Id = this.nextId
+    this.replies[msg.Id] = rawReply{to: msg.To, reply: reply}
+    this.ready.Messages = append(this.ready.Messages, msg)
+}
+
+// commit queues a committed entry for the next Ready. Called with
+// the node's lock held.
+func (this *RawNode) commit(entry Entry) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    this.ready.CommittedEntries = append(this.ready.CommittedEntries, entry)
+}
+
+// dropFrom removes the entries from index on from the Ready being
+// built, and has the caller delete those already handed out. Must
+// be called with this.mu held.
+func (this *RawNode) dropFrom(index int) {
+    entries := this.ready.Entries
+    for len(entries) > 0 && entries[len(entries)-1].Index >= index {
+        entries = entries[:len(entries)-1]
+    }
+    this.ready.Entries = entries
+    if index <= this.handed {
+        if this.ready.Truncate == 0 || index < this.ready.Truncate {
+            this.ready.Truncate = index
+        }
+        this.handed = index - 1
+    }
+}
+
+// rawLogStore records a RawNode's log writes in its Ready.
+type rawLogStore struct {
+    raw *RawNode
+}
+
+func (this rawLogStore) FirstIndex() (int, error) {
+    this.raw.mu.Lock()
+    defer this.raw.mu.Unlock()
+    return this.raw.first, nil
+}
+
+func (this rawLogStore) LastIndex() (int, error) {
+    this.raw.mu.Lock()
+    defer this.raw.mu.Unlock()
+    return this.raw.last, nil
+}
+
+// GetLog only finds entries not yet handed out: the node keeps its
+// log in memory and does not read it back.
+func (this rawLogStore) GetLog(index int) (Entry, error) {
+    this.raw.mu.Lock()
+    defer this.raw.mu.Unlock()
+    for _, entry := range this.raw.ready.Entries {
+        if entry.Index == index {
+            return entry, nil
+        }
+    }
+    return Entry{}, ErrNotFound
+}
+
+func (this rawLogStore) StoreLogs(entries []Entry) error {
+    if len(entries) == 0 {
+        return nil
+    }
+    raw := this.raw
+    raw.mu.Lock()
+    defer raw.mu.Unlock()
+
+    raw.dropFrom(entries[0].Index)
+    raw.ready.Entries = append(raw.ready.Entries, entries...)
+    if raw.first == 0 {
+        raw.first = entries[0].Index
+    }
+    raw.last = entries[len(entries)-1].Index
+    return nil
+}
+
+func (this rawLogStore) DeleteRange(min, max int) error {
+    raw := this.raw
+    raw.mu.Lock()
+    defer raw.mu.Unlock()
+
+    if max >= raw.last {
+        raw.dropFrom(min)
+        raw.last = min - 1
+        if raw.last < raw.first {
+            raw.first, raw.last = 0, 0
+        }
+        return nil
+    }
+    raw.ready.Compact = maxInt(raw.ready.Compact, max)
+    raw.first = max + 1
+    entries := raw.ready.Entries
+    for len(entries) > 0 && entries[0].Index <= max {
+        entries = entries[1:]
+    }
+    raw.ready.Entries = entries
+    return nil
+}
+
+// rawStableStore records a RawNode's term and vote in its Ready.
+type rawStableStore struct {
+    raw *RawNode
+}
+
+func (this rawStableStore) Set(key string, value []byte) error {
+    if key != keyVotedFor {
+        return nil
+    }
+    raw := this.raw
+    raw.mu.Lock()
+    defer raw.mu.Unlock()
+
+    if raw.ready.HardState == nil {
+        raw.ready.HardState = &HardState{}
+    }
+    raw.ready.HardState.VotedFor = ServerId(value)
+    return nil
+}
+
+func (this rawStableStore) Get(key string) ([]byte, error) {
+    raw := this.raw
+    raw.mu.Lock()
+    defer raw.mu.Unlock()
+
+    if key != keyVotedFor || raw.ready.HardState == nil {
+        return nil, ErrNotFound
+    }
+    return []byte(raw.ready.HardState.VotedFor), nil
+}
+
+func (this rawStableStore) SetInt(key string, value int) error {
+    raw := this.raw
+    raw.mu.Lock()
+    defer raw.mu.Unlock()
+
+    if raw.ready.HardState == nil {
+        raw.ready.HardState = &HardState{}
+    }
+    if key == keyCurrentTerm {
+        raw.ready.HardState.Term = value
+    }
+    return nil
+}
+
+func (this rawStableStore) GetInt(key string) (int, error) {
+    raw := this.raw
+    raw.mu.Lock()
+    defer raw.mu.Unlock()
+
+    if raw.ready.HardState == nil {
+        return 0, ErrNotFound
+    }
+    if key == keyCurrentTerm {
+        return raw.ready.HardState.Term, nil
+    }
+    return 0, ErrNotFound
+}
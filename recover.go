@@ -0,0 +1,120 @@
+package raft
+
+import (
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+)
+
+// keyRecoveredConfiguration is the StableStore key under which
+// RecoverCluster leaves the configuration a node starts from.
+const keyRecoveredConfiguration = "RecoveredConfiguration"
+
+// RecoverCluster is the manual escape hatch for a cluster that has
+// permanently lost a majority of its servers, and with it the
+// ability to elect a leader or change its membership. With every
+// surviving server stopped, it is run on the stores of each with
+// the same configuration, which the server then starts from in
+// place of the one in its log, whatever peers NewNode is given.
+// Once the survivors are started again they elect a leader among
+// the new members.
+//
+// The configuration takes effect at the last index of the log, as
+// if it had been committed there, so no entry is invented that
+// could conflict with the logs of the other survivors. Entries that
+// had not reached a majority may be lost, or committed after all:
+// recovery trades safety for availability, and is only for when
+// the lost servers are never coming back.
+func RecoverCluster(logs LogStore, stable StableStore, configuration Configuration) error {
+    servers := append([]Server(nil), configuration.Servers...)
+    if err := checkServers(servers); err != nil {
+        return err
+    }
+    last, err := logs.LastIndex()
+    if err != nil {
+        return err
+    }
+    term := 0
+    if last > 0 {
+        entry, err := logs.GetLog(last)
+        if err != nil {
+            return err
+        }
+        term = entry.TermNum
+    }
+
+    return storeRecoveredConfiguration(stable, Configuration{Servers: servers, Index: last, Term: term})
+}
+
+// storeRecoveredConfiguration leaves configuration in stable, for
+// the node to start from, taking effect at its index and term.
+func storeRecoveredConfiguration(stable StableStore, configuration Configuration) error {
+    buf := binary.AppendUvarint(nil, uint64(configuration.Index))
+    buf = binary.AppendUvarint(buf, uint64(configuration.Term))
+    return stable.Set(keyRecoveredConfiguration, append(buf, encodeMembers(configuration)...))
+}
+
+// recoveredConfiguration returns the configuration RecoverCluster
+// left in stable, if any.
+func recoveredConfiguration(stable StableStore) (Configuration, bool, error) {
+    buf, err := stable.Get(keyRecoveredConfiguration)
+    if errors.Is(err, ErrNotFound) {
+        return Configuration{}, false, nil
+    }
+    if err != nil {
+        return Configuration{}, false, err
+    }
+    index, n := binary.Uvarint(buf)
+    if n <= 0 {
+        return Configuration{}, false, errCorruptConfiguration
+    }
+    buf = buf[n:]
+    term, n := binary.Uvarint(buf)
+    if n <= 0 {
+        return Configuration{}, false, errCorruptConfiguration
+    }
+    configuration, err := decodeMembers(buf[n:])
+    if err != nil {
+        return Configuration{}, false, err
+    }
+    configuration.Index, configuration.Term = int(index), int(term)
+    return configuration, true, nil
+}
+
+// ReadPeersJSON reads a configuration for RecoverCluster from a file
+// in the peers.json format of hashicorp/raft:
+//
+//    [
+//        {"id": "a", "address": "10.0.0.1:8300"},
+//        {"id": "b", "address": "10.0.0.2:8300"}
+//    ]
+//
+// Non-voters, marked "non_voter": true, are read-only replicas.
+func ReadPeersJSON(path string) (Configuration, error) {
+    buf, err := os.ReadFile(path)
+    if err != nil {
+        return Configuration{}, err
+    }
+    var peers []struct {
+        Id       string `json:"id"`
+        Address  string `json:"address"`
+        NonVoter bool   `json:"non_voter"`
+    }
+    if err := json.Unmarshal(buf, &peers); err != nil {
+        return Configuration{}, fmt.Errorf("%w: %s: %v", ErrInvalidConfig, path, err)
+    }
+    var configuration Configuration
+    for _, peer := range peers {
+        configuration.Servers = append(configuration.Servers, Server{
+            Id:      ServerId(peer.Id),
+            Address: ServerAddress(peer.Address),
+            Replica: peer.NonVoter,
+        })
+    }
+    if err := checkServers(configuration.Servers); err != nil {
+        return Configuration{}, err
+    }
+    return configuration, nil
+}
@@ -0,0 +1,201 @@
+package raft
+
+import (
+    "fmt"
+    "sync"
+)
+
+// Registry lets nodes running in the same process find each other by
+// ID, the way a deployment's nodes find each other by address. Each
+// node is created with the Transport the registry hands out for its
+// ID, and joins once registered; a node that is shut down leaves.
+// It serves tests, demos and embedding several nodes in one binary.
+//
+//    registry := raft.NewRegistry()
+//    for _, id := range []raft.ServerId{"a", "b", "c"} {
+//        node, err := registry.NewNode(id, others(id), apply)
+//        ...
+//    }
+type Registry struct {
+    mu    sync.Mutex
+    nodes map[ServerId]*Node
+
+    // Decides whether a message gets from one node to another; see
+    // SetLinkFilter.
+    filter func(from, to ServerId) error
+
+    // Deliveries in progress, which Close waits for.
+    deliveries sync.WaitGroup
+}
+
+func NewRegistry() *Registry {
+    return &Registry{nodes: make(map[ServerId]*Node)}
+}
+
+// NewNode creates a node with NewNode, using the registry's
+// transport, and registers it.
+func (this *Registry) NewNode(id ServerId, peers []ServerId, statemachine func(command []byte), options ...Option) (*Node, error) {
+    options = append(options, WithTransport(this.Transport(id)))
+    node, err := NewNode(id, peers, statemachine, options...)
+    if err != nil {
+        return nil, err
+    }
+    if err := this.Register(node); err != nil {
+        return nil, err
+    }
+    return node, nil
+}
+
+// Register makes node reachable by its ID. It fails if another node
+// with the same ID is registered.
+func (this *Registry) Register(node *Node) error {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    if _, ok := this.nodes[node.id]; ok {
+        return fmt.Errorf("%w: node %q is already registered", ErrInvalidConfig, node.id)
+    }
+    this.nodes[node.id] = node
+    return nil
+}
+
+// Deregister makes the node with the given ID unreachable.
+func (this *Registry) Deregister(id ServerId) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    delete(this.nodes, id)
+}
+
+// deregisterShutdown deregisters the node with the given ID if it
+// has been shut down, leaving in place a node that was registered
+// with the same ID after it, such as one restarted over the same
+// stores.
+func (this *Registry) deregisterShutdown(id ServerId) {
+    node := this.Node(id)
+    if node == nil {
+        return
+    }
+    node.mu.Lock()
+    shutdown := node.shutdown
+    node.mu.Unlock()
+    if !shutdown {
+        return
+    }
+
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    if this.nodes[id] == node {
+        delete(this.nodes, id)
+    }
+}
+
+// SetLinkFilter has every message, and every response, checked by
+// filter before it is delivered: if filter returns an error, the
+// message is lost and the sender's RPC fails with the error. It
+// lets tests partition the nodes; nil delivers everything.
+func (this *Registry) SetLinkFilter(filter func(from, to ServerId) error) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    this.filter = filter
+}
+
+// checkLink returns the error the link filter reports for messages
+// from one node to another, if any.
+func (this *Registry) checkLink(from, to ServerId) error {
+    this.mu.Lock()
+    filter := this.filter
+    this.mu.Unlock()
+    if filter == nil {
+        return nil
+    }
+    return filter(from, to)
+}
+
+// Close waits for the messages in flight to be delivered, and their
+// responses returned. The registry remains usable.
+func (this *Registry) Close() error {
+    this.deliveries.Wait()
+    return nil
+}
+
+// Node returns the registered node with the given ID, or nil.
+func (this *Registry) Node(id ServerId) *Node {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    return this.nodes[id]
+}
+
+// Transport returns the transport for the node with ID from, which
+// reaches the other nodes registered here.
+func (this *Registry) Transport(from ServerId) Transport {
+    return registryTransport{
+        messageTransport: messageTransport{from: from, send: this.send},
+        registry:         this,
+    }
+}
+
+// send delivers msg to the Step of its receiver on a goroutine of its
+// own, which Shutdown of the sender and Close wait for. A node that
+// has been shut down or paused is unreachable.
+func (this *Registry) send(msg Message, reply func(Message, error)) {
+    deliver := func() {
+        peer := this.Node(msg.To)
+        switch {
+        case peer == nil:
+            reply(Message{}, fmt.Errorf("%w: %q", ErrUnknownPeer, msg.To))
+        case isClosed(peer.closed):
+            reply(Message{}, fmt.Errorf("%w: %q", ErrRaftShutdown, msg.To))
+        case peer.Paused():
+            reply(Message{}, fmt.Errorf("%w: %q is paused", ErrInjectedFault, msg.To))
+        default:
+            if err := this.checkLink(msg.From, msg.To); err != nil {
+                reply(Message{}, err)
+                return
+            }
+            resp, err := peer.Step(msg)
+            if linkErr := this.checkLink(msg.To, msg.From); linkErr != nil {
+                resp, err = Message{}, linkErr
+            }
+            reply(resp, err)
+        }
+    }
+
+    this.deliveries.Add(1)
+    sender := this.Node(msg.From)
+    if sender == nil {
+        go func() {
+            defer this.deliveries.Done()
+            deliver()
+        }()
+        return
+    }
+    sender.rpcs.Add(1)
+    go func() {
+        defer sender.rpcs.Done()
+        defer this.deliveries.Done()
+        deliver()
+    }()
+}
+
+// registryTransport carries the RPCs of one node to the nodes
+// registered alongside it.
+type registryTransport struct {
+    messageTransport
+    registry *Registry
+}
+
+// Close removes the sending node from the registry once it has been
+// shut down, unless a node with the same ID has taken its place.
+func (this registryTransport) Close() error {
+    this.registry.deregisterShutdown(this.from)
+    return nil
+}
+
+func isClosed(ch <-chan struct{}) bool {
+    select {
+    case <-ch:
+        return true
+    default:
+        return false
+    }
+}
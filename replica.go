@@ -0,0 +1,33 @@
+package raft
+
+import "errors"
+
+// ErrReplica is returned for asking a read-only replica to stand for
+// election or take over leadership.
+var ErrReplica = errors.New("raft: node is a read-only replica")
+
+// A read-only replica, added with AddReplica, is a member marked
+// Server.Replica in the configuration. The leader replicates the log
+// and snapshots to it as to any follower, and it applies them to its
+// state machine, but it never votes or stands for election, and it
+// counts toward no quorum, so that stale reads and analytics can be
+// scaled out with replicas without slowing down commits or putting
+// write availability at risk. A replica is promoted with AddVoter,
+// and a voter demoted with AddReplica.
+//
+// Replicas serve reads the way followers do: CheckStaleRead and
+// Staleness bound how far behind the leader they are, and
+// WaitForAppliedIndex lets a client read its own writes.
+
+// Replica reports whether the node is a read-only replica of the
+// cluster it follows.
+func (this *Node) Replica() bool {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    return this.isReplica()
+}
+
+// isReplica is Replica. Must be called with this.mu held.
+func (this *Node) isReplica() bool {
+    return this.isMember() && !this.isVoter(this.id)
+}
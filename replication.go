@@ -0,0 +1,482 @@
+package raft
+
+import (
+    "context"
+    "sort"
+    "time"
+)
+
+// Propose queues command for appending to the leader's log. The
+// returned future resolves once the command has been applied, with
+// the result and error Config.Apply returned for it, or
+// immediately with a *NotLeaderError if this node is not the
+// leader, unless Config.ForwardProposals is set and the leader is
+// known. If leadership is lost before the command is applied, the
+// future fails with a *LeadershipLostError naming the new leader if
+// known, which tells whether the command had been appended.
+//
+// If ctx is done first, the future fails with its error: a command
+// still queued is dropped, failing with ErrEnqueueTimeout if the
+// deadline of ctx passed, while one already appended may still be
+// applied.
+//
+// Queued proposals are appended together, up to the limits set by
+// Config.Batch, and each follower receives them in a single
+// AppendEntries per round trip.
+func (this *Node) Propose(ctx context.Context, command []byte) *ProposeFuture {
+    return this.ProposeWithPriority(ctx, command, 0)
+}
+
+// ProposeWithPriority is Propose for a command with the given
+// priority. Priorities only change the order in which commands are
+// applied if Config.ReorderApply is set; otherwise commands are
+// applied in log order whatever their priority.
+func (this *Node) ProposeWithPriority(ctx context.Context, command []byte, priority int) *ProposeFuture {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    future := newProposeFuture(EntryNormal, command)
+    future.priority = priority
+    this.propose(ctx, future)
+    return future
+}
+
+// Barrier returns a future that resolves once this node, which must
+// be the leader, has applied every entry committed before the call.
+// Reads of the state machine made after that see every write that
+// was acknowledged before Barrier was called. The barrier is an
+// entry queued behind the proposals already waiting; if it has not
+// been appended to the log within timeout, the future fails with
+// ErrEnqueueTimeout. A zero timeout waits as long as it takes.
+//
+// Deprecated: Use BarrierContext, which also stops waiting when its
+// context is canceled.
+func (this *Node) Barrier(timeout time.Duration) *ProposeFuture {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    future := newProposeFuture(EntryBarrier, nil)
+    if timeout > 0 {
+        future.deadline = this.clock.Now().Add(timeout)
+    }
+    this.enqueue(future)
+    return future
+}
+
+// BarrierContext is Barrier bounded by ctx instead of a timeout: if
+// ctx is done before the barrier resolves, the future fails as a
+// proposal's does.
+func (this *Node) BarrierContext(ctx context.Context) *ProposeFuture {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    future := newProposeFuture(EntryBarrier, nil)
+    this.propose(ctx, future)
+    return future
+}
+
+// propose enqueues future's entry, failing the future if ctx is done
+// first. Must be called with this.mu held.
+func (this *Node) propose(ctx context.Context, future *ProposeFuture) {
+    this.watchProposal(ctx, future)
+    if !isClosed(future.done) {
+        this.enqueue(future)
+    }
+}
+
+// enqueue queues future's entry for appending to the log, or fails
+// it if this node cannot append it. Must be called with this.mu
+// held.
+func (this *Node) enqueue(future *ProposeFuture) {
+    command := future.command
+    if this.shutdown {
+        future.respond(ErrRaftShutdown)
+        return
+    }
+    if this.draining {
+        future.respond(this.drainingError())
+        return
+    }
+    if this.nodeType != Leader {
+        if this.config.ForwardProposals && this.leaderId != "" && future.entryType != EntryBarrier {
+            this.forward(future)
+            return
+        }
+        future.respond(this.notLeader())
+        return
+    }
+    if this.leaderTransfer != nil {
+        future.respond(ErrLeadershipTransferInProgress)
+        return
+    }
+    if this.standby() && future.entryType == EntryNormal && future.clientId != MirrorClientId {
+        future.respond(ErrStandby)
+        return
+    }
+    if entrySize(Entry{Command: command})+appendEntriesOverhead > this.config.MaxMessageSize {
+        future.respond(ErrCommandTooLarge)
+        return
+    }
+
+    this.proposals = append(this.proposals, future)
+    this.reschedule()
+    this.proposalBytes += len(command)
+    if this.batchFull() {
+        this.flushProposals()
+    }
+}
+
+// DefaultMaxAppendEntriesBytes is the size of the entries a leader
+// sends in one AppendEntries.
+const DefaultMaxAppendEntriesBytes = 1 << 20
+
+// DefaultMaxInflight is the number of AppendEntries a leader keeps
+// in flight to a follower that is accepting its entries.
+const DefaultMaxInflight = 8
+
+// DefaultMaxInflightBytes is the size of the entries a leader keeps
+// in flight to a follower that is accepting its entries.
+const DefaultMaxInflightBytes = 16 << 20
+
+// windowOpen reports whether the entries in flight to the peer at
+// position i leave room for more. Must be called with this.mu held.
+func (this *Node) windowOpen(i int) bool {
+    return this.config.MaxInflightBytes == 0 || this.inflightBytes[i] < this.config.MaxInflightBytes
+}
+
+// broadcastAppendEntries sends every peer the entries it is missing,
+// or an empty heartbeat if it is up to date and has nothing in
+// flight, unless the node has stepped down. Must be called with
+// this.mu held.
+func (this *Node) broadcastAppendEntries() {
+    if this.nodeType != Leader {
+        return
+    }
+    for i, peer := range this.peers {
+        if peer == this.id {
+            continue
+        }
+        this.replicateTo(i, true)
+    }
+}
+
+// replicateTo sends the peer at position i whatever its in-flight
+// window allows. A peer being probed after a rejection, or not yet
+// known to match the leader's log, gets one request at a time; a
+// peer in pipeline mode is sent new entries without waiting for
+// earlier replies, up to Config.MaxInflight requests holding up to
+// Config.MaxInflightBytes of entries: a slow peer holds back its own
+// replication, not that of the others. An idle peer is sent
+// a heartbeat if heartbeat is set. Entries are only sent once a
+// handshake has settled the peer's message size. Must be called
+// with this.mu held.
+//
+// A peer that failed to answer is left alone until its backoff
+// has elapsed.
+func (this *Node) replicateTo(i int, heartbeat bool) {
+    if this.backoff[i] > 0 {
+        return
+    }
+    if _, ok := this.messageLimit(this.peers[i]); !ok {
+        this.sendHandshake(this.peers[i])
+        // A peer sharing no protocol version with this node would
+        // refuse even heartbeats.
+        if this.inflight[i] == 0 && heartbeat && this.protocolVersions[this.peers[i]] >= 0 {
+            this.sendAppendEntries(i)
+        }
+        return
+    }
+
+    hasEntries := func() bool {
+        return this.nextIndex[i] <= this.lastLogIndex()
+    }
+    if this.inflight[i] == 0 && (heartbeat || hasEntries()) {
+        if !this.sendAppendEntries(i) {
+            return
+        }
+    }
+    for this.pipeline[i] && this.inflight[i] < this.config.MaxInflight && this.windowOpen(i) && hasEntries() {
+        if !this.sendAppendEntries(i) {
+            return
+        }
+    }
+}
+
+// sendAppendEntries sends the peer at position i as many entries
+// from its nextIndex onwards as fit in one message, or none if its
+// message size is not yet known. In pipeline mode nextIndex
+// optimistically moves past them. Must be called with this.mu held;
+// the RPC itself runs on its own goroutine. It reports whether a
+// request was sent.
+func (this *Node) sendAppendEntries(i int) bool {
+    // Entries the peer needs have been compacted away.
+    if this.nextIndex[i] <= this.log[0].Index {
+        return this.sendInstallSnapshot(i)
+    }
+
+    prevLogIndex := this.nextIndex[i] - 1
+    prevLogTerm, _ := this.termAt(prevLogIndex)
+    req := AppendEntriesRequest{
+        Term:         this.currentTerm,
+        LeaderId:     this.id,
+        PrevLogIndex: prevLogIndex,
+        PrevLogTerm:  prevLogTerm,
+        LeaderCommit: this.commitIndex,
+    }
+    if limit, ok := this.messageLimit(this.peers[i]); ok {
+        if max := this.config.MaxAppendEntriesBytes; max > 0 {
+            limit = minInt(limit, appendEntriesOverhead+max)
+        }
+        size := appendEntriesOverhead
+        witness := this.witnesses[this.peers[i]]
+        for index := prevLogIndex + 1; index <= this.lastLogIndex(); index++ {
+            entry, ok := this.entryAt(index)
+            if !ok {
+                break
+            }
+            if witness {
+                entry = witnessEntry(entry)
+            }
+            size += entrySize(entry)
+
+            // Always send at least one entry, or a follower
+            // could never get past it.
+            if size > limit && len(req.Entries) > 0 {
+                break
+            }
+            if max := this.config.MaxAppendEntries; max > 0 && len(req.Entries) == max {
+                break
+            }
+            req.Entries = append(req.Entries, entry)
+        }
+    }
+
+    epoch := this.epoch[i]
+    this.inflight[i]++
+    this.inflightBytes[i] += entriesSize(req.Entries)
+    if this.pipeline[i] {
+        this.nextIndex[i] = prevLogIndex + len(req.Entries) + 1
+    }
+
+    sent := this.clock.Now()
+    peerId := this.peers[i]
+    this.transport.AppendEntries(peerId, req, func(resp AppendEntriesResponse, err error) {
+        this.handleAppendEntriesReply(peerId, epoch, sent, req, resp, err)
+    })
+    return true
+}
+
+// handleAppendEntriesReply updates the replication state of peer
+// peerId after req, sent in the given replication epoch, was
+// answered or failed. Replies may arrive in any order, and after the
+// peer has left the cluster.
+func (this *Node) handleAppendEntriesReply(
+    peerId ServerId,
+    epoch int,
+    sent time.Time,
+    req AppendEntriesRequest,
+    resp AppendEntriesResponse,
+    err error) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    if this.shutdown {
+        return
+    }
+    if err == nil {
+        if this.config.TLATracer != nil {
+            resp := appendEntriesResponseTLA(peerId, this.id, req, resp.Term, resp.Success)
+            this.traceTLA("ReceiveAppendEntriesResponse", resp)
+        }
+        this.observeDuration(MetricAppendLatency,
+            this.labels(LabelPeer, string(peerId)), sent)
+        this.testToAbdicateLeadership(resp.Term, "")
+    } else {
+        this.logDebug("AppendEntries failed", "peer", peerId, "err", err)
+    }
+
+    // Ignore replies that arrive after we lost leadership, or
+    // from peers that have left.
+    if this.nodeType != Leader || this.currentTerm != req.Term {
+        return
+    }
+    i, ok := this.position(peerId)
+    if !ok {
+        return
+    }
+    this.observeReply(i, err)
+
+    // Requests sent before the last fallback to probing no
+    // longer count against the window.
+    current := epoch == this.epoch[i]
+    if current && this.inflight[i] > 0 {
+        this.inflight[i]--
+        this.inflightBytes[i] = maxInt(this.inflightBytes[i]-entriesSize(req.Entries), 0)
+    }
+
+    // Undelivered requests are resent by the next heartbeat.
+    if err != nil {
+        return
+    }
+
+    // If successful: update nextIndex and matchIndex for
+    // follower. If AppendEntries fails because of log
+    // inconsistency: decrement nextIndex and retry
+    // (see §5.3 of the raft paper).
+    if resp.Success {
+        matchIndex := req.PrevLogIndex + len(req.Entries)
+        this.matchIndex[i] = maxInt(this.matchIndex[i], matchIndex)
+        this.nextIndex[i] = maxInt(this.nextIndex[i], matchIndex+1)
+        if current {
+            this.pipeline[i] = true
+        }
+        this.advanceCommitIndex()
+
+        // Committing may have changed the membership.
+        if i, ok = this.position(peerId); ok {
+            this.replicateTo(i, false)
+        }
+        this.continueLeaderTransfer()
+        return
+    }
+
+    // A rejection of a stale request, or of entries the peer has
+    // since acknowledged, says nothing about its log.
+    if !current || req.PrevLogIndex < this.matchIndex[i] {
+        return
+    }
+
+    // Fall back to probing one request at a time, starting just
+    // before the rejected entries.
+    this.logDebug("peer rejected entries", "peer", peerId,
+        "prevLogIndex", req.PrevLogIndex, "prevLogTerm", req.PrevLogTerm)
+    this.epoch[i]++
+    this.inflight[i] = 0
+    this.inflightBytes[i] = 0
+    this.pipeline[i] = false
+    if req.PrevLogIndex > 0 {
+        this.nextIndex[i] = req.PrevLogIndex
+    }
+    this.replicateTo(i, true)
+}
+
+// advanceCommitIndex commits the highest entry from the current term
+// that is stored on a majority of servers, or as many as
+// Config.CommitQuorum requires, and applies everything up to it.
+// Must be called with this.mu held.
+//
+// If there exists an N such that N > commitIndex, a majority
+// of matchIndex[i] ≥ N, and log[N].term == currentTerm:
+// set commitIndex = N (see §5.3 and §5.4 of the raft paper).
+func (this *Node) advanceCommitIndex() {
+    for n := this.lastLogIndex(); n > this.commitIndex; n-- {
+        if term, _ := this.termAt(n); term != this.currentTerm {
+            break
+        }
+
+        stored := func(id ServerId) bool {
+            if id == this.id {
+                return true
+            }
+            i, ok := this.position(id)
+            return ok && this.matchIndex[i] >= n
+        }
+        if this.hasQuorumOf(stored, this.commitQuorum) {
+            this.commitIndex = n
+            this.traceTLA("Commit", nil)
+            this.applyCommitted()
+            this.advanceMembership()
+            return
+        }
+    }
+}
+
+// applyCommitted applies every committed entry not yet applied to
+// the state machine, in log order unless Config.ReorderApply is
+// set, or with Config.ApplyQueue queues them for the applier. Must
+// be called with this.mu held.
+//
+// If commitIndex > lastApplied: increment lastApplied, apply
+// log[lastApplied] to state machine (see §5.3 of the raft paper).
+func (this *Node) applyCommitted() {
+    this.notifyCommitted()
+    if this.applyQueue != nil {
+        this.dispatchCommitted()
+        return
+    }
+    batch := this.admitCommitted(this.commitIndex)
+    this.applyCommands(batch)
+    this.finishApplied(batch)
+}
+
+// admitCommitted admits the committed entries after those already
+// admitted, up to and including index. Must be called with this.mu
+// held.
+func (this *Node) admitCommitted(index int) []appliedEntry {
+    var batch []appliedEntry
+    for ; this.admitted < index; this.admitted++ {
+        // An entry that cannot be read waits, with those after
+        // it, for a later commit to try again.
+        entry, ok := this.entryAt(this.admitted + 1)
+        if !ok {
+            break
+        }
+        batch = append(batch, this.admit(entry))
+    }
+    if this.config.OnCommit != nil {
+        for _, applied := range batch {
+            this.config.OnCommit(applied.entry)
+        }
+    }
+    return batch
+}
+
+// finishApplied records that the state machine has applied batch,
+// and resolves the proposals it holds. Must be called with this.mu
+// held.
+func (this *Node) finishApplied(batch []appliedEntry) {
+    for _, applied := range batch {
+        entry := applied.entry
+        this.lastApplied = entry.Index
+        this.recordChecksum(entry)
+
+        if future, ok := this.pending[entry.Index]; ok {
+            delete(this.pending, entry.Index)
+            if future.term == entry.TermNum {
+                future.index, future.term = applied.index, applied.term
+                future.result = applied.result
+                future.respond(applied.err)
+            } else {
+                // A later leader replaced the proposed entry.
+                future.respond(ErrLeadershipLostWhileCommitting)
+            }
+        }
+    }
+    this.notifyApplied()
+    this.notifyWatches(batch)
+    this.maybeSnapshot()
+    this.serveSnapshotRequests()
+    this.continueRestore()
+    this.reportLogGauges()
+}
+
+// applyCommands passes the commands admitted in batch to the state
+// machine, in log order or, with Config.ReorderApply, highest
+// priority first, recording their results in batch. Must be called
+// with this.mu held, unless called by the applier.
+func (this *Node) applyCommands(batch []appliedEntry) {
+    var commands []int
+    for i, applied := range batch {
+        if applied.apply {
+            commands = append(commands, i)
+        }
+    }
+    if this.config.ReorderApply {
+        sort.SliceStable(commands, func(i, j int) bool {
+            return batch[commands[i]].entry.Priority > batch[commands[j]].entry.Priority
+        })
+    }
+    for _, i := range commands {
+        batch[i].result, batch[i].err = this.stateMachine(batch[i].entry.Command)
+    }
+}
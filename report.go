@@ -0,0 +1,145 @@
+package raft
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// StartupReport describes how a node was configured and what state
+// it holds when it starts, so that misconfiguration shows up in the
+// logs straight away rather than after the first failed election.
+type StartupReport struct {
+    Id        ServerId
+    StartedAt time.Time
+
+    // The effective configuration, with defaults applied.
+    Config Config
+
+    // Descriptions of the collaborators. A store or transport that
+    // implements fmt.Stringer describes itself, for instance with
+    // its path and format version; others are named by their type.
+    LogStore    string
+    StableStore string
+    Transport   string
+
+    // Persistent state.
+    Term     int
+    VotedFor ServerId
+
+    // The log, and the snapshot it was compacted into if any.
+    LastLogIndex  int
+    LastLogTerm   int
+    CommitIndex   int
+    SnapshotIndex int
+    SnapshotTerm  int
+    SnapshotSize  int
+
+    // IDs of every member of the cluster, this node included unless
+    // it has yet to join, and the checksum of the configuration.
+    Members               []ServerId
+    ConfigurationChecksum uint64
+
+    // Suspicious settings found in the configuration.
+    Warnings []string
+}
+
+// String formats the report on a single line.
+func (this *StartupReport) String() string {
+    var b strings.Builder
+    fmt.Fprintf(&b, "node %s: members=%v configuration=%x term=%d votedFor=%s",
+        this.Id, this.Members, this.ConfigurationChecksum, this.Term, this.VotedFor)
+    fmt.Fprintf(&b, " log=%d@%d commit=%d", this.LastLogIndex, this.LastLogTerm, this.CommitIndex)
+    if this.SnapshotIndex > 0 {
+        fmt.Fprintf(&b, " snapshot=%d@%d (%d bytes)", this.SnapshotIndex, this.SnapshotTerm, this.SnapshotSize)
+    }
+    fmt.Fprintf(&b, " logStore=%s stableStore=%s transport=%s", this.LogStore, this.StableStore, this.Transport)
+    fmt.Fprintf(&b, " tick=%v heartbeat=%d election=%d-%d",
+        this.Config.TickInterval, this.Config.HeartbeatTicks,
+        this.Config.ElectionTicksMin, this.Config.ElectionTicksMax)
+    fmt.Fprintf(&b, " protocol=%d-%d", this.Config.MinProtocolVersion, this.Config.MaxProtocolVersion)
+    for _, warning := range this.Warnings {
+        fmt.Fprintf(&b, " warning=%q", warning)
+    }
+    return b.String()
+}
+
+// StartupReport returns the report made when the node was last
+// started, or nil if Start has not been called.
+func (this *Node) StartupReport() *StartupReport {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    return this.startupReport
+}
+
+// reportStartup builds and logs the startup report. Must be called
+// with this.mu held.
+func (this *Node) reportStartup() {
+    report := &StartupReport{
+        Id:            this.id,
+        StartedAt:     this.clock.Now(),
+        Config:        this.config,
+        LogStore:      describe(this.logs),
+        StableStore:   describe(this.stable),
+        Transport:     describe(this.config.Transport),
+        Term:          this.currentTerm,
+        VotedFor:      this.votedFor,
+        LastLogIndex:  this.lastLogIndex(),
+        LastLogTerm:   this.lastLogTerm(),
+        CommitIndex:   this.commitIndex,
+        SnapshotIndex: this.log[0].Index,
+        SnapshotTerm:  this.log[0].TermNum,
+        SnapshotSize:  len(this.snapshot),
+    }
+
+    report.Members = append([]ServerId(nil), this.peers...)
+    report.ConfigurationChecksum = this.configuration().Checksum()
+    if len(report.Members) == 0 {
+        report.Warnings = append(report.Warnings,
+            "no configuration yet, so the node waits to be bootstrapped or to join a cluster")
+    } else if len(report.Members)%2 == 0 {
+        report.Warnings = append(report.Warnings,
+            fmt.Sprintf("an even number of members (%d) tolerates no more failures than one fewer", len(report.Members)))
+    }
+    if this.config.ElectionTicksMin == this.config.ElectionTicksMax {
+        report.Warnings = append(report.Warnings,
+            "election timeouts are not randomized, so split votes may repeat")
+    }
+    if this.config.Snapshotter != nil && this.config.SnapshotThreshold == 0 {
+        report.Warnings = append(report.Warnings,
+            "a Snapshotter is set but SnapshotThreshold is zero, so the log is compacted after every entry")
+    }
+    this.startupReport = report
+
+    this.logInfo("starting",
+        "members", report.Members,
+        "configurationChecksum", strconv.FormatUint(report.ConfigurationChecksum, 16),
+        "term", report.Term,
+        "votedFor", report.VotedFor,
+        "lastLogIndex", report.LastLogIndex,
+        "lastLogTerm", report.LastLogTerm,
+        "snapshotIndex", report.SnapshotIndex,
+        "snapshotTerm", report.SnapshotTerm,
+        "logStore", report.LogStore,
+        "stableStore", report.StableStore,
+        "transport", report.Transport,
+        "tickInterval", this.config.TickInterval,
+        "heartbeatTicks", this.config.HeartbeatTicks,
+        "electionTicksMin", this.config.ElectionTicksMin,
+        "electionTicksMax", this.config.ElectionTicksMax,
+        "maxAppendEntriesBytes", this.config.MaxAppendEntriesBytes,
+        "maxMessageSize", this.config.MaxMessageSize,
+        "snapshotThreshold", this.config.SnapshotThreshold)
+    for _, warning := range report.Warnings {
+        this.logWarn("suspicious configuration", "warning", warning)
+    }
+}
+
+// describe names a store or transport for the startup report.
+func describe(component any) string {
+    if stringer, ok := component.(fmt.Stringer); ok {
+        return stringer.String()
+    }
+    return fmt.Sprintf("%T", component)
+}
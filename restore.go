@@ -0,0 +1,124 @@
+package raft
+
+import (
+    "errors"
+    "io"
+)
+
+// ErrRestoreInProgress is returned by Restore, and by
+// ChangeConfiguration, while an earlier restore has yet to take
+// place.
+var ErrRestoreInProgress = errors.New("raft: restore in progress")
+
+// pendingRestore is a snapshot Restore waits to install, once the
+// leader has applied its whole log.
+type pendingRestore struct {
+    state  snapshotState
+    future *ProposeFuture
+}
+
+// Restore replaces the state of the cluster with a snapshot read
+// from reader, as written out by SnapshotFuture.Open, to recover
+// from a backup. It must be called on the leader. The leader stops
+// appending proposals until it has applied its whole log, restores
+// the state machine, the client sessions and the epoch from the
+// snapshot, and makes it its latest snapshot, covering an index past
+// its log, which it discards. Followers then catch up with
+// InstallSnapshot. The membership is left as it is.
+//
+// Restore returns once a no-op entry appended after the snapshot is
+// committed, so that a majority holds the restored state; the
+// followers converge on it as they catch up.
+func (this *Node) Restore(reader io.Reader) error {
+    data, err := io.ReadAll(reader)
+    if err != nil {
+        return err
+    }
+    state, err := decodeSnapshot(data)
+    if err != nil {
+        return err
+    }
+
+    this.mu.Lock()
+    future := newProposeFuture(EntryNoOp, nil)
+    switch {
+    case this.shutdown:
+        future.respond(ErrRaftShutdown)
+    case this.nodeType != Leader:
+        future.respond(this.notLeader())
+    case this.config.Snapshotter == nil:
+        future.respond(ErrNoSnapshotter)
+    case this.leaderTransfer != nil:
+        future.respond(ErrLeadershipTransferInProgress)
+    case this.restore != nil:
+        future.respond(ErrRestoreInProgress)
+    case this.configurationChange != nil || this.members.Joint() || this.members.Index > this.commitIndex:
+        future.respond(ErrConfigurationChangeInProgress)
+    default:
+        this.logInfo("restoring snapshot", "lastIncludedIndex", state.index, "size", len(data))
+        this.restore = &pendingRestore{state: state, future: future}
+        this.continueRestore()
+    }
+    this.mu.Unlock()
+    return future.Error()
+}
+
+// continueRestore installs the snapshot Restore is waiting to, once
+// the leader has applied every entry of its log. Must be called
+// with this.mu held.
+func (this *Node) continueRestore() {
+    restore := this.restore
+    if restore == nil || this.lastApplied < this.lastLogIndex() || this.admitted > this.lastApplied {
+        return
+    }
+    this.restore = nil
+
+    if err := this.archiveLog(this.lastApplied); err != nil {
+        restore.future.respond(err)
+        return
+    }
+    if err := this.config.Snapshotter.Restore(restore.state.data); err != nil {
+        this.logError("restoring snapshot failed", "err", err)
+        restore.future.respond(err)
+        return
+    }
+
+    // The snapshot covers an index no follower holds, so that
+    // every follower has to install it.
+    index := this.lastLogIndex() + 1
+    this.baseMembers = this.configuration()
+    this.truncateLog(this.log[0].Index + 1)
+    this.discardLog(this.log[0].Index)
+    this.log = []Entry{{Index: index, TermNum: this.currentTerm}}
+    this.commitIndex, this.lastApplied, this.admitted = index, index, index
+    this.clusterEpoch = maxInt(this.clusterEpoch, restore.state.epoch)
+    this.sessions = restore.state.sessions
+    this.snapshot = this.encodeSnapshot(restore.state.data)
+    this.snapshotChecksum = this.checksum
+    this.persistSnapshot(this.snapshot)
+    this.resetChecksum(this.checksum)
+    this.notifyApplied()
+
+    this.endTransfers()
+    for i := range this.peers {
+        this.epoch[i]++
+        this.inflight[i] = 0
+        this.inflightBytes[i] = 0
+        this.pipeline[i] = false
+        this.nextIndex[i] = index
+    }
+
+    future := restore.future
+    future.index, future.term = index+1, this.currentTerm
+    if err := this.appendLog(Entry{Type: EntryNoOp, Index: future.index, TermNum: future.term}); err != nil {
+        future.respond(err)
+        this.abdicate(err)
+        return
+    }
+    this.pending[future.index] = future
+    this.advanceCommitIndex()
+    this.broadcastAppendEntries()
+
+    // Proposals held back by the restore follow the no-op.
+    this.flushProposals()
+}
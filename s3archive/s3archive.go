@@ -0,0 +1,155 @@
+// Package s3archive stores the segments and snapshots of a
+// raft.ObjectArchive in an S3 bucket, or any service speaking the S3
+// API, signing requests with AWS Signature Version 4:
+//
+//    store := &s3archive.Store{
+//        Endpoint:        "https://s3.eu-west-1.amazonaws.com",
+//        Region:          "eu-west-1",
+//        Bucket:          "raft-archive",
+//        AccessKeyId:     os.Getenv("AWS_ACCESS_KEY_ID"),
+//        SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+//    }
+//    node, err := raft.NewNode(id, peers, apply,
+//        raft.WithSnapshotter(fsm, 8192),
+//        func(config *raft.Config) {
+//            config.Archive = raft.NewObjectArchive(store, "cluster-1/node-"+string(id)+"/")
+//        })
+//
+// Objects are addressed path-style, as Endpoint/Bucket/key, which
+// S3-compatible services such as MinIO accept too.
+package s3archive
+
+import (
+    "bytes"
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "net/http"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/tawawhite/raft"
+)
+
+// Store is a raft.ObjectStore keeping objects in an S3 bucket.
+type Store struct {
+    // The service URL, such as https://s3.us-east-1.amazonaws.com,
+    // and the region requests are signed for.
+    Endpoint string
+    Region   string
+
+    Bucket string
+
+    // Credentials. SessionToken is only needed for temporary ones.
+    AccessKeyId     string
+    SecretAccessKey string
+    SessionToken    string
+
+    // Sends the requests; http.DefaultClient if nil.
+    Client *http.Client
+}
+
+var _ raft.ObjectStore = (*Store)(nil)
+
+// Put uploads data to key with a single PUT request.
+func (this *Store) Put(ctx context.Context, key string, data []byte) error {
+    url := strings.TrimSuffix(this.Endpoint, "/") + "/" + this.Bucket + "/" + escapePath(key)
+    req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewReader(data))
+    if err != nil {
+        return err
+    }
+    req.ContentLength = int64(len(data))
+    this.sign(req, data, time.Now().UTC())
+
+    client := this.Client
+    if client == nil {
+        client = http.DefaultClient
+    }
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode/100 != 2 {
+        body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+        return fmt.Errorf("s3archive: PUT %s: %s: %s", key, resp.Status, bytes.TrimSpace(body))
+    }
+    return nil
+}
+
+// sign adds the headers of AWS Signature Version 4 to req, whose
+// body is payload, as of now.
+func (this *Store) sign(req *http.Request, payload []byte, now time.Time) {
+    date := now.Format("20060102")
+    timestamp := now.Format("20060102T150405Z")
+    payloadHash := sha256.Sum256(payload)
+
+    req.Header.Set("X-Amz-Date", timestamp)
+    req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+    if this.SessionToken != "" {
+        req.Header.Set("X-Amz-Security-Token", this.SessionToken)
+    }
+
+    headers := map[string]string{"host": req.URL.Host}
+    for name := range req.Header {
+        headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+    }
+    names := make([]string, 0, len(headers))
+    for name := range headers {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    var canonicalHeaders strings.Builder
+    for _, name := range names {
+        canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+    }
+    signedHeaders := strings.Join(names, ";")
+
+    canonicalRequest := strings.Join([]string{
+        req.Method,
+        req.URL.EscapedPath(),
+        req.URL.RawQuery,
+        canonicalHeaders.String(),
+        signedHeaders,
+        hex.EncodeToString(payloadHash[:]),
+    }, "\n")
+    requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+    scope := date + "/" + this.Region + "/s3/aws4_request"
+    stringToSign := "AWS4-HMAC-SHA256\n" + timestamp + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+    key := hmacSHA256([]byte("AWS4"+this.SecretAccessKey), date)
+    key = hmacSHA256(key, this.Region)
+    key = hmacSHA256(key, "s3")
+    key = hmacSHA256(key, "aws4_request")
+    signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+    req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+this.AccessKeyId+"/"+scope+
+        ", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+    mac := hmac.New(sha256.New, key)
+    mac.Write([]byte(data))
+    return mac.Sum(nil)
+}
+
+// escapePath escapes key as S3 expects in a path: every byte but
+// the unreserved characters and slashes.
+func escapePath(key string) string {
+    var escaped strings.Builder
+    for i := 0; i < len(key); i++ {
+        c := key[i]
+        if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+            c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+            escaped.WriteByte(c)
+        } else {
+            fmt.Fprintf(&escaped, "%%%02X", c)
+        }
+    }
+    return escaped.String()
+}
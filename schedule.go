@@ -0,0 +1,82 @@
+package raft
+
+import "time"
+
+// SnapshotWindow is a daily period of time, given as offsets from
+// midnight. A window whose End is before its Start spans midnight.
+type SnapshotWindow struct {
+    Start time.Duration
+    End   time.Duration
+}
+
+// contains reports whether t falls in the window.
+func (this SnapshotWindow) contains(t time.Time) bool {
+    midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+    offset := t.Sub(midnight)
+    if this.Start <= this.End {
+        return offset >= this.Start && offset < this.End
+    }
+    return offset >= this.Start || offset < this.End
+}
+
+// SnapshotSchedule restricts when a node takes snapshots, so that
+// their I/O does not disturb commit latency at busy times.
+type SnapshotSchedule struct {
+    // Snapshots are never started during a blackout window.
+    Blackout []SnapshotWindow
+
+    // If any are given, snapshots are only started during a
+    // preferred window.
+    Preferred []SnapshotWindow
+
+    // If positive, time is divided into slots of this length
+    // assigned to the nodes of the cluster in turn, in ID order,
+    // and a node only snapshots in its own slot. Every node should
+    // use the same schedule, so that at most one snapshots at a
+    // time as long as their clocks roughly agree.
+    RotationSlot time.Duration
+
+    // Windows are in this location; nil means time.Local.
+    Location *time.Location
+
+    // Once this many applied entries have accumulated, a snapshot
+    // is taken regardless of the schedule, bounding the log.
+    // Zero means never.
+    ForceThreshold int
+}
+
+// snapshotAllowed reports whether the schedule lets the node start
+// a snapshot at now, with the given number of applied entries in
+// its log. Must be called with this.mu held.
+func (this *Node) snapshotAllowed(now time.Time, entries int) bool {
+    schedule := this.config.SnapshotSchedule
+    if schedule.ForceThreshold > 0 && entries >= schedule.ForceThreshold {
+        return true
+    }
+    if schedule.Location != nil {
+        now = now.In(schedule.Location)
+    }
+
+    for _, window := range schedule.Blackout {
+        if window.contains(now) {
+            return false
+        }
+    }
+    if len(schedule.Preferred) > 0 {
+        preferred := false
+        for _, window := range schedule.Preferred {
+            preferred = preferred || window.contains(now)
+        }
+        if !preferred {
+            return false
+        }
+    }
+
+    if schedule.RotationSlot > 0 && len(this.peers) > 0 {
+        slot := int(now.UnixNano()/int64(schedule.RotationSlot)) % len(this.peers)
+        if this.peers[slot] != this.id {
+            return false
+        }
+    }
+    return true
+}
@@ -0,0 +1,63 @@
+package raft
+
+import (
+    "errors"
+    "fmt"
+    "hash/fnv"
+    "sort"
+)
+
+// ErrUnknownServer is returned for a server ID that cannot be
+// resolved to an address.
+var ErrUnknownServer = errors.New("raft: unknown server")
+
+// ServerId identifies a server for as long as it is a member of its
+// cluster, whatever its address: a name or UUID the operator assigns
+// and never reuses for another server. The empty ServerId stands for
+// no server, such as an unknown leader.
+type ServerId string
+
+// ServerAddress is where a server can be reached, in the form its
+// transport understands, such as "host:port".
+type ServerAddress string
+
+// AddressProvider resolves server IDs to their current addresses,
+// so that transports keep up with servers whose addresses change,
+// as when DNS records are updated or containers are rescheduled.
+// Transports resolve an ID again each time they connect to it.
+type AddressProvider interface {
+    ServerAddress(id ServerId) (ServerAddress, error)
+}
+
+// StaticAddresses is an AddressProvider for addresses that do not
+// change.
+type StaticAddresses map[ServerId]ServerAddress
+
+func (this StaticAddresses) ServerAddress(id ServerId) (ServerAddress, error) {
+    address, ok := this[id]
+    if !ok {
+        return "", fmt.Errorf("%w: %q", ErrUnknownServer, id)
+    }
+    return address, nil
+}
+
+// sortServers sorts ids in ascending order.
+func sortServers(ids []ServerId) {
+    sort.Slice(ids, func(i, j int) bool {
+        return ids[i] < ids[j]
+    })
+}
+
+// sortMembers sorts servers by ID.
+func sortMembers(servers []Server) {
+    sort.Slice(servers, func(i, j int) bool {
+        return servers[i].Id < servers[j].Id
+    })
+}
+
+// hash returns the FNV-1a hash of the ID.
+func (this ServerId) hash() uint32 {
+    hash := fnv.New32a()
+    hash.Write([]byte(this))
+    return hash.Sum32()
+}
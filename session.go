@@ -0,0 +1,178 @@
+package raft
+
+import (
+    "context"
+    "errors"
+    "sort"
+)
+
+// ErrSessionExpired is returned for a command whose client session
+// is unknown, because it was never registered or was evicted. The
+// command was not applied, and can no longer be applied exactly
+// once: the client must register a new session.
+var ErrSessionExpired = errors.New("raft: client session expired")
+
+// ErrStaleSequence is returned for a command whose sequence number
+// is lower than the latest one applied for its session. The command
+// was applied before, but its outcome is no longer remembered.
+var ErrStaleSequence = errors.New("raft: command sequence number already applied")
+
+// errCorruptSnapshot is returned when a snapshot cannot be decoded.
+var errCorruptSnapshot = errors.New("raft: corrupt snapshot")
+
+// clientSession tracks the commands applied for one client, so that
+// commands retried after a failover are applied only once (see §6.3
+// of the Raft dissertation).
+type clientSession struct {
+    // Latest sequence number applied, and where.
+    sequence int
+    index    int
+    term     int
+
+    // Index of the last entry that registered or used the session,
+    // which decides evictions.
+    lastUsed int
+}
+
+// RegisterClient opens a client session for ProposeSession. Once the
+// returned future resolves, its Index is the client's ID. ctx bounds
+// it as it does Propose.
+func (this *Node) RegisterClient(ctx context.Context) *ProposeFuture {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    future := newProposeFuture(EntryRegisterClient, nil)
+    this.propose(ctx, future)
+    return future
+}
+
+// ProposeSession is Propose for a command sent within a client
+// session. Each client numbers its commands 1, 2, 3... and
+// resubmits a command with the same sequence number, to any node,
+// until it succeeds. A command whose sequence number was already
+// applied is not applied again: its future resolves with the Index
+// and Term of the first application.
+//
+// Sessions are kept for up to Config.MaxClientSessions clients,
+// evicting the least recently used; commands of an evicted session
+// fail with ErrSessionExpired.
+func (this *Node) ProposeSession(ctx context.Context, clientId, sequence int, command []byte) *ProposeFuture {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    future := newProposeFuture(EntryNormal, command)
+    future.clientId = clientId
+    future.sequence = sequence
+    this.propose(ctx, future)
+    return future
+}
+
+// appliedEntry is a committed entry being applied, with the outcome
+// for its proposer.
+type appliedEntry struct {
+    entry Entry
+
+    // Whether the state machine should apply the command.
+    apply bool
+
+    // The outcome, and where the command was applied.
+    result any
+    err    error
+    index  int
+    term   int
+}
+
+// admit decides how entry, the next committed entry, is applied,
+// updating the client sessions. Must be called with this.mu held,
+// on entries in log order.
+func (this *Node) admit(entry Entry) appliedEntry {
+    applied := appliedEntry{entry: entry, index: entry.Index, term: entry.TermNum}
+    switch entry.Type {
+    case EntryRegisterClient:
+        this.sessions[entry.Index] = &clientSession{lastUsed: entry.Index}
+        this.evictSessions()
+    case EntryPromote:
+        epoch, _ := decodeFence(entry.Command)
+        this.clusterEpoch = maxInt(this.clusterEpoch, epoch)
+        delete(this.sessions, MirrorClientId)
+    case EntryDemote:
+        epoch, after := decodeFence(entry.Command)
+        this.clusterEpoch = maxInt(this.clusterEpoch, epoch)
+        this.sessions[MirrorClientId] = &clientSession{sequence: after, lastUsed: entry.Index}
+    case EntryNormal:
+        if entry.ClientId == 0 {
+            applied.apply = true
+            break
+        }
+        session, ok := this.sessions[entry.ClientId]
+        switch {
+        case !ok:
+            applied.err = ErrSessionExpired
+        case entry.Sequence < session.sequence:
+            applied.err = ErrStaleSequence
+        case entry.Sequence == session.sequence:
+            applied.index, applied.term = session.index, session.term
+        default:
+            applied.apply = true
+            session.sequence = entry.Sequence
+            session.index, session.term = entry.Index, entry.TermNum
+        }
+        if ok {
+            session.lastUsed = entry.Index
+        }
+    }
+    return applied
+}
+
+// evictSessions drops the least recently used sessions beyond
+// Config.MaxClientSessions. Must be called with this.mu held.
+func (this *Node) evictSessions() {
+    max := this.config.MaxClientSessions
+    ids := make([]int, 0, len(this.sessions))
+    for id := range this.sessions {
+        if id != MirrorClientId {
+            ids = append(ids, id)
+        }
+    }
+    if max <= 0 || len(ids) <= max {
+        return
+    }
+    sort.Slice(ids, func(i, j int) bool {
+        return this.sessions[ids[i]].lastUsed < this.sessions[ids[j]].lastUsed
+    })
+    for _, id := range ids[:len(ids)-max] {
+        delete(this.sessions, id)
+    }
+}
+
+// encodeSnapshot wraps the state machine's snapshot with the epoch,
+// the configuration and the client sessions, which must be restored
+// along with it. Must be called with this.mu held.
+func (this *Node) encodeSnapshot(data []byte) []byte {
+    term, _ := this.termAt(this.lastApplied)
+    return marshalSnapshot(snapshotState{
+        index:    this.lastApplied,
+        term:     term,
+        epoch:    this.clusterEpoch,
+        members:  this.configurationAt(this.lastApplied),
+        sessions: this.sessions,
+        checksum: this.checksum,
+        data:     data,
+    })
+}
+
+// snapshotState is a snapshot made by encodeSnapshot, decoded.
+type snapshotState struct {
+    version  int
+    index    int
+    term     int
+    epoch    int
+    members  Configuration
+    sessions map[int]*clientSession
+
+    // The rolling checksum of the log up to index, as audited.
+    checksum uint64
+
+    // The state machine's snapshot.
+    data []byte
+}
@@ -0,0 +1,88 @@
+package raft
+
+import (
+    "context"
+    "io"
+)
+
+// Shutdown stops the node for good. If Config.TransferLeadershipOnShutdown
+// is set and the node is the leader, it first hands leadership to
+// another node, so the cluster need not wait out an election
+// timeout. It then stops the node's timers, fails every proposal
+// not yet applied with ErrRaftShutdown, closes the transport if it
+// implements io.Closer and waits for the node's goroutines to exit.
+// The state machine is not called once Shutdown has begun, though
+// with Config.ApplyQueue Shutdown waits for a batch it is applying.
+//
+// Proposals failed by Shutdown may still have been committed, and
+// are applied when the node restarts from its storage. Shutdown
+// returns ctx's error if ctx is done first; the node has stopped
+// regardless, and Done is closed once its goroutines have exited.
+func (this *Node) Shutdown(ctx context.Context) error {
+    if this.config.TransferLeadershipOnShutdown && this.Role() == Leader {
+        if err := this.LeadershipTransfer(ctx); err != nil {
+            this.mu.Lock()
+            this.logWarn("leadership transfer before shutdown failed", "err", err)
+            this.mu.Unlock()
+        }
+    }
+
+    this.mu.Lock()
+    if !this.shutdown {
+        this.shutdown = true
+        this.logInfo("shutting down", "term", this.currentTerm, "role", this.nodeType)
+        if this.leaderTransfer != nil {
+            this.endLeaderTransfer(ErrRaftShutdown)
+        }
+        if this.configurationChange != nil {
+            this.configurationChange.respond(ErrRaftShutdown)
+            this.configurationChange = nil
+        }
+        if this.restore != nil {
+            this.restore.future.respond(ErrRaftShutdown)
+            this.restore = nil
+        }
+        this.failProposals(ErrRaftShutdown)
+        this.failVerifications(ErrRaftShutdown)
+        this.failForwarded(ErrRaftShutdown)
+        this.endWatches(ErrRaftShutdown)
+        for _, future := range this.snapshotRequests {
+            future.respond(ErrRaftShutdown)
+        }
+        this.snapshotRequests = nil
+        for index, future := range this.pending {
+            delete(this.pending, index)
+            future.respond(ErrRaftShutdown)
+        }
+        if this.applyQueue != nil {
+            close(this.applyQueue)
+        }
+        this.mu.Unlock()
+
+        this.Stop()
+        var closeErr error
+        if closer, ok := this.config.Transport.(io.Closer); ok {
+            closeErr = closer.Close()
+        }
+        go func() {
+            this.rpcs.Wait()
+            this.closeErr = closeErr
+            close(this.closed)
+        }()
+    } else {
+        this.mu.Unlock()
+    }
+
+    select {
+    case <-this.closed:
+        return this.closeErr
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// Done is closed once Shutdown has stopped the node and its
+// goroutines have exited.
+func (this *Node) Done() <-chan struct{} {
+    return this.closed
+}
@@ -0,0 +1,103 @@
+package simulation
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+)
+
+// ErrNondeterministic is returned by DeterminismChecker.Err when
+// replicas of a state machine disagree after applying the same log.
+var ErrNondeterministic = errors.New("simulation: state machine is nondeterministic")
+
+// FSM is an application state machine under test.
+type FSM interface {
+    Apply(command []byte)
+    Snapshot() ([]byte, error)
+}
+
+// DeterminismChecker applies the log committed by a cluster to
+// several independent replicas of an application state machine and
+// compares their snapshots, catching state machines whose results
+// depend on the clock, randomness or map iteration order.
+type DeterminismChecker struct {
+    cluster  *Cluster
+    replicas []FSM
+    every    int
+
+    // Commands applied to every replica so far.
+    applied int
+
+    err error
+}
+
+// CheckDeterminism starts applying the cluster's committed log to
+// the given number of replicas made by newFSM, comparing their
+// snapshots after every `every` commands.
+func (this *Cluster) CheckDeterminism(newFSM func() FSM, replicas, every int) *DeterminismChecker {
+    checker := &DeterminismChecker{
+        cluster:  this,
+        replicas: make([]FSM, replicas),
+        every:    every,
+    }
+    for i := range checker.replicas {
+        checker.replicas[i] = newFSM()
+    }
+    this.stepHooks = append(this.stepHooks, checker.step)
+    return checker
+}
+
+// Err returns the first disagreement found, after comparing the
+// replicas once more.
+func (this *DeterminismChecker) Err() error {
+    if this.err == nil {
+        this.compare()
+    }
+    return this.err
+}
+
+// step applies the commands committed in the last step.
+func (this *DeterminismChecker) step() {
+    if this.err != nil {
+        return
+    }
+
+    // Every node applies a prefix of the same log, so the longest
+    // one holds everything committed so far.
+    var log []string
+    for _, applied := range this.cluster.applied {
+        if len(applied) > len(log) {
+            log = applied
+        }
+    }
+    for this.applied < len(log) {
+        for _, replica := range this.replicas {
+            replica.Apply([]byte(log[this.applied]))
+        }
+        this.applied++
+        if this.applied%this.every == 0 {
+            if this.compare(); this.err != nil {
+                return
+            }
+        }
+    }
+}
+
+// compare records an error if the replicas' snapshots differ.
+func (this *DeterminismChecker) compare() {
+    var first []byte
+    for i, replica := range this.replicas {
+        snapshot, err := replica.Snapshot()
+        if err != nil {
+            this.err = fmt.Errorf("simulation: replica %d: %w", i, err)
+            return
+        }
+        if i == 0 {
+            first = snapshot
+        } else if !bytes.Equal(snapshot, first) {
+            this.err = fmt.Errorf("%w: replicas 0 and %d differ after %d commands",
+                ErrNondeterministic, i, this.applied)
+            return
+        }
+    }
+}
@@ -0,0 +1,303 @@
+package simulation
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/tawawhite/raft"
+)
+
+// ErrInvariant is returned by InvariantChecker.Err when the cluster
+// breaks one of the safety properties of Raft.
+var ErrInvariant = errors.New("simulation: invariant violated")
+
+// journalSize is how many events a journal keeps, the oldest being
+// dropped first.
+const journalSize = 10000
+
+// journal records what happens in a cluster, so that a violation
+// can be reported with the events that led to it.
+type journal struct {
+    entries []journalEntry
+}
+
+type journalEntry struct {
+    at time.Duration
+
+    // The nodes the event concerns; nil for the whole cluster.
+    nodes []int
+
+    // Whether the event is a heartbeat, or the answer to one, of
+    // which a cluster exchanges many to no effect.
+    routine bool
+
+    text string
+}
+
+// note records an event concerning nodes, if a journal is kept.
+func (this *Cluster) note(nodes []int, routine bool, format string, args ...any) {
+    if this.journal == nil {
+        return
+    }
+    entries := append(this.journal.entries, journalEntry{
+        at:      this.now,
+        nodes:   nodes,
+        routine: routine,
+        text:    fmt.Sprintf(format, args...),
+    })
+    if len(entries) > journalSize {
+        entries = entries[len(entries)-journalSize:]
+    }
+    this.journal.entries = entries
+}
+
+// noteMessage records the delivery of msg from one node to another.
+func (this *Cluster) noteMessage(from, to int, msg raft.Message) {
+    if this.journal == nil {
+        return
+    }
+    nodes := []int{from, to}
+    if msg.Response {
+        routine := msg.Type == raft.MsgAppendEntries && msg.Success
+        this.note(nodes, routine, "%v response %d→%d: term %d, success %v",
+            msg.Type, from, to, msg.Term, msg.Success)
+        return
+    }
+    switch msg.Type {
+    case raft.MsgAppendEntries:
+        this.note(nodes, len(msg.Entries) == 0, "AppendEntries %d→%d: term %d, after %d/%d, %d entries, commit %d",
+            from, to, msg.Term, msg.Index, msg.LogTerm, len(msg.Entries), msg.Commit)
+    case raft.MsgRequestVote:
+        this.note(nodes, false, "RequestVote %d→%d: term %d, log ends at %d/%d",
+            from, to, msg.Term, msg.Index, msg.LogTerm)
+    default:
+        this.note(nodes, false, "%v %d→%d: term %d", msg.Type, from, to, msg.Term)
+    }
+}
+
+// InvariantChecker checks after every event of a cluster that it
+// keeps the safety properties of Raft (see Figure 3 of the raft
+// paper):
+//
+//   - Election Safety: at most one leader is elected in a term.
+//   - Log Matching: if two logs hold an entry of the same index and
+//     term, they hold the same entries up to it.
+//   - Leader Completeness: a leader holds every entry committed in
+//     an earlier term, and committed entries never change.
+//   - State Machine Safety: every node applies a prefix of the same
+//     sequence of commands.
+//
+// Entries are compared by term, read from the nodes' log stores;
+// those compacted away are not checked.
+//
+// A violation is reported with the events of the run that led to it;
+// Run and Minimize check a scenario of Steps, and shrink it to the
+// fewest that still break an invariant.
+type InvariantChecker struct {
+    cluster *Cluster
+
+    // The leader of each term, the term of each committed entry,
+    // and the term in which it was first seen committed.
+    leaders    map[int]int
+    committed  map[int]int
+    commitTerm map[int]int
+
+    // The status of every node after the previous event.
+    statuses []raft.Status
+
+    err error
+}
+
+// CheckInvariants starts checking the cluster's safety properties
+// after every event, and keeping a journal of events to report a
+// violation with.
+func (this *Cluster) CheckInvariants() *InvariantChecker {
+    checker := &InvariantChecker{
+        cluster:    this,
+        leaders:    make(map[int]int),
+        committed:  make(map[int]int),
+        commitTerm: make(map[int]int),
+        statuses:   make([]raft.Status, len(this.nodes)),
+    }
+    if this.journal == nil {
+        this.journal = &journal{}
+    }
+    this.stepHooks = append(this.stepHooks, checker.step)
+    return checker
+}
+
+// Err returns the first violation found, wrapping ErrInvariant, with
+// the events that led to it.
+func (this *InvariantChecker) Err() error {
+    return this.err
+}
+
+// step checks the invariants after an event.
+func (this *InvariantChecker) step() {
+    if this.err != nil {
+        return
+    }
+    cluster := this.cluster
+    logs := make([][]int, len(cluster.nodes))
+    firsts := make([]int, len(cluster.nodes))
+    for id, node := range cluster.nodes {
+        status := node.Status()
+        previous := this.statuses[id]
+        if status.Role != previous.Role || status.Term != previous.Term {
+            cluster.note([]int{id}, false, "node %d is %v in term %d, log ending at %d/%d",
+                id, status.Role, status.Term, status.LastLogIndex, status.LastLogTerm)
+        }
+        if status.CommitIndex != previous.CommitIndex {
+            cluster.note([]int{id}, false, "node %d commits up to %d", id, status.CommitIndex)
+        }
+        this.statuses[id] = status
+        firsts[id], logs[id] = this.readLog(id)
+    }
+
+    for id, status := range this.statuses {
+        if status.Role != raft.Leader {
+            continue
+        }
+        if other, ok := this.leaders[status.Term]; ok && other != id {
+            this.fail([]int{id, other}, "nodes %d and %d both lead term %d", other, id, status.Term)
+            return
+        }
+        this.leaders[status.Term] = id
+    }
+
+    for a := range logs {
+        for b := a + 1; b < len(logs); b++ {
+            if index, ok := matchLogs(firsts[a], logs[a], firsts[b], logs[b]); !ok {
+                this.fail([]int{a, b}, "logs of nodes %d and %d share an entry after differing at %d", a, b, index)
+                return
+            }
+        }
+    }
+
+    for id, status := range this.statuses {
+        for index := status.CommitIndex; index > 0; index-- {
+            term, ok := termAt(firsts[id], logs[id], index)
+            if !ok {
+                break
+            }
+            if committed, ok := this.committed[index]; ok {
+                if committed != term {
+                    this.fail([]int{id}, "node %d holds entry %d of term %d, committed in term %d",
+                        id, index, term, committed)
+                    return
+                }
+                continue
+            }
+            this.committed[index] = term
+            this.commitTerm[index] = status.Term
+        }
+    }
+    for id, status := range this.statuses {
+        if status.Role != raft.Leader {
+            continue
+        }
+        for index, term := range this.committed {
+            if this.commitTerm[index] >= status.Term || index < firsts[id] {
+                continue
+            }
+            if held, ok := termAt(firsts[id], logs[id], index); !ok || held != term {
+                this.fail([]int{id}, "leader %d of term %d lacks entry %d of term %d, committed by term %d",
+                    id, status.Term, index, term, this.commitTerm[index])
+                return
+            }
+        }
+    }
+
+    if err := cluster.CheckApplied(); err != nil {
+        this.fail(nil, "%v", err)
+    }
+}
+
+// readLog returns the first index of the log store of node id and
+// the terms of the entries it holds from there on.
+func (this *InvariantChecker) readLog(id int) (int, []int) {
+    store := this.cluster.logs[id]
+    first, _ := store.FirstIndex()
+    last, _ := store.LastIndex()
+    var terms []int
+    for index := first; first > 0 && index <= last; index++ {
+        entry, err := store.GetLog(index)
+        if err != nil {
+            break
+        }
+        terms = append(terms, entry.TermNum)
+    }
+    return first, terms
+}
+
+// termAt returns the term of the entry at index of a log holding
+// terms from first on.
+func termAt(first int, terms []int, index int) (int, bool) {
+    if index < first || index-first >= len(terms) {
+        return 0, false
+    }
+    return terms[index-first], true
+}
+
+// matchLogs checks Log Matching between two logs, returning the
+// index at which they differ below an entry they share if they
+// break it.
+func matchLogs(firstA int, a []int, firstB int, b []int) (int, bool) {
+    first := firstA
+    if firstB > first {
+        first = firstB
+    }
+    shared := false
+    for index := first + min(len(a)-(first-firstA), len(b)-(first-firstB)) - 1; index >= first; index-- {
+        termA, _ := termAt(firstA, a, index)
+        termB, _ := termAt(firstB, b, index)
+        switch {
+        case termA == termB:
+            shared = true
+        case shared:
+            return index, false
+        }
+    }
+    return 0, true
+}
+
+// fail records a violation concerning nodes, or the whole cluster if
+// nodes is nil, with the journal filtered down to the events that
+// concern them, heartbeats left out. Minimize cuts a scenario, and so
+// its events, down further.
+func (this *InvariantChecker) fail(nodes []int, format string, args ...any) {
+    var trace strings.Builder
+    var kept []journalEntry
+    for _, entry := range this.cluster.journal.entries {
+        if !entry.routine && concerns(entry, nodes) {
+            kept = append(kept, entry)
+        }
+    }
+    const traceLength = 200
+    if len(kept) > traceLength {
+        kept = kept[len(kept)-traceLength:]
+    }
+    for _, entry := range kept {
+        fmt.Fprintf(&trace, "\n  %v: %s", entry.at, entry.text)
+    }
+    this.err = fmt.Errorf("%w at %v: %s; events leading to it:%s",
+        ErrInvariant, this.cluster.now, fmt.Sprintf(format, args...), trace.String())
+}
+
+// concerns reports whether entry concerns any of nodes, or nodes is
+// the whole cluster.
+func concerns(entry journalEntry, nodes []int) bool {
+    if nodes == nil || entry.nodes == nil {
+        return true
+    }
+    for _, a := range entry.nodes {
+        for _, b := range nodes {
+            if a == b {
+                return true
+            }
+        }
+    }
+    return false
+}
@@ -0,0 +1,133 @@
+package simulation
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/tawawhite/raft"
+)
+
+func TestInvariantsHold(t *testing.T) {
+    for seed := int64(1); seed <= 5; seed++ {
+        opts := DefaultOptions(seed)
+        opts.Nodes = 5
+        cluster := New(opts)
+        checker := cluster.CheckInvariants()
+        cluster.SetFaults(Faults{DropRate: 0.05, DuplicateRate: 0.05, ReorderRate: 0.2, ReorderWindow: 10 * time.Millisecond})
+
+        for round := 0; round < 10; round++ {
+            for id := 0; id < opts.Nodes; id++ {
+                cluster.Propose(id, fmt.Sprint(round, "/", id))
+            }
+            cluster.RunFor(200 * time.Millisecond)
+            switch round % 3 {
+            case 0:
+                cluster.Partition([]int{round % 5, (round + 1) % 5}, []int{(round + 2) % 5, (round + 3) % 5, (round + 4) % 5})
+            case 1:
+                cluster.Isolate(round % 5)
+            default:
+                cluster.Heal()
+            }
+            cluster.RunFor(300 * time.Millisecond)
+        }
+        cluster.Heal()
+        cluster.RunFor(time.Second)
+        if err := checker.Err(); err != nil {
+            t.Fatalf("seed %d: %v", seed, err)
+        }
+        if len(cluster.Applied(0)) == 0 {
+            t.Fatalf("seed %d: nothing was committed", seed)
+        }
+    }
+}
+
+func TestInvariantViolation(t *testing.T) {
+    stores := make(map[int]*raft.InmemStore)
+    opts := DefaultOptions(1)
+    opts.Configure = func(id int, config *raft.Config) {
+        stores[id] = raft.NewInmemStore()
+        config.LogStore = stores[id]
+    }
+    cluster := New(opts)
+    checker := cluster.CheckInvariants()
+    cluster.RunUntil(func() bool { return cluster.Leader() != nil }, 5*time.Second)
+    leader := cluster.number(cluster.Leader().Id())
+    for i := 0; i < 3; i++ {
+        cluster.Propose(leader, fmt.Sprint("command ", i))
+    }
+    cluster.RunFor(200 * time.Millisecond)
+    if err := checker.Err(); err != nil {
+        t.Fatal(err)
+    }
+
+    // A store that loses a committed entry under a node breaks
+    // the invariants.
+    follower := (leader + 1) % opts.Nodes
+    entry, err := stores[follower].GetLog(2)
+    if err != nil {
+        t.Fatal(err)
+    }
+    entry.TermNum++
+    stores[follower].StoreLogs([]raft.Entry{entry})
+    cluster.RunFor(10 * time.Millisecond)
+    err = checker.Err()
+    if !errors.Is(err, ErrInvariant) || !strings.Contains(err.Error(), "entries, commit") {
+        t.Fatalf("corrupt log reported as %v", err)
+    }
+}
+
+func TestMinimize(t *testing.T) {
+    stores := make(map[int]*raft.InmemStore)
+    opts := DefaultOptions(1)
+    opts.Configure = func(id int, config *raft.Config) {
+        stores[id] = raft.NewInmemStore()
+        config.LogStore = stores[id]
+    }
+    leader := func(cluster *Cluster) int {
+        if node := cluster.Leader(); node != nil {
+            return cluster.number(node.Id())
+        }
+        return 0
+    }
+    corrupted := false
+    corrupt := func(cluster *Cluster) {
+        follower := (leader(cluster) + 1) % opts.Nodes
+        if entry, err := stores[follower].GetLog(2); err == nil {
+            entry.TermNum++
+            stores[follower].StoreLogs([]raft.Entry{entry})
+            corrupted = true
+        }
+    }
+    steps := []Step{
+        func(cluster *Cluster) { cluster.RunUntil(func() bool { return cluster.Leader() != nil }, 5*time.Second) },
+        func(cluster *Cluster) { cluster.Propose(leader(cluster), "a") },
+        func(cluster *Cluster) { cluster.RunFor(200 * time.Millisecond) },
+        func(cluster *Cluster) { cluster.Propose(leader(cluster), "b") },
+        func(cluster *Cluster) { cluster.Isolate(2) },
+        func(cluster *Cluster) { cluster.RunFor(100 * time.Millisecond) },
+        func(cluster *Cluster) { cluster.Heal() },
+        func(cluster *Cluster) { cluster.RunFor(100 * time.Millisecond) },
+        func(cluster *Cluster) { cluster.Propose(leader(cluster), "c") },
+        func(cluster *Cluster) { cluster.RunFor(200 * time.Millisecond) },
+        corrupt,
+        func(cluster *Cluster) { cluster.RunFor(10 * time.Millisecond) },
+    }
+    if err := Run(opts, steps[:len(steps)-2]); err != nil {
+        t.Fatal(err)
+    }
+
+    minimized, err := Minimize(opts, steps)
+    if !errors.Is(err, ErrInvariant) {
+        t.Fatalf("minimized scenario fails with %v", err)
+    }
+    if len(minimized) >= len(steps) {
+        t.Fatalf("minimized %d steps to %d", len(steps), len(minimized))
+    }
+    corrupted = false
+    if err := Run(opts, minimized); !errors.Is(err, ErrInvariant) || !corrupted {
+        t.Fatalf("rerun of the minimized scenario: %v, corrupted %v", err, corrupted)
+    }
+}
@@ -0,0 +1,188 @@
+package simulation
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/tawawhite/raft"
+    "github.com/tawawhite/raft/linearizability"
+)
+
+// ErrNotLinearizable is returned by KVWorkload.Check for a history
+// that no sequential execution explains.
+var ErrNotLinearizable = errors.New("simulation: history is not linearizable")
+
+// KVWorkload drives key-value clients against a simulated cluster
+// and records their operations for linearizability checking. Every
+// operation, reads included, goes through the log: commands are
+// "put <key> <value>" and "get <key> <nonce>", and a get returns the
+// value the key had on the proposing node when it applied the get,
+// so the cluster must not compact its logs. The nonce tells apart
+// identical gets.
+//
+// Each client has at most one operation outstanding, as the checker
+// expects; operations started while a client is busy are skipped.
+type KVWorkload struct {
+    cluster *Cluster
+    clients []*kvClient
+    history []linearizability.Operation
+
+    // Gets started so far, numbering their nonces.
+    gets int
+}
+
+type kvClient struct {
+    // Operation in flight, if any.
+    busy    bool
+    input   linearizability.KVInput
+    command string
+    call   time.Duration
+    node   int
+    future *raft.ProposeFuture
+}
+
+// NewKVWorkload returns a workload of the given number of clients.
+// Completed operations are recorded as the cluster runs.
+func NewKVWorkload(cluster *Cluster, clients int) *KVWorkload {
+    this := &KVWorkload{
+        cluster: cluster,
+        clients: make([]*kvClient, clients),
+    }
+    for i := range this.clients {
+        this.clients[i] = &kvClient{}
+    }
+    cluster.stepHooks = append(cluster.stepHooks, this.collect)
+    return this
+}
+
+// Put starts a put by client on the current leader. It reports
+// whether the operation was started.
+func (this *KVWorkload) Put(client int, key, value string) bool {
+    return this.start(client, linearizability.KVInput{Op: linearizability.Put, Key: key, Value: value})
+}
+
+// Get starts a get by client on the current leader. It reports
+// whether the operation was started.
+func (this *KVWorkload) Get(client int, key string) bool {
+    return this.start(client, linearizability.KVInput{Op: linearizability.Get, Key: key})
+}
+
+// Busy reports whether client has an operation outstanding.
+func (this *KVWorkload) Busy(client int) bool {
+    return this.clients[client].busy
+}
+
+// History returns the operations recorded so far, plus every put
+// still outstanding with an unknown outcome.
+func (this *KVWorkload) History() []linearizability.Operation {
+    history := append([]linearizability.Operation(nil), this.history...)
+    for id, client := range this.clients {
+        if client.busy && client.input.Op == linearizability.Put {
+            history = append(history, linearizability.Operation{
+                ClientId: id,
+                Input:    client.input,
+                Output:   linearizability.KVOutput{},
+                Call:     int64(client.call),
+                Return:   linearizability.Never,
+            })
+        }
+    }
+    return history
+}
+
+// Check verifies the recorded history is linearizable.
+func (this *KVWorkload) Check() error {
+    history := this.History()
+    if !linearizability.Check(linearizability.KVModel, history) {
+        return fmt.Errorf("%w (%d operations)", ErrNotLinearizable, len(history))
+    }
+    return nil
+}
+
+func (this *KVWorkload) start(id int, input linearizability.KVInput) bool {
+    client := this.clients[id]
+    leader := this.cluster.Leader()
+    if client.busy || leader == nil {
+        return false
+    }
+
+    var command string
+    if input.Op == linearizability.Put {
+        command = "put " + input.Key + " " + input.Value
+    } else {
+        this.gets++
+        command = "get " + input.Key + " " + strconv.Itoa(this.gets)
+    }
+    *client = kvClient{
+        busy:    true,
+        input:   input,
+        command: command,
+        call:    this.cluster.Now(),
+        node:    this.cluster.number(leader.Id()),
+        future:  leader.Propose(context.Background(), []byte(command)),
+    }
+    return true
+}
+
+// collect records the operations that completed in the last step.
+// Failed operations were never applied and are left out, except puts
+// whose leader lost leadership after appending them: they may still
+// take effect, so their outcome is unknown.
+func (this *KVWorkload) collect() {
+    for id, client := range this.clients {
+        if !client.busy {
+            continue
+        }
+        select {
+        case <-client.future.Done():
+        default:
+            continue
+        }
+        client.busy = false
+        if err := client.future.Error(); err != nil {
+            var lost *raft.LeadershipLostError
+            if errors.As(err, &lost) && lost.Appended && client.input.Op == linearizability.Put {
+                this.history = append(this.history, linearizability.Operation{
+                    ClientId: id,
+                    Input:    client.input,
+                    Output:   linearizability.KVOutput{},
+                    Call:     int64(client.call),
+                    Return:   linearizability.Never,
+                })
+            }
+            continue
+        }
+
+        output := linearizability.KVOutput{}
+        if client.input.Op == linearizability.Get {
+            output.Value = replay(this.cluster.Applied(client.node), client.command, client.input.Key)
+        }
+        this.history = append(this.history, linearizability.Operation{
+            ClientId: id,
+            Input:    client.input,
+            Output:   output,
+            Call:     int64(client.call),
+            Return:   int64(this.cluster.Now()),
+        })
+    }
+}
+
+// replay returns the value of key when get, a unique get command,
+// was applied.
+func replay(applied []string, get string, key string) string {
+    value := ""
+    for _, command := range applied {
+        if command == get {
+            break
+        }
+        fields := strings.SplitN(command, " ", 3)
+        if len(fields) == 3 && fields[0] == "put" && fields[1] == key {
+            value = fields[2]
+        }
+    }
+    return value
+}
@@ -0,0 +1,56 @@
+package simulation
+
+// Step is one action of a scenario, such as proposing a command,
+// partitioning the network or running the cluster for a while.
+type Step func(*Cluster)
+
+// Run runs steps on a new cluster made from opts, checking its
+// invariants, and returns the first violation, if any. Steps after
+// the violation are not run.
+func Run(opts Options, steps []Step) error {
+    cluster := New(opts)
+    checker := cluster.CheckInvariants()
+    for _, step := range steps {
+        step(cluster)
+        if err := checker.Err(); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Minimize shrinks a scenario that breaks an invariant to one from
+// which no step can be removed without it passing, by delta
+// debugging: it reruns the scenario without ever smaller runs of
+// steps, keeping every removal after which some invariant still
+// breaks. Because a cluster made from the same options replays the
+// same way, the events leading to the violation it returns are those
+// of the minimized scenario. It returns steps and nil if the
+// scenario passes.
+func Minimize(opts Options, steps []Step) ([]Step, error) {
+    err := Run(opts, steps)
+    if err == nil {
+        return steps, nil
+    }
+    for chunks := 2; len(steps) > 1; {
+        size := (len(steps) + chunks - 1) / chunks
+        removed := false
+        for start := 0; start < len(steps); start += size {
+            end := min(start+size, len(steps))
+            rest := append(append([]Step(nil), steps[:start]...), steps[end:]...)
+            if restErr := Run(opts, rest); restErr != nil {
+                steps, err = rest, restErr
+                chunks = max(chunks-1, 2)
+                removed = true
+                break
+            }
+        }
+        if !removed {
+            if chunks >= len(steps) {
+                break
+            }
+            chunks = min(chunks*2, len(steps))
+        }
+    }
+    return steps, err
+}
@@ -0,0 +1,481 @@
+// Package simulation runs a cluster of raft Nodes on a single
+// goroutine over an in-memory network driven by a virtual clock.
+//
+// Nothing in a simulated cluster sleeps or depends on wall-clock
+// time: ticks, message deliveries and RPC timeouts are events on
+// the virtual clock, and every random choice comes from the seed.
+// Running the same test with the same seed therefore reproduces
+// the same elections, partitions and log divergence exactly.
+package simulation
+
+import (
+    "container/heap"
+    "context"
+    "errors"
+    "fmt"
+    "math/rand"
+    "strconv"
+    "time"
+
+    "github.com/tawawhite/raft"
+)
+
+// ErrUnreachable is reported to a node whose RPC was lost in the
+// network.
+var ErrUnreachable = errors.New("simulation: peer unreachable")
+
+// Options configures a simulated cluster.
+type Options struct {
+    // Number of nodes. The cluster numbers them 0 to Nodes-1, and
+    // gives each the decimal form of its number as its ServerId.
+    Nodes int
+
+    // Seed for every random choice made by the cluster.
+    Seed int64
+
+    // One-way network latency is uniform in [MinLatency, MaxLatency].
+    MinLatency time.Duration
+    MaxLatency time.Duration
+
+    // How long a node waits before a lost RPC fails.
+    RPCTimeout time.Duration
+
+    // If set, adjusts the configuration of each node. The cluster
+    // provides the transport and the seed.
+    Configure func(id int, config *raft.Config)
+}
+
+// DefaultOptions returns options for a three node cluster on a fast
+// local network.
+func DefaultOptions(seed int64) Options {
+    return Options{
+        Nodes:      3,
+        Seed:       seed,
+        MinLatency: time.Millisecond,
+        MaxLatency: 5 * time.Millisecond,
+        RPCTimeout: 50 * time.Millisecond,
+    }
+}
+
+// Cluster is a simulated cluster. It is not safe for concurrent use;
+// tests drive it from a single goroutine.
+type Cluster struct {
+    opts Options
+    rand *rand.Rand
+
+    // Virtual time since the simulation started, and the
+    // pending events ordered by time.
+    now    time.Duration
+    events eventQueue
+    seq    uint64
+
+    nodes []*raft.Node
+
+    // The log store of each node.
+    logs []raft.LogStore
+
+    // Commands applied by each node, in order.
+    applied [][]string
+
+    // Directed links that currently lose every message.
+    cut map[link]bool
+
+    // Misbehaviour of every link, and of particular links.
+    faults     Faults
+    linkFaults map[link]Faults
+
+    // Run after every event.
+    stepHooks []func()
+
+    // What has happened, while an InvariantChecker is running.
+    journal *journal
+}
+
+// Faults describes how the network mistreats messages on a link.
+// The zero value is a well-behaved network.
+type Faults struct {
+    // Probability that a message is lost.
+    DropRate float64
+
+    // Probability that a message is delivered twice. The receiver
+    // handles both copies; the sender sees one reply.
+    DuplicateRate float64
+
+    // Delay added to every message on top of the network latency.
+    Delay time.Duration
+
+    // Probability that a message is held back by up to
+    // ReorderWindow, letting later messages overtake it.
+    ReorderRate   float64
+    ReorderWindow time.Duration
+}
+
+type link struct {
+    from, to int
+}
+
+// New creates a cluster of followers. Nothing happens until the
+// clock is advanced with Step, RunFor or RunUntil. It panics if
+// Configure leaves a node with an invalid configuration.
+func New(opts Options) *Cluster {
+    this := &Cluster{
+        opts:    opts,
+        rand:    rand.New(rand.NewSource(opts.Seed)),
+        applied:    make([][]string, opts.Nodes),
+        cut:        make(map[link]bool),
+        linkFaults: make(map[link]Faults),
+    }
+
+    for id := 0; id < opts.Nodes; id++ {
+        id := id
+        var peers []raft.ServerId
+        for peer := 0; peer < opts.Nodes; peer++ {
+            if peer != id {
+                peers = append(peers, ServerId(peer))
+            }
+        }
+        configure := func(config *raft.Config) {
+            config.Transport = raft.NewMessageTransport(ServerId(id), this.send)
+            // Zero would seed the node from the clock.
+            config.Seed = this.rand.Int63() | 1
+            if opts.Configure != nil {
+                opts.Configure(id, config)
+            }
+            if config.LogStore == nil {
+                config.LogStore = raft.NewInmemStore()
+            }
+            this.logs = append(this.logs, config.LogStore)
+        }
+        node, err := raft.NewNode(ServerId(id), peers, func(command []byte) {
+            this.applied[id] = append(this.applied[id], string(command))
+        }, configure)
+        if err == nil && len(peers) == 0 {
+            err = node.BootstrapCluster(raft.Configuration{Servers: []raft.Server{{Id: ServerId(id)}}})
+        }
+        if err != nil {
+            panic(fmt.Sprintf("simulation: node %d: %v", id, err))
+        }
+        this.nodes = append(this.nodes, node)
+    }
+
+    this.after(raft.TickInterval, this.tick)
+    return this
+}
+
+// Now returns the virtual time elapsed since the cluster started.
+func (this *Cluster) Now() time.Duration {
+    return this.now
+}
+
+// Node returns the node with the given number.
+func (this *Cluster) Node(id int) *raft.Node {
+    return this.nodes[id]
+}
+
+// ServerId returns the ServerId of the node with the given number.
+func ServerId(id int) raft.ServerId {
+    return raft.ServerId(strconv.Itoa(id))
+}
+
+// number returns the number of the node with the given ServerId, or
+// -1 if there is none.
+func (this *Cluster) number(id raft.ServerId) int {
+    number, err := strconv.Atoi(string(id))
+    if err != nil || number < 0 || number >= len(this.nodes) || ServerId(number) != id {
+        return -1
+    }
+    return number
+}
+
+// Nodes returns every node in number order.
+func (this *Cluster) Nodes() []*raft.Node {
+    return this.nodes
+}
+
+// Applied returns the commands node id has applied, in order.
+func (this *Cluster) Applied(id int) []string {
+    return this.applied[id]
+}
+
+// Leader returns the leader with the highest term, or nil if no
+// node currently believes it is leader. A deposed leader cut off
+// from the cluster may still believe it leads an older term.
+func (this *Cluster) Leader() *raft.Node {
+    var leader *raft.Node
+    for _, node := range this.nodes {
+        if node.Role() == raft.Leader && (leader == nil || node.Term() > leader.Term()) {
+            leader = node
+        }
+    }
+    return leader
+}
+
+// Propose proposes command on node id.
+func (this *Cluster) Propose(id int, command string) *raft.ProposeFuture {
+    this.note([]int{id}, false, "%q proposed to node %d", command, id)
+    return this.nodes[id].Propose(context.Background(), []byte(command))
+}
+
+// Step processes the next event, advancing the clock to it.
+func (this *Cluster) Step() {
+    event := heap.Pop(&this.events).(*event)
+    this.now = event.at
+    event.fn()
+    for _, hook := range this.stepHooks {
+        hook()
+    }
+}
+
+// RunFor processes every event due in the next d of virtual time.
+func (this *Cluster) RunFor(d time.Duration) {
+    end := this.now + d
+    for this.events.Len() > 0 && this.events[0].at <= end {
+        this.Step()
+    }
+    this.now = end
+}
+
+// RunUntil processes events until cond holds, checking it after each
+// event, or until timeout of virtual time has passed. It reports
+// whether cond was met.
+func (this *Cluster) RunUntil(cond func() bool, timeout time.Duration) bool {
+    end := this.now + timeout
+    for !cond() {
+        if this.events.Len() == 0 || this.events[0].at > end {
+            this.now = end
+            return false
+        }
+        this.Step()
+    }
+    return true
+}
+
+// Partition splits the network into the given groups of node numbers.
+// Nodes in different groups cannot reach each other; nodes not
+// listed are isolated from everyone.
+func (this *Cluster) Partition(groups ...[]int) {
+    group := make(map[int]int)
+    for g, ids := range groups {
+        for _, id := range ids {
+            group[id] = g + 1
+        }
+    }
+    this.note(nil, false, "partitioned into %v", groups)
+    this.cut = make(map[link]bool)
+    for _, from := range this.nodes {
+        for _, to := range this.nodes {
+            a, b := group[this.number(from.Id())], group[this.number(to.Id())]
+            if from != to && (a == 0 || a != b) {
+                this.cut[link{this.number(from.Id()), this.number(to.Id())}] = true
+            }
+        }
+    }
+}
+
+// Isolate cuts node id off from every other node.
+func (this *Cluster) Isolate(id int) {
+    this.note([]int{id}, false, "node %d isolated", id)
+    for other := range this.nodes {
+        if other != id {
+            this.cut[link{id, other}] = true
+            this.cut[link{other, id}] = true
+        }
+    }
+}
+
+// Cut loses every message sent from one node to another, leaving
+// the opposite direction working: an asymmetric partition.
+func (this *Cluster) Cut(from, to int) {
+    this.note([]int{from, to}, false, "link from node %d to %d cut", from, to)
+    this.cut[link{from, to}] = true
+}
+
+// Disconnect cuts the links between two nodes in both directions.
+func (this *Cluster) Disconnect(a, b int) {
+    this.Cut(a, b)
+    this.Cut(b, a)
+}
+
+// Connect restores the links between two nodes in both directions.
+func (this *Cluster) Connect(a, b int) {
+    this.note([]int{a, b}, false, "nodes %d and %d connected", a, b)
+    delete(this.cut, link{a, b})
+    delete(this.cut, link{b, a})
+}
+
+// Heal restores every link. Faults set with SetFaults and
+// SetLinkFaults stay in place.
+func (this *Cluster) Heal() {
+    this.note(nil, false, "network healed")
+    this.cut = make(map[link]bool)
+}
+
+// SetFaults sets the faults of every link without faults of its own.
+func (this *Cluster) SetFaults(faults Faults) {
+    this.faults = faults
+}
+
+// SetLinkFaults sets the faults of messages sent from one node to
+// another, overriding SetFaults for that direction.
+func (this *Cluster) SetLinkFaults(from, to int, faults Faults) {
+    this.linkFaults[link{from, to}] = faults
+}
+
+// ClearFaults removes all faults, leaving partitions in place.
+func (this *Cluster) ClearFaults() {
+    this.faults = Faults{}
+    this.linkFaults = make(map[link]Faults)
+}
+
+// CheckApplied verifies state machine safety: every node has applied
+// a prefix of the same sequence of commands.
+func (this *Cluster) CheckApplied() error {
+    for a := range this.applied {
+        for b := a + 1; b < len(this.applied); b++ {
+            x, y := this.applied[a], this.applied[b]
+            for i := 0; i < len(x) && i < len(y); i++ {
+                if x[i] != y[i] {
+                    return fmt.Errorf("simulation: nodes %d and %d applied %q and %q at position %d",
+                        a, b, x[i], y[i], i+1)
+                }
+            }
+        }
+    }
+    return nil
+}
+
+// tick advances every node's logical clock, in number order.
+func (this *Cluster) tick() {
+    for _, node := range this.nodes {
+        node.Tick()
+    }
+    this.after(raft.TickInterval, this.tick)
+}
+
+// after schedules fn to run d from now.
+func (this *Cluster) after(d time.Duration, fn func()) {
+    this.seq++
+    heap.Push(&this.events, &event{at: this.now + d, seq: this.seq, fn: fn})
+}
+
+// latency draws a one-way network delay.
+func (this *Cluster) latency() time.Duration {
+    spread := this.opts.MaxLatency - this.opts.MinLatency
+    if spread <= 0 {
+        return this.opts.MinLatency
+    }
+    return this.opts.MinLatency + time.Duration(this.rand.Int63n(int64(spread)+1))
+}
+
+// transmit decides the fate of a message sent from one node to
+// another, returning the delay of each copy that will arrive: none
+// if it is lost, two if it is duplicated.
+func (this *Cluster) transmit(from, to int) []time.Duration {
+    faults, ok := this.linkFaults[link{from, to}]
+    if !ok {
+        faults = this.faults
+    }
+
+    // Draw every random number whatever the outcome, so that the
+    // sequence of draws does not depend on the partitions.
+    drop := this.rand.Float64()
+    duplicate := this.rand.Float64()
+    delays := []time.Duration{this.delay(faults), this.delay(faults)}
+
+    if this.cut[link{from, to}] || drop < faults.DropRate {
+        return nil
+    }
+    if duplicate < faults.DuplicateRate {
+        return delays
+    }
+    return delays[:1]
+}
+
+// delay draws the delay of one message under faults.
+func (this *Cluster) delay(faults Faults) time.Duration {
+    delay := this.latency() + faults.Delay
+    reorder := this.rand.Float64()
+    held := time.Duration(0)
+    if faults.ReorderWindow > 0 {
+        held = time.Duration(this.rand.Int63n(int64(faults.ReorderWindow) + 1))
+    }
+    if reorder < faults.ReorderRate {
+        delay += held
+    }
+    return delay
+}
+
+// send delivers a request through the network, passes it to the
+// receiver's Step for each copy that arrives, and sends each
+// response back the same way. The sender sees the first response to
+// arrive, or ErrUnreachable if none arrives within the RPC timeout.
+func (this *Cluster) send(msg raft.Message, reply func(raft.Message, error)) {
+    from, to := this.number(msg.From), this.number(msg.To)
+    if to < 0 {
+        this.after(0, func() { reply(raft.Message{}, raft.ErrUnknownPeer) })
+        return
+    }
+
+    replied := false
+    respond := func(resp raft.Message, err error) {
+        if !replied {
+            replied = true
+            reply(resp, err)
+        }
+    }
+    this.after(this.opts.RPCTimeout, func() { respond(raft.Message{}, ErrUnreachable) })
+
+    for _, delay := range this.transmit(from, to) {
+        this.after(delay, func() {
+            if this.nodes[to].Paused() {
+                return
+            }
+            this.noteMessage(from, to, msg)
+            resp, err := this.nodes[to].Step(msg)
+            for _, delay := range this.transmit(to, from) {
+                this.after(delay, func() {
+                    if !replied && err == nil {
+                        this.noteMessage(to, from, resp)
+                    }
+                    respond(resp, err)
+                })
+            }
+        })
+    }
+}
+
+// event is something scheduled to happen at a virtual time. Events
+// due at the same time run in the order they were scheduled.
+type event struct {
+    at  time.Duration
+    seq uint64
+    fn  func()
+}
+
+type eventQueue []*event
+
+func (this eventQueue) Len() int {
+    return len(this)
+}
+
+func (this eventQueue) Less(i, j int) bool {
+    if this[i].at != this[j].at {
+        return this[i].at < this[j].at
+    }
+    return this[i].seq < this[j].seq
+}
+
+func (this eventQueue) Swap(i, j int) {
+    this[i], this[j] = this[j], this[i]
+}
+
+func (this *eventQueue) Push(x any) {
+    *this = append(*this, x.(*event))
+}
+
+func (this *eventQueue) Pop() any {
+    old := *this
+    event := old[len(old)-1]
+    *this = old[:len(old)-1]
+    return event
+}
@@ -0,0 +1,432 @@
+package raft
+
+import "fmt"
+
+// Snapshotter is implemented by state machines that support log
+// compaction. Snapshot must reflect every command applied so far.
+type Snapshotter interface {
+    Snapshot() ([]byte, error)
+    Restore(snapshot []byte) error
+}
+
+// SnapshotLimiter bounds how many snapshots may be taken or sent
+// with InstallSnapshot at the same time by the nodes that share it.
+// A process hosting many Raft groups shares one limiter between all
+// of them to smooth out disk and network I/O spikes. A nil
+// *SnapshotLimiter imposes no limit.
+type SnapshotLimiter struct {
+    slots chan struct{}
+}
+
+// NewSnapshotLimiter returns a limiter allowing n concurrent
+// snapshot operations.
+func NewSnapshotLimiter(n int) *SnapshotLimiter {
+    return &SnapshotLimiter{slots: make(chan struct{}, n)}
+}
+
+// TryAcquire takes a slot if one is free. Callers that fail simply
+// retry later; Raft snapshots are never urgent enough to block on.
+func (this *SnapshotLimiter) TryAcquire() bool {
+    if this == nil {
+        return true
+    }
+    select {
+    case this.slots <- struct{}{}:
+        return true
+    default:
+        return false
+    }
+}
+
+// Release returns a slot taken by TryAcquire.
+func (this *SnapshotLimiter) Release() {
+    if this == nil {
+        return
+    }
+    <-this.slots
+}
+
+// InUse returns the number of snapshot operations in progress.
+func (this *SnapshotLimiter) InUse() int {
+    if this == nil {
+        return 0
+    }
+    return len(this.slots)
+}
+
+// maybeSnapshot snapshots the state machine and compacts the log
+// if enough applied entries have accumulated, the schedule allows
+// it and the limiter has a free slot. Must be called with this.mu
+// held.
+func (this *Node) maybeSnapshot() {
+    // The sessions already reflect entries queued for the applier.
+    if this.admitted > this.lastApplied {
+        return
+    }
+    entries := this.lastApplied - this.log[0].Index
+    if this.config.Snapshotter == nil && !this.config.Witness || entries <= this.config.SnapshotThreshold {
+        return
+    }
+    if !this.snapshotAllowed(this.clock.Now(), entries) {
+        return
+    }
+    if !this.config.SnapshotLimiter.TryAcquire() {
+        return
+    }
+    defer this.config.SnapshotLimiter.Release()
+    this.takeSnapshot()
+}
+
+// takeSnapshot snapshots the state machine up to lastApplied and
+// compacts the log. Must be called with this.mu held, with no
+// entries queued for the applier.
+//
+// The snapshot is persisted, then archived, before the entries it
+// replaces: if either fails, the entries stay in the log rather
+// than reach the archive twice, or be lost to a restart.
+func (this *Node) takeSnapshot() error {
+    defer this.observeDuration(MetricSnapshotDuration, this.labels(), this.clock.Now())
+    var data []byte
+    if !this.config.Witness {
+        var err error
+        if data, err = this.config.Snapshotter.Snapshot(); err != nil {
+            this.logError("snapshot failed", "index", this.lastApplied, "err", err)
+            return err
+        }
+    }
+    snapshot := this.encodeSnapshot(data)
+    if err := this.persistSnapshot(snapshot); err != nil {
+        return err
+    }
+    if err := this.archiveSnapshot(snapshot); err != nil {
+        return err
+    }
+    if err := this.archiveLog(this.lastApplied); err != nil {
+        return err
+    }
+    this.snapshot = snapshot
+    this.snapshotChecksum = this.checksum
+    this.compactLog(this.lastApplied)
+    return nil
+}
+
+// compactLog discards the log up to and including index, which
+// becomes the new sentinel. Must be called with this.mu held.
+func (this *Node) compactLog(index int) {
+    offset := index - this.log[0].Index
+    this.baseMembers = this.configurationAt(index)
+    sentinel := Entry{Index: index, TermNum: this.log[offset].TermNum}
+    this.log = append([]Entry{sentinel}, this.log[offset+1:]...)
+    this.discardLog(index)
+}
+
+// snapshotTransfer is a snapshot being sent to, or received from,
+// a peer in chunks.
+type snapshotTransfer struct {
+    lastIncludedIndex int
+    lastIncludedTerm  int
+    checksum          uint64
+    data              []byte
+
+    // Offset of the next chunk.
+    offset int
+
+    // Whether the transfer holds a slot of the limiter, which it
+    // gives up while the peer fails to answer.
+    slot bool
+
+    // Paces the chunks sent, with Config.SnapshotRateLimit.
+    rate tokenBucket
+}
+
+// sendInstallSnapshot sends the peer at position i the next chunk of
+// the latest snapshot, sized to fit its message limit and
+// Config.SnapshotChunkSize. A transfer needs a free slot in the
+// limiter, held until the transfer ends or the peer fails to
+// answer, and chunks are held back by Config.SnapshotRateLimit;
+// either way the chunk is retried on the next heartbeat. The peer
+// is probed one request at a time until it accepts entries again.
+// Must be called with this.mu held. It reports whether a request
+// was sent.
+func (this *Node) sendInstallSnapshot(i int) bool {
+    peerId := this.peers[i]
+    limit, ok := this.messageLimit(peerId)
+    if !ok {
+        return false
+    }
+
+    transfer := this.transfers[i]
+    if transfer == nil || !transfer.slot {
+        if !this.config.SnapshotLimiter.TryAcquire() {
+            return false
+        }
+    }
+    if transfer == nil {
+        transfer = &snapshotTransfer{
+            lastIncludedIndex: this.log[0].Index,
+            lastIncludedTerm:  this.log[0].TermNum,
+            checksum:          this.snapshotChecksum,
+            data:              this.snapshot,
+            rate:              tokenBucket{rate: this.config.SnapshotRateLimit},
+        }
+        if this.witnesses[peerId] {
+            transfer.data = witnessSnapshot(transfer.data)
+        }
+        this.transfers[i] = transfer
+    }
+    transfer.slot = true
+
+    size := maxInt(limit-installSnapshotOverhead, 1)
+    if chunk := this.config.SnapshotChunkSize; chunk > 0 {
+        size = minInt(size, chunk)
+    }
+    size = minInt(size, len(transfer.data)-transfer.offset)
+    if size > 0 {
+        if size = transfer.rate.take(this.clock.Now(), size); size == 0 {
+            return false
+        }
+    }
+    end := transfer.offset + size
+    req := InstallSnapshotRequest{
+        Term:              this.currentTerm,
+        LeaderId:          this.id,
+        LastIncludedIndex: transfer.lastIncludedIndex,
+        LastIncludedTerm:  transfer.lastIncludedTerm,
+        Checksum:          transfer.checksum,
+        Offset:            transfer.offset,
+        Data:              transfer.data[transfer.offset:end],
+        Done:              end == len(transfer.data),
+    }
+    epoch := this.epoch[i]
+    this.inflight[i]++
+    this.pipeline[i] = false
+
+    this.transport.InstallSnapshot(peerId, req, func(resp InstallSnapshotResponse, err error) {
+        this.handleInstallSnapshotReply(peerId, epoch, transfer, req, resp, err)
+    })
+    return true
+}
+
+// endTransfer ends the snapshot transfer to the peer at position i,
+// if any, freeing its limiter slot. Must be called with this.mu held.
+func (this *Node) endTransfer(i int) {
+    if transfer := this.transfers[i]; transfer != nil {
+        this.transfers[i] = nil
+        this.releaseTransfer(transfer)
+    }
+}
+
+// releaseTransfer frees the limiter slot transfer holds, if any.
+// Must be called with this.mu held.
+func (this *Node) releaseTransfer(transfer *snapshotTransfer) {
+    if transfer.slot {
+        transfer.slot = false
+        this.config.SnapshotLimiter.Release()
+    }
+}
+
+// endTransfers ends every snapshot transfer, when leadership is lost.
+// Must be called with this.mu held.
+func (this *Node) endTransfers() {
+    for i := range this.transfers {
+        this.endTransfer(i)
+    }
+}
+
+// handleInstallSnapshotReply sends the next chunk of transfer to
+// peer peerId, or once the last chunk has been accepted records that
+// the peer holds everything up to the snapshot. A chunk the peer
+// failed to answer is resent once its backoff has elapsed, resuming
+// the transfer; a transfer the peer rejected starts over.
+func (this *Node) handleInstallSnapshotReply(
+    peerId ServerId,
+    epoch int,
+    transfer *snapshotTransfer,
+    req InstallSnapshotRequest,
+    resp InstallSnapshotResponse,
+    err error) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    if this.shutdown {
+        return
+    }
+    if err == nil {
+        this.testToAbdicateLeadership(resp.Term, "")
+    }
+    if this.nodeType != Leader || this.currentTerm != req.Term {
+        return
+    }
+    i, ok := this.position(peerId)
+    if !ok {
+        return
+    }
+    this.observeReply(i, err)
+    if epoch == this.epoch[i] && this.inflight[i] > 0 {
+        this.inflight[i]--
+    }
+    if this.transfers[i] != transfer {
+        return
+    }
+    if err != nil {
+        this.logWarn("snapshot chunk failed", "peer", peerId,
+            "lastIncludedIndex", req.LastIncludedIndex, "offset", req.Offset, "err", err)
+        this.releaseTransfer(transfer)
+        return
+    }
+    if !resp.Success {
+        this.logWarn("snapshot transfer rejected", "peer", peerId,
+            "lastIncludedIndex", req.LastIncludedIndex, "offset", req.Offset)
+        this.endTransfer(i)
+        return
+    }
+    if !req.Done {
+        transfer.offset += len(req.Data)
+        this.sendInstallSnapshot(i)
+        return
+    }
+
+    this.endTransfer(i)
+    this.matchIndex[i] = maxInt(this.matchIndex[i], req.LastIncludedIndex)
+    this.nextIndex[i] = maxInt(this.nextIndex[i], req.LastIncludedIndex+1)
+    this.advanceCommitIndex()
+
+    // Committing may have changed the membership.
+    if i, ok = this.position(peerId); ok {
+        this.replicateTo(i, false)
+    }
+    this.continueLeaderTransfer()
+}
+
+// InstallSnapshotRPC is invoked by the leader to send a follower
+// that has fallen behind its compacted log a snapshot of the state
+// machine, in chunks (see §7 of the raft paper). It fails if
+// chunks before this one are missing or this node cannot restore
+// the snapshot; a chunk resent because its reply was lost succeeds
+// again.
+func (this *Node) InstallSnapshotRPC(
+    term int,
+    leaderId ServerId,
+    lastIncludedIndex,
+    lastIncludedTerm,
+    offset int,
+    checksum uint64,
+    data []byte,
+    done bool) (termResult int, success bool, err error) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    if this.shutdown {
+        return this.currentTerm, false, nil
+    }
+
+    if err := this.testToAbdicateLeadership(term, leaderId); err != nil {
+        return this.currentTerm, false, err
+    }
+
+    // 1. Reply immediately if term < currentTerm.
+    if term < this.currentTerm {
+        return this.currentTerm, false, nil
+    }
+    this.setLeader(leaderId)
+    this.resetElectionTimer()
+    this.leaderContact = this.clock.Now()
+
+    // Nothing to do if the snapshot is already covered by the
+    // state machine.
+    if lastIncludedIndex <= this.lastApplied {
+        this.incoming = nil
+        return this.currentTerm, true, nil
+    }
+    // Nor can the state machine be reset while the applier has
+    // entries queued; the leader retries once it has drained.
+    if this.config.Snapshotter == nil && !this.config.Witness || this.admitted > this.lastApplied {
+        return this.currentTerm, false, nil
+    }
+
+    // 2. Create new snapshot file if first chunk (offset is 0),
+    //    unless it is the snapshot already being received.
+    incoming := this.incoming
+    receiving := incoming != nil &&
+        incoming.lastIncludedIndex == lastIncludedIndex &&
+        incoming.lastIncludedTerm == lastIncludedTerm
+    if offset == 0 && !receiving {
+        incoming = &snapshotTransfer{
+            lastIncludedIndex: lastIncludedIndex,
+            lastIncludedTerm:  lastIncludedTerm,
+        }
+        this.incoming = incoming
+    } else if !receiving || offset > incoming.offset {
+        return this.currentTerm, false, nil
+    }
+
+    // A chunk resent because its reply was lost holds data
+    // already written, which is skipped.
+    data = data[minInt(incoming.offset-offset, len(data)):]
+
+    // 3. Write data into snapshot file at given offset.
+    incoming.data = append(incoming.data, data...)
+    incoming.offset += len(data)
+
+    // 4. Reply and wait for more data chunks if done is false.
+    if !done {
+        return this.currentTerm, true, nil
+    }
+
+    // 5. Save snapshot file, discard any existing or partial
+    //    snapshot with a smaller index.
+    this.incoming = nil
+
+    // 8. Reset state machine using snapshot contents. This comes
+    //    before the log is touched, so that a snapshot that cannot
+    //    be restored leaves the node as it was. The snapshot is
+    //    persisted first, as takeSnapshot does, so that the entries
+    //    it replaces are only discarded once it is durable.
+    state, err := decodeSnapshot(incoming.data)
+    if err != nil {
+        this.logError("restoring snapshot failed", "lastIncludedIndex", lastIncludedIndex, "err", err)
+        return this.currentTerm, false, nil
+    }
+    if this.config.Witness {
+        incoming.data = witnessSnapshot(incoming.data)
+    }
+    if err := this.persistSnapshot(incoming.data); err != nil {
+        return this.currentTerm, false, fmt.Errorf("%w: %v", ErrPersist, err)
+    }
+    if !this.config.Witness {
+        if err := this.config.Snapshotter.Restore(state.data); err != nil {
+            this.logError("restoring snapshot failed", "lastIncludedIndex", lastIncludedIndex, "err", err)
+            return this.currentTerm, false, nil
+        }
+    }
+
+    // 6. If existing log entry has same index and term as
+    //    snapshot’s last included entry, retain log entries
+    //    following it and reply.
+    // 7. Discard the entire log.
+    if termAt, ok := this.termAt(lastIncludedIndex); ok && termAt == lastIncludedTerm {
+        this.archiveLog(lastIncludedIndex)
+        this.compactLog(lastIncludedIndex)
+    } else {
+        this.truncateLog(this.log[0].Index + 1)
+        this.discardLog(this.log[0].Index)
+        this.log = []Entry{{Index: lastIncludedIndex, TermNum: lastIncludedTerm}}
+    }
+
+    this.clusterEpoch = state.epoch
+    this.sessions = state.sessions
+    this.baseMembers = state.members
+    this.setMembers(this.configurationAt(this.lastLogIndex()))
+    this.snapshot = incoming.data
+    this.snapshotChecksum = checksum
+    this.resetChecksum(checksum)
+    this.commitIndex = maxInt(this.commitIndex, lastIncludedIndex)
+    if lastIncludedIndex > this.lastApplied {
+        this.endWatches(ErrCompacted)
+    }
+    this.lastApplied = lastIncludedIndex
+    this.admitted = lastIncludedIndex
+    this.notifyCommitted()
+    this.notifyApplied()
+    return this.currentTerm, true, nil
+}
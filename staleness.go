@@ -0,0 +1,145 @@
+package raft
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "math"
+    "time"
+)
+
+// ErrStaleRead is returned by CheckStaleRead when the node lags its
+// leader by more than Config.MaxStaleness. Errors matching it are
+// *StaleReadError.
+var ErrStaleRead = errors.New("raft: node is too far behind the leader to serve reads")
+
+// StaleReadError reports how far behind a node rejecting a stale
+// read was.
+type StaleReadError struct {
+    Staleness
+    MaxStaleness time.Duration
+}
+
+func (this *StaleReadError) Error() string {
+    return fmt.Sprintf("%v: lagging by %v and %d entries, bound is %v",
+        ErrStaleRead, this.Lag, this.Entries, this.MaxStaleness)
+}
+
+func (this *StaleReadError) Is(target error) bool {
+    return target == ErrStaleRead
+}
+
+// Staleness describes how far a node's state machine may trail the
+// leader's.
+type Staleness struct {
+    // Time since the node last heard from the leader with everything
+    // the leader had committed applied. Zero on the leader; the
+    // maximum duration if the node has never caught up.
+    Lag time.Duration
+
+    // Entries the leader had committed, when last heard from, that
+    // the node has not yet applied.
+    Entries int
+}
+
+// Staleness returns how far the node trails the leader, as far as it
+// knows.
+func (this *Node) Staleness() Staleness {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    return this.staleness()
+}
+
+// CheckStaleRead returns nil if the node is fresh enough to serve a
+// stale read from its state machine, and a *StaleReadError if it
+// lags by more than Config.MaxStaleness. Every node passes when
+// MaxStaleness is zero, except witnesses, which fail with
+// ErrWitness.
+func (this *Node) CheckStaleRead() error {
+    if this.config.Witness {
+        return ErrWitness
+    }
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    bound := this.config.MaxStaleness
+    staleness := this.staleness()
+    if bound > 0 && staleness.Lag > bound {
+        return &StaleReadError{Staleness: staleness, MaxStaleness: bound}
+    }
+    return nil
+}
+
+// WaitForStaleRead blocks until the node is fresh enough to serve a
+// stale read, as CheckStaleRead checks, or ctx is done. In the
+// latter case the *StaleReadError is returned wrapped with the error
+// of ctx.
+func (this *Node) WaitForStaleRead(ctx context.Context) error {
+    for {
+        err := this.CheckStaleRead()
+        var stale *StaleReadError
+        if !errors.As(err, &stale) {
+            return err
+        }
+
+        this.mu.Lock()
+        ch := make(chan struct{})
+        this.freshWaiters = append(this.freshWaiters, ch)
+        this.mu.Unlock()
+
+        select {
+        case <-ch:
+        case <-ctx.Done():
+            this.mu.Lock()
+            this.dropFreshWaiter(ch)
+            this.mu.Unlock()
+            return fmt.Errorf("%w: %w", err, ctx.Err())
+        }
+    }
+}
+
+// notifyFresh wakes the callers of WaitForStaleRead to check again.
+// Must be called with this.mu held.
+func (this *Node) notifyFresh() {
+    for _, waiter := range this.freshWaiters {
+        close(waiter)
+    }
+    this.freshWaiters = nil
+}
+
+// dropFreshWaiter forgets a waiter that gave up. Must be called with
+// this.mu held.
+func (this *Node) dropFreshWaiter(ch chan struct{}) {
+    for i, waiter := range this.freshWaiters {
+        if waiter == ch {
+            this.freshWaiters = append(this.freshWaiters[:i], this.freshWaiters[i+1:]...)
+            return
+        }
+    }
+}
+
+// Must be called with this.mu held.
+func (this *Node) staleness() Staleness {
+    if this.nodeType == Leader {
+        return Staleness{}
+    }
+    staleness := Staleness{
+        Lag:     time.Duration(math.MaxInt64),
+        Entries: maxInt(this.leaderCommit-this.lastApplied, 0),
+    }
+    if !this.caughtUp.IsZero() {
+        staleness.Lag = this.clock.Now().Sub(this.caughtUp)
+    }
+    return staleness
+}
+
+// noteLeaderCommit records the commit index announced by the
+// leader, after applying what it allows. Must be called with
+// this.mu held.
+func (this *Node) noteLeaderCommit(leaderCommit int) {
+    this.leaderCommit = maxInt(this.leaderCommit, leaderCommit)
+    if this.lastApplied >= this.leaderCommit {
+        this.caughtUp = this.clock.Now()
+        this.notifyFresh()
+    }
+}
@@ -0,0 +1,319 @@
+package raft
+
+import (
+    "context"
+    "encoding/binary"
+    "errors"
+    "time"
+)
+
+// ErrStandby is returned for proposals to a standby cluster, which
+// only accepts the entries it mirrors from its primary.
+var ErrStandby = errors.New("raft: cluster is a standby")
+
+// ErrCompacted is returned by ReadCommitted for entries that have
+// been compacted into a snapshot.
+var ErrCompacted = errors.New("raft: entries compacted")
+
+// MirrorClientId is the client session in which a standby cluster
+// records the primary's entries it has applied. The session exists
+// for as long as the cluster is a standby.
+const MirrorClientId = -1
+
+// ReadCommitted returns up to max committed entries following index
+// after, for mirroring the log elsewhere. It fails with ErrCompacted
+// if some of them are only held in a snapshot.
+func (this *Node) ReadCommitted(after, max int) ([]Entry, error) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    if after < this.log[0].Index {
+        return nil, ErrCompacted
+    }
+    last := minInt(this.commitIndex, after+max)
+    if last <= after {
+        return nil, nil
+    }
+    return this.readEntries(after+1, last)
+}
+
+// ErrNotStandby is returned for promoting a cluster that is not a
+// standby.
+var ErrNotStandby = errors.New("raft: cluster is not a standby")
+
+// DRState is a node's view of its cluster's disaster recovery role,
+// for orchestration tools.
+type DRState struct {
+    // Whether the cluster is a standby, refusing client writes.
+    Standby bool
+
+    // Number of the latest promotion or demotion the cluster has
+    // applied. Of two clusters that both accept writes, the one
+    // with the lower epoch was fenced and should be discarded.
+    Epoch int
+
+    // While a standby, the index in the primary's log up to which
+    // the cluster has mirrored it.
+    Mirrored int
+}
+
+// DRState returns the node's view of its cluster's disaster
+// recovery role.
+func (this *Node) DRState() DRState {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    state := DRState{Epoch: this.clusterEpoch}
+    if session, ok := this.sessions[MirrorClientId]; ok {
+        state.Standby = true
+        state.Mirrored = session.sequence
+    }
+    return state
+}
+
+// Standby reports whether the node belongs to a standby cluster that
+// has not been promoted.
+func (this *Node) Standby() bool {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    return this.standby()
+}
+
+// standby reports whether the cluster is a standby. Must be called
+// with this.mu held.
+func (this *Node) standby() bool {
+    _, ok := this.sessions[MirrorClientId]
+    return ok
+}
+
+// PromoteStandby ends standby mode for the whole cluster, raising
+// its epoch to at least epoch: once the returned future resolves,
+// mirrored entries are refused and client proposals are accepted.
+// Mirror.PromoteStandbyCluster fences the primary first; call this
+// directly only when the primary is lost, with an epoch above the
+// primary's last known one.
+func (this *Node) PromoteStandby(epoch int) *ProposeFuture {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    future := newProposeFuture(EntryPromote, encodeFence(epoch, 0))
+    if !this.shutdown && !this.standby() {
+        future.respond(ErrNotStandby)
+        return future
+    }
+    this.enqueue(future)
+    return future
+}
+
+// DemoteToStandby turns the whole cluster into a standby, raising
+// its epoch to at least epoch: once the returned future resolves,
+// client proposals fail with ErrStandby, and a Mirror resumes
+// copying from its new primary after index after. A demoted cluster
+// can mirror the standby that replaced it from the index where that
+// was promoted, as both then hold the same state.
+func (this *Node) DemoteToStandby(epoch, after int) *ProposeFuture {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    future := newProposeFuture(EntryDemote, encodeFence(epoch, after))
+    this.enqueue(future)
+    return future
+}
+
+// encodeFence encodes the command of a promotion or demotion.
+func encodeFence(epoch, after int) []byte {
+    buf := binary.AppendUvarint(nil, uint64(epoch))
+    return binary.AppendUvarint(buf, uint64(after))
+}
+
+// decodeFence decodes the command of a promotion or demotion.
+func decodeFence(command []byte) (epoch, after int) {
+    value, n := binary.Uvarint(command)
+    if n <= 0 {
+        return 0, 0
+    }
+    epoch = int(value)
+    value, n = binary.Uvarint(command[n:])
+    if n <= 0 {
+        return epoch, 0
+    }
+    return epoch, int(value)
+}
+
+// MirrorSource is where a Mirror reads the primary's committed log.
+// A *Node of the primary cluster is one.
+type MirrorSource interface {
+    ReadCommitted(after, max int) ([]Entry, error)
+}
+
+// FencedSource is a MirrorSource that a Mirror can fence, for
+// PromoteStandbyCluster. A *Node of the primary cluster is one.
+type FencedSource interface {
+    MirrorSource
+    DRState() DRState
+    DemoteToStandby(epoch, after int) *ProposeFuture
+}
+
+// Entries a Mirror reads per round.
+const mirrorBatch = 256
+
+// Mirror keeps a standby cluster, created with Config.Standby, a
+// copy of a primary cluster by proposing the primary's committed
+// commands to it, asynchronously and in log order. A standby in
+// another region thus serves as a disaster recovery copy without
+// the primary's quorum spanning regions.
+//
+// Run a Mirror beside every node of the standby cluster: only the
+// one beside the leader copies. Progress is recorded in the
+// standby's log, so a new leader resumes where the last one
+// stopped. Sessions are not carried over: a command the primary
+// applied once despite being retried within a session is applied
+// as many times as it was logged.
+type Mirror struct {
+    source   MirrorSource
+    target   *Node
+    interval time.Duration
+
+    stop chan struct{}
+    done chan struct{}
+}
+
+// NewMirror returns a Mirror copying from source to target every
+// interval once started.
+func NewMirror(source MirrorSource, target *Node, interval time.Duration) *Mirror {
+    return &Mirror{source: source, target: target, interval: interval}
+}
+
+// Start copies from a background goroutine until Stop is called.
+func (this *Mirror) Start() {
+    if this.stop != nil {
+        return
+    }
+    this.stop = make(chan struct{})
+    this.done = make(chan struct{})
+    go func() {
+        defer close(this.done)
+        ticker := this.target.clock.NewTicker(this.interval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ticker.C():
+                if err := this.Copy(); err != nil {
+                    this.target.mu.Lock()
+                    this.target.logWarn("mirroring failed", "err", err)
+                    this.target.mu.Unlock()
+                }
+            case <-this.stop:
+                return
+            }
+        }
+    }()
+}
+
+// Stop halts the goroutine started by Start and waits for it.
+func (this *Mirror) Stop() {
+    if this.stop == nil {
+        return
+    }
+    close(this.stop)
+    <-this.done
+    this.stop = nil
+}
+
+// Copy proposes to the standby the next batch of the primary's
+// commands and waits for them to be applied. It does nothing unless
+// the target is the leader of a standby.
+func (this *Mirror) Copy() error {
+    _, err := this.copy()
+    return err
+}
+
+// copy is Copy, returning the index in the primary's log up to
+// which the standby is known to be a copy.
+func (this *Mirror) copy() (int, error) {
+    target := this.target
+    target.mu.Lock()
+    session, ok := target.sessions[MirrorClientId]
+    leader := target.nodeType == Leader
+    var after int
+    if ok {
+        after = session.sequence
+    }
+    target.mu.Unlock()
+    if !ok || !leader {
+        return after, nil
+    }
+
+    // Read past entries without commands, which are not proposed
+    // and so do not advance the recorded progress.
+    var last *ProposeFuture
+    for last == nil {
+        entries, err := this.source.ReadCommitted(after, mirrorBatch)
+        if err != nil || len(entries) == 0 {
+            return after, err
+        }
+        for _, entry := range entries {
+            if entry.Type == EntryNormal {
+                last = target.ProposeSession(context.Background(), MirrorClientId, entry.Index, entry.Command)
+            }
+        }
+        after = entries[len(entries)-1].Index
+    }
+    if err := last.Error(); err != nil && !errors.Is(err, ErrStaleSequence) {
+        return 0, err
+    }
+    return after, nil
+}
+
+// PromoteStandbyCluster fails over from the primary to the standby.
+// It stops the Mirror, demotes the primary to a standby under a new
+// epoch so that it refuses writes, copies what the primary committed
+// before that, and promotes the standby under the same epoch. The
+// demoted primary is left ready to mirror the promoted standby. It
+// must be called on the Mirror beside the standby's leader, and the
+// source must be a FencedSource.
+//
+// If it fails before the standby is promoted, the primary may be
+// left demoted; Node.PromoteStandby restores it.
+func (this *Mirror) PromoteStandbyCluster(ctx context.Context) error {
+    this.Stop()
+    source, ok := this.source.(FencedSource)
+    if !ok {
+        return errors.New("raft: mirror source cannot be fenced")
+    }
+    state := this.target.DRState()
+    if !state.Standby {
+        return ErrNotStandby
+    }
+    epoch := maxInt(state.Epoch, source.DRState().Epoch) + 1
+
+    fence := source.DemoteToStandby(epoch, 0)
+    if err := waitFuture(ctx, fence); err != nil {
+        return err
+    }
+    for mirrored := 0; mirrored < fence.Index(); {
+        var err error
+        if mirrored, err = this.copy(); err != nil {
+            return err
+        }
+        if err := ctx.Err(); err != nil {
+            return err
+        }
+    }
+
+    promotion := this.target.PromoteStandby(epoch)
+    if err := waitFuture(ctx, promotion); err != nil {
+        return err
+    }
+    return waitFuture(ctx, source.DemoteToStandby(epoch, promotion.Index()))
+}
+
+// waitFuture waits for future to resolve, or ctx to end.
+func waitFuture(ctx context.Context, future *ProposeFuture) error {
+    select {
+    case <-future.Done():
+        return future.Error()
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
@@ -0,0 +1,221 @@
+package raft
+
+import (
+    "strconv"
+    "time"
+)
+
+// Status is a snapshot of a node's state.
+type Status struct {
+    Id   ServerId
+    Term int
+    Role NodeType
+
+    // Where the node runs, as the configuration records it.
+    Locality Locality
+
+    // Whether the node is being drained for maintenance.
+    Draining bool
+
+    // Leader of the current term, if known, else empty, and where
+    // it can be reached, as Leader returns them.
+    LeaderId      ServerId
+    LeaderAddress ServerAddress
+
+    // When the node last heard from the leader, as LastContact.
+    LastContact time.Time
+
+    CommitIndex  int
+    LastApplied  int
+    LastLogIndex int
+    LastLogTerm  int
+
+    // Checksum of the node's configuration, the same on every
+    // member of a cluster that agrees on its membership.
+    ConfigurationIndex    int
+    ConfigurationChecksum uint64
+
+    // Replication progress of every other node, while this node is
+    // the leader.
+    Peers []PeerStatus
+}
+
+// PeerStatus is the leader's view of a peer.
+type PeerStatus struct {
+    Id         ServerId
+    NextIndex  int
+    MatchIndex int
+
+    // When the peer last answered an AppendEntries or
+    // InstallSnapshot; zero if it has not this term.
+    LastContact time.Time
+
+    // Whether the peer answered the last RPC, and the RPCs it has
+    // failed to answer in a row.
+    Reachable bool
+    Failures  int
+
+    // How far the peer is behind the leader's log, in entries and
+    // in bytes. Entries compacted away count as the size of the
+    // snapshot that replaced them.
+    EntriesBehind int
+    BytesBehind   int
+
+    // Whether a snapshot is being sent to the peer, and how much
+    // of it has been.
+    SendingSnapshot bool
+    SnapshotSent    int
+    SnapshotSize    int
+
+    // The protocol version agreed with the peer, or -1 if none has
+    // been yet or they share none.
+    ProtocolVersion int
+
+    // Whether the peer said it is a witness when it last
+    // handshook, and whether it is a read-only replica.
+    Witness bool
+    Replica bool
+
+    // Where the peer runs, as the configuration records it.
+    Locality Locality
+}
+
+// LastContact returns when the node last heard from the leader,
+// through an AppendEntries or InstallSnapshot it accepted, so that
+// applications can tell how stale its view may be. A leader returns
+// the current time; a node that has not heard from a leader returns
+// the zero time.
+func (this *Node) LastContact() time.Time {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    return this.lastLeaderContact()
+}
+
+// lastLeaderContact is LastContact. Must be called with this.mu
+// held.
+func (this *Node) lastLeaderContact() time.Time {
+    if this.nodeType == Leader {
+        return this.clock.Now()
+    }
+    return this.leaderContact
+}
+
+// Status returns the node's current state.
+func (this *Node) Status() Status {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    configuration := this.configuration()
+    status := Status{
+        Id:                    this.id,
+        Term:                  this.currentTerm,
+        Role:                  this.nodeType,
+        Locality:              this.locality(this.id),
+        Draining:              this.draining,
+        LeaderId:              this.leaderId,
+        LeaderAddress:         this.leaderAddress(),
+        LastContact:           this.lastLeaderContact(),
+        CommitIndex:           this.commitIndex,
+        LastApplied:           this.lastApplied,
+        LastLogIndex:          this.lastLogIndex(),
+        LastLogTerm:           this.lastLogTerm(),
+        ConfigurationIndex:    configuration.Index,
+        ConfigurationChecksum: configuration.Checksum(),
+    }
+    if this.nodeType == Leader {
+        for i, peer := range this.peers {
+            if peer == this.id {
+                continue
+            }
+            peerStatus := PeerStatus{
+                Id:            peer,
+                NextIndex:     this.nextIndex[i],
+                MatchIndex:    this.matchIndex[i],
+                LastContact:   this.lastContact[i],
+                Reachable:     this.reachable[i],
+                Failures:      this.failures[i],
+                EntriesBehind: this.lastLogIndex() - this.matchIndex[i],
+                BytesBehind:   this.bytesBehind(this.matchIndex[i]),
+            }
+            peerStatus.Witness = this.witnesses[peer]
+            peerStatus.Replica = !this.isVoter(peer)
+            peerStatus.Locality = this.locality(peer)
+            peerStatus.ProtocolVersion = -1
+            if version, ok := this.protocolVersions[peer]; ok {
+                peerStatus.ProtocolVersion = version
+            }
+            if transfer := this.transfers[i]; transfer != nil {
+                peerStatus.SendingSnapshot = true
+                peerStatus.SnapshotSent = transfer.offset
+                peerStatus.SnapshotSize = len(transfer.data)
+            }
+            status.Peers = append(status.Peers, peerStatus)
+        }
+    }
+    return status
+}
+
+// bytesBehind estimates the bytes a peer that has stored the log
+// up to matchIndex must be sent to catch up. Must be called with
+// this.mu held.
+func (this *Node) bytesBehind(matchIndex int) int {
+    bytes := 0
+    if matchIndex < this.log[0].Index {
+        bytes += len(this.snapshot)
+    }
+    for index := maxInt(matchIndex, this.log[0].Index) + 1; index <= this.lastLogIndex(); index++ {
+        entry, _ := this.entryAt(index)
+        bytes += entrySize(entry)
+    }
+    return bytes
+}
+
+// Stats returns the node's status flattened into strings, for
+// logging and diagnostics endpoints.
+func (this *Node) Stats() map[string]string {
+    status := this.Status()
+    this.mu.Lock()
+    peers := len(this.peers)
+    if this.isMember() {
+        peers--
+    }
+    this.mu.Unlock()
+    stats := map[string]string{
+        "id":             string(status.Id),
+        "term":           strconv.Itoa(status.Term),
+        "role":           status.Role.String(),
+        "leader_id":      string(status.LeaderId),
+        "leader_address": string(status.LeaderAddress),
+        "commit_index":   strconv.Itoa(status.CommitIndex),
+        "last_applied":   strconv.Itoa(status.LastApplied),
+        "last_log_index": strconv.Itoa(status.LastLogIndex),
+        "last_log_term":  strconv.Itoa(status.LastLogTerm),
+        "num_peers":      strconv.Itoa(peers),
+
+        "configuration_index":    strconv.Itoa(status.ConfigurationIndex),
+        "configuration_checksum": strconv.FormatUint(status.ConfigurationChecksum, 16),
+    }
+    switch {
+    case status.Role == Leader:
+        stats["last_contact"] = "0"
+    case status.LastContact.IsZero():
+        stats["last_contact"] = "never"
+    default:
+        stats["last_contact"] = this.clock.Now().Sub(status.LastContact).String()
+    }
+    for _, peer := range status.Peers {
+        prefix := "peer_" + string(peer.Id) + "_"
+        stats[prefix+"next_index"] = strconv.Itoa(peer.NextIndex)
+        stats[prefix+"match_index"] = strconv.Itoa(peer.MatchIndex)
+        stats[prefix+"reachable"] = strconv.FormatBool(peer.Reachable)
+        stats[prefix+"bytes_behind"] = strconv.Itoa(peer.BytesBehind)
+        stats[prefix+"protocol_version"] = strconv.Itoa(peer.ProtocolVersion)
+        if !peer.LastContact.IsZero() {
+            stats[prefix+"last_contact"] = this.clock.Now().Sub(peer.LastContact).String()
+        }
+        if peer.SendingSnapshot {
+            stats[prefix+"snapshot_sent"] = strconv.Itoa(peer.SnapshotSent) + "/" + strconv.Itoa(peer.SnapshotSize)
+        }
+    }
+    return stats
+}
@@ -0,0 +1,356 @@
+package raft
+
+import (
+    "errors"
+    "fmt"
+)
+
+// ErrNotFound is returned by stores for missing keys and entries.
+var ErrNotFound = errors.New("raft: not found")
+
+// ErrPersist is returned by RPC handlers that could not persist the
+// term or vote they were about to reply with. The request failed,
+// as if it never reached the node, and may be retried.
+var ErrPersist = errors.New("raft: persisting state failed")
+
+// Keys under which a node keeps its persistent state in its
+// StableStore.
+const (
+    keyCurrentTerm = "CurrentTerm"
+    keyVotedFor    = "VotedFor"
+    keySnapshot    = "Snapshot"
+)
+
+// LogStore persists log entries. Indexes are contiguous between
+// FirstIndex and LastIndex, which are both 0 for an empty store.
+type LogStore interface {
+    FirstIndex() (int, error)
+    LastIndex() (int, error)
+    GetLog(index int) (Entry, error)
+
+    // StoreLogs writes entries, replacing any at the same indexes.
+    StoreLogs(entries []Entry) error
+
+    // DeleteRange removes the entries from min to max inclusive.
+    DeleteRange(min, max int) error
+}
+
+// StableStore persists small values, such as the current term and
+// vote, that must survive restarts.
+type StableStore interface {
+    Set(key string, value []byte) error
+    Get(key string) ([]byte, error)
+    SetInt(key string, value int) error
+    GetInt(key string) (int, error)
+}
+
+// SnapshotStore persists the latest snapshot a node has taken or
+// installed, which it restarts from.
+type SnapshotStore interface {
+    // SaveSnapshot replaces the stored snapshot.
+    SaveSnapshot(snapshot []byte) error
+
+    // LoadSnapshot returns the stored snapshot, or ErrNotFound if
+    // none was saved.
+    LoadSnapshot() ([]byte, error)
+}
+
+// The helpers below write through to storage, and to the stores
+// being migrated to, if any.
+
+// persistState writes currentTerm and votedFor through to stable
+// storage, failing with ErrPersist if it cannot: the node must not
+// act on either, by replying to an RPC or campaigning, before it
+// has. Must be called with this.mu held, whenever either changes.
+func (this *Node) persistState() error {
+    if migration := this.migration; migration != nil {
+        migration.fail(migration.stable.SetInt(keyCurrentTerm, this.currentTerm))
+        migration.fail(migration.stable.Set(keyVotedFor, []byte(this.votedFor)))
+    }
+    if err := this.stable.SetInt(keyCurrentTerm, this.currentTerm); err != nil {
+        this.logError("persisting term failed", "term", this.currentTerm, "err", err)
+        return fmt.Errorf("%w: %v", ErrPersist, err)
+    }
+    if err := this.stable.Set(keyVotedFor, []byte(this.votedFor)); err != nil {
+        this.logError("persisting vote failed", "votedFor", this.votedFor, "err", err)
+        return fmt.Errorf("%w: %v", ErrPersist, err)
+    }
+    return nil
+}
+
+// persistSnapshot writes the node's latest snapshot through to its
+// SnapshotStore, or to the StableStore if it has none, encrypted if
+// the node encrypts its log. Must be called with this.mu held,
+// before the entries the snapshot covers are discarded.
+func (this *Node) persistSnapshot(snapshot []byte) error {
+    var err error
+    if this.config.Encryption != nil {
+        if snapshot, err = encryptSnapshot(this.config.Encryption, snapshot); err != nil {
+            this.logError("encrypting snapshot failed", "err", err)
+            return err
+        }
+    }
+    if migration := this.migration; migration != nil && this.config.SnapshotStore == nil {
+        migration.fail(migration.stable.Set(keySnapshot, snapshot))
+    }
+    if store := this.config.SnapshotStore; store != nil {
+        err = store.SaveSnapshot(snapshot)
+    } else {
+        err = this.stable.Set(keySnapshot, snapshot)
+    }
+    if err != nil {
+        this.logError("persisting snapshot failed", "err", err)
+    }
+    return err
+}
+
+// loadSnapshot returns the snapshot persistSnapshot last wrote, or
+// nil if there is none.
+func (this *Node) loadSnapshot() ([]byte, error) {
+    var snapshot []byte
+    var err error
+    if store := this.config.SnapshotStore; store != nil {
+        snapshot, err = store.LoadSnapshot()
+    } else {
+        snapshot, err = this.stable.Get(keySnapshot)
+    }
+    if errors.Is(err, ErrNotFound) {
+        return nil, nil
+    }
+    if err != nil || this.config.Encryption == nil {
+        return snapshot, err
+    }
+    return decryptSnapshot(this.config.Encryption, snapshot)
+}
+
+// recoverState rebuilds the node's state from its stores when it
+// restarts: the term and vote, then the latest snapshot, which is
+// restored to the state machine, then the entries of the log store
+// past it. The entries are applied again once the node learns how
+// far they are committed. Called by NewNode.
+func (this *Node) recoverState() error {
+    term, err := this.stable.GetInt(keyCurrentTerm)
+    if err != nil && !errors.Is(err, ErrNotFound) {
+        return fmt.Errorf("reading term: %w", err)
+    }
+    votedFor, err := this.stable.Get(keyVotedFor)
+    if err != nil && !errors.Is(err, ErrNotFound) {
+        return fmt.Errorf("reading vote: %w", err)
+    }
+    this.currentTerm, this.votedFor = term, ServerId(votedFor)
+
+    snapshot, err := this.loadSnapshot()
+    if err != nil {
+        return fmt.Errorf("reading snapshot: %w", err)
+    }
+    if snapshot != nil {
+        state, err := decodeSnapshot(snapshot)
+        if err != nil {
+            return fmt.Errorf("reading snapshot: %w", err)
+        }
+        if !this.config.Witness {
+            if this.config.Snapshotter == nil {
+                return fmt.Errorf("%w: restoring a snapshot needs a Snapshotter", ErrInvalidConfig)
+            }
+            if err := this.config.Snapshotter.Restore(state.data); err != nil {
+                return fmt.Errorf("restoring snapshot: %w", err)
+            }
+        }
+        this.log = []Entry{{Index: state.index, TermNum: state.term}}
+        this.commitIndex, this.lastApplied, this.admitted = state.index, state.index, state.index
+        this.clusterEpoch = state.epoch
+        this.sessions = state.sessions
+        this.baseMembers = state.members
+        this.snapshot = snapshot
+        this.snapshotChecksum = state.checksum
+        this.resetChecksum(state.checksum)
+    }
+    if err := this.replayLog(); err != nil {
+        return err
+    }
+
+    // A configuration left by RecoverCluster overrides those in the
+    // log up to the index it takes effect at, unless a snapshot has
+    // since covered a newer one.
+    recovered, ok, err := recoveredConfiguration(this.stable)
+    if err != nil {
+        return fmt.Errorf("reading recovered configuration: %w", err)
+    }
+    if ok && (recovered.Index == 0 || recovered.Index >= this.baseMembers.Index) {
+        // A log compacted away entirely leaves RecoverCluster no
+        // index, so the configuration takes effect at the snapshot,
+        // and is stored again with its index so that it is not
+        // taken over newer ones on later restarts.
+        if recovered.Index < this.log[0].Index {
+            recovered.Index, recovered.Term = this.log[0].Index, this.log[0].TermNum
+            if err := storeRecoveredConfiguration(this.stable, recovered); err != nil {
+                return fmt.Errorf("storing recovered configuration: %w", err)
+            }
+        }
+        this.baseMembers = recovered
+    }
+    this.setMembers(this.configurationAt(this.lastLogIndex()))
+
+    if this.currentTerm > 0 || this.lastLogIndex() > 0 {
+        this.logInfo("recovered state", "term", this.currentTerm, "votedFor", this.votedFor,
+            "snapshotIndex", this.log[0].Index, "lastIndex", this.lastLogIndex(), "members", this.peers)
+    }
+    return nil
+}
+
+// replayLog loads the entries of the log store past the snapshot
+// into the log, without writing them back. Called by recoverState.
+func (this *Node) replayLog() error {
+    first, err := this.logs.FirstIndex()
+    if err != nil {
+        return fmt.Errorf("reading log: %w", err)
+    }
+    last, err := this.logs.LastIndex()
+    if err != nil {
+        return fmt.Errorf("reading log: %w", err)
+    }
+    base := this.log[0]
+    if last <= base.Index {
+        return nil
+    }
+    if first > base.Index+1 {
+        return fmt.Errorf("reading log: entries %d to %d are neither in the log nor in a snapshot", base.Index+1, first-1)
+    }
+
+    // The entries were written before the snapshot was installed,
+    // and belong to another history if they disagree with it.
+    if first <= base.Index && base.Index > 0 {
+        entry, err := this.logs.GetLog(base.Index)
+        if err != nil {
+            return fmt.Errorf("reading log: %w", err)
+        }
+        if entry.TermNum != base.TermNum {
+            this.logWarn("discarding log conflicting with snapshot", "index", base.Index,
+                "term", entry.TermNum, "snapshotTerm", base.TermNum)
+            return this.logs.DeleteRange(first, last)
+        }
+    }
+    for index := base.Index + 1; index <= last; index++ {
+        entry, err := this.logs.GetLog(index)
+        if err != nil {
+            return fmt.Errorf("reading log: entry %d: %w", index, err)
+        }
+        this.log = append(this.log, heldEntry(entry))
+    }
+    return nil
+}
+
+// appendLog appends entries to the log store and then the log,
+// failing with ErrPersist, and leaving the log as it was, if they
+// cannot be stored. Must be called with this.mu held.
+func (this *Node) appendLog(entries ...Entry) error {
+    if err := this.logs.StoreLogs(entries); err != nil {
+        this.logError("persisting entries failed", "index", entries[0].Index, "err", err)
+        return fmt.Errorf("%w: %v", ErrPersist, err)
+    }
+    if migration := this.migration; migration != nil {
+        migration.fail(migration.logs.StoreLogs(entries))
+    }
+    for _, entry := range entries {
+        this.log = append(this.log, heldEntry(entry))
+        if this.nodeType == Leader {
+            this.traceTLA("Replicate", nil)
+        }
+    }
+    this.appendMembers(entries)
+    return nil
+}
+
+// heldEntry returns entry as the log holds it in memory: without its
+// command if it is a normal entry, as a witness keeps it. Commands
+// are read back from the LogStore when they are applied or
+// replicated, through its LogCache if Config.LogCacheSize is set, so
+// that a long log does not take up memory. Configuration and fence
+// entries are held whole, being small and read while the node
+// follows membership.
+func heldEntry(entry Entry) Entry {
+    return witnessEntry(entry)
+}
+
+// readEntry returns the entry at index, reading its command back
+// from the LogStore if the log holds it without, or ErrNotFound if
+// the log does not hold it. The sentinel is returned for the base
+// index. Must be called with this.mu held.
+func (this *Node) readEntry(index int) (Entry, error) {
+    offset := index - this.log[0].Index
+    if offset < 0 || offset >= len(this.log) {
+        return Entry{}, ErrNotFound
+    }
+    entry := this.log[offset]
+    if offset == 0 || entry.Type != EntryNormal || this.config.Witness {
+        return entry, nil
+    }
+    stored, err := this.logs.GetLog(index)
+    if err != nil {
+        return Entry{}, err
+    }
+    if stored.Index != index || stored.TermNum != entry.TermNum {
+        return Entry{}, fmt.Errorf("raft: log store holds entry %d of term %d at %d, want term %d",
+            stored.Index, stored.TermNum, index, entry.TermNum)
+    }
+    return stored, nil
+}
+
+// readEntries returns the entries from first to last, which the log
+// must hold. Must be called with this.mu held.
+func (this *Node) readEntries(first, last int) ([]Entry, error) {
+    entries := make([]Entry, 0, last-first+1)
+    for index := first; index <= last; index++ {
+        entry, err := this.readEntry(index)
+        if err != nil {
+            return nil, err
+        }
+        entries = append(entries, entry)
+    }
+    return entries, nil
+}
+
+// abdicate steps down a leader that could not persist its own
+// entries: followers must not commit entries the leader may have
+// lost. Must be called with this.mu held.
+func (this *Node) abdicate(err error) {
+    this.logError("stepping down, entries not persisted", "term", this.currentTerm, "err", err)
+    this.becomeFollower()
+}
+
+// truncateLog discards the entries from index on. Must be called
+// with this.mu held.
+func (this *Node) truncateLog(index int) {
+    last := this.lastLogIndex()
+    this.log = this.log[:index-this.log[0].Index]
+    if this.members.Index >= index {
+        this.setMembers(this.configurationAt(this.lastLogIndex()))
+    }
+    if err := this.logs.DeleteRange(index, last); err != nil {
+        this.logError("deleting entries failed", "index", index, "err", err)
+    }
+    if migration := this.migration; migration != nil {
+        migration.fail(migration.logs.DeleteRange(index, last))
+    }
+}
+
+// discardLog drops the stored entries up to and including index,
+// once they are covered by a snapshot. Must be called with this.mu
+// held.
+func (this *Node) discardLog(index int) {
+    if err := discardStored(this.logs, index); err != nil {
+        this.logError("deleting compacted entries failed", "index", index, "err", err)
+    }
+    if migration := this.migration; migration != nil {
+        migration.fail(discardStored(migration.logs, index))
+    }
+}
+
+// discardStored drops the entries of logs up to and including index.
+func discardStored(logs LogStore, index int) error {
+    first, err := logs.FirstIndex()
+    if err == nil && first > 0 && first <= index {
+        err = logs.DeleteRange(first, index)
+    }
+    return err
+}
@@ -0,0 +1,99 @@
+package raft
+
+import "time"
+
+// Number of completed leadership tenures a node remembers.
+const tenureHistory = 16
+
+// FlapWarning reports that a node keeps losing leadership soon
+// after winning it.
+type FlapWarning struct {
+    NodeId ServerId
+
+    // Term of the tenure that triggered the warning.
+    Term int
+
+    // The consecutive short tenures, oldest first.
+    Tenures []time.Duration
+
+    // Tenures shorter than this counted as short.
+    MinTenure time.Duration
+
+    // Likely reasons, most likely first.
+    SuggestedCauses []string
+}
+
+// Causes attached to every FlapWarning.
+var flapCauses = []string{
+    "election timeout too tight for the network round trip or heartbeat interval",
+    "slow disk or state machine stalling the leader's heartbeats",
+    "packet loss or a partial partition between the leader and its followers",
+}
+
+// FlapDetector warns when leadership flaps: when a node's tenure as
+// leader falls below MinTenure MaxShortTenures times in a row. Each
+// node counts its own short tenures, so one detector may be shared.
+type FlapDetector struct {
+    MinTenure       time.Duration
+    MaxShortTenures int
+
+    // Called with every warning. It runs with the node's lock held
+    // and must not call back into the Node.
+    OnFlap func(FlapWarning)
+}
+
+// observeTenure records a completed tenure and warns if leadership is
+// flapping. The count restarts after every warning. Must be called
+// with this.mu held.
+func (this *Node) observeTenure(detector *FlapDetector, tenure time.Duration) {
+    if tenure >= detector.MinTenure {
+        this.shortTenures = nil
+        return
+    }
+    this.shortTenures = append(this.shortTenures, tenure)
+    if len(this.shortTenures) < detector.MaxShortTenures {
+        return
+    }
+    warning := FlapWarning{
+        NodeId:          this.id,
+        Term:            this.currentTerm,
+        Tenures:         this.shortTenures,
+        MinTenure:       detector.MinTenure,
+        SuggestedCauses: append([]string(nil), flapCauses...),
+    }
+    this.shortTenures = nil
+
+    if detector.OnFlap != nil {
+        detector.OnFlap(warning)
+    }
+}
+
+// Tenures returns how long this node held leadership each time,
+// for up to the last 16 completed tenures, oldest first, and how
+// long it has been leader if it is now.
+func (this *Node) Tenures() (completed []time.Duration, current time.Duration) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    completed = append([]time.Duration(nil), this.tenures...)
+    if this.nodeType == Leader {
+        current = this.clock.Now().Sub(this.leaderSince)
+    }
+    return completed, current
+}
+
+// endTenure records the end of this node's leadership. Must be
+// called with this.mu held, before leaving the Leader role.
+func (this *Node) endTenure() {
+    if this.nodeType != Leader {
+        return
+    }
+    tenure := this.clock.Now().Sub(this.leaderSince)
+    this.tenures = append(this.tenures, tenure)
+    if len(this.tenures) > tenureHistory {
+        this.tenures = this.tenures[len(this.tenures)-tenureHistory:]
+    }
+    if this.config.FlapDetector != nil {
+        this.observeTenure(this.config.FlapDetector, tenure)
+    }
+}
@@ -0,0 +1,143 @@
+package raft
+
+import (
+    "time"
+)
+
+// TickInterval is the default wall-clock time one Tick stands for
+// when the node is driven by Start.
+const TickInterval = 10 * time.Millisecond
+
+// Start drives the node's timers from a background goroutine,
+// calling Tick every Config.TickInterval until Stop is called. It
+// first logs a StartupReport describing the node's configuration
+// and state.
+func (this *Node) Start() {
+    this.mu.Lock()
+    if this.stop != nil {
+        this.mu.Unlock()
+        return
+    }
+    this.begin()
+    this.stop = make(chan struct{})
+    this.done = make(chan struct{})
+    stop, done := this.stop, this.done
+    this.mu.Unlock()
+
+    go func() {
+        defer close(done)
+        ticker := this.clock.NewTicker(this.config.TickInterval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ticker.C():
+                this.Tick()
+            case <-stop:
+                return
+            }
+        }
+    }()
+}
+
+// begin logs the startup report and starts looking for a leader,
+// before the node's first tick. Must be called with this.mu held.
+func (this *Node) begin() {
+    this.reportStartup()
+    if this.config.Prewarm && this.nodeType == Follower {
+        this.prewarm()
+    }
+}
+
+// Stop halts the goroutine started by Start and waits for it to
+// return. RPCs already in flight may still complete.
+func (this *Node) Stop() {
+    this.mu.Lock()
+    stop, done := this.stop, this.done
+    this.stop, this.done = nil, nil
+    this.mu.Unlock()
+
+    if stop == nil {
+        return
+    }
+    close(stop)
+    <-done
+}
+
+// Tick advances the node's logical clock by one tick. Leaders
+// send heartbeats every Config.HeartbeatTicks; followers and candidates
+// start an election once their election timeout elapses.
+func (this *Node) Tick() {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    this.tick()
+}
+
+// tick is Tick. Must be called with this.mu held.
+func (this *Node) tick() {
+    if this.shutdown || this.paused {
+        return
+    }
+    if this.removed() {
+        this.logInfo("stepping down, no longer a voter", "term", this.currentTerm)
+        this.becomeFollower()
+        return
+    }
+    if this.nodeType == Leader {
+        this.tickLeaderTransfer()
+        this.tickVerifications()
+        this.tickBackoff()
+
+        this.expireProposals(this.clock.Now())
+        if len(this.proposals) > 0 {
+            this.proposalWait++
+            if this.proposalWait >= this.flushTicks() {
+                this.flushProposals()
+            }
+        }
+        // Appending them may have failed, stepping the node down.
+        if this.nodeType != Leader {
+            return
+        }
+
+        this.heartbeatElapsed++
+        if this.heartbeatElapsed >= this.config.HeartbeatTicks {
+            this.heartbeatElapsed = 0
+            this.broadcastAppendEntries()
+        }
+
+        if this.config.Audit.Interval > 0 {
+            this.auditElapsed++
+            if this.auditElapsed >= this.config.Audit.Interval {
+                this.auditElapsed = 0
+                this.broadcastAudit()
+            }
+        }
+        this.tickDemotion()
+        return
+    }
+
+    prewarming := this.tickPrewarm()
+    this.electionElapsed++
+    if this.electionElapsed < this.electionTimeout || prewarming {
+        return
+    }
+    // A node outside the configuration it follows waits to join,
+    // and neither a witness nor a replica can lead, nor a node being
+    // drained.
+    if !this.isVoter(this.id) || this.config.Witness || this.draining {
+        this.resetElectionTimer()
+        return
+    }
+    this.campaign(false)
+}
+
+// resetElectionTimer restarts the election timer with a freshly
+// randomized timeout.
+func (this *Node) resetElectionTimer() {
+    this.syncTimer()
+    this.electionElapsed = 0
+    this.electionTimeout = this.config.ElectionTicksMin +
+        this.rand.Intn(this.config.ElectionTicksMax-this.config.ElectionTicksMin+1) +
+        this.priorityDelay()
+    this.reschedule()
+}
@@ -0,0 +1,159 @@
+package raft
+
+import (
+    "encoding/json"
+    "io"
+    "sync"
+)
+
+// TLATracer writes the state transitions of the nodes given it by
+// Config.TLATracer, and the AppendEntries and RequestVote messages
+// they exchange, one JSON object per line, in the format the trace
+// validation of the etcd Raft TLA+ specification reads: each line
+// is {"tag":"trace","event":{...}}, an event naming the action of
+// the specification taken, the node that took it, its term, vote,
+// commit index, role, last log index and voters, and the message
+// sent or received. Checking the traces of a test cluster sharing
+// one TLATracer against the specification checks its executions
+// against the protocol. Snapshots, membership changes and the RPCs
+// the specification does not model are left out.
+//
+// A TLATracer is safe for concurrent use, and writes each event with
+// the lock of the node it is of held, so that the events of a
+// cluster are written in an order consistent with their causes.
+type TLATracer struct {
+    mu      sync.Mutex
+    encoder *json.Encoder
+    err     error
+}
+
+// NewTLATracer returns a TLATracer writing to w.
+func NewTLATracer(w io.Writer) *TLATracer {
+    return &TLATracer{encoder: json.NewEncoder(w)}
+}
+
+// Err returns the first error writing an event failed with. Events
+// are not written after one has failed.
+func (this *TLATracer) Err() error {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    return this.err
+}
+
+// tlaRecord is a line of a TLA+ trace.
+type tlaRecord struct {
+    Tag   string   `json:"tag"`
+    Event tlaEvent `json:"event"`
+}
+
+type tlaEvent struct {
+    Name    string        `json:"name"`
+    NodeId  ServerId      `json:"nid"`
+    State   tlaState      `json:"state"`
+    Role    string        `json:"role"`
+    Log     int           `json:"log"`
+    Conf    [2][]ServerId `json:"conf"`
+    Message *tlaMessage   `json:"message,omitempty"`
+}
+
+type tlaState struct {
+    Term   int      `json:"term"`
+    Vote   ServerId `json:"vote"`
+    Commit int      `json:"commit"`
+}
+
+type tlaMessage struct {
+    Type    string   `json:"type"`
+    From    ServerId `json:"from"`
+    To      ServerId `json:"to"`
+    Term    int      `json:"term"`
+    Entries int      `json:"entries"`
+    LogTerm int      `json:"logTerm"`
+    Index   int      `json:"index"`
+    Commit  int      `json:"commit"`
+    Reject  bool     `json:"reject"`
+}
+
+// The names of the specification's message types.
+const (
+    tlaAppendEntries         = "MsgApp"
+    tlaAppendEntriesResponse = "MsgAppResp"
+    tlaRequestVote           = "MsgVote"
+    tlaRequestVoteResponse   = "MsgVoteResp"
+)
+
+// traceTLA writes the event name, taken by the node, with the message
+// it sent or received, if any. Must be called with this.mu held.
+func (this *Node) traceTLA(name string, msg *tlaMessage) {
+    tracer := this.config.TLATracer
+    if tracer == nil {
+        return
+    }
+    event := tlaEvent{
+        Name:    name,
+        NodeId:  this.id,
+        State:   tlaState{Term: this.currentTerm, Vote: this.votedFor, Commit: this.commitIndex},
+        Role:    "State" + this.nodeType.String(),
+        Log:     this.lastLogIndex(),
+        Message: msg,
+    }
+    for i, servers := range [][]Server{this.members.Servers, this.members.NextServers} {
+        event.Conf[i] = []ServerId{}
+        for _, server := range servers {
+            event.Conf[i] = append(event.Conf[i], server.Id)
+        }
+    }
+
+    tracer.mu.Lock()
+    defer tracer.mu.Unlock()
+    if tracer.err == nil {
+        tracer.err = tracer.encoder.Encode(tlaRecord{Tag: "trace", Event: event})
+    }
+}
+
+// appendEntriesTLA returns req, sent by from to to, as a message of
+// the specification.
+func appendEntriesTLA(from, to ServerId, req AppendEntriesRequest) *tlaMessage {
+    return &tlaMessage{
+        Type:    tlaAppendEntries,
+        From:    from,
+        To:      to,
+        Term:    req.Term,
+        Entries: len(req.Entries),
+        LogTerm: req.PrevLogTerm,
+        Index:   req.PrevLogIndex,
+        Commit:  req.LeaderCommit,
+    }
+}
+
+// appendEntriesResponseTLA returns the response of from to to's req
+// as a message of the specification, which tells the leader the
+// last index the follower matched.
+func appendEntriesResponseTLA(from, to ServerId, req AppendEntriesRequest, term int, success bool) *tlaMessage {
+    msg := &tlaMessage{Type: tlaAppendEntriesResponse, From: from, To: to, Term: term, Reject: !success}
+    if success {
+        msg.Index = req.PrevLogIndex + len(req.Entries)
+    } else {
+        msg.Index = req.PrevLogIndex
+    }
+    return msg
+}
+
+// requestVoteTLA returns req, sent by from to to, as a message of the
+// specification.
+func requestVoteTLA(from, to ServerId, req RequestVoteRequest) *tlaMessage {
+    return &tlaMessage{
+        Type:    tlaRequestVote,
+        From:    from,
+        To:      to,
+        Term:    req.Term,
+        LogTerm: req.LastLogTerm,
+        Index:   req.LastLogIndex,
+    }
+}
+
+// requestVoteResponseTLA returns the answer of from to a vote
+// requested by to as a message of the specification.
+func requestVoteResponseTLA(from, to ServerId, term int, granted bool) *tlaMessage {
+    return &tlaMessage{Type: tlaRequestVoteResponse, From: from, To: to, Term: term, Reject: !granted}
+}
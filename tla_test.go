@@ -0,0 +1,82 @@
+package raft
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "strings"
+    "sync"
+    "testing"
+)
+
+// lockedBuffer is a bytes.Buffer safe for concurrent use.
+type lockedBuffer struct {
+    mu     sync.Mutex
+    buffer bytes.Buffer
+}
+
+func (this *lockedBuffer) Write(p []byte) (int, error) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    return this.buffer.Write(p)
+}
+
+func (this *lockedBuffer) String() string {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    return this.buffer.String()
+}
+
+func TestTLATracer(t *testing.T) {
+    var trace lockedBuffer
+    tracer := NewTLATracer(&trace)
+    cluster := startCluster(t, 3, func(config *Config) { config.TLATracer = tracer })
+    leader := cluster.leader(t)
+    if err := leader.Propose(context.Background(), []byte("x")).Error(); err != nil {
+        t.Fatal(err)
+    }
+    cluster.waitApplied(t, []string{"x"})
+    if err := tracer.Err(); err != nil {
+        t.Fatal(err)
+    }
+
+    // Messages are received after they are sent, and terms and
+    // commit indexes never go back.
+    sent := make(map[tlaMessage]bool)
+    states := make(map[ServerId]tlaState)
+    names := make(map[string]bool)
+    for _, line := range strings.Split(strings.TrimSpace(trace.String()), "\n") {
+        var record tlaRecord
+        if err := json.Unmarshal([]byte(line), &record); err != nil || record.Tag != "trace" {
+            t.Fatalf("line %s: %v", line, err)
+        }
+        event := record.Event
+        names[event.Name] = true
+        if msg := event.Message; msg != nil {
+            if strings.HasPrefix(event.Name, "Send") {
+                sent[*msg] = true
+            } else if !sent[*msg] {
+                t.Fatalf("%s received %+v before it was sent", event.NodeId, *msg)
+            }
+        }
+        previous := states[event.NodeId]
+        if event.State.Term < previous.Term || event.State.Commit < previous.Commit {
+            t.Fatalf("%s went back from %+v to %+v", event.NodeId, previous, event.State)
+        }
+        states[event.NodeId] = event.State
+        if len(event.Conf[0]) != 3 {
+            t.Fatalf("%s has voters %v", event.NodeId, event.Conf[0])
+        }
+    }
+    for _, name := range []string{
+        "InitState", "BecomeCandidate", "BecomeLeader", "Replicate", "Commit",
+        "SendRequestVoteRequest", "ReceiveRequestVoteRequest",
+        "SendRequestVoteResponse", "ReceiveRequestVoteResponse",
+        "SendAppendEntriesRequest", "ReceiveAppendEntriesRequest",
+        "SendAppendEntriesResponse", "ReceiveAppendEntriesResponse",
+    } {
+        if !names[name] {
+            t.Errorf("no %s event traced", name)
+        }
+    }
+}
@@ -0,0 +1,302 @@
+package raft
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "strconv"
+)
+
+// ErrInvalidTrace is returned by ReplayTrace for a trace it cannot
+// read.
+var ErrInvalidTrace = errors.New("raft: invalid trace")
+
+// TraceDivergence is returned by ReplayTrace where the replay departs
+// from the trace.
+type TraceDivergence struct {
+    // The position of the event in the trace, from 0.
+    Event int
+
+    // The event recorded and the event replayed in its place, as
+    // JSON, or empty if there was none.
+    Recorded string
+    Replayed string
+}
+
+func (this *TraceDivergence) Error() string {
+    return fmt.Sprintf("raft: replay departs from the trace at event %d: recorded %s, replayed %s",
+        this.Event, orNone(this.Recorded), orNone(this.Replayed))
+}
+
+func orNone(event string) string {
+    if event == "" {
+        return "none"
+    }
+    return event
+}
+
+// traceEventType names the events of a trace.
+type traceEventType int
+
+const (
+    // The node the trace is of, which opens it.
+    traceStart traceEventType = iota
+
+    // The inputs of a RawNode, by the method given them.
+    traceTick
+    traceStep
+    tracePropose
+    traceCampaign
+    traceForgetLeader
+    traceUnreachable
+    traceAdvance
+
+    // A Ready handed out, and a change of state an input brought.
+    traceReady
+    traceTransition
+)
+
+var traceEventNames = []string{
+    traceStart:        "start",
+    traceTick:         "tick",
+    traceStep:         "step",
+    tracePropose:      "propose",
+    traceCampaign:     "campaign",
+    traceForgetLeader: "forget-leader",
+    traceUnreachable:  "unreachable",
+    traceAdvance:      "advance",
+    traceReady:        "ready",
+    traceTransition:   "transition",
+}
+
+func (this traceEventType) String() string {
+    if this >= 0 && int(this) < len(traceEventNames) {
+        return traceEventNames[this]
+    }
+    return "traceEventType(" + strconv.Itoa(int(this)) + ")"
+}
+
+func (this traceEventType) MarshalText() ([]byte, error) {
+    return []byte(this.String()), nil
+}
+
+func (this *traceEventType) UnmarshalText(text []byte) error {
+    for eventType, name := range traceEventNames {
+        if name == string(text) {
+            *this = traceEventType(eventType)
+            return nil
+        }
+    }
+    return fmt.Errorf("%w: unknown event %q", ErrInvalidTrace, text)
+}
+
+// traceEvent is an event of a trace, with the fields its type uses.
+type traceEvent struct {
+    Type traceEventType
+
+    // The node and its timings, in the start event.
+    Id               ServerId   `json:",omitempty"`
+    Peers            []ServerId `json:",omitempty"`
+    Seed             int64      `json:",omitempty"`
+    HeartbeatTicks   int        `json:",omitempty"`
+    ElectionTicksMin int        `json:",omitempty"`
+    ElectionTicksMax int        `json:",omitempty"`
+
+    // The message stepped, the command proposed, or the peer
+    // reported unreachable.
+    Message *tracedMessage `json:",omitempty"`
+    Command []byte         `json:",omitempty"`
+    Peer    ServerId       `json:",omitempty"`
+
+    Ready *tracedReady `json:",omitempty"`
+    State *traceState  `json:",omitempty"`
+}
+
+// tracedMessage is a Message as JSON, which has no encoding for
+// errors.
+type tracedMessage struct {
+    Message
+    Err *httpError `json:",omitempty"`
+}
+
+func newTracedMessage(msg Message) *tracedMessage {
+    return &tracedMessage{Message: msg, Err: newHTTPError(msg.Err)}
+}
+
+func (this *tracedMessage) message() Message {
+    msg := this.Message
+    msg.Err = this.Err.err()
+    return msg
+}
+
+// tracedReady is a Ready as JSON.
+type tracedReady struct {
+    HardState        *HardState      `json:",omitempty"`
+    Truncate         int             `json:",omitempty"`
+    Entries          []Entry         `json:",omitempty"`
+    Compact          int             `json:",omitempty"`
+    Messages         []tracedMessage `json:",omitempty"`
+    CommittedEntries []Entry         `json:",omitempty"`
+}
+
+// traceState is the state of a node a trace follows.
+type traceState struct {
+    Term         int
+    Role         NodeType
+    LeaderId     ServerId `json:",omitempty"`
+    CommitIndex  int
+    LastLogIndex int
+}
+
+// tracer records the events of a RawNode.
+type tracer struct {
+    record func(event traceEvent)
+    state  traceState
+}
+
+// newTraceWriter returns a tracer writing events to w, one JSON
+// object per line, which warns through logger of the first write
+// that fails.
+func newTraceWriter(w io.Writer, id ServerId, logger Logger) *tracer {
+    encoder := json.NewEncoder(w)
+    failed := false
+    return &tracer{record: func(event traceEvent) {
+        if err := encoder.Encode(event); err != nil && !failed {
+            failed = true
+            logger.Warn("recording trace failed", "node", id, "err", err)
+        }
+    }}
+}
+
+// traced records event, an input the node was given, followed by
+// the state it left the node in, if that changed.
+func (this *RawNode) traced(event traceEvent) {
+    if this.trace == nil {
+        return
+    }
+    this.trace.record(event)
+    status := this.node.Status()
+    state := traceState{
+        Term:         status.Term,
+        Role:         status.Role,
+        LeaderId:     status.LeaderId,
+        CommitIndex:  status.CommitIndex,
+        LastLogIndex: status.LastLogIndex,
+    }
+    if state != this.trace.state {
+        this.trace.state = state
+        this.trace.record(traceEvent{Type: traceTransition, State: &state})
+    }
+}
+
+// tracedReady records ready, handed out by the node.
+func (this *RawNode) tracedReady(ready Ready) {
+    if this.trace == nil {
+        return
+    }
+    traced := &tracedReady{
+        HardState:        ready.HardState,
+        Truncate:         ready.Truncate,
+        Entries:          ready.Entries,
+        Compact:          ready.Compact,
+        CommittedEntries: ready.CommittedEntries,
+    }
+    for _, msg := range ready.Messages {
+        traced.Messages = append(traced.Messages, *newTracedMessage(msg))
+    }
+    this.trace.record(traceEvent{Type: traceReady, Ready: traced})
+}
+
+// ReplayTrace replays a trace recorded by a RawNode with Config.Trace
+// set: it gives a new RawNode the inputs of the trace, in order, and
+// returns a *TraceDivergence at the first Ready or change of state
+// that differs from the recorded one. The trace records the node's
+// ID, peers, seed and timeouts; options must configure the rest as
+// the recorded node was, for it to be replayed faithfully.
+func ReplayTrace(trace io.Reader, options ...Option) error {
+    var recorded []traceEvent
+    decoder := json.NewDecoder(trace)
+    for {
+        var event traceEvent
+        if err := decoder.Decode(&event); err == io.EOF {
+            break
+        } else if err != nil {
+            return fmt.Errorf("%w: event %d: %v", ErrInvalidTrace, len(recorded), err)
+        }
+        recorded = append(recorded, event)
+    }
+    if len(recorded) == 0 || recorded[0].Type != traceStart {
+        return fmt.Errorf("%w: no start event", ErrInvalidTrace)
+    }
+
+    start := recorded[0]
+    options = append(options, WithSeed(start.Seed),
+        WithTimeouts(start.HeartbeatTicks, start.ElectionTicksMin, start.ElectionTicksMax),
+        func(config *Config) { config.Trace = nil })
+    raw, err := NewRawNode(start.Id, start.Peers, options...)
+    if err != nil {
+        return err
+    }
+    replayed := []traceEvent{start}
+    raw.trace = &tracer{record: func(event traceEvent) {
+        replayed = append(replayed, event)
+    }}
+
+    compared := 1
+    for _, event := range recorded[1:] {
+        switch event.Type {
+        case traceTick:
+            raw.Tick()
+        case traceStep:
+            if event.Message == nil {
+                return fmt.Errorf("%w: step without a message", ErrInvalidTrace)
+            }
+            raw.Step(event.Message.message())
+        case tracePropose:
+            raw.Propose(context.Background(), event.Command)
+        case traceCampaign:
+            raw.Campaign()
+        case traceForgetLeader:
+            raw.ForgetLeader()
+        case traceUnreachable:
+            raw.ReportUnreachable(event.Peer)
+        case traceAdvance:
+            raw.Advance()
+        case traceReady:
+            raw.Ready()
+        case traceTransition:
+            // Replayed with the input that brought it.
+        default:
+            return fmt.Errorf("%w: unexpected %v event", ErrInvalidTrace, event.Type)
+        }
+        for ; compared < len(replayed); compared++ {
+            if err := compareTraceEvents(compared, recorded, replayed[compared]); err != nil {
+                return err
+            }
+        }
+    }
+    if compared < len(recorded) {
+        return compareTraceEvents(compared, recorded, traceEvent{Type: -1})
+    }
+    return nil
+}
+
+// compareTraceEvents returns a *TraceDivergence unless replayed is
+// the event recorded at position i. A replayed event of negative
+// type stands for none.
+func compareTraceEvents(i int, recorded []traceEvent, replayed traceEvent) error {
+    var want, got []byte
+    if i < len(recorded) {
+        want, _ = json.Marshal(recorded[i])
+    }
+    if replayed.Type >= 0 {
+        got, _ = json.Marshal(replayed)
+    }
+    if bytes.Equal(want, got) {
+        return nil
+    }
+    return &TraceDivergence{Event: i, Recorded: string(want), Replayed: string(got)}
+}
@@ -0,0 +1,95 @@
+package raft
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "strings"
+    "testing"
+)
+
+// traceCluster drives three RawNodes recording traces, delivering
+// their messages to each other at once, and returns the traces.
+func traceCluster(t *testing.T) map[ServerId]*bytes.Buffer {
+    ids := []ServerId{"1", "2", "3"}
+    raws := make(map[ServerId]*RawNode)
+    traces := make(map[ServerId]*bytes.Buffer)
+    for _, id := range ids {
+        var peers []ServerId
+        for _, peer := range ids {
+            if peer != id {
+                peers = append(peers, peer)
+            }
+        }
+        traces[id] = &bytes.Buffer{}
+        raw, err := NewRawNode(id, peers, WithTimeouts(3, 10, 20), func(config *Config) {
+            config.Trace = traces[id]
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+        raws[id] = raw
+    }
+
+    proposed := 0
+    for tick := 0; tick < 500; tick++ {
+        for _, id := range ids {
+            raws[id].Tick()
+        }
+        for delivered := true; delivered; {
+            delivered = false
+            for _, id := range ids {
+                raw := raws[id]
+                if !raw.HasReady() {
+                    continue
+                }
+                ready := raw.Ready()
+                raw.Advance()
+                for _, msg := range ready.Messages {
+                    if err := raws[msg.To].Step(msg); err != nil {
+                        t.Fatal(err)
+                    }
+                    delivered = true
+                }
+            }
+        }
+        for _, id := range ids {
+            if tick%50 == 0 && raws[id].Status().Role == Leader {
+                raws[id].Propose(context.Background(), []byte(fmt.Sprint("command ", proposed)))
+                proposed++
+            }
+        }
+    }
+    if proposed == 0 {
+        t.Fatal("no leader elected")
+    }
+    return traces
+}
+
+func TestReplayTrace(t *testing.T) {
+    traces := traceCluster(t)
+    for id, trace := range traces {
+        if err := ReplayTrace(bytes.NewReader(trace.Bytes())); err != nil {
+            t.Fatalf("replaying the trace of %s: %v", id, err)
+        }
+    }
+
+    // Without one of the messages it was given, the trace no longer
+    // replays.
+    lines := strings.SplitAfter(traces["1"].String(), "\n")
+    for i, line := range lines {
+        if i > 20 && strings.Contains(line, `"Type":"step"`) {
+            lines = append(lines[:i], lines[i+1:]...)
+            break
+        }
+    }
+    var divergence *TraceDivergence
+    if err := ReplayTrace(strings.NewReader(strings.Join(lines, ""))); !errors.As(err, &divergence) {
+        t.Fatalf("replaying a trace missing a message returned %v", err)
+    }
+
+    if err := ReplayTrace(strings.NewReader(`{"Type":"tick"}`)); !errors.Is(err, ErrInvalidTrace) {
+        t.Fatalf("replaying a trace without a start returned %v", err)
+    }
+}
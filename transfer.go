@@ -0,0 +1,216 @@
+package raft
+
+import (
+    "context"
+    "errors"
+    "fmt"
+)
+
+// ErrLeadershipTransferFailed is returned by LeadershipTransfer
+// when no other node took over leadership within an election
+// timeout. The node remains the leader.
+var ErrLeadershipTransferFailed = errors.New("raft: leadership transfer failed")
+
+type TimeoutNowRequest struct {
+    ProtocolVersion int
+    Term            int
+    LeaderId        ServerId
+}
+
+type TimeoutNowResponse struct {
+    ProtocolVersion int
+    Term            int
+}
+
+// leadershipTransfer is a handover of leadership under way.
+type leadershipTransfer struct {
+    // Position of the peer taking over.
+    peer int
+
+    // Ticks since the transfer began, and whether the peer has
+    // been told to start an election.
+    elapsed int
+    sent    bool
+
+    // Receives the outcome.
+    done chan error
+}
+
+// LeadershipTransfer hands leadership to the follower with the most
+// up-to-date log: the leader stops accepting proposals, brings the
+// follower's log up to date and then tells it to start an election
+// at once (see §3.10 of the Raft dissertation). It returns once
+// this node has stepped down, or with ErrLeadershipTransferFailed
+// if nobody took over within an election timeout, or when ctx is
+// done, in which case the node resumes accepting proposals.
+func (this *Node) LeadershipTransfer(ctx context.Context) error {
+    return this.transferLeadership(ctx, "")
+}
+
+// LeadershipTransferTo is LeadershipTransfer to the member with the
+// given ID, which must be neither a witness nor a replica, as when
+// balancing leaders across processes.
+func (this *Node) LeadershipTransferTo(ctx context.Context, id ServerId) error {
+    if id == "" {
+        return fmt.Errorf("%w: no node to transfer to", ErrLeadershipTransferFailed)
+    }
+    return this.transferLeadership(ctx, id)
+}
+
+// transferLeadership hands leadership to the member with the given
+// ID, or if it is empty, to the follower with the most up-to-date
+// log, the one with the highest election priority among equals.
+func (this *Node) transferLeadership(ctx context.Context, id ServerId) error {
+    this.mu.Lock()
+    if this.shutdown {
+        this.mu.Unlock()
+        return ErrRaftShutdown
+    }
+    if this.nodeType != Leader {
+        err := this.notLeader()
+        this.mu.Unlock()
+        return err
+    }
+    if this.leaderTransfer != nil {
+        this.mu.Unlock()
+        return ErrLeadershipTransferInProgress
+    }
+
+    target := -1
+    if id == "" {
+        target = this.transferTarget()
+    } else {
+        i, ok := this.position(id)
+        switch {
+        case !ok:
+            this.mu.Unlock()
+            return fmt.Errorf("%w: %q is not a member", ErrLeadershipTransferFailed, id)
+        case id == this.id:
+            this.mu.Unlock()
+            return nil
+        case this.witnesses[id]:
+            this.mu.Unlock()
+            return fmt.Errorf("%w: %q is a witness", ErrLeadershipTransferFailed, id)
+        case !this.isVoter(id):
+            this.mu.Unlock()
+            return fmt.Errorf("%w: %q is a replica", ErrLeadershipTransferFailed, id)
+        }
+        target = i
+    }
+    if target < 0 {
+        this.mu.Unlock()
+        return fmt.Errorf("%w: no other node to transfer to", ErrLeadershipTransferFailed)
+    }
+
+    transfer := &leadershipTransfer{peer: target, done: make(chan error, 1)}
+    this.leaderTransfer = transfer
+    this.reschedule()
+    this.logInfo("transferring leadership", "peer", this.peers[target], "term", this.currentTerm)
+
+    // Proposals already queued go out with the rest of the log.
+    this.flushProposals()
+    this.continueLeaderTransfer()
+    this.mu.Unlock()
+
+    select {
+    case err := <-transfer.done:
+        return err
+    case <-ctx.Done():
+        this.mu.Lock()
+        if this.leaderTransfer == transfer {
+            this.endLeaderTransfer(ctx.Err())
+        }
+        this.mu.Unlock()
+        return <-transfer.done
+    }
+}
+
+// transferTarget returns the position of the follower with the most
+// up-to-date log, the one with the highest election priority among
+// equals, or -1 if there is none. Must be called with this.mu held.
+func (this *Node) transferTarget() int {
+    target := -1
+    for i, peer := range this.peers {
+        if peer == this.id || this.witnesses[peer] || !this.isVoter(peer) {
+            continue
+        }
+        if target < 0 || this.matchIndex[i] > this.matchIndex[target] ||
+            this.matchIndex[i] == this.matchIndex[target] && this.priorities[peer] > this.priorities[this.peers[target]] {
+            target = i
+        }
+    }
+    return target
+}
+
+// continueLeaderTransfer replicates to the peer taking over until
+// its log matches the leader's, then tells it to time out. Must be
+// called with this.mu held.
+func (this *Node) continueLeaderTransfer() {
+    transfer := this.leaderTransfer
+    if transfer == nil || transfer.sent {
+        return
+    }
+    i := transfer.peer
+    if this.matchIndex[i] < this.lastLogIndex() {
+        this.replicateTo(i, false)
+        return
+    }
+
+    transfer.sent = true
+    peerId := this.peers[i]
+    req := TimeoutNowRequest{Term: this.currentTerm, LeaderId: this.id}
+    this.transport.TimeoutNow(peerId, req, func(resp TimeoutNowResponse, err error) {
+        this.mu.Lock()
+        defer this.mu.Unlock()
+
+        if this.shutdown {
+            return
+        }
+        if err == nil {
+            this.testToAbdicateLeadership(resp.Term, "")
+            return
+        }
+        if this.leaderTransfer == transfer {
+            this.endLeaderTransfer(fmt.Errorf("%w: %v", ErrLeadershipTransferFailed, err))
+        }
+    })
+}
+
+// tickLeaderTransfer abandons a transfer that has not completed
+// within an election timeout. Must be called with this.mu held.
+func (this *Node) tickLeaderTransfer() {
+    if this.leaderTransfer == nil {
+        return
+    }
+    this.leaderTransfer.elapsed++
+    if this.leaderTransfer.elapsed >= this.config.ElectionTicksMax {
+        this.logWarn("leadership transfer timed out", "peer", this.peers[this.leaderTransfer.peer])
+        this.endLeaderTransfer(ErrLeadershipTransferFailed)
+    }
+}
+
+// endLeaderTransfer reports the outcome of the transfer under way.
+// Must be called with this.mu held.
+func (this *Node) endLeaderTransfer(err error) {
+    this.leaderTransfer.done <- err
+    this.leaderTransfer = nil
+}
+
+// TimeoutNowRPC is invoked by a leader handing over leadership to
+// this node, which starts an election without waiting for its
+// election timeout.
+func (this *Node) TimeoutNowRPC(term int, leaderId ServerId) (termResult int) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    if this.shutdown {
+        return this.currentTerm
+    }
+    this.testToAbdicateLeadership(term, leaderId)
+    if term < this.currentTerm || this.nodeType != Follower || this.config.Witness || this.isReplica() || this.draining {
+        return this.currentTerm
+    }
+    this.logInfo("leadership handed over", "leader", leaderId, "term", term)
+    this.campaign(true)
+    return this.currentTerm
+}
@@ -0,0 +1,81 @@
+package raft
+
+import "errors"
+
+// ErrUnknownPeer is reported by a Transport asked to reach a node it
+// does not know about.
+var ErrUnknownPeer = errors.New("raft: unknown peer")
+
+// ErrMalformedRequest is returned by the RPC handlers for a request
+// no node following the protocol sends, such as one whose entries do
+// not follow on from its PrevLogIndex, which is ignored.
+var ErrMalformedRequest = errors.New("raft: malformed request")
+
+type AppendEntriesRequest struct {
+    ProtocolVersion int
+    Term            int
+    LeaderId        ServerId
+    PrevLogIndex    int
+    PrevLogTerm     int
+    Entries         []Entry
+    LeaderCommit    int
+}
+
+type AppendEntriesResponse struct {
+    ProtocolVersion int
+    Term            int
+    Success         bool
+}
+
+type RequestVoteRequest struct {
+    ProtocolVersion int
+    Term            int
+    CandidateId     ServerId
+    LastLogIndex    int
+    LastLogTerm     int
+
+    // Whether the leader handed leadership over to the candidate,
+    // which exempts the request from leader stickiness.
+    LeadershipTransfer bool
+}
+
+type RequestVoteResponse struct {
+    ProtocolVersion int
+    Term            int
+    VoteGranted     bool
+}
+
+type InstallSnapshotRequest struct {
+    ProtocolVersion   int
+    Term              int
+    LeaderId          ServerId
+    LastIncludedIndex int
+    LastIncludedTerm  int
+    Checksum          uint64
+    Offset            int
+    Data              []byte
+    Done              bool
+}
+
+type InstallSnapshotResponse struct {
+    ProtocolVersion int
+    Term            int
+    Success         bool
+}
+
+// Transport carries RPCs from a node to its peers.
+//
+// A node calls its transport with its lock held, so implementations
+// must not block and must not call reply before returning. reply is
+// called exactly once, with a non-nil error if the RPC could not be
+// delivered or answered.
+type Transport interface {
+    AppendEntries(target ServerId, req AppendEntriesRequest, reply func(AppendEntriesResponse, error))
+    RequestVote(target ServerId, req RequestVoteRequest, reply func(RequestVoteResponse, error))
+    InstallSnapshot(target ServerId, req InstallSnapshotRequest, reply func(InstallSnapshotResponse, error))
+    Handshake(target ServerId, req HandshakeRequest, reply func(HandshakeResponse, error))
+    Audit(target ServerId, req AuditRequest, reply func(AuditResponse, error))
+    TimeoutNow(target ServerId, req TimeoutNowRequest, reply func(TimeoutNowResponse, error))
+    Probe(target ServerId, req ProbeRequest, reply func(ProbeResponse, error))
+    Forward(target ServerId, req ForwardRequest, reply func(ForwardResponse, error))
+}
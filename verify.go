@@ -0,0 +1,172 @@
+package raft
+
+import "context"
+
+// VerifyFuture tracks a check that a node is still the leader.
+type VerifyFuture struct {
+    // Heartbeat round the check waits for, and the peers that
+    // have answered it or a later round.
+    round int
+    acks  map[ServerId]bool
+
+    // Ticks since the check began.
+    elapsed int
+
+    err  error
+    done chan struct{}
+}
+
+// Error blocks until leadership has been confirmed, returning nil,
+// or could not be.
+func (this *VerifyFuture) Error() error {
+    <-this.done
+    return this.err
+}
+
+// Done is closed once Error would no longer block.
+func (this *VerifyFuture) Done() <-chan struct{} {
+    return this.done
+}
+
+func (this *VerifyFuture) respond(err error) {
+    this.err = err
+    close(this.done)
+}
+
+// VerifyLeader confirms that this node is still the leader by
+// exchanging a round of heartbeats with a quorum, so that reads it
+// serves from its own state machine are not stale because another
+// node has since been elected (see §8 of the raft paper). The
+// future fails with a *NotLeaderError if the node is not, or
+// stops being, the leader, or if a quorum does not answer within
+// an election timeout, and with the error of ctx if ctx is done
+// first.
+func (this *Node) VerifyLeader(ctx context.Context) *VerifyFuture {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    future := &VerifyFuture{acks: make(map[ServerId]bool), done: make(chan struct{})}
+    if err := ctx.Err(); err != nil {
+        future.respond(err)
+        return future
+    }
+    if this.shutdown {
+        future.respond(ErrRaftShutdown)
+        return future
+    }
+    if this.nodeType != Leader {
+        future.respond(this.notLeader())
+        return future
+    }
+    if this.hasQuorum(func(id ServerId) bool { return id == this.id }) {
+        future.respond(nil)
+        return future
+    }
+
+    this.verifyRound++
+    future.round = this.verifyRound
+    this.verifications = append(this.verifications, future)
+    this.reschedule()
+    for i, peer := range this.peers {
+        if peer != this.id {
+            this.sendVerifyHeartbeat(i, future.round)
+        }
+    }
+    if ctx.Done() != nil {
+        go func() {
+            select {
+            case <-future.done:
+            case <-ctx.Done():
+                this.mu.Lock()
+                defer this.mu.Unlock()
+                this.abandonVerification(future, ctx.Err())
+            }
+        }()
+    }
+    return future
+}
+
+// abandonVerification fails future with err, the error of its
+// caller's context. Must be called with this.mu held.
+func (this *Node) abandonVerification(future *VerifyFuture, err error) {
+    for i, pending := range this.verifications {
+        if pending == future {
+            this.verifications = append(this.verifications[:i], this.verifications[i+1:]...)
+            future.respond(err)
+            return
+        }
+    }
+}
+
+// sendVerifyHeartbeat sends the peer at position i an empty
+// AppendEntries outside its replication window, whose answer only
+// counts towards verifying leadership. Must be called with this.mu
+// held.
+func (this *Node) sendVerifyHeartbeat(i, round int) {
+    prevLogIndex := minInt(this.nextIndex[i]-1, this.lastLogIndex())
+    prevLogTerm, _ := this.termAt(prevLogIndex)
+    req := AppendEntriesRequest{
+        Term:         this.currentTerm,
+        LeaderId:     this.id,
+        PrevLogIndex: prevLogIndex,
+        PrevLogTerm:  prevLogTerm,
+        LeaderCommit: this.commitIndex,
+    }
+    peerId := this.peers[i]
+    this.transport.AppendEntries(peerId, req, func(resp AppendEntriesResponse, err error) {
+        this.handleVerifyReply(peerId, round, req, resp, err)
+    })
+}
+
+// handleVerifyReply counts a peer's answer to a verifying heartbeat.
+// Any answer in the leader's term, accepting the entries or not,
+// shows the peer has not moved on to a later leader.
+func (this *Node) handleVerifyReply(peerId ServerId, round int, req AppendEntriesRequest, resp AppendEntriesResponse, err error) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    if this.shutdown || err != nil {
+        return
+    }
+    this.testToAbdicateLeadership(resp.Term, "")
+    if this.nodeType != Leader || this.currentTerm != req.Term || resp.Term != req.Term {
+        return
+    }
+
+    pending := this.verifications[:0]
+    for _, future := range this.verifications {
+        if future.round <= round {
+            future.acks[peerId] = true
+        }
+        if this.hasQuorum(func(id ServerId) bool { return id == this.id || future.acks[id] }) {
+            future.respond(nil)
+            continue
+        }
+        pending = append(pending, future)
+    }
+    this.verifications = pending
+}
+
+// tickVerifications fails the checks a quorum has not answered
+// within an election timeout. Must be called with this.mu held.
+func (this *Node) tickVerifications() {
+    pending := this.verifications[:0]
+    for _, future := range this.verifications {
+        future.elapsed++
+        if future.elapsed >= this.config.ElectionTicksMax {
+            future.respond(&NotLeaderError{LeaderId: ""})
+            continue
+        }
+        pending = append(pending, future)
+    }
+    this.verifications = pending
+}
+
+// failVerifications fails every pending check with err. Must be
+// called with this.mu held.
+func (this *Node) failVerifications(err error) {
+    for _, future := range this.verifications {
+        future.respond(err)
+    }
+    this.verifications = nil
+}
@@ -0,0 +1,729 @@
+package raft
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "hash/crc32"
+    "io"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// SyncPolicy is when a WALStore makes what it writes durable with
+// fsync.
+type SyncPolicy int
+
+const (
+    // SyncEveryWrite syncs before every write returns, so that no
+    // entry a node acknowledged is lost, even to a power failure.
+    SyncEveryWrite SyncPolicy = iota
+
+    // SyncGroupCommit syncs once for all the writes made within
+    // WALOptions.MaxSyncDelay of the first that is not yet synced.
+    // Each write returns once the sync it shares is done, so nothing
+    // acknowledged is lost, but may wait up to MaxSyncDelay longer
+    // than with SyncEveryWrite for others to join it: it trades the
+    // latency of a lone write for the throughput of many concurrent
+    // ones.
+    SyncGroupCommit
+
+    // SyncNever leaves syncing to the operating system, for tests.
+    SyncNever
+)
+
+func (this SyncPolicy) String() string {
+    switch this {
+    case SyncEveryWrite:
+        return "EveryWrite"
+    case SyncGroupCommit:
+        return "GroupCommit"
+    case SyncNever:
+        return "Never"
+    default:
+        return fmt.Sprintf("SyncPolicy(%d)", int(this))
+    }
+}
+
+// WALOptions tunes a WALStore.
+type WALOptions struct {
+    // When writes are made durable.
+    Sync SyncPolicy
+
+    // With SyncGroupCommit, how long a write waits for others to
+    // share its sync.
+    MaxSyncDelay time.Duration
+
+    // The size past which the WAL starts a new segment file.
+    // Compaction deletes whole segments, and rewrites the segment
+    // it ends in, so smaller segments make compaction cheaper and
+    // the WAL's files more numerous.
+    SegmentSize int64
+
+    // Where to count the corruption found on opening the WAL, if
+    // anywhere.
+    Metrics      Metrics
+    MetricLabels Labels
+}
+
+// DefaultWALOptions returns options that sync every write.
+func DefaultWALOptions() WALOptions {
+    return WALOptions{
+        Sync:         SyncEveryWrite,
+        MaxSyncDelay: 2 * time.Millisecond,
+        SegmentSize:  64 << 20,
+    }
+}
+
+// A segment file starts with a header of walMagic, the format
+// version, the index of the segment's first entry and the CRC of the
+// three. Each record that follows has a header of the length and the
+// CRC of the entry, encoded by MarshalEntry, that follows it. CRCs
+// are CRC-32C.
+const (
+    walMagic             = "RWAL"
+    walVersion           = 1
+    walSegmentHeaderSize = len(walMagic) + 1 + 8 + 4
+    walHeaderSize        = 4 + 4
+)
+
+var walTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrWALCorrupt is matched by the errors reporting corruption found
+// in a WALStore, which are *WALCorruptionError.
+var ErrWALCorrupt = errors.New("raft: WAL corrupt")
+
+// WALCorruptionError locates a corrupt record of a WALStore.
+type WALCorruptionError struct {
+    // The segment file, and the offset of the record in it.
+    Segment string
+    Offset  int64
+
+    // The index of the entry the record should hold.
+    Index int
+
+    Reason string
+}
+
+func (this *WALCorruptionError) Error() string {
+    return fmt.Sprintf("raft: WAL segment %s corrupt at offset %d, entry %d: %s",
+        this.Segment, this.Offset, this.Index, this.Reason)
+}
+
+func (this *WALCorruptionError) Is(target error) bool {
+    return target == ErrWALCorrupt
+}
+
+// WALStore is a LogStore keeping entries in a write-ahead log: a
+// directory of segment files, each named after the index of its
+// first entry and holding the entries that follow in log order.
+// Entries are appended to the last segment, and replaced by
+// truncating it; compaction deletes the segments it covers whole.
+// The node does not close its LogStore; close a WALStore once the
+// node using it has shut down.
+//
+// Every record is checksummed. On opening, the WAL keeps the entries
+// before the first record that fails its checks, and truncates the
+// log there, deleting every later segment, rather than hand out
+// entries it cannot trust; Corruption reports where, and
+// WALOptions.Metrics counts it in MetricWALCorruptions. A record
+// left incomplete at the very end by a crash was never synced, and
+// is dropped without being reported. Followers fetch the entries
+// lost from the leader.
+type WALStore struct {
+    dir     string
+    options WALOptions
+
+    mu       sync.Mutex
+    segments []*walSegment
+    closed   bool
+
+    // The corruption found on opening, if any.
+    corruption *WALCorruptionError
+
+    // What has changed since the last sync, and the error of a
+    // failed sync, after which the WAL accepts no more writes.
+    dirty    bool
+    dirDirty bool
+    syncErr  error
+
+    // With SyncGroupCommit, the writes made and synced so far,
+    // whether a write is waiting for others to join its sync, and
+    // the signal that it is done.
+    written int
+    synced  int
+    syncing bool
+    done    *sync.Cond
+}
+
+// walSegment is a segment file of a WALStore.
+type walSegment struct {
+    file  *os.File
+    first int
+
+    // The offset of the record of each entry, and the size of
+    // the file.
+    offsets []int64
+    size    int64
+}
+
+// NewWALStore opens the WAL in dir, creating it if needed.
+func NewWALStore(dir string, options WALOptions) (*WALStore, error) {
+    switch {
+    case options.Sync < SyncEveryWrite || options.Sync > SyncNever:
+        return nil, fmt.Errorf("%w: unknown sync policy %v", ErrInvalidConfig, options.Sync)
+    case options.MaxSyncDelay < 0:
+        return nil, fmt.Errorf("%w: MaxSyncDelay is negative", ErrInvalidConfig)
+    case options.SegmentSize <= 0:
+        return nil, fmt.Errorf("%w: SegmentSize must be positive", ErrInvalidConfig)
+    }
+    if err := os.MkdirAll(dir, 0o755); err != nil {
+        return nil, err
+    }
+    names, err := filepath.Glob(filepath.Join(dir, "*.wal"))
+    if err != nil {
+        return nil, err
+    }
+    sort.Strings(names)
+
+    this := &WALStore{dir: dir, options: options}
+    this.done = sync.NewCond(&this.mu)
+    for i, name := range names {
+        segment, corruption, err := openSegment(name, i == len(names)-1)
+        if err != nil {
+            this.Close()
+            return nil, err
+        }
+        // A compaction that crashed before deleting the segment it
+        // rewrote leaves it behind, overlapping the rewritten one.
+        if n := len(this.segments); n == 1 && segment != nil && segment.first < this.segments[0].next() {
+            this.segments[0].file.Close()
+            os.Remove(this.segments[0].file.Name())
+            this.segments = this.segments[:0]
+        }
+        if n := len(this.segments); n > 0 && segment != nil && segment.first != this.segments[n-1].next() {
+            corruption = &WALCorruptionError{
+                Segment: filepath.Base(name),
+                Index:   this.segments[n-1].next(),
+                Reason:  "segment missing",
+            }
+            segment.file.Close()
+            segment = nil
+            os.Remove(name)
+        }
+        if segment != nil {
+            this.segments = append(this.segments, segment)
+        }
+        if corruption != nil {
+            this.corruption = corruption
+            for _, later := range names[i+1:] {
+                if err := os.Remove(later); err != nil {
+                    this.Close()
+                    return nil, err
+                }
+            }
+            if options.Metrics != nil {
+                options.Metrics.IncrCounter(MetricWALCorruptions, options.MetricLabels, 1)
+            }
+            break
+        }
+    }
+    return this, nil
+}
+
+// Corruption returns the corruption found on opening the WAL, where
+// the log was truncated, or nil.
+func (this *WALStore) Corruption() error {
+    if this.corruption == nil {
+        return nil
+    }
+    return this.corruption
+}
+
+// openSegment opens the segment file name, the last of the WAL if
+// last is set, and indexes its entries, truncating it at the first
+// record that fails its checks. It returns the corruption found, and
+// no segment if the header itself is corrupt, in which case the file
+// is deleted.
+func openSegment(name string, last bool) (*walSegment, *WALCorruptionError, error) {
+    first, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(name), ".wal"))
+    if err != nil {
+        return nil, nil, fmt.Errorf("raft: WAL segment %s: bad name", filepath.Base(name))
+    }
+    file, err := os.OpenFile(name, os.O_RDWR, 0)
+    if err != nil {
+        return nil, nil, err
+    }
+    data, err := io.ReadAll(file)
+    if err != nil {
+        file.Close()
+        return nil, nil, err
+    }
+
+    segment := &walSegment{file: file, first: first, size: int64(walSegmentHeaderSize)}
+    corrupt := func(offset int64, incomplete bool, reason string) (*walSegment, *WALCorruptionError, error) {
+        corruption := &WALCorruptionError{
+            Segment: filepath.Base(name),
+            Offset:  offset,
+            Index:   segment.next(),
+            Reason:  reason,
+        }
+        var err error
+        if offset == 0 {
+            file.Close()
+            segment, err = nil, os.Remove(name)
+        } else if err = file.Truncate(offset); err == nil {
+            err = file.Sync()
+        }
+        if err != nil {
+            if segment != nil {
+                file.Close()
+            }
+            return nil, nil, err
+        }
+        // A crash while appending leaves an incomplete record, or
+        // zeros, at the end of the last segment.
+        if last && (incomplete || len(bytes.Trim(data[offset:], "\x00")) == 0) {
+            return segment, nil, nil
+        }
+        return segment, corruption, nil
+    }
+
+    if len(data) < walSegmentHeaderSize {
+        return corrupt(0, true, "incomplete segment header")
+    }
+    if !bytes.Equal(data[:walSegmentHeaderSize], walSegmentHeader(first)) {
+        return corrupt(0, false, "bad segment header")
+    }
+    for segment.size < int64(len(data)) {
+        record := data[segment.size:]
+        if len(record) < walHeaderSize {
+            return corrupt(segment.size, true, "incomplete record header")
+        }
+        size := walHeaderSize + int(binary.BigEndian.Uint32(record))
+        if len(record) < size {
+            return corrupt(segment.size, true, "incomplete record")
+        }
+        if crc32.Checksum(record[walHeaderSize:size], walTable) != binary.BigEndian.Uint32(record[4:]) {
+            return corrupt(segment.size, false, "checksum mismatch")
+        }
+        entry, err := UnmarshalEntry(record[walHeaderSize:size])
+        if err != nil {
+            return corrupt(segment.size, false, err.Error())
+        }
+        if entry.Index != segment.next() {
+            return corrupt(segment.size, false, fmt.Sprintf("holds entry %d", entry.Index))
+        }
+        segment.offsets = append(segment.offsets, segment.size)
+        segment.size += int64(size)
+    }
+    return segment, nil, nil
+}
+
+// walSegmentHeader returns the header of the segment whose first
+// entry is first.
+func walSegmentHeader(first int) []byte {
+    header := append([]byte(walMagic), walVersion)
+    header = binary.BigEndian.AppendUint64(header, uint64(first))
+    return binary.BigEndian.AppendUint32(header, crc32.Checksum(header, walTable))
+}
+
+// next returns the index of the entry that would follow the
+// segment's.
+func (this *walSegment) next() int {
+    return this.first + len(this.offsets)
+}
+
+// walSegmentName names a segment after the index of its first entry,
+// so that segments sort in log order.
+func walSegmentName(first int) string {
+    return fmt.Sprintf("%020d.wal", first)
+}
+
+func (this *WALStore) FirstIndex() (int, error) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    first, _ := this.bounds()
+    return first, nil
+}
+
+func (this *WALStore) LastIndex() (int, error) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    _, last := this.bounds()
+    return last, nil
+}
+
+// bounds returns the first and last index held, both 0 if none.
+// Must be called with this.mu held.
+func (this *WALStore) bounds() (first, last int) {
+    if len(this.segments) == 0 {
+        return 0, 0
+    }
+    first, last = this.segments[0].first, this.segments[len(this.segments)-1].next()-1
+    if last < first {
+        return 0, 0
+    }
+    return first, last
+}
+
+// segmentOf returns the position of the segment holding index, or
+// -1. Must be called with this.mu held.
+func (this *WALStore) segmentOf(index int) int {
+    i := sort.Search(len(this.segments), func(i int) bool {
+        return this.segments[i].next() > index
+    })
+    if i == len(this.segments) || index < this.segments[i].first {
+        return -1
+    }
+    return i
+}
+
+func (this *WALStore) GetLog(index int) (Entry, error) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    i := this.segmentOf(index)
+    if i < 0 {
+        return Entry{}, ErrNotFound
+    }
+    segment := this.segments[i]
+    offset := segment.offsets[index-segment.first]
+    end := segment.size
+    if index+1 < segment.next() {
+        end = segment.offsets[index+1-segment.first]
+    }
+    record := make([]byte, end-offset)
+    if _, err := segment.file.ReadAt(record, offset); err != nil {
+        return Entry{}, err
+    }
+    if crc32.Checksum(record[walHeaderSize:], walTable) != binary.BigEndian.Uint32(record[4:]) {
+        return Entry{}, &WALCorruptionError{
+            Segment: filepath.Base(segment.file.Name()),
+            Offset:  offset,
+            Index:   index,
+            Reason:  "checksum mismatch",
+        }
+    }
+    return UnmarshalEntry(record[walHeaderSize:])
+}
+
+func (this *WALStore) StoreLogs(entries []Entry) error {
+    if len(entries) == 0 {
+        return nil
+    }
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    if this.syncErr != nil {
+        return this.syncErr
+    }
+    if _, last := this.bounds(); last != 0 && entries[0].Index <= last {
+        if err := this.truncate(entries[0].Index); err != nil {
+            return err
+        }
+    }
+    if _, last := this.bounds(); last == 0 {
+        if err := this.removeAll(); err != nil {
+            return err
+        }
+        if err := this.roll(entries[0].Index); err != nil {
+            return err
+        }
+    } else if entries[0].Index != last+1 {
+        return fmt.Errorf("raft: WAL: entry %d does not follow %d", entries[0].Index, last)
+    }
+
+    segment := this.segments[len(this.segments)-1]
+    var buf []byte
+    offsets := make([]int64, len(entries))
+    for i, entry := range entries {
+        offsets[i] = segment.size + int64(len(buf))
+        data := MarshalEntry(entry)
+        buf = binary.BigEndian.AppendUint32(buf, uint32(len(data)))
+        buf = binary.BigEndian.AppendUint32(buf, crc32.Checksum(data, walTable))
+        buf = append(buf, data...)
+    }
+    if _, err := segment.file.WriteAt(buf, segment.size); err != nil {
+        segment.file.Truncate(segment.size)
+        return err
+    }
+    segment.offsets = append(segment.offsets, offsets...)
+    segment.size += int64(len(buf))
+    this.dirty = true
+
+    if segment.size >= this.options.SegmentSize {
+        if err := this.roll(segment.next()); err != nil {
+            return err
+        }
+    }
+    return this.commit()
+}
+
+func (this *WALStore) DeleteRange(min, max int) error {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    first, last := this.bounds()
+    if last == 0 || max < first || min > last {
+        return nil
+    }
+    var err error
+    switch {
+    case min <= first && max >= last:
+        err = this.removeAll()
+    case min <= first:
+        err = this.compact(max)
+    case max >= last:
+        err = this.truncate(min)
+    default:
+        return fmt.Errorf("raft: WAL cannot delete entries %d to %d from the middle of the log", min, max)
+    }
+    if err != nil {
+        return err
+    }
+    return this.commit()
+}
+
+// truncate drops the entries from index on. Must be called with
+// this.mu held.
+func (this *WALStore) truncate(index int) error {
+    i := this.segmentOf(index)
+    if i < 0 {
+        return nil
+    }
+    segment := this.segments[i]
+    if index == segment.first {
+        return this.removeFrom(i)
+    }
+    size := segment.offsets[index-segment.first]
+    if err := segment.file.Truncate(size); err != nil {
+        return err
+    }
+    segment.offsets = segment.offsets[:index-segment.first]
+    segment.size = size
+    this.dirty = true
+    return this.removeFrom(i + 1)
+}
+
+// compact drops the entries up to and including index, which is
+// not the last, deleting the segments they fill and rewriting the
+// one they end in. Must be called with this.mu held.
+func (this *WALStore) compact(index int) error {
+    i := this.segmentOf(index + 1)
+    for _, segment := range this.segments[:i] {
+        segment.file.Close()
+        if err := os.Remove(segment.file.Name()); err != nil {
+            return err
+        }
+    }
+    this.segments = this.segments[i:]
+    this.dirDirty = true
+
+    segment := this.segments[0]
+    if segment.first > index {
+        return nil
+    }
+    offset := segment.offsets[index+1-segment.first]
+    data := make([]byte, walSegmentHeaderSize+int(segment.size-offset))
+    copy(data, walSegmentHeader(index+1))
+    if _, err := segment.file.ReadAt(data[walSegmentHeaderSize:], offset); err != nil {
+        return err
+    }
+    name := filepath.Join(this.dir, walSegmentName(index+1))
+    if err := this.writeFile(name, data); err != nil {
+        return err
+    }
+    file, err := os.OpenFile(name, os.O_RDWR, 0)
+    if err != nil {
+        return err
+    }
+    segment.file.Close()
+    os.Remove(segment.file.Name())
+
+    shift := offset - int64(walSegmentHeaderSize)
+    offsets := make([]int64, 0, segment.next()-index-1)
+    for _, o := range segment.offsets[index+1-segment.first:] {
+        offsets = append(offsets, o-shift)
+    }
+    this.segments[0] = &walSegment{file: file, first: index + 1, offsets: offsets, size: segment.size - shift}
+    return nil
+}
+
+// writeFile creates the file name holding data, atomically.
+func (this *WALStore) writeFile(name string, data []byte) error {
+    file, err := os.CreateTemp(this.dir, filepath.Base(name)+".tmp*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(file.Name())
+
+    if _, err := file.Write(data); err != nil {
+        file.Close()
+        return err
+    }
+    if this.options.Sync != SyncNever {
+        if err := file.Sync(); err != nil {
+            file.Close()
+            return err
+        }
+    }
+    if err := file.Close(); err != nil {
+        return err
+    }
+    return os.Rename(file.Name(), name)
+}
+
+// removeAll deletes every segment. Must be called with this.mu
+// held.
+func (this *WALStore) removeAll() error {
+    return this.removeFrom(0)
+}
+
+// removeFrom deletes the segments from position i on. Must be
+// called with this.mu held.
+func (this *WALStore) removeFrom(i int) error {
+    if i >= len(this.segments) {
+        return nil
+    }
+    for _, segment := range this.segments[i:] {
+        segment.file.Close()
+        if err := os.Remove(segment.file.Name()); err != nil && !os.IsNotExist(err) {
+            return err
+        }
+    }
+    this.segments = this.segments[:i]
+    this.dirDirty = true
+    return nil
+}
+
+// roll starts a segment whose first entry will be index, once the
+// last is durable as far as the policy requires. Must be called with
+// this.mu held.
+func (this *WALStore) roll(index int) error {
+    if this.options.Sync != SyncNever {
+        if err := this.sync(); err != nil {
+            return err
+        }
+    }
+    name := filepath.Join(this.dir, walSegmentName(index))
+    file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+    if err != nil {
+        return err
+    }
+    if _, err := file.Write(walSegmentHeader(index)); err != nil {
+        file.Close()
+        return err
+    }
+    this.segments = append(this.segments, &walSegment{file: file, first: index, size: int64(walSegmentHeaderSize)})
+    this.dirty, this.dirDirty = true, true
+    return nil
+}
+
+// commit makes the changes made so far durable as the policy
+// requires. Must be called with this.mu held, which it releases
+// while waiting for a group commit.
+func (this *WALStore) commit() error {
+    switch this.options.Sync {
+    case SyncEveryWrite:
+        return this.sync()
+    case SyncGroupCommit:
+        return this.groupCommit()
+    }
+    return nil
+}
+
+// groupCommit waits until the changes made so far are synced. The
+// first write to find no sync under way waits MaxSyncDelay for
+// others to join, then syncs them all; the others wait for it. Must
+// be called with this.mu held.
+func (this *WALStore) groupCommit() error {
+    this.written++
+    write := this.written
+    for this.synced < write && this.syncErr == nil {
+        if this.syncing {
+            this.done.Wait()
+            continue
+        }
+        this.syncing = true
+        this.mu.Unlock()
+        time.Sleep(this.options.MaxSyncDelay)
+        this.mu.Lock()
+        this.syncing = false
+        // Close syncs what it can, failing the writes if it cannot.
+        if !this.closed {
+            this.syncErr = this.sync()
+        }
+        this.done.Broadcast()
+    }
+    return this.syncErr
+}
+
+// Sync makes everything written so far durable, whatever the policy.
+func (this *WALStore) Sync() error {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    if this.syncErr != nil {
+        return this.syncErr
+    }
+    return this.sync()
+}
+
+// sync syncs the last segment, and the directory if segments were
+// added or removed, and with them every write made so far. Must be
+// called with this.mu held.
+func (this *WALStore) sync() error {
+    if this.dirty && len(this.segments) > 0 {
+        if err := this.segments[len(this.segments)-1].file.Sync(); err != nil {
+            return err
+        }
+    }
+    this.dirty = false
+    if this.dirDirty {
+        dir, err := os.Open(this.dir)
+        if err != nil {
+            return err
+        }
+        err = dir.Sync()
+        dir.Close()
+        if err != nil {
+            return err
+        }
+        this.dirDirty = false
+    }
+    this.synced = this.written
+    return nil
+}
+
+// Close syncs what is pending and closes the segment files.
+func (this *WALStore) Close() error {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    if this.closed {
+        return nil
+    }
+    this.closed = true
+    err := this.syncErr
+    if err == nil && this.options.Sync != SyncNever {
+        err = this.sync()
+    }
+    if err == nil {
+        this.synced = this.written
+    }
+    this.syncErr = err
+    this.done.Broadcast()
+    for _, segment := range this.segments {
+        segment.file.Close()
+    }
+    return err
+}
+
+func (this *WALStore) String() string {
+    return fmt.Sprintf("WAL %s (sync %v)", this.dir, this.options.Sync)
+}
@@ -0,0 +1,278 @@
+package raft
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "sync"
+    "testing"
+    "time"
+)
+
+// walEntries returns the entries from first to last of term, whose
+// commands name their index and term.
+func walEntries(first, last, term int) []Entry {
+    var entries []Entry
+    for index := first; index <= last; index++ {
+        entries = append(entries, Entry{
+            Type:    EntryNormal,
+            Index:   index,
+            TermNum: term,
+            Command: []byte(fmt.Sprintf("%d/%d", index, term)),
+        })
+    }
+    return entries
+}
+
+// checkWAL fails t unless wal holds the entries from first to last,
+// of term 1 before replacedFrom and of term 2 from it on.
+func checkWAL(t *testing.T, wal *WALStore, first, last, replacedFrom int) {
+    t.Helper()
+    gotFirst, _ := wal.FirstIndex()
+    gotLast, _ := wal.LastIndex()
+    if gotFirst != first || gotLast != last {
+        t.Fatalf("WAL holds %d to %d, want %d to %d", gotFirst, gotLast, first, last)
+    }
+    for index := first; last > 0 && index <= last; index++ {
+        entry, err := wal.GetLog(index)
+        if err != nil {
+            t.Fatalf("entry %d: %v", index, err)
+        }
+        term := 1
+        if replacedFrom > 0 && index >= replacedFrom {
+            term = 2
+        }
+        if entry.Index != index || entry.TermNum != term || string(entry.Command) != fmt.Sprintf("%d/%d", index, term) {
+            t.Fatalf("entry %d is %+v, want term %d", index, entry, term)
+        }
+    }
+}
+
+func TestWALStore(t *testing.T) {
+    for _, policy := range []SyncPolicy{SyncEveryWrite, SyncGroupCommit, SyncNever} {
+        t.Run(policy.String(), func(t *testing.T) {
+            dir := t.TempDir()
+            options := WALOptions{Sync: policy, MaxSyncDelay: time.Millisecond, SegmentSize: 300}
+            wal, err := NewWALStore(dir, options)
+            if err != nil {
+                t.Fatal(err)
+            }
+            for index := 1; index <= 100; index += 10 {
+                if err := wal.StoreLogs(walEntries(index, index+9, 1)); err != nil {
+                    t.Fatal(err)
+                }
+            }
+            checkWAL(t, wal, 1, 100, 0)
+            if len(wal.segments) < 3 {
+                t.Fatalf("WAL has %d segments, want several", len(wal.segments))
+            }
+
+            if err := wal.StoreLogs(walEntries(55, 60, 2)); err != nil {
+                t.Fatal(err)
+            }
+            checkWAL(t, wal, 1, 60, 55)
+            if err := wal.DeleteRange(1, 33); err != nil {
+                t.Fatal(err)
+            }
+            checkWAL(t, wal, 34, 60, 55)
+            if err := wal.DeleteRange(40, 45); err == nil {
+                t.Fatal("deleted entries from the middle of the log")
+            }
+            if err := wal.StoreLogs(walEntries(70, 71, 2)); err == nil {
+                t.Fatal("stored entries past a gap")
+            }
+
+            wal.Close()
+            if wal, err = NewWALStore(dir, options); err != nil {
+                t.Fatal(err)
+            }
+            checkWAL(t, wal, 34, 60, 55)
+            if err := wal.DeleteRange(58, 60); err != nil {
+                t.Fatal(err)
+            }
+            checkWAL(t, wal, 34, 57, 55)
+            if err := wal.DeleteRange(1, 57); err != nil {
+                t.Fatal(err)
+            }
+            checkWAL(t, wal, 0, 0, 0)
+            if err := wal.StoreLogs(walEntries(200, 205, 1)); err != nil {
+                t.Fatal(err)
+            }
+            if err := wal.DeleteRange(200, 202); err != nil {
+                t.Fatal(err)
+            }
+
+            wal.Close()
+            if wal, err = NewWALStore(dir, options); err != nil {
+                t.Fatal(err)
+            }
+            checkWAL(t, wal, 203, 205, 0)
+            wal.Close()
+        })
+    }
+}
+
+func TestWALGroupCommitWaitsForSync(t *testing.T) {
+    wal, err := NewWALStore(t.TempDir(), WALOptions{Sync: SyncGroupCommit, MaxSyncDelay: 20 * time.Millisecond, SegmentSize: 64 << 20})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer wal.Close()
+
+    // Concurrent writes share one sync, each returning once it is
+    // done.
+    var wg sync.WaitGroup
+    for i := 0; i < 4; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            wal.mu.Lock()
+            wal.dirty = true
+            err := wal.commit()
+            unsynced := wal.synced < wal.written
+            wal.mu.Unlock()
+            if err != nil || unsynced {
+                t.Errorf("commit returned %v before its write was synced", err)
+            }
+        }()
+    }
+    wg.Wait()
+
+    began := time.Now()
+    if err := wal.StoreLogs(walEntries(1, 1, 1)); err != nil {
+        t.Fatal(err)
+    }
+    if waited := time.Since(began); waited < 20*time.Millisecond {
+        t.Fatalf("write returned after %v, before MaxSyncDelay", waited)
+    }
+    if wal.synced != wal.written {
+        t.Fatal("write returned before it was synced")
+    }
+}
+
+// corruptionCounter counts the corruptions a WALStore reports.
+type corruptionCounter struct {
+    NoopMetrics
+    count float64
+}
+
+func (this *corruptionCounter) IncrCounter(name string, labels Labels, delta float64) {
+    if name == MetricWALCorruptions {
+        this.count += delta
+    }
+}
+
+func TestWALCorruption(t *testing.T) {
+    dir := t.TempDir()
+    metrics := &corruptionCounter{}
+    options := WALOptions{Sync: SyncEveryWrite, SegmentSize: 300, Metrics: metrics}
+    wal, err := NewWALStore(dir, options)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for index := 1; index <= 100; index += 10 {
+        if err := wal.StoreLogs(walEntries(index, index+9, 1)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    wal.Close()
+    names, _ := filepath.Glob(filepath.Join(dir, "*.wal"))
+    sort.Strings(names)
+    appendTo := func(name string, data []byte) {
+        file, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0)
+        if err != nil {
+            t.Fatal(err)
+        }
+        file.Write(data)
+        file.Close()
+    }
+
+    // A record torn by a crash, or zeros, at the end are dropped
+    // without being reported.
+    for _, tail := range [][]byte{{0, 0, 0, 50, 1, 2}, make([]byte, 100)} {
+        appendTo(names[len(names)-1], tail)
+        if wal, err = NewWALStore(dir, options); err != nil {
+            t.Fatal(err)
+        }
+        if err := wal.Corruption(); err != nil || metrics.count != 0 {
+            t.Fatalf("torn tail reported as %v", err)
+        }
+        checkWAL(t, wal, 1, 100, 0)
+        wal.Close()
+    }
+
+    // A flipped bit in the middle fails reads, and truncates the log
+    // when the WAL is opened again.
+    if wal, err = NewWALStore(dir, options); err != nil {
+        t.Fatal(err)
+    }
+    segment := wal.segments[2]
+    data, _ := os.ReadFile(names[2])
+    data[segment.offsets[1]+walHeaderSize+2] ^= 0xff
+    os.WriteFile(names[2], data, 0o644)
+    if _, err := wal.GetLog(segment.first + 1); !errors.Is(err, ErrWALCorrupt) {
+        t.Fatalf("reading a corrupt entry returned %v", err)
+    }
+    wal.Close()
+
+    if wal, err = NewWALStore(dir, options); err != nil {
+        t.Fatal(err)
+    }
+    var corruption *WALCorruptionError
+    if !errors.As(wal.Corruption(), &corruption) || corruption.Index != segment.first+1 {
+        t.Fatalf("corruption reported as %v", wal.Corruption())
+    }
+    if metrics.count != 1 {
+        t.Fatalf("%v corruptions counted, want 1", metrics.count)
+    }
+    checkWAL(t, wal, 1, segment.first, 0)
+    if left, _ := filepath.Glob(filepath.Join(dir, "*.wal")); len(left) != 3 {
+        t.Fatalf("%d segments left, want 3", len(left))
+    }
+    if err := wal.StoreLogs(walEntries(segment.first+1, segment.first+30, 2)); err != nil {
+        t.Fatal(err)
+    }
+    wal.Close()
+
+    // A corrupt header on the first segment loses everything.
+    names, _ = filepath.Glob(filepath.Join(dir, "*.wal"))
+    sort.Strings(names)
+    data, _ = os.ReadFile(names[0])
+    data[0] = 'X'
+    os.WriteFile(names[0], data, 0o644)
+    if wal, err = NewWALStore(dir, options); err != nil {
+        t.Fatal(err)
+    }
+    defer wal.Close()
+    checkWAL(t, wal, 0, 0, 0)
+}
+
+// BenchmarkWALStore appends batches of 16 entries of 256 bytes, as a
+// node does, under each sync policy.
+func BenchmarkWALStore(b *testing.B) {
+    for _, policy := range []SyncPolicy{SyncEveryWrite, SyncGroupCommit, SyncNever} {
+        b.Run(policy.String(), func(b *testing.B) {
+            options := DefaultWALOptions()
+            options.Sync = policy
+            wal, err := NewWALStore(b.TempDir(), options)
+            if err != nil {
+                b.Fatal(err)
+            }
+            defer wal.Close()
+
+            command := make([]byte, 256)
+            entries := make([]Entry, 16)
+            b.SetBytes(int64(len(entries) * len(command)))
+            for n := 0; n < b.N; n++ {
+                for i := range entries {
+                    entries[i] = Entry{Type: EntryNormal, Index: n*len(entries) + i + 1, TermNum: 1, Command: command}
+                }
+                if err := wal.StoreLogs(entries); err != nil {
+                    b.Fatal(err)
+                }
+            }
+        })
+    }
+}
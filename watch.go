@@ -0,0 +1,250 @@
+package raft
+
+import (
+    "errors"
+    "sync"
+)
+
+// ErrWatchOverflow ends a watch whose reader fell more than
+// watchBacklog entries behind the state machine.
+var ErrWatchOverflow = errors.New("raft: watch fell too far behind")
+
+// watchBacklog is how many applied entries a watch holds for a
+// reader that falls behind before it ends.
+const watchBacklog = 1 << 16
+
+// watchReadBatch is how many entries a watch reads back from the log
+// at a time, holding the node's lock.
+const watchReadBatch = 256
+
+// AppliedEntry is a command a node has applied, delivered by
+// WatchApplied.
+type AppliedEntry struct {
+    Index   int
+    Term    int
+    Command []byte
+
+    // What Config.Apply returned for the command. Commands read back
+    // from the log, applied before the watch started, have neither.
+    Result any
+    Err    error
+}
+
+// AppliedWatch delivers the commands a node applies, in log order,
+// for building change-data-capture feeds or secondary indexes off the
+// log. Its reader sets the pace: entries are queued for a reader that
+// falls behind, up to watchBacklog of them, past which the watch
+// ends with ErrWatchOverflow.
+type AppliedWatch struct {
+    node *Node
+    ch   chan AppliedEntry
+
+    // Guarded by node.mu: the next entry to read back from the log,
+    // the first one queued as it is applied, the entries queued, and
+    // why the watch ended, once it has.
+    next  int
+    live  int
+    queue []AppliedEntry
+    ended bool
+    err   error
+
+    // Signalled when entries are queued or the watch ends, and
+    // closed by Close.
+    wake     chan struct{}
+    stop     chan struct{}
+    stopOnce sync.Once
+}
+
+// WatchApplied starts delivering the commands the node applies from
+// fromIndex on, in log order and without gaps, to the channel of the
+// watch returned. Commands the node applied before the watch started
+// are read back from the log, without their results; the watch ends
+// with ErrCompacted if some of them have been compacted into a
+// snapshot, or if the node installs a snapshot from the leader,
+// which it restores instead of applying the commands it covers. Only
+// commands passed to the state machine are delivered, save those
+// read back from the log, which include commands a client session
+// deduplicated.
+//
+// The channel is closed when the watch ends, after every entry
+// queued has been delivered; Err then tells why.
+func (this *Node) WatchApplied(fromIndex int) *AppliedWatch {
+    watch := &AppliedWatch{
+        node: this,
+        ch:   make(chan AppliedEntry),
+        wake: make(chan struct{}, 1),
+        stop: make(chan struct{}),
+    }
+
+    this.mu.Lock()
+    watch.next = maxInt(fromIndex, 1)
+    watch.live = maxInt(watch.next, this.lastApplied+1)
+    if this.shutdown {
+        watch.ended, watch.err = true, ErrRaftShutdown
+    } else {
+        this.watches = append(this.watches, watch)
+    }
+    this.mu.Unlock()
+
+    go watch.run()
+    return watch
+}
+
+// Entries returns the channel the watch delivers entries to.
+func (this *AppliedWatch) Entries() <-chan AppliedEntry {
+    return this.ch
+}
+
+// Err returns why the watch ended: nil if it was closed, or else the
+// error that ended it. Only valid once the channel is closed.
+func (this *AppliedWatch) Err() error {
+    this.node.mu.Lock()
+    defer this.node.mu.Unlock()
+    return this.err
+}
+
+// Close ends the watch, dropping the entries not yet delivered.
+func (this *AppliedWatch) Close() {
+    this.node.mu.Lock()
+    this.node.endWatch(this, nil)
+    this.node.removeEndedWatches()
+    this.node.mu.Unlock()
+    this.stopOnce.Do(func() { close(this.stop) })
+}
+
+// run delivers the entries read back from the log, then those queued
+// as they are applied, until the watch ends.
+func (this *AppliedWatch) run() {
+    defer close(this.ch)
+    node := this.node
+    for {
+        var entries []AppliedEntry
+        node.mu.Lock()
+        switch {
+        case this.ended && (this.err == nil || len(this.queue) == 0 || this.next < this.live):
+            // Entries queued after a gap in those read back are
+            // dropped.
+            node.mu.Unlock()
+            return
+        case this.next < this.live:
+            var err error
+            entries, err = this.readBack()
+            if err != nil {
+                node.endWatch(this, err)
+                node.removeEndedWatches()
+                node.mu.Unlock()
+                return
+            }
+        default:
+            entries, this.queue = this.queue, nil
+        }
+        node.mu.Unlock()
+
+        if len(entries) == 0 {
+            select {
+            case <-this.wake:
+            case <-this.stop:
+                return
+            }
+            continue
+        }
+        for _, entry := range entries {
+            select {
+            case this.ch <- entry:
+            case <-this.stop:
+                return
+            }
+        }
+    }
+}
+
+// readBack reads the next commands applied before the watch started
+// from the log. Must be called with node.mu held.
+func (this *AppliedWatch) readBack() ([]AppliedEntry, error) {
+    node := this.node
+    if this.next <= node.log[0].Index {
+        return nil, ErrCompacted
+    }
+    last := minInt(this.live-1, this.next+watchReadBatch-1)
+    read, err := node.readEntries(this.next, last)
+    if err != nil {
+        return nil, err
+    }
+    this.next = last + 1
+    var entries []AppliedEntry
+    for _, entry := range read {
+        if entry.Type == EntryNormal {
+            entries = append(entries, AppliedEntry{Index: entry.Index, Term: entry.TermNum, Command: entry.Command})
+        }
+    }
+    return entries, nil
+}
+
+// signal wakes the watch's goroutine, if it is waiting.
+func (this *AppliedWatch) signal() {
+    select {
+    case this.wake <- struct{}{}:
+    default:
+    }
+}
+
+// notifyWatches queues for every watch the commands of batch the
+// state machine applied. Must be called with this.mu held.
+func (this *Node) notifyWatches(batch []appliedEntry) {
+    for _, watch := range this.watches {
+        for _, applied := range batch {
+            if !applied.apply || applied.entry.Index < watch.live {
+                continue
+            }
+            if len(watch.queue) >= watchBacklog {
+                this.endWatch(watch, ErrWatchOverflow)
+                break
+            }
+            entry := applied.entry
+            watch.queue = append(watch.queue, AppliedEntry{
+                Index:   entry.Index,
+                Term:    entry.TermNum,
+                Command: entry.Command,
+                Result:  applied.result,
+                Err:     applied.err,
+            })
+        }
+        watch.signal()
+    }
+    this.removeEndedWatches()
+}
+
+// endWatches ends every watch with err. Must be called with this.mu
+// held.
+func (this *Node) endWatches(err error) {
+    for _, watch := range this.watches {
+        this.endWatch(watch, err)
+    }
+    this.removeEndedWatches()
+}
+
+// endWatch ends watch with err, once the entries queued have been
+// delivered, unless it already ended; removeEndedWatches then stops
+// notifying it. Must be called with this.mu held.
+func (this *Node) endWatch(watch *AppliedWatch, err error) {
+    if watch.ended {
+        return
+    }
+    watch.ended, watch.err = true, err
+    if err == nil {
+        watch.queue = nil
+    }
+    watch.signal()
+}
+
+// removeEndedWatches stops notifying the watches that ended. Must be
+// called with this.mu held.
+func (this *Node) removeEndedWatches() {
+    watches := this.watches[:0]
+    for _, watch := range this.watches {
+        if !watch.ended {
+            watches = append(watches, watch)
+        }
+    }
+    this.watches = watches
+}
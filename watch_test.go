@@ -0,0 +1,97 @@
+package raft
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "testing"
+    "time"
+)
+
+// emptySnapshotter snapshots a state machine that holds nothing.
+type emptySnapshotter struct{}
+
+func (emptySnapshotter) Snapshot() ([]byte, error) { return nil, nil }
+
+func (emptySnapshotter) Restore([]byte) error { return nil }
+
+// receive reads n entries from watch, failing t if they do not come.
+func receive(t *testing.T, watch *AppliedWatch, n int) []AppliedEntry {
+    t.Helper()
+    var entries []AppliedEntry
+    for len(entries) < n {
+        select {
+        case entry, ok := <-watch.Entries():
+            if !ok {
+                t.Fatalf("watch ended after %d entries: %v", len(entries), watch.Err())
+            }
+            entries = append(entries, entry)
+        case <-time.After(5 * time.Second):
+            t.Fatalf("received %d entries, want %d", len(entries), n)
+        }
+    }
+    return entries
+}
+
+func TestWatchApplied(t *testing.T) {
+    cluster := startCluster(t, 3,
+        WithApply(func(command []byte) (any, error) { return len(command), nil }),
+        WithSnapshotter(emptySnapshotter{}, 1000))
+    leader := cluster.leader(t)
+    propose := func(command string) {
+        if err := leader.Propose(context.Background(), []byte(command)).Error(); err != nil {
+            t.Fatal(err)
+        }
+    }
+    propose("a")
+    propose("bb")
+
+    // A watch reads back the commands applied before it started,
+    // then receives those applied since with their results.
+    watch := leader.WatchApplied(0)
+    propose("ccc")
+    entries := receive(t, watch, 3)
+    for i, command := range []string{"a", "bb", "ccc"} {
+        if string(entries[i].Command) != command || (i > 0 && entries[i].Index <= entries[i-1].Index) {
+            t.Fatalf("entries %+v", entries)
+        }
+    }
+    if entries[0].Result != nil || entries[2].Result != 3 || entries[2].Term != leader.Term() {
+        t.Fatalf("entries %+v", entries)
+    }
+
+    // From an index on, only the commands from there are delivered.
+    from := leader.WatchApplied(entries[1].Index)
+    if got := receive(t, from, 2); string(got[0].Command) != "bb" || string(got[1].Command) != "ccc" {
+        t.Fatalf("watch from %d received %+v", entries[1].Index, got)
+    }
+    from.Close()
+    if _, ok := <-from.Entries(); ok || from.Err() != nil {
+        t.Fatalf("closed watch: %v", from.Err())
+    }
+
+    // Commands compacted away cannot be read back.
+    if err := leader.Snapshot().Error(); err != nil {
+        t.Fatal(err)
+    }
+    compacted := leader.WatchApplied(1)
+    if _, ok := <-compacted.Entries(); ok || !errors.Is(compacted.Err(), ErrCompacted) {
+        t.Fatalf("watch of compacted entries: %v", compacted.Err())
+    }
+
+    // A watch whose reader falls behind keeps every entry, in order,
+    // until the node shuts down.
+    for i := 0; i < 50; i++ {
+        propose(fmt.Sprint(i))
+    }
+    entries = receive(t, watch, 50)
+    for i, entry := range entries {
+        if string(entry.Command) != fmt.Sprint(i) || entry.Result != len(entry.Command) {
+            t.Fatalf("entry %d is %+v", i, entry)
+        }
+    }
+    leader.Shutdown(context.Background())
+    if _, ok := <-watch.Entries(); ok || !errors.Is(watch.Err(), ErrRaftShutdown) {
+        t.Fatalf("watch after shutdown: %v", watch.Err())
+    }
+}
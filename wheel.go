@@ -0,0 +1,212 @@
+package raft
+
+import (
+    "sync"
+)
+
+// A timingWheel has wheelLevels levels of wheelSlots slots each:
+// level 0 holds the timers due within wheelSlots ticks, one slot a
+// tick, and each level above covers wheelSlots times the span of
+// the one below, its timers cascading down as their turn nears.
+// Four levels of 64 slots cover 2^24 ticks, the longest delay a
+// timer can be scheduled for.
+const (
+    wheelBits   = 6
+    wheelSlots  = 1 << wheelBits
+    wheelLevels = 4
+)
+
+// timingWheel is a hierarchical timing wheel, through which a
+// MultiNode ticks each of its groups only when one of the group's
+// timers is due, rather than every group every tick. Scheduling and
+// expiring a timer take constant time however many groups there
+// are.
+type timingWheel struct {
+    mu sync.Mutex
+
+    // Ticks elapsed since the wheel was created.
+    now int
+
+    levels [wheelLevels][wheelSlots][]wheelEntry
+}
+
+// wheelTimer is a node's timer in a timingWheel. Each node has at
+// most one: rescheduling it leaves the superseded entry in the
+// wheel, to be skipped when it comes due.
+type wheelTimer struct {
+    wheel *timingWheel
+    node  *Node
+
+    // Incremented each time the timer is scheduled, and guarded by
+    // the wheel's mu.
+    generation int
+}
+
+type wheelEntry struct {
+    timer      *wheelTimer
+    generation int
+    due        int
+}
+
+// newTimer returns a timer for node, not yet scheduled.
+func (this *timingWheel) newTimer(node *Node) *wheelTimer {
+    return &wheelTimer{wheel: this, node: node}
+}
+
+// ticks returns the number of ticks elapsed.
+func (this *timingWheel) ticks() int {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    return this.now
+}
+
+// schedule has timer expire after delay ticks, clamped to the span
+// of the wheel, superseding when it was due before.
+func (this *timingWheel) schedule(timer *wheelTimer, delay int) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    delay = minInt(maxInt(delay, 1), 1<<(wheelBits*wheelLevels)-1)
+    timer.generation++
+    this.insert(wheelEntry{timer: timer, generation: timer.generation, due: this.now + delay})
+}
+
+// cancel stops timer from expiring until it is scheduled again.
+func (this *timingWheel) cancel(timer *wheelTimer) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+    timer.generation++
+}
+
+// insert files entry in the slot of the lowest level whose span
+// reaches its due tick. Must be called with this.mu held.
+func (this *timingWheel) insert(entry wheelEntry) {
+    delta := entry.due - this.now
+    level := 0
+    for delta >= 1<<(wheelBits*(level+1)) {
+        level++
+    }
+    slot := (entry.due >> (wheelBits * level)) & (wheelSlots - 1)
+    this.levels[level][slot] = append(this.levels[level][slot], entry)
+}
+
+// advance moves the wheel one tick on, and returns the tick and the
+// timers that expire on it.
+func (this *timingWheel) advance() (int, []*wheelTimer) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    this.now++
+
+    // Entering a new turn of a level brings the timers of its next
+    // slot down to the levels below, starting from the highest.
+    var level int
+    for level = 1; level < wheelLevels; level++ {
+        if this.now&(1<<(wheelBits*level)-1) != 0 {
+            break
+        }
+    }
+    for level--; level >= 1; level-- {
+        slot := (this.now >> (wheelBits * level)) & (wheelSlots - 1)
+        entries := this.levels[level][slot]
+        this.levels[level][slot] = nil
+        for _, entry := range entries {
+            this.insert(entry)
+        }
+    }
+
+    slot := this.now & (wheelSlots - 1)
+    entries := this.levels[0][slot]
+    this.levels[0][slot] = nil
+    var due []*wheelTimer
+    for _, entry := range entries {
+        if entry.generation == entry.timer.generation {
+            due = append(due, entry.timer)
+        }
+    }
+    return this.now, due
+}
+
+// The Node side of a timingWheel. A node driven by one is ticked
+// only when a timer of its is due, and then catches up on the ticks
+// it skipped. Until then, its tick counters lag the wheel, so every
+// change to them or to what the node waits for first catches up,
+// then reschedules the node's timer.
+
+// catchUp adds the ticks the wheel has moved on since the node was
+// last ticked to its idle timers: those of elections, heartbeats and
+// audits. The others only run while the node is ticked every tick.
+// Must be called with this.mu held.
+func (this *Node) catchUp(to int) {
+    lag := to - this.ticked
+    if lag <= 0 {
+        return
+    }
+    this.ticked = to
+    switch {
+    case this.paused:
+    case this.nodeType == Leader:
+        this.heartbeatElapsed += lag
+        this.auditElapsed += lag
+    default:
+        this.electionElapsed += lag
+    }
+}
+
+// syncTimer catches up with the wheel driving the node, if any,
+// before a tick counter is reset. Must be called with this.mu held.
+func (this *Node) syncTimer() {
+    if this.timer != nil {
+        this.catchUp(this.timer.wheel.ticks())
+    }
+}
+
+// reschedule catches up with the wheel driving the node, if any,
+// and sets its timer for the next tick on which the node has work
+// to do. Must be called with this.mu held, after anything that may
+// bring that tick forward.
+func (this *Node) reschedule() {
+    if this.timer == nil {
+        return
+    }
+    this.syncTimer()
+    if this.shutdown {
+        this.timer.wheel.cancel(this.timer)
+        return
+    }
+    this.timer.wheel.schedule(this.timer, this.ticksUntilDue())
+}
+
+// ticksUntilDue returns how many ticks the node can go without
+// being ticked. Must be called with this.mu held.
+func (this *Node) ticksUntilDue() int {
+    if this.paused {
+        return wheelSlots
+    }
+    if this.nodeType == Leader {
+        if len(this.proposals) > 0 || this.leaderTransfer != nil || len(this.verifications) > 0 {
+            return 1
+        }
+        due := this.config.HeartbeatTicks - this.heartbeatElapsed
+        if interval := this.config.Audit.Interval; interval > 0 {
+            due = minInt(due, interval-this.auditElapsed)
+        }
+        return due
+    }
+    if this.prewarmPending > 0 {
+        return 1
+    }
+    return this.electionTimeout - this.electionElapsed
+}
+
+// tickTo ticks the node on tick of its wheel, after catching up
+// with the ticks before it.
+func (this *Node) tickTo(tick int) {
+    this.mu.Lock()
+    defer this.mu.Unlock()
+
+    this.catchUp(tick - 1)
+    this.ticked = tick
+    this.tick()
+    this.reschedule()
+}
@@ -0,0 +1,117 @@
+package raft
+
+import (
+    "context"
+    "fmt"
+    "math/rand"
+    "testing"
+    "time"
+)
+
+func TestTimingWheel(t *testing.T) {
+    var wheel timingWheel
+    random := rand.New(rand.NewSource(1))
+    due := make(map[*wheelTimer]int)
+    for i := 0; i < 2000; i++ {
+        timer := wheel.newTimer(nil)
+        delay := 1 + random.Intn(300000)
+        if i%3 == 0 {
+            delay = 1 + random.Intn(100)
+        }
+        wheel.schedule(timer, delay)
+        due[timer] = delay
+    }
+    // Rescheduling supersedes the earlier deadline, and cancelling
+    // drops it.
+    for timer := range due {
+        switch random.Intn(8) {
+        case 0:
+            delay := 1 + random.Intn(5000)
+            wheel.schedule(timer, delay)
+            due[timer] = delay
+        case 1:
+            wheel.cancel(timer)
+            delete(due, timer)
+        }
+    }
+
+    for tick := 1; tick <= 300000; tick++ {
+        now, expired := wheel.advance()
+        if now != tick {
+            t.Fatalf("wheel is at tick %d, want %d", now, tick)
+        }
+        for _, timer := range expired {
+            want, ok := due[timer]
+            if !ok || want != tick {
+                t.Fatalf("timer expired at tick %d, due at %d (scheduled %v)", tick, want, ok)
+            }
+            delete(due, timer)
+        }
+    }
+    if len(due) != 0 {
+        t.Fatalf("%d timers never expired", len(due))
+    }
+}
+
+func TestMultiNodeSteadyState(t *testing.T) {
+    ids := []ServerId{"1", "2", "3"}
+    multis := make(map[ServerId]*MultiNode)
+    for _, id := range ids {
+        multis[id] = NewMultiNode(id, time.Millisecond, func(msg Message, reply func(Message, error)) {
+            go func() {
+                reply(multis[msg.To].Step(msg))
+            }()
+        })
+    }
+    groups := []string{"g0", "g1", "g2", "g3", "g4"}
+    for _, group := range groups {
+        for _, id := range ids {
+            var peers []ServerId
+            for _, peer := range ids {
+                if peer != id {
+                    peers = append(peers, peer)
+                }
+            }
+            if _, err := multis[id].AddGroup(group, peers, func([]byte) {}, WithTimeouts(3, 10, 20)); err != nil {
+                t.Fatal(err)
+            }
+        }
+    }
+    for _, multi := range multis {
+        multi.Start()
+    }
+    defer func() {
+        for _, multi := range multis {
+            multi.Shutdown(context.Background())
+        }
+    }()
+
+    leader := func(group string) *Node {
+        for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+            for _, multi := range multis {
+                if node := multi.Group(group); node.Role() == Leader {
+                    return node
+                }
+            }
+        }
+        t.Fatalf("group %s elected no leader", group)
+        return nil
+    }
+
+    // Heartbeats scheduled through the wheel keep the leaders in
+    // place.
+    terms := make(map[string]int)
+    for _, group := range groups {
+        terms[group] = leader(group).Status().Term
+    }
+    time.Sleep(200 * time.Millisecond)
+    for _, group := range groups {
+        node := leader(group)
+        if term := node.Status().Term; term != terms[group] {
+            t.Fatalf("group %s moved from term %d to %d while steady", group, terms[group], term)
+        }
+        if err := node.Propose(context.Background(), []byte(fmt.Sprint(group))).Error(); err != nil {
+            t.Fatal(err)
+        }
+    }
+}
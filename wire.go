@@ -0,0 +1,398 @@
+package raft
+
+import (
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "sort"
+)
+
+// SnapshotVersion is the version of the snapshot format this package
+// writes, and the latest it reads.
+const SnapshotVersion = 1
+
+// The field numbers of the messages in raft.proto.
+const (
+    fieldEntryIndex    fieldNumber = 1
+    fieldEntryTerm     fieldNumber = 2
+    fieldEntryType     fieldNumber = 3
+    fieldEntryCommand  fieldNumber = 4
+    fieldEntryPriority fieldNumber = 5
+    fieldEntryClientId fieldNumber = 6
+    fieldEntrySequence fieldNumber = 7
+
+    fieldServerId      fieldNumber = 1
+    fieldServerAddress fieldNumber = 2
+    fieldServerReplica fieldNumber = 3
+    fieldServerRegion  fieldNumber = 4
+    fieldServerZone    fieldNumber = 5
+    fieldServerDemoted fieldNumber = 6
+
+    fieldConfigurationServers     fieldNumber = 1
+    fieldConfigurationNextServers fieldNumber = 2
+
+    fieldSessionClientId fieldNumber = 1
+    fieldSessionSequence fieldNumber = 2
+    fieldSessionIndex    fieldNumber = 3
+    fieldSessionTerm     fieldNumber = 4
+    fieldSessionLastUsed fieldNumber = 5
+
+    fieldMetaVersion            fieldNumber = 1
+    fieldMetaIndex              fieldNumber = 2
+    fieldMetaTerm               fieldNumber = 3
+    fieldMetaEpoch              fieldNumber = 4
+    fieldMetaConfiguration      fieldNumber = 5
+    fieldMetaConfigurationIndex fieldNumber = 6
+    fieldMetaConfigurationTerm  fieldNumber = 7
+    fieldMetaSessions           fieldNumber = 8
+    fieldMetaChecksum           fieldNumber = 9
+
+    fieldSnapshotMeta fieldNumber = 1
+    fieldSnapshotData fieldNumber = 2
+)
+
+// MarshalEntry encodes entry as the Entry message of raft.proto, for
+// LogStores that keep entries on disk and tools that read them.
+func MarshalEntry(entry Entry) []byte {
+    buf := appendVarint(nil, fieldEntryIndex, uint64(entry.Index))
+    buf = appendVarint(buf, fieldEntryTerm, uint64(entry.TermNum))
+    buf = appendVarint(buf, fieldEntryType, uint64(entry.Type))
+    buf = appendBytes(buf, fieldEntryCommand, entry.Command)
+    buf = appendVarint(buf, fieldEntryPriority, encodeZigZag(int64(entry.Priority)))
+    buf = appendVarint(buf, fieldEntryClientId, encodeZigZag(int64(entry.ClientId)))
+    return appendVarint(buf, fieldEntrySequence, uint64(entry.Sequence))
+}
+
+// UnmarshalEntry decodes an entry encoded by MarshalEntry, or by any
+// other implementation of raft.proto.
+func UnmarshalEntry(buf []byte) (Entry, error) {
+    var entry Entry
+    err := decodeFields(buf, func(num fieldNumber, value uint64, bytes []byte) error {
+        switch num {
+        case fieldEntryIndex:
+            entry.Index = int(value)
+        case fieldEntryTerm:
+            entry.TermNum = int(value)
+        case fieldEntryType:
+            entry.Type = EntryType(value)
+        case fieldEntryCommand:
+            entry.Command = append([]byte(nil), bytes...)
+        case fieldEntryPriority:
+            entry.Priority = int(decodeZigZag(value))
+        case fieldEntryClientId:
+            entry.ClientId = int(decodeZigZag(value))
+        case fieldEntrySequence:
+            entry.Sequence = int(value)
+        }
+        return nil
+    })
+    if err != nil {
+        return Entry{}, fmt.Errorf("raft: corrupt entry: %w", err)
+    }
+    return entry, nil
+}
+
+// encodeMembers encodes the servers of a configuration, and those it
+// is moving to if it is joint, as the command of a configuration
+// entry: the Configuration message of raft.proto.
+func encodeMembers(configuration Configuration) []byte {
+    var buf []byte
+    for _, server := range configuration.Servers {
+        buf = appendMessage(buf, fieldConfigurationServers, encodeServer(server))
+    }
+    for _, server := range configuration.NextServers {
+        buf = appendMessage(buf, fieldConfigurationNextServers, encodeServer(server))
+    }
+    return buf
+}
+
+func encodeServer(server Server) []byte {
+    buf := appendBytes(nil, fieldServerId, []byte(server.Id))
+    buf = appendBytes(buf, fieldServerAddress, []byte(server.Address))
+    if server.Replica {
+        buf = appendVarint(buf, fieldServerReplica, 1)
+    }
+    buf = appendBytes(buf, fieldServerRegion, []byte(server.Locality.Region))
+    buf = appendBytes(buf, fieldServerZone, []byte(server.Locality.Zone))
+    if server.Demoted {
+        buf = appendVarint(buf, fieldServerDemoted, 1)
+    }
+    return buf
+}
+
+// decodeMembers decodes the servers encoded by encodeMembers.
+func decodeMembers(buf []byte) (Configuration, error) {
+    var configuration Configuration
+    err := decodeFields(buf, func(num fieldNumber, value uint64, bytes []byte) error {
+        if num != fieldConfigurationServers && num != fieldConfigurationNextServers {
+            return nil
+        }
+        var server Server
+        err := decodeFields(bytes, func(num fieldNumber, value uint64, bytes []byte) error {
+            switch num {
+            case fieldServerId:
+                server.Id = ServerId(bytes)
+            case fieldServerAddress:
+                server.Address = ServerAddress(bytes)
+            case fieldServerReplica:
+                server.Replica = value != 0
+            case fieldServerRegion:
+                server.Locality.Region = string(bytes)
+            case fieldServerZone:
+                server.Locality.Zone = string(bytes)
+            case fieldServerDemoted:
+                server.Demoted = value != 0
+            }
+            return nil
+        })
+        if num == fieldConfigurationServers {
+            configuration.Servers = append(configuration.Servers, server)
+        } else {
+            configuration.NextServers = append(configuration.NextServers, server)
+        }
+        return err
+    })
+    if err != nil {
+        return Configuration{}, fmt.Errorf("%w: %v", errCorruptConfiguration, err)
+    }
+    return configuration, nil
+}
+
+// SnapshotMeta describes a snapshot taken by a node: the entry it
+// covers the log up to, and the state restored along with the state
+// machine's own snapshot.
+type SnapshotMeta struct {
+    Version int
+    Index   int
+    Term    int
+    Epoch   int
+
+    // The membership in force at Index.
+    Configuration Configuration
+
+    // The number of client sessions.
+    Sessions int
+}
+
+// ReadSnapshotMeta decodes the metadata of a snapshot, as sent with
+// InstallSnapshot, leaving out the state machine's snapshot.
+func ReadSnapshotMeta(snapshot []byte) (SnapshotMeta, error) {
+    state, err := decodeSnapshot(snapshot)
+    if err != nil {
+        return SnapshotMeta{}, err
+    }
+    return SnapshotMeta{
+        Version:       state.version,
+        Index:         state.index,
+        Term:          state.term,
+        Epoch:         state.epoch,
+        Configuration: state.members,
+        Sessions:      len(state.sessions),
+    }, nil
+}
+
+// marshalSnapshot encodes state as the Snapshot message of
+// raft.proto.
+func marshalSnapshot(state snapshotState) []byte {
+    ids := make([]int, 0, len(state.sessions))
+    for id := range state.sessions {
+        ids = append(ids, id)
+    }
+    sort.Ints(ids)
+
+    meta := appendVarint(nil, fieldMetaVersion, SnapshotVersion)
+    meta = appendVarint(meta, fieldMetaIndex, uint64(state.index))
+    meta = appendVarint(meta, fieldMetaTerm, uint64(state.term))
+    meta = appendVarint(meta, fieldMetaEpoch, uint64(state.epoch))
+    meta = appendMessage(meta, fieldMetaConfiguration, encodeMembers(state.members))
+    meta = appendVarint(meta, fieldMetaConfigurationIndex, uint64(state.members.Index))
+    meta = appendVarint(meta, fieldMetaConfigurationTerm, uint64(state.members.Term))
+    for _, id := range ids {
+        session := state.sessions[id]
+        buf := appendVarint(nil, fieldSessionClientId, encodeZigZag(int64(id)))
+        buf = appendVarint(buf, fieldSessionSequence, uint64(session.sequence))
+        buf = appendVarint(buf, fieldSessionIndex, uint64(session.index))
+        buf = appendVarint(buf, fieldSessionTerm, uint64(session.term))
+        buf = appendVarint(buf, fieldSessionLastUsed, uint64(session.lastUsed))
+        meta = appendMessage(meta, fieldMetaSessions, buf)
+    }
+    meta = appendVarint(meta, fieldMetaChecksum, state.checksum)
+
+    buf := appendMessage(nil, fieldSnapshotMeta, meta)
+    return appendBytes(buf, fieldSnapshotData, state.data)
+}
+
+// decodeSnapshot decodes a snapshot made by marshalSnapshot.
+func decodeSnapshot(snapshot []byte) (snapshotState, error) {
+    state := snapshotState{sessions: make(map[int]*clientSession)}
+    err := decodeFields(snapshot, func(num fieldNumber, value uint64, bytes []byte) error {
+        switch num {
+        case fieldSnapshotMeta:
+            return decodeSnapshotMeta(bytes, &state)
+        case fieldSnapshotData:
+            state.data = bytes
+        }
+        return nil
+    })
+    if err != nil {
+        return snapshotState{}, fmt.Errorf("%w: %v", errCorruptSnapshot, err)
+    }
+    if state.version > SnapshotVersion {
+        return snapshotState{}, fmt.Errorf("%w: version %d is newer than %d", errCorruptSnapshot, state.version, SnapshotVersion)
+    }
+    return state, nil
+}
+
+func decodeSnapshotMeta(buf []byte, state *snapshotState) error {
+    return decodeFields(buf, func(num fieldNumber, value uint64, bytes []byte) error {
+        switch num {
+        case fieldMetaVersion:
+            state.version = int(value)
+        case fieldMetaIndex:
+            state.index = int(value)
+        case fieldMetaTerm:
+            state.term = int(value)
+        case fieldMetaEpoch:
+            state.epoch = int(value)
+        case fieldMetaConfiguration:
+            members, err := decodeMembers(bytes)
+            if err != nil {
+                return err
+            }
+            state.members.Servers, state.members.NextServers = members.Servers, members.NextServers
+        case fieldMetaConfigurationIndex:
+            state.members.Index = int(value)
+        case fieldMetaConfigurationTerm:
+            state.members.Term = int(value)
+        case fieldMetaSessions:
+            var id int
+            session := &clientSession{}
+            err := decodeFields(bytes, func(num fieldNumber, value uint64, bytes []byte) error {
+                switch num {
+                case fieldSessionClientId:
+                    id = int(decodeZigZag(value))
+                case fieldSessionSequence:
+                    session.sequence = int(value)
+                case fieldSessionIndex:
+                    session.index = int(value)
+                case fieldSessionTerm:
+                    session.term = int(value)
+                case fieldSessionLastUsed:
+                    session.lastUsed = int(value)
+                }
+                return nil
+            })
+            if err != nil {
+                return err
+            }
+            state.sessions[id] = session
+        case fieldMetaChecksum:
+            state.checksum = value
+        }
+        return nil
+    })
+}
+
+// fieldNumber is the number of a field of a message in raft.proto.
+type fieldNumber uint64
+
+// The wire types of the protobuf encoding.
+const (
+    wireVarint  = 0
+    wireFixed64 = 1
+    wireBytes   = 2
+    wireFixed32 = 5
+)
+
+// errMalformed is the error decodeFields returns for a message it
+// cannot parse.
+var errMalformed = errors.New("raft: malformed message")
+
+func appendTag(buf []byte, num fieldNumber, typ uint64) []byte {
+    return binary.AppendUvarint(buf, uint64(num)<<3|typ)
+}
+
+// appendVarint appends a varint field, unless value is zero, which
+// proto3 leaves out.
+func appendVarint(buf []byte, num fieldNumber, value uint64) []byte {
+    if value == 0 {
+        return buf
+    }
+    buf = appendTag(buf, num, wireVarint)
+    return binary.AppendUvarint(buf, value)
+}
+
+// appendBytes appends a length-delimited field, unless value is
+// empty, which proto3 leaves out.
+func appendBytes(buf []byte, num fieldNumber, value []byte) []byte {
+    if len(value) == 0 {
+        return buf
+    }
+    return appendMessage(buf, num, value)
+}
+
+// appendMessage appends a length-delimited field even if value is
+// empty, as an embedded message that is present.
+func appendMessage(buf []byte, num fieldNumber, value []byte) []byte {
+    buf = appendTag(buf, num, wireBytes)
+    buf = binary.AppendUvarint(buf, uint64(len(value)))
+    return append(buf, value...)
+}
+
+// encodeZigZag maps signed integers to unsigned ones small in
+// magnitude, for sint64 fields.
+func encodeZigZag(value int64) uint64 {
+    return uint64(value<<1) ^ uint64(value>>63)
+}
+
+func decodeZigZag(value uint64) int64 {
+    return int64(value>>1) ^ -int64(value&1)
+}
+
+// decodeFields calls field with the number and value of every field
+// of the message in buf, in order: the integer of a varint field, or
+// the contents of a length-delimited one. Fields of other wire types
+// are skipped, as are fields field does not know, so that messages
+// from newer versions still decode.
+func decodeFields(buf []byte, field func(num fieldNumber, value uint64, bytes []byte) error) error {
+    for len(buf) > 0 {
+        tag, n := binary.Uvarint(buf)
+        if n <= 0 || tag>>3 == 0 {
+            return errMalformed
+        }
+        buf = buf[n:]
+        num := fieldNumber(tag >> 3)
+
+        var err error
+        switch tag & 7 {
+        case wireVarint:
+            var value uint64
+            value, n = binary.Uvarint(buf)
+            if n <= 0 {
+                return errMalformed
+            }
+            err = field(num, value, nil)
+        case wireBytes:
+            size, m := binary.Uvarint(buf)
+            if m <= 0 || size > uint64(len(buf)-m) {
+                return errMalformed
+            }
+            n = m + int(size)
+            err = field(num, 0, buf[m:n])
+        case wireFixed64:
+            n = 8
+        case wireFixed32:
+            n = 4
+        default:
+            return fmt.Errorf("%w: wire type %d", errMalformed, tag&7)
+        }
+        if n > len(buf) {
+            return errMalformed
+        }
+        if err != nil {
+            return err
+        }
+        buf = buf[n:]
+    }
+    return nil
+}
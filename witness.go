@@ -0,0 +1,55 @@
+package raft
+
+import "errors"
+
+// ErrWitness is returned for reads from a witness, which has no
+// state machine to read.
+var ErrWitness = errors.New("raft: node is a witness")
+
+// A witness, configured with Config.Witness, is a member that votes
+// and counts toward commit quorums but keeps only the metadata of
+// the log: the index, term and type of each entry, and the
+// commands of the entries that are not EntryNormal, which the
+// cluster itself interprets, such as configuration changes. It has
+// no state machine and never stands for election, so that a cheap
+// third site can break ties between two data centres.
+//
+// Leaders learn which peers are witnesses by handshake, and send
+// them entries without their commands and snapshots without the
+// state machine's data. An entry committed by the leader and
+// witnesses alone is held whole by the leader only, until the other
+// voters catch up.
+
+// witnessEntry returns entry as a witness keeps it.
+func witnessEntry(entry Entry) Entry {
+    if entry.Type == EntryNormal {
+        entry.Command = nil
+    }
+    return entry
+}
+
+// witnessEntries returns entries as a witness keeps them, copying
+// them only if some must change.
+func witnessEntries(entries []Entry) []Entry {
+    for i, entry := range entries {
+        if entry.Type == EntryNormal && entry.Command != nil {
+            stripped := append([]Entry(nil), entries...)
+            for j := i; j < len(stripped); j++ {
+                stripped[j] = witnessEntry(stripped[j])
+            }
+            return stripped
+        }
+    }
+    return entries
+}
+
+// witnessSnapshot returns snapshot, made by encodeSnapshot, without
+// the state machine's data.
+func witnessSnapshot(snapshot []byte) []byte {
+    state, err := decodeSnapshot(snapshot)
+    if err != nil || state.data == nil {
+        return snapshot
+    }
+    state.data = nil
+    return marshalSnapshot(state)
+}